@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// newSystemctl returns the Systemctl for the backend setting. "auto" goes
+// through systemd unless the user manager's bus is unreachable, as in some
+// containers and sandboxes, and the user's cgroup is there to write to
+// instead. On error the returned Systemctl uses systemd.
+func newSystemctl(cfg config.Config, uid int, dryRun bool) (systemdctl.Systemctl, error) {
+	sys := systemdctl.Systemctl{DryRun: dryRun}
+	switch cfg.Backend {
+	case config.BackendSystemd:
+		return sys, nil
+	case config.BackendCgroupfs:
+	default:
+		if systemdctl.UserBusReachable() {
+			return sys, nil
+		}
+	}
+	cg, err := systemdctl.DetectUserCgroup(uid)
+	if err != nil {
+		if cfg.Backend == config.BackendCgroupfs {
+			return sys, fmt.Errorf("backend = %q: %w", cfg.Backend, err)
+		}
+		return sys, nil
+	}
+	sys.Cgroupfs = cg
+	return sys, nil
+}
+
+// newUserManager returns the manager that creates the game scopes for sys.
+func newUserManager(sys systemdctl.Systemctl) (*systemdctl.UserManager, error) {
+	if sys.Cgroupfs != "" {
+		return systemdctl.NewCgroupfsManager(sys), nil
+	}
+	mgr, err := systemdctl.NewUserManager(sys.DryRun)
+	if err != nil {
+		return nil, fmt.Errorf("connect to user dbus: %w (set backend = \"cgroupfs\" to write cgroups directly)", err)
+	}
+	return mgr, nil
+}
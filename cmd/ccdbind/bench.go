@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/bench"
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/gpu"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+func runBenchTopology(args []string) {
+	fs := flag.NewFlagSet("ccdbind bench-topology", flag.ExitOnError)
+	flagJSON := fs.Bool("json", false, "output JSON")
+	flagSave := fs.Bool("save", true, "store results for CPU selection")
+	flagChase := fs.Int("chase-mib", 64, "pointer-chase working set in MiB")
+	flagConfig := fs.String("config", "", "config file path (TOML). Default: XDG config path")
+	_ = fs.Parse(args)
+
+	configPath := strings.TrimSpace(*flagConfig)
+	if configPath == "" {
+		p, err := config.DefaultConfigPath()
+		if err != nil {
+			fatal(err)
+		}
+		configPath = p
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	res, err := topology.Detect()
+	if err != nil {
+		fatal(err)
+	}
+	var g *gpu.GPU
+	if gpuApplies(cfg) {
+		sel, err := selectGPU(cfg.GPU)
+		if err != nil {
+			fatal(err)
+		}
+		g = &sel
+	}
+	opts := bench.DefaultOptions()
+	if *flagChase <= 0 {
+		fatal(fmt.Errorf("invalid --chase-mib=%d", *flagChase))
+	}
+	opts.ChaseBytes = *flagChase << 20
+
+	if !*flagJSON {
+		fmt.Fprintf(os.Stderr, "benchmarking %d clusters; keep the system idle and no game running\n", len(res.Clusters))
+	}
+	out, err := bench.Run(res.Clusters, opts)
+	if err != nil {
+		fatal(err)
+	}
+	if g != nil {
+		out.GPU = g.Name
+		for i, c := range out.Clusters {
+			out.Clusters[i].GPULocal = g.LocalCPUs != "" && topology.IntersectCPULists(c.CPUs, g.LocalCPUs) != ""
+		}
+	}
+
+	if *flagSave {
+		path, err := bench.DefaultPath()
+		if err != nil {
+			fatal(err)
+		}
+		if err := bench.Save(path, out); err != nil {
+			fatal(err)
+		}
+		if !*flagJSON {
+			fmt.Fprintf(os.Stderr, "saved %s\n", path)
+		}
+	}
+
+	if *flagJSON {
+		b, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	fmt.Printf("clusters (chase over %d MiB):\n", out.ChaseBytes>>20)
+	for _, c := range out.Clusters {
+		line := fmt.Sprintf("  %d=%s chase=%.1fns bandwidth=%.1fGB/s", c.ID, c.CPUs, c.ChaseNs, c.BandwidthGBs)
+		if c.GPULocal {
+			line += " local to " + out.GPU
+		}
+		fmt.Println(line)
+	}
+	fmt.Println("handoff latency (ns, one way):")
+	header := make([]string, 0, len(out.Clusters))
+	for _, c := range out.Clusters {
+		header = append(header, fmt.Sprintf("%8d", c.ID))
+	}
+	fmt.Printf("     %s\n", strings.Join(header, ""))
+	for i, row := range out.LatencyNs {
+		cells := make([]string, 0, len(row))
+		for _, ns := range row {
+			if ns == 0 {
+				cells = append(cells, fmt.Sprintf("%8s", "-"))
+				continue
+			}
+			cells = append(cells, fmt.Sprintf("%8.1f", ns))
+		}
+		fmt.Printf("  %2d %s\n", out.Clusters[i].ID, strings.Join(cells, ""))
+	}
+	if id, ok := out.FastestCache(res.Clusters); ok {
+		fmt.Printf("cluster %d has the fastest large working set; prefer = \"cache\" will use it when sysfs cache sizes are equal\n", id)
+	}
+}
+
+// refineFromBench applies saved bench-topology results to res, see
+// bench.Refine. Missing or unreadable results leave res unchanged.
+func refineFromBench(res topology.Result, prefer string) topology.Result {
+	path, err := bench.DefaultPath()
+	if err != nil {
+		return res
+	}
+	saved, err := bench.Load(path)
+	if err != nil {
+		return res
+	}
+	return bench.Refine(res, prefer, saved)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/Reidond/ccdbind/internal/boost"
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/procscan"
+)
+
+// boostRecord is what a boosted process had before, see boostPIDs.
+type boostRecord struct {
+	startTime uint64
+	saved     boost.Saved
+}
+
+// boostFor resolves the priority boost of a game: the global game_nice,
+// game_ioprio and game_sched, overridden by its profile. A sched policy this
+// process may not set is dropped with a warning, once per policy.
+func (r *runtime) boostFor(prof config.Profile) boost.Settings {
+	if prof.NoBoost {
+		return boost.Settings{}
+	}
+	b := r.cfg.Boost
+	if prof.Nice != nil {
+		b.Nice = prof.Nice
+	}
+	if prof.IOPrio != (boost.IOPrio{}) {
+		b.IOPrio = prof.IOPrio
+	}
+	if prof.Sched != (boost.Sched{}) {
+		b.Sched = prof.Sched
+	}
+	if err := b.Check(); err != nil {
+		if !r.schedRefused[b.Sched] {
+			if r.schedRefused == nil {
+				r.schedRefused = map[boost.Sched]bool{}
+			}
+			r.schedRefused[b.Sched] = true
+			logging.Warnf(nil, "not changing the scheduling policy of games: %v", err)
+		}
+		b.Sched = boost.Sched{}
+	}
+	return b
+}
+
+// boostPIDs applies b to every thread of the newly placed pids and keeps
+// what they had, so unboost can put it back. The nice value is left to
+// gamemoded for the processes it renices.
+func (r *runtime) boostPIDs(unit string, pids []int, starts map[int]uint64, b boost.Settings) {
+	if b.Empty() || r.dryRun {
+		return
+	}
+	for _, pid := range pids {
+		s := b
+		if s.Nice != nil && r.gameModeRenices(pid) {
+			s.Nice = nil
+		}
+		if s.Empty() {
+			continue
+		}
+		saved, err := boost.Apply(pid, s)
+		if err != nil {
+			logging.Warnf(logging.Fields{"UNIT": unit, "PID": strconv.Itoa(pid)}, "boost pid %d: %v", pid, err)
+		}
+		if len(saved) == 0 {
+			continue
+		}
+		if r.boosted == nil {
+			r.boosted = map[int]boostRecord{}
+		}
+		// A process boosted before keeps its first originals.
+		if rec, ok := r.boosted[pid]; !ok || rec.startTime != starts[pid] {
+			r.boosted[pid] = boostRecord{startTime: starts[pid], saved: saved}
+		}
+	}
+}
+
+// unboost puts the boosted processes that are still running back to their
+// original priorities.
+func (r *runtime) unboost() {
+	for pid, rec := range r.boosted {
+		if start, err := procscan.StartTime(pid); err != nil || (rec.startTime != 0 && start != rec.startTime) {
+			continue
+		}
+		if err := boost.Restore(pid, rec.saved); err != nil {
+			logging.Warnf(logging.Fields{"PID": strconv.Itoa(pid)}, "restore priority of pid %d: %v", pid, err)
+		}
+	}
+	r.boosted = nil
+}
+
+// pruneBoosted forgets boosted processes that are gone.
+func (r *runtime) pruneBoosted(alive map[int]struct{}) {
+	for pid := range r.boosted {
+		if _, ok := alive[pid]; !ok {
+			delete(r.boosted, pid)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/procscan"
+)
+
+// completeCmd is the hidden subcommand the shell scripts call with the words
+// typed so far; it prints one candidate per line, optionally followed by a
+// tab and a description.
+const completeCmd = "__complete"
+
+// completionFlags lists the flags of each subcommand; "" is the daemon.
+var completionFlags = map[string][]string{
+	"":               {"--config", "--interval", "--print-topology", "--dry-run", "--dump-state", "--paranoid", "--if-running", "--log-level", "--scan-bench"},
+	"status":         {"--json", "--filter", "--only-games", "--all", "--config", "--sample", "--watch", "--interval"},
+	"verify":         {"--json", "--config"},
+	"restore-all":    {"--config", "--dry-run", "--stop-scopes"},
+	"install":        {"--config", "--enable", "--now", "--force", "--dry-run"},
+	"uninstall":      {"--dry-run"},
+	"quirks":         {"--config", "--json"},
+	"replay":         {"--config", "--events", "--json"},
+	"bench-topology": {"--json", "--save", "--chase-mib", "--config"},
+	"topology":       {"--sysfs", "-o", "--prefer", "--json"},
+	"hotkey-daemon":  {"--trigger", "--pause-for"},
+}
+
+// completionValues lists the fixed values of flags that take one.
+var completionValues = map[string][]string{
+	"--filter":     {"games", "all"},
+	"--if-running": {"exit", "takeover", "status"},
+	"--log-level":  {"error", "warning", "info", "debug"},
+}
+
+// completionArgs names the flags that take a value, so the value is not
+// mistaken for a positional argument.
+var completionArgs = map[string]bool{
+	"--config": true, "--interval": true, "--if-running": true, "--log-level": true,
+	"--filter": true, "--sample": true, "--url": true, "--events": true,
+	"--chase-mib": true, "--trigger": true, "--pause-for": true, "--scan-bench": true,
+	"--sysfs": true, "-o": true, "--prefer": true,
+}
+
+var completionSubcommands = []string{
+	"status", "verify", "restore-all", "install", "uninstall", "quirks", "replay", "bench-topology", "topology", "config",
+	"pin", "unpin", "pause", "resume", "toggle", "hotkey-daemon", "completion",
+}
+
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ccdbind completion bash|zsh|fish")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		fatal(fmt.Errorf("unknown shell %q (expected bash|zsh|fish)", args[0]))
+	}
+}
+
+// runComplete prints the candidates for the last of words, the command line
+// after "ccdbind" with the word being completed last (possibly empty).
+func runComplete(words []string) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	for _, c := range completions(words[:len(words)-1], cur, &completionSource{}) {
+		if strings.HasPrefix(c, cur) {
+			fmt.Println(c)
+		}
+	}
+}
+
+// completionSource looks up the dynamic candidates. Lookups are lazy, as a
+// scan of /proc is wasted on completing a flag.
+type completionSource struct {
+	cfg   *config.Config
+	games map[string][]procscan.GameProcess
+}
+
+func (s *completionSource) config() config.Config {
+	if s.cfg == nil {
+		s.cfg = &config.Config{}
+		if path, err := config.DefaultConfigPath(); err == nil {
+			if cfg, err := config.Load(path); err == nil {
+				s.cfg = &cfg
+			}
+		}
+	}
+	return *s.cfg
+}
+
+func (s *completionSource) running() map[string][]procscan.GameProcess {
+	if s.games == nil {
+		cfg := s.config()
+		scanner := newScanner(cfg, os.Getuid())
+		s.games, _ = scanner.Scan()
+		if s.games == nil {
+			s.games = map[string][]procscan.GameProcess{}
+		}
+	}
+	return s.games
+}
+
+// gameIDs lists the configured profiles and the running games; with quirks
+// the titles with a [quirks] table too.
+func (s *completionSource) gameIDs(withQuirks bool) []string {
+	desc := map[string]string{}
+	for id := range s.config().Profiles {
+		desc[id] = "profile"
+	}
+	if withQuirks {
+		for _, id := range loadQuirks(s.config()).IDs() {
+			if _, ok := desc[id]; !ok {
+				desc[id] = "quirks"
+			}
+		}
+	}
+	for id := range s.running() {
+		desc[id] = "running"
+	}
+	out := make([]string, 0, len(desc))
+	for _, id := range sortedKeys(desc) {
+		out = append(out, id+"\t"+desc[id])
+	}
+	return out
+}
+
+// pids lists the running game processes.
+func (s *completionSource) pids() []string {
+	var procs []procscan.GameProcess
+	for _, ps := range s.running() {
+		procs = append(procs, ps...)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+	out := make([]string, 0, len(procs))
+	for _, p := range procs {
+		out = append(out, fmt.Sprintf("%d\t%s (%s)", p.PID, p.Exe, p.GameID))
+	}
+	return out
+}
+
+// completions returns the candidates for cur given the preceding words.
+// No candidates lets the shell fall back to completing file names.
+func completions(prev []string, cur string, src *completionSource) []string {
+	sub := ""
+	if len(prev) > 0 && !strings.HasPrefix(prev[0], "-") {
+		sub = prev[0]
+		prev = prev[1:]
+	}
+	if len(prev) > 0 {
+		if vals, ok := completionValues[prev[len(prev)-1]]; ok {
+			return vals
+		}
+		if completionArgs[prev[len(prev)-1]] {
+			return nil
+		}
+	}
+	if name, _, ok := strings.Cut(cur, "="); ok && strings.HasPrefix(name, "-") {
+		var out []string
+		for _, v := range completionValues[name] {
+			out = append(out, name+"="+v)
+		}
+		return out
+	}
+	if strings.HasPrefix(cur, "-") {
+		return completionFlags[sub]
+	}
+
+	// Positional arguments typed so far, skipping flags and their values.
+	var pos []string
+	for i := 0; i < len(prev); i++ {
+		w := prev[i]
+		if strings.HasPrefix(w, "-") {
+			if completionArgs[w] && !strings.Contains(w, "=") {
+				i++
+			}
+			continue
+		}
+		pos = append(pos, w)
+	}
+
+	switch sub {
+	case "":
+		if len(prev) == 0 {
+			return completionSubcommands
+		}
+	case "pin":
+		switch len(pos) {
+		case 0:
+			return src.pids()
+		case 1:
+			if _, err := strconv.Atoi(pos[0]); err == nil {
+				return src.gameIDs(false)
+			}
+		}
+	case "quirks":
+		switch {
+		case len(pos) == 0:
+			return []string{"list", "show"}
+		case pos[0] == "show":
+			return src.gameIDs(true)
+		}
+	case "config":
+		if len(pos) == 0 {
+			return []string{"apply"}
+		}
+	case "pause", "toggle":
+		if len(pos) == 0 {
+			return []string{"15m", "30m", "1h", "2h"}
+		}
+	case "completion":
+		if len(pos) == 0 {
+			return []string{"bash", "zsh", "fish"}
+		}
+	}
+	return nil
+}
+
+const bashCompletion = `# bash completion for ccdbind
+_ccdbind() {
+	local IFS=$'\n'
+	COMPREPLY=($(ccdbind __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1))
+}
+complete -o default -F _ccdbind ccdbind
+`
+
+const zshCompletion = `#compdef ccdbind
+# zsh completion for ccdbind
+_ccdbind() {
+	local -a cands
+	cands=("${(@f)$(ccdbind __complete "${(@)words[2,CURRENT]}" 2>/dev/null | sed 's/:/\\:/g; s/\t/:/')}")
+	if (( ${#cands} )) && [[ -n ${cands[1]} ]]; then
+		_describe 'ccdbind' cands
+	else
+		_files
+	fi
+}
+compdef _ccdbind ccdbind
+`
+
+const fishCompletion = `# fish completion for ccdbind
+function __ccdbind_complete
+	set -l words (commandline -opc)[2..-1] (commandline -ct)
+	ccdbind __complete $words 2>/dev/null
+end
+complete -c ccdbind -a '(__ccdbind_complete)'
+`
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/notify"
+	"github.com/Reidond/ccdbind/internal/procscan"
+)
+
+const (
+	actionPin    = "pin"
+	actionIgnore = "ignore"
+)
+
+// confirmer implements confirm_games. A process detected through its
+// environment whose exe is on neither the allow nor the ignore list is held
+// back until the user answers a notification for that exe.
+type confirmer struct {
+	n           *notify.Notifier
+	unavailable bool // no notification server; unknown exes are pinned as before
+
+	asked   map[string]string // exe -> game ID it was first seen with
+	decided map[string]bool   // exe -> pin (true) or ignore (false)
+}
+
+// holdUnconfirmed removes unconfirmed processes from games and asks about
+// exes seen for the first time. Pin requests and GameMode registrations are
+// explicit and never held; processes placed with their parent game are held
+// with it.
+func (r *runtime) holdUnconfirmed(ctx context.Context, games map[string][]procscan.GameProcess) {
+	if !r.cfg.ConfirmGames {
+		return
+	}
+	if r.confirm == nil {
+		r.confirm = &confirmer{asked: map[string]string{}, decided: map[string]bool{}}
+		if n, err := notify.New(); err != nil {
+			log.Printf("confirm_games: notifications unavailable (%v); pinning unknown games without asking", err)
+			r.confirm.unavailable = true
+		} else {
+			r.confirm.n = n
+		}
+	}
+	c := r.confirm
+	if c.unavailable {
+		return
+	}
+	for id, procs := range games {
+		kept := procs[:0]
+		var children []procscan.GameProcess
+		for _, p := range procs {
+			pin, ok := c.decided[p.Exe]
+			switch {
+			case p.IDSource == "request" || p.IDSource == gameModeSource || p.Exe == "" || r.scanner.Allowlisted(p.Exe):
+				kept = append(kept, p)
+			case p.IDSource == procscan.IDSourceParent || p.IDSource == procscan.IDSourceOverlay:
+				// Helpers and the overlay follow the game they belong to.
+				children = append(children, p)
+			case ok:
+				if pin {
+					kept = append(kept, p)
+				}
+			default:
+				c.ask(ctx, p.Exe, id)
+			}
+		}
+		if len(kept) > 0 {
+			kept = append(kept, children...)
+		}
+		if len(kept) == 0 {
+			delete(games, id)
+		} else {
+			games[id] = kept
+		}
+	}
+}
+
+func (c *confirmer) ask(ctx context.Context, exe, gameID string) {
+	if _, ok := c.asked[exe]; ok || c.unavailable {
+		return
+	}
+	c.asked[exe] = gameID
+	summary := fmt.Sprintf("New game: %s", exe)
+	body := fmt.Sprintf("%s was detected as game %s. Pin it to the game CPUs from now on?", exe, gameID)
+	err := c.n.Ask(ctx, exe, summary, body, []notify.Action{
+		{Key: actionPin, Label: "Pin as game"},
+		{Key: actionIgnore, Label: "Ignore"},
+	})
+	if err != nil {
+		log.Printf("confirm_games: notify: %v; pinning unknown games without asking", err)
+		c.unavailable = true
+		return
+	}
+	logging.Infof(logging.Fields{"GAME_ID": gameID}, "confirm_games: holding %s (game %s) until answered", exe, gameID)
+}
+
+// answers returns the notification answers channel, nil while there is none.
+func (r *runtime) answers() <-chan notify.Answer {
+	if r.confirm == nil || r.confirm.n == nil {
+		return nil
+	}
+	return r.confirm.n.Answers()
+}
+
+// recordAnswer applies the user's choice for an exe and appends it to the
+// allow or ignore file so it survives restarts. It reports whether a tick is
+// needed to pin the newly allowed processes.
+func (r *runtime) recordAnswer(a notify.Answer) bool {
+	c := r.confirm
+	exe, gameID := a.Key, c.asked[a.Key]
+	var path string
+	switch a.Action {
+	case actionPin:
+		c.decided[exe] = true
+		path = r.cfg.AllowFile
+	case actionIgnore:
+		c.decided[exe] = false
+		path = r.cfg.IgnoreFile
+	default:
+		log.Printf("confirm_games: %s (game %s) dismissed; not pinned until restart or listed in %s", exe, gameID, r.cfg.AllowFile)
+		return false
+	}
+	logging.Infof(logging.Fields{"GAME_ID": gameID}, "confirm_games: %s (game %s): %s", exe, gameID, a.Action)
+	if r.dryRun {
+		log.Printf("dry-run: append %q to %s", exe, path)
+	} else if err := config.AppendListFile(path, exe); err != nil {
+		log.Printf("confirm_games: record %s: %v", exe, err)
+	}
+	return c.decided[exe]
+}
+
+func (r *runtime) closeConfirm() {
+	if r.confirm != nil && r.confirm.n != nil {
+		r.confirm.n.Close()
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/clock"
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/health"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// daemon bundles what the main loop needs so ticks and control requests run
+// on the same goroutine and never interleave.
+type daemon struct {
+	r   *runtime
+	sys systemdctl.Systemctl
+	mgr *systemdctl.UserManager
+
+	configPath    string
+	statePath     string
+	st            *state.File
+	forceParanoid bool
+	interval      time.Duration    // --interval, overrides the config when > 0
+	logLevel      *logging.Level   // --log-level, overrides the config
+	debug         []logging.Domain // CCDBIND_DEBUG, overrides the config
+	health        *health.Tracker
+
+	// retryC fires when the earliest game scope retry is due.
+	retryC <-chan time.Time
+	// keepaliveC fires when the pin_delay wait or the restart_keepalive
+	// window ends.
+	keepaliveC <-chan time.Time
+	// pauseC fires when a timed `ccdbind pause` ends.
+	pauseC <-chan time.Time
+	// busLost is set once the user manager connection closed.
+	busLost bool
+}
+
+// unitChanges returns the channel reporting changes to the watched slices,
+// nil without a user manager connection.
+func (d *daemon) unitChanges() <-chan struct{} {
+	if d.busLost {
+		return nil
+	}
+	return d.sys.Bus.Changed()
+}
+
+// unitRemovals returns the channel naming units the user manager removed,
+// nil without a user manager connection.
+func (d *daemon) unitRemovals() <-chan string {
+	if d.busLost {
+		return nil
+	}
+	return d.sys.Bus.Removed()
+}
+
+func (d *daemon) tick(ctx context.Context) error {
+	if d.st.Paused {
+		return nil
+	}
+	start := d.r.clock.Now()
+	games, err := d.r.scanner.Scan()
+	d.r.metrics.scan.Observe(d.r.clock.Now().Sub(start))
+	if err != nil {
+		return fmt.Errorf("scan: %w", err)
+	}
+	d.r.checkScanAccess()
+	applyPinRequests(d.r, games)
+	d.r.applyGameMode(games)
+	d.r.applyManual(games)
+	for id := range games {
+		if d.r.cfg.Profiles[id].Ignore {
+			delete(games, id)
+		}
+	}
+	d.r.holdUnconfirmed(ctx, games)
+	err = handleTick(ctx, d.r, d.sys, d.mgr, d.statePath, d.st, d.r.slices, games)
+	d.r.notifyEvents(games, d.st, err)
+	d.r.syncQoS(d.st.PinApplied)
+	d.syncIRQ(d.st.PinApplied)
+	// Before syncPower, which skips CPUs that are offline.
+	d.syncSMT(d.st.PinApplied && d.r.wantsSMTOff(games))
+	d.syncPower(d.st.PinApplied)
+	d.syncPowerProfile(d.st.PinApplied)
+	d.syncUndo(d.st.PinApplied)
+	d.r.metrics.pinApplied.SetBool(d.st.PinApplied)
+	d.r.metrics.games.Set(float64(len(games)))
+	d.r.metrics.scopes.Set(float64(len(d.r.gameScopes())))
+	if err != nil {
+		return err
+	}
+	trackOSLoad(d.r, d.statePath, d.st)
+	return nil
+}
+
+// timedTick runs a tick under the health tracker. due is when a timer tick
+// was scheduled (zero for event-driven ticks), for the lag statistic.
+func (d *daemon) timedTick(ctx context.Context, due time.Time) {
+	limit := d.r.cfg.Interval
+	d.health.Begin(d.r.clock.Now(), due, limit)
+	err := d.tick(ctx)
+	dur, slow := d.health.End(d.r.clock.Now())
+	d.r.metrics.tick.Observe(dur)
+	if slow {
+		logging.Warnf(nil, "watchdog: tick took %s, longer than the %s interval", dur.Round(time.Millisecond), limit)
+	}
+	if err != nil {
+		logging.Errorf(nil, "tick: %v", err)
+	}
+	d.retryC = nil
+	if at, ok := nextScopeRetry(d.st); ok {
+		d.retryC = d.r.clock.After(at.Sub(d.r.clock.Now()))
+	}
+	d.keepaliveC = nil
+	for _, at := range []time.Time{d.r.pinAt, d.r.keepUntil} {
+		if !at.IsZero() {
+			d.keepaliveC = d.r.clock.After(at.Sub(d.r.clock.Now()))
+		}
+	}
+}
+
+// watchdog logs a tick that is still running after its interval, typically a
+// hung systemctl or D-Bus call.
+func watchdog(ctx context.Context, clk clock.Clock, tr *health.Tracker) {
+	t := clk.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C():
+			if d, stuck := tr.Stuck(now); stuck {
+				logging.Warnf(nil, "watchdog: tick still running after %s", d.Round(time.Millisecond))
+			}
+		}
+	}
+}
+
+// apply validates data, switches the running daemon to it and persists it to
+// the config path. If the first tick under the new config fails, the previous
+// settings are reinstated and re-applied, and the file on disk is untouched.
+// With no games running nothing is pinned and the switch is immediate.
+func (d *daemon) apply(ctx context.Context, data []byte) error {
+	cfg, err := config.Parse(data)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if d.interval > 0 {
+		cfg.Interval = d.interval
+	}
+	if d.logLevel != nil {
+		cfg.LogLevel = *d.logLevel
+	}
+	if d.debug != nil {
+		cfg.Debug = d.debug
+	}
+	next, err := newSettings(cfg, d.r.uid, d.forceParanoid)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	prev := d.r.settings
+	if err := d.release(removedSlices(prev.withRecorded(ctx, d.sys, prev.slices, *d.st), next.withRecorded(ctx, d.sys, next.slices, *d.st))); err != nil {
+		return fmt.Errorf("release dropped slices: %w", err)
+	}
+	d.r.settings = next
+	if err := d.tick(ctx); err != nil {
+		logging.Errorf(nil, "config apply failed, rolling back: %v", err)
+		if rerr := d.release(removedSlices(next.withRecorded(ctx, d.sys, next.slices, *d.st), prev.withRecorded(ctx, d.sys, prev.slices, *d.st))); rerr != nil {
+			logging.Errorf(nil, "rollback: %v", rerr)
+		}
+		d.r.settings = prev
+		if rerr := d.tick(ctx); rerr != nil {
+			logging.Errorf(nil, "rollback: %v", rerr)
+		}
+		return fmt.Errorf("apply: %w (rolled back)", err)
+	}
+
+	if err := writeFileAtomic(d.configPath, data); err != nil {
+		return fmt.Errorf("applied but not persisted: %w", err)
+	}
+	logging.SetLevel(cfg.LogLevel)
+	logging.SetDomains(cfg.Debug)
+	log.Printf("config applied os_cpus=%q game_cpus=%q slices=%v", next.osCPUs, next.gameCPUs, next.slices)
+	return nil
+}
+
+// release restores slices that are no longer pinned under the new config.
+// Slices kept across the change stay pinned; the next tick hands them over
+// to the new CPU split without passing through their unpinned originals.
+func (d *daemon) release(slices []string) error {
+	if !d.st.PinApplied || len(slices) == 0 {
+		return nil
+	}
+	targets := restoreTargets(slices, *d.st)
+	if err := restoreSlices(d.sys, slices, *d.st); err != nil {
+		return err
+	}
+	d.r.metrics.restores.Inc()
+	for _, unit := range targets {
+		delete(d.st.OriginalAllowedCPUs, unit)
+		delete(d.st.OriginalAllowedMemoryNodes, unit)
+		delete(d.st.KeptAllowedCPUs, unit)
+	}
+	return state.Save(d.statePath, *d.st)
+}
+
+func removedSlices(from, to []string) []string {
+	keep := make(map[string]struct{}, len(to))
+	for _, s := range to {
+		keep[s] = struct{}{}
+	}
+	out := make([]string, 0, len(from))
+	for _, s := range from {
+		if _, ok := keep[s]; !ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// loopRequest hands a control request that changes daemon state to the main
+// loop.
+type loopRequest struct {
+	req   controlRequest
+	reply chan error
+}
+
+type controlRequest struct {
+	Op     string `json:"op"`
+	Config string `json:"config,omitempty"`
+	// PID and GameID are for "pin"; For is the length of a "pause".
+	PID    int           `json:"pid,omitempty"`
+	GameID string        `json:"game_id,omitempty"`
+	For    time.Duration `json:"for_ns,omitempty"`
+}
+
+type controlResponse struct {
+	OK     bool             `json:"ok"`
+	Error  string           `json:"error,omitempty"`
+	Health *health.Snapshot `json:"health,omitempty"`
+	// Status answers "status" on the read-only status socket.
+	Status *publicStatus `json:"status,omitempty"`
+}
+
+func controlSocketPath() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		return "", errors.New("XDG_RUNTIME_DIR not set")
+	}
+	return filepath.Join(base, "ccdbind", "control.sock"), nil
+}
+
+// serveControl accepts JSON-line requests on a user-only unix socket and
+// forwards state changes to the main loop through reqs. Health queries are
+// answered directly from tr.
+func serveControl(ctx context.Context, path string, reqs chan<- loopRequest, tr *health.Tracker) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	_ = os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+		_ = os.Remove(path)
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handleControlConn(ctx, conn, reqs, tr)
+		}
+	}()
+	return nil
+}
+
+// loopOps are the control ops run on the main loop, see daemon.handle.
+var loopOps = map[string]bool{"apply": true, "pin": true, "unpin": true, "pause": true, "resume": true, "toggle": true}
+
+func handleControlConn(ctx context.Context, conn net.Conn, reqs chan<- loopRequest, tr *health.Tracker) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Minute))
+
+	var req controlRequest
+	resp := controlResponse{}
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("decode request: %v", err)
+	} else if req.Op == "health" {
+		snap := tr.Snapshot()
+		resp.OK, resp.Health = true, &snap
+	} else if !loopOps[req.Op] {
+		resp.Error = fmt.Sprintf("unknown op %q", req.Op)
+	} else {
+		ar := loopRequest{req: req, reply: make(chan error, 1)}
+		select {
+		case reqs <- ar:
+			if err := <-ar.reply; err != nil {
+				resp.Error = err.Error()
+			} else {
+				resp.OK = true
+			}
+		case <-ctx.Done():
+			resp.Error = "daemon shutting down"
+		}
+	}
+	_ = json.NewEncoder(conn).Encode(resp)
+}
+
+func runConfig(args []string) {
+	if len(args) != 2 || args[0] != "apply" {
+		fmt.Fprintln(os.Stderr, "usage: ccdbind config apply FILE")
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(args[1])
+	if err != nil {
+		fatal(err)
+	}
+	// Validate locally first for a quick, daemon-independent error.
+	if _, err := config.Parse(data); err != nil {
+		fatal(fmt.Errorf("invalid config: %w", err))
+	}
+
+	resp, err := controlCall(controlRequest{Op: "apply", Config: string(data)}, 5*time.Second)
+	if err != nil {
+		fatal(err)
+	}
+	if !resp.OK {
+		fatal(errors.New(strings.TrimSpace(resp.Error)))
+	}
+	fmt.Println("config applied")
+}
+
+// controlCall sends one request to the running daemon. timeout bounds the
+// connect only; an apply may take as long as a tick.
+func controlCall(req controlRequest, timeout time.Duration) (controlResponse, error) {
+	sockPath, err := controlSocketPath()
+	if err != nil {
+		return controlResponse{}, err
+	}
+	conn, err := net.DialTimeout("unix", sockPath, timeout)
+	if err != nil {
+		return controlResponse{}, fmt.Errorf("connect to daemon: %w", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return controlResponse{}, err
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return controlResponse{}, fmt.Errorf("read response: %w", err)
+	}
+	return resp, nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
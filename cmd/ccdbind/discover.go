@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// coversSlice reports whether pin_all_slices pins unit: a top-level slice of
+// the user manager other than game.slice, where the game scopes of ccdbind
+// and ccdpin live, the slice ccdbind itself runs in, session.slice without
+// pin_session_slice, and those matching exclude_slices. A dash in a slice
+// name nests it under another slice, which pinning the parent already
+// covers.
+func (s settings) coversSlice(unit string) bool {
+	if !s.cfg.PinAllSlices || systemdctl.IsCgroupPath(unit) {
+		return false
+	}
+	name, ok := strings.CutSuffix(unit, ".slice")
+	if !ok || name == "" || strings.Contains(name, "-") || unit == "game.slice" || unit == s.ownSlice {
+		return false
+	}
+	if unit == "session.slice" && !s.cfg.PinSessionSlice {
+		return false
+	}
+	for _, pattern := range s.cfg.ExcludeSlices {
+		if ok, _ := path.Match(pattern, unit); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// serviceSlice returns the top-level user slice holding cgroup when cgroup
+// is a service of the user manager, as the daemon's own cgroup is under
+// systemd, and "" otherwise.
+func serviceSlice(cgroup string) string {
+	_, rest, ok := strings.Cut(cgroup, ".service/")
+	if !ok || !strings.HasSuffix(cgroup, ".service") {
+		return ""
+	}
+	slice, _, _ := strings.Cut(rest, "/")
+	if !strings.HasSuffix(slice, ".slice") {
+		return ""
+	}
+	return slice
+}
+
+// listSlices returns the slices the user manager has loaded.
+func listSlices(ctx context.Context, sys systemdctl.Systemctl) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	units, err := sys.ListUnits(ctx, "*.slice")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(units)
+	return units, nil
+}
+
+// withDiscovered adds the slices pin_all_slices covers to base, as the
+// user manager has them now. They are listed afresh on every tick, so slices
+// started while games run are pinned too. A failed listing leaves base as it
+// is.
+func (s settings) withDiscovered(ctx context.Context, sys systemdctl.Systemctl, base []string) []string {
+	if !s.cfg.PinAllSlices {
+		return base
+	}
+	units, err := listSlices(ctx, sys)
+	if err != nil {
+		logging.Warnf(nil, "pin_all_slices: %v", err)
+		return base
+	}
+	out := append([]string(nil), base...)
+	for _, unit := range units {
+		if s.coversSlice(unit) && !slices.Contains(out, unit) {
+			out = append(out, unit)
+		}
+	}
+	return out
+}
+
+// withRecorded adds the slices pin_all_slices pinned to base for a
+// restore: those with a recorded original that the user manager still has.
+// A slice without one was never pinned and is left alone.
+func (s settings) withRecorded(ctx context.Context, sys systemdctl.Systemctl, base []string, st state.File) []string {
+	var recorded []string
+	for _, unit := range sortedKeys(st.OriginalAllowedCPUs) {
+		if s.coversSlice(unit) && !slices.Contains(base, unit) {
+			recorded = append(recorded, unit)
+		}
+	}
+	if len(recorded) == 0 {
+		return base
+	}
+	out := append([]string(nil), base...)
+	units, err := listSlices(ctx, sys)
+	if err != nil {
+		logging.Warnf(nil, "pin_all_slices: %v", err)
+	}
+	for _, unit := range recorded {
+		if err != nil || slices.Contains(units, unit) {
+			out = append(out, unit)
+		}
+	}
+	return out
+}
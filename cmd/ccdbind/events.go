@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/procscan"
+)
+
+// eventDebounce coalesces the burst of execs a game launch produces (wine,
+// preloader, the game itself) into one tick.
+const eventDebounce = 50 * time.Millisecond
+
+// listenProcEvents forwards proc connector events until ctx is done. It
+// returns nil when the connector is unavailable; the daemon then keeps
+// polling every interval.
+func listenProcEvents(ctx context.Context) <-chan procscan.Event {
+	ev, err := procscan.ListenEvents()
+	if err != nil {
+		log.Printf("proc connector unavailable (%v); polling only", err)
+		return nil
+	}
+	go func() {
+		<-ctx.Done()
+		_ = ev.Close()
+	}()
+
+	out := make(chan procscan.Event, 256)
+	go func() {
+		defer close(out)
+		for {
+			events, err := ev.Read()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("proc connector: %v; falling back to polling", err)
+				}
+				return
+			}
+			for _, e := range events {
+				if e.Kind == procscan.EventFork {
+					continue
+				}
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// eventNeedsTick reports whether ev should trigger a tick: an exec that looks
+// like a game, the exit of a process ccdbind placed, or lost events.
+func (r *runtime) eventNeedsTick(ev procscan.Event) bool {
+	switch ev.Kind {
+	case procscan.EventExec:
+		return r.scanner.IsGame(ev.PID)
+	case procscan.EventExit:
+		_, ok := r.pidToUnit[ev.PID]
+		return ok
+	case procscan.EventLost:
+		return true
+	}
+	return false
+}
+
+// pollInterval is the base timer period: the configured interval, or the
+// slower reconcile interval while proc events drive detection.
+func (r *runtime) pollInterval(eventsActive bool) time.Duration {
+	if eventsActive && r.cfg.ReconcileInterval > r.cfg.Interval {
+		return r.cfg.ReconcileInterval
+	}
+	return r.cfg.Interval
+}
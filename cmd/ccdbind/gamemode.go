@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/Reidond/ccdbind/internal/gamemode"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/procscan"
+)
+
+// gameModeSource marks processes found through gamemoded registrations.
+const gameModeSource = "gamemode"
+
+// gameMode returns the gamemoded client, connecting on first use, or nil
+// when the gamemode setting is off or gamemoded is unavailable.
+func (r *runtime) gameMode() *gamemode.Client {
+	if !r.cfg.GameMode || r.gmUnavailable {
+		return nil
+	}
+	if r.gm == nil {
+		c, err := gamemode.New()
+		if err != nil {
+			if errors.Is(err, gamemode.ErrNotInstalled) {
+				logging.Debugf(nil, "gamemode: %v", err)
+			} else {
+				log.Printf("gamemode: %v; not cooperating with gamemoded", err)
+			}
+			r.gmUnavailable = true
+			return nil
+		}
+		cfg := c.Config()
+		log.Printf("gamemode: following gamemoded registrations (desiredgov=%q renice=%d)", cfg.Governor, -cfg.Renice)
+		r.gm = c
+	}
+	return r.gm
+}
+
+// gameModeChanges returns the channel signalling registration changes, nil
+// while there is none.
+func (r *runtime) gameModeChanges() <-chan struct{} {
+	if !r.cfg.GameMode || r.gm == nil {
+		return nil
+	}
+	return r.gm.Changed()
+}
+
+// gameModeLost drops the client after its bus connection went away.
+func (r *runtime) gameModeLost() {
+	log.Printf("gamemode: session bus connection lost; no longer following gamemoded")
+	r.gm, r.gmUnavailable = nil, true
+}
+
+// applyGameMode adds processes registered with gamemoded that the scanner
+// did not find, grouped by executable name like exe_allowlist matches.
+func (r *runtime) applyGameMode(games map[string][]procscan.GameProcess) {
+	gm := r.gameMode()
+	if gm == nil {
+		return
+	}
+	found := map[int]bool{}
+	for _, procs := range games {
+		for _, p := range procs {
+			found[p.PID] = true
+		}
+	}
+	for _, pid := range gm.Games() {
+		if found[pid] {
+			continue
+		}
+		gp, err := r.scanner.Adopt(pid, "", gameModeSource)
+		if err != nil || gp.Exe == "" {
+			// Another user's game, or one that exited before gamemoded noticed.
+			logging.Tracef(logging.Scan, logging.Fields{"PID": strconv.Itoa(pid)}, "gamemode pid %d skipped: %v", pid, err)
+			continue
+		}
+		gp.GameID = r.cfg.GameAliases.Resolve(gp.Exe)
+		games[gp.GameID] = append(games[gp.GameID], gp)
+	}
+}
+
+// gameModeOwnsGovernor reports whether gamemoded is switching the governor
+// now: it has games registered and a desiredgov to switch to.
+func (r *runtime) gameModeOwnsGovernor() bool {
+	if !r.cfg.GameMode || r.gm == nil {
+		return false
+	}
+	return r.gm.Config().Governor != "" && len(r.gm.Games()) > 0
+}
+
+// gameModeRenices reports whether gamemoded sets the nice value of pid.
+func (r *runtime) gameModeRenices(pid int) bool {
+	if !r.cfg.GameMode || r.gm == nil {
+		return false
+	}
+	return r.gm.Config().Renice != 0 && r.gm.Registered(pid)
+}
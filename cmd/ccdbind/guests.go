@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/guests"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// guestCPUs resolves the guest_cpus setting against the current OS CPUs. It
+// returns "" when VMs and containers are left alone.
+func (r *runtime) guestCPUs(osCPUs string) string {
+	if r.cfg.GuestCPUs == "os" {
+		return osCPUs
+	}
+	return r.cfg.GuestCPUs
+}
+
+// syncGuests pins the VM and container cgroups running now to cpus, or
+// restores them when cpus is empty. Guests started while games run are
+// picked up on the next tick. Failures are logged once per cgroup and do
+// not hold up the slices or the games: system-level guests usually need
+// privileges ccdbind does not have. It reports whether st changed.
+func syncGuests(r *runtime, sys systemdctl.Systemctl, st *state.File, cpus string) bool {
+	if cpus == "" {
+		return restoreGuests(sys, st)
+	}
+	changed := false
+	seen := map[string]struct{}{}
+	for _, g := range guests.Detect(r.uid) {
+		seen[g.Cgroup] = struct{}{}
+		if _, skip := r.guestSkipped[g.Cgroup]; skip {
+			continue
+		}
+		fields := logging.Fields{"UNIT": filepath.Base(g.Cgroup)}
+		if r.paranoid && g.System {
+			r.skipGuest(g.Cgroup)
+			logging.Warnf(fields, "paranoid: not pinning %s %s outside user manager (%s)", g.Runtime, g.Kind, g.Cgroup)
+			continue
+		}
+		ctx, cancel := systemdctl.DefaultContext()
+		cur, err := sys.GetAllowedCPUs(ctx, g.Cgroup)
+		cancel()
+		if err != nil {
+			r.skipGuest(g.Cgroup)
+			logging.Warnf(fields, "guest %s: %v", g.Cgroup, err)
+			continue
+		}
+		if cur == cpus {
+			continue
+		}
+		if st.OriginalGuestCPUs == nil {
+			st.OriginalGuestCPUs = map[string]string{}
+		}
+		if _, ok := st.OriginalGuestCPUs[g.Cgroup]; !ok {
+			st.OriginalGuestCPUs[g.Cgroup] = cur
+			changed = true
+		}
+		ctx, cancel = systemdctl.DefaultContext()
+		err = sys.SetAllowedCPUs(ctx, g.Cgroup, cpus)
+		cancel()
+		if err != nil {
+			r.skipGuest(g.Cgroup)
+			delete(st.OriginalGuestCPUs, g.Cgroup)
+			hint := ""
+			if g.System {
+				hint = " (system cgroups need root or a delegated subtree)"
+			}
+			logging.Warnf(fields, "pin %s %s %s: %v%s", g.Runtime, g.Kind, g.Cgroup, err, hint)
+			continue
+		}
+		logging.Infof(fields, "pinned %s %s %s to cpus=%q", g.Runtime, g.Kind, g.Cgroup, cpus)
+	}
+	for cg := range r.guestSkipped {
+		if _, ok := seen[cg]; !ok {
+			delete(r.guestSkipped, cg)
+		}
+	}
+	for cg := range st.OriginalGuestCPUs {
+		if _, ok := seen[cg]; !ok {
+			delete(st.OriginalGuestCPUs, cg)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (r *runtime) skipGuest(cgroup string) {
+	if r.guestSkipped == nil {
+		r.guestSkipped = map[string]struct{}{}
+	}
+	r.guestSkipped[cgroup] = struct{}{}
+}
+
+// restoreGuests returns pinned guests that still exist to their original
+// cpusets. It reports whether st changed.
+func restoreGuests(sys systemdctl.Systemctl, st *state.File) bool {
+	if len(st.OriginalGuestCPUs) == 0 {
+		return false
+	}
+	for cg, val := range st.OriginalGuestCPUs {
+		if _, err := os.Stat(filepath.Join(systemdctl.CgroupRoot, strings.Trim(cg, "/"))); err != nil {
+			continue
+		}
+		ctx, cancel := systemdctl.DefaultContext()
+		err := sys.SetAllowedCPUs(ctx, cg, val)
+		cancel()
+		if err != nil {
+			logging.Warnf(logging.Fields{"UNIT": filepath.Base(cg)}, "restore guest %s: %v", cg, err)
+		}
+	}
+	st.OriginalGuestCPUs = nil
+	return true
+}
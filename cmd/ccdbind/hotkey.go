@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/shortcuts"
+)
+
+const toggleShortcutID = "toggle-pause"
+
+// runHotkeyDaemon binds a global shortcut through the desktop portal and
+// toggles pause/resume on the daemon each time it is pressed, for when a pin
+// leaves the desktop sluggish and a terminal is out of reach.
+func runHotkeyDaemon(args []string) {
+	fs := flag.NewFlagSet("ccdbind hotkey-daemon", flag.ExitOnError)
+	flagTrigger := fs.String("trigger", "CTRL+SHIFT+F12", "preferred key combo; the desktop may ask to confirm or change it")
+	flagFor := fs.Duration("pause-for", 0, "pause for this long instead of until the next press")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 || *flagFor < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	logging.Setup("ccdbind-hotkey")
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	sess, err := shortcuts.Open(ctx)
+	if err != nil {
+		fatal(fmt.Errorf("global shortcuts portal: %w", err))
+	}
+	defer sess.Close()
+	bound, err := sess.Bind(ctx, []shortcuts.Shortcut{{
+		ID:               toggleShortcutID,
+		Description:      "Pause or resume ccdbind CPU pinning",
+		PreferredTrigger: *flagTrigger,
+	}})
+	if err != nil {
+		fatal(err)
+	}
+	trigger := "unassigned"
+	for _, b := range bound {
+		if b.ID == toggleShortcutID && b.Trigger != "" {
+			trigger = b.Trigger
+		}
+	}
+	logging.Infof(nil, "hotkey bound: %s toggles pause", trigger)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id, ok := <-sess.Activated():
+			if !ok {
+				fatal(errors.New("session bus connection closed"))
+			}
+			if id != toggleShortcutID {
+				continue
+			}
+			resp, err := controlCall(controlRequest{Op: "toggle", For: *flagFor}, 5*time.Second)
+			switch {
+			case err != nil:
+				logging.Errorf(nil, "toggle: %v", err)
+			case !resp.OK:
+				logging.Errorf(nil, "toggle: %s", resp.Error)
+			default:
+				logging.Infof(nil, "hotkey: %s", pausedState())
+			}
+		}
+	}
+}
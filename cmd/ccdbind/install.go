@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// installMarker starts every unit file written by `ccdbind install`, so
+// `ccdbind uninstall` only removes files it wrote.
+const installMarker = "# Generated by `ccdbind install`; `ccdbind uninstall` removes it."
+
+const serviceUnitName = "ccdbind.service"
+
+func userUnitDir() (string, error) {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, "systemd", "user"), nil
+}
+
+// serviceUnit returns the ccdbind.service user unit running exe with
+// configPath. The daemon restores its slices on SIGTERM, so stopping gets
+// time for that; a daemon failing at startup (a bad config, say) is given
+// up on after a few attempts instead of restarting every second forever.
+func serviceUnit(exe, configPath string) string {
+	return installMarker + `
+[Unit]
+Description=CCD bind daemon (user)
+Wants=game.slice
+After=game.slice
+StartLimitIntervalSec=60
+StartLimitBurst=5
+
+[Service]
+Type=simple
+ExecStart=` + unitQuote(exe) + ` --config ` + unitQuote(configPath) + ` --if-running=takeover
+Restart=on-failure
+RestartSec=2s
+TimeoutStopSec=30s
+NoNewPrivileges=yes
+
+[Install]
+WantedBy=default.target
+`
+}
+
+func gameSliceUnit() string {
+	return installMarker + `
+[Unit]
+Description=Game slice (user)
+
+[Slice]
+CPUAccounting=yes
+`
+}
+
+// unitQuote makes s a single ExecStart argument: specifiers are escaped and
+// paths with spaces or quotes are quoted.
+func unitQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// runInstall writes the user units for this binary and optionally enables
+// and starts the service.
+func runInstall(args []string) {
+	fs := flag.NewFlagSet("ccdbind install", flag.ExitOnError)
+	flagConfig := fs.String("config", "", "config file the service uses. Default: XDG config path")
+	flagEnable := fs.Bool("enable", false, "enable the service to start at login")
+	flagNow := fs.Bool("now", false, "also start (or restart) the service now")
+	flagForce := fs.Bool("force", false, "overwrite unit files not written by ccdbind install")
+	flagDryRun := fs.Bool("dry-run", false, "print the units and commands without writing or running anything")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		fatal(err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	if strings.HasPrefix(exe, os.TempDir()) || strings.Contains(exe, "go-build") {
+		fatal(fmt.Errorf("%s looks like a temporary build; install the binary (e.g. to ~/.local/bin) and run install from there", exe))
+	}
+	configPath := strings.TrimSpace(*flagConfig)
+	if configPath == "" {
+		configPath, err = config.DefaultConfigPath()
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if configPath, err = filepath.Abs(configPath); err != nil {
+		fatal(err)
+	}
+	if _, err := os.Stat(configPath); err != nil {
+		fmt.Printf("note: %s does not exist yet; the defaults apply until it does\n", configPath)
+	}
+
+	dir, err := userUnitDir()
+	if err != nil {
+		fatal(err)
+	}
+	units := []struct{ name, content string }{
+		{serviceUnitName, serviceUnit(exe, configPath)},
+		{"game.slice", gameSliceUnit()},
+	}
+	for _, u := range units {
+		path := filepath.Join(dir, u.name)
+		old, err := os.ReadFile(path)
+		switch {
+		case err == nil && bytes.Equal(old, []byte(u.content)):
+			fmt.Printf("%s is up to date\n", path)
+			continue
+		case err == nil && !bytes.HasPrefix(old, []byte(installMarker)) && !*flagForce:
+			fatal(fmt.Errorf("%s exists and was not written by ccdbind install; use --force to replace it", path))
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			fatal(err)
+		}
+		if *flagDryRun {
+			fmt.Printf("would write %s:\n%s\n", path, u.content)
+			continue
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			fatal(err)
+		}
+		if err := writeFileAtomic(path, []byte(u.content)); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("wrote %s\n", path)
+	}
+
+	sys := systemdctl.Systemctl{DryRun: *flagDryRun}
+	ctx, cancel := systemdctl.DefaultContext()
+	defer cancel()
+	if err := sys.DaemonReload(ctx); err != nil {
+		fatal(err)
+	}
+	switch {
+	case *flagEnable:
+		if err := sys.EnableUnit(ctx, serviceUnitName); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("enabled %s\n", serviceUnitName)
+	case !*flagNow:
+		fmt.Printf("run `systemctl --user enable --now %s` or `ccdbind install --enable --now` to start it\n", serviceUnitName)
+	}
+	if *flagNow {
+		// Restart so an already running service picks up the new unit.
+		if err := sys.RestartUnit(ctx, serviceUnitName); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("started %s\n", serviceUnitName)
+	}
+}
+
+// runUninstall stops and disables the service, which restores the slices,
+// and removes the unit files written by `ccdbind install`. Config and state
+// are left alone.
+func runUninstall(args []string) {
+	fs := flag.NewFlagSet("ccdbind uninstall", flag.ExitOnError)
+	flagDryRun := fs.Bool("dry-run", false, "print what would be done without doing it")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	dir, err := userUnitDir()
+	if err != nil {
+		fatal(err)
+	}
+	sys := systemdctl.Systemctl{DryRun: *flagDryRun}
+	ctx, cancel := systemdctl.DefaultContext()
+	defer cancel()
+	if err := sys.DisableUnit(ctx, serviceUnitName); err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
+	for _, name := range []string{serviceUnitName, "game.slice"} {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			continue
+		case err != nil:
+			fatal(err)
+		case !bytes.HasPrefix(data, []byte(installMarker)):
+			fmt.Printf("kept %s: not written by ccdbind install\n", path)
+			continue
+		}
+		if *flagDryRun {
+			fmt.Printf("would remove %s\n", path)
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("removed %s\n", path)
+	}
+	if err := sys.DaemonReload(ctx); err != nil {
+		fatal(err)
+	}
+	fmt.Println("config and state are kept; run `ccdbind restore-all` if slices are still pinned")
+}
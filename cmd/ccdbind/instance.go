@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errAlreadyRunning is returned by lockInstance when another daemon holds the
+// lock.
+var errAlreadyRunning = errors.New("another ccdbind instance is running")
+
+// handoverSignal asks the daemon to exit for a takeover: it saves its state
+// and leaves the slices pinned for the next instance instead of restoring
+// them.
+const handoverSignal = syscall.SIGUSR2
+
+func instanceLockPath() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("ccdbind-%d.pid", os.Getuid())), nil
+	}
+	return filepath.Join(base, "ccdbind", "ccdbind.pid"), nil
+}
+
+// lockInstance takes an exclusive flock on the pidfile and writes our PID
+// into it. The lock dies with the process, so a stale pidfile never blocks a
+// restart. On contention it returns errAlreadyRunning and the holder's PID.
+func lockInstance(path string) (release func(), holder int, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		pid := readPID(path)
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, pid, errAlreadyRunning
+		}
+		return nil, pid, err
+	}
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	release = func() {
+		_ = f.Truncate(0)
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}
+	return release, 0, nil
+}
+
+// readPID returns the PID in the pidfile at path. A holder that has just
+// taken the lock may not have written it yet, so an empty file is read again
+// for a moment before giving up with 0.
+func readPID(path string) int {
+	for i := 0; ; i++ {
+		b, _ := os.ReadFile(path)
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err == nil || i == 10 {
+			return pid
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// takeOver asks the running instance to hand over with handoverSignal and
+// waits for its lock. The holder exits without restoring, so running games
+// stay pinned, and the new instance picks up the saved state file as-is.
+func takeOver(path string, holder int, timeout time.Duration) (func(), error) {
+	if holder <= 0 {
+		return nil, fmt.Errorf("%w; pid unknown, cannot take over", errAlreadyRunning)
+	}
+	log.Printf("taking over from ccdbind pid %d", holder)
+	if err := syscall.Kill(holder, handoverSignal); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return nil, fmt.Errorf("signal pid %d: %w", holder, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		release, _, err := lockInstance(path)
+		if err == nil {
+			return release, nil
+		}
+		if !errors.Is(err, errAlreadyRunning) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("pid %d did not exit within %s", holder, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/Reidond/ccdbind/internal/irqbalance"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// syncIRQ bans the game CPUs in irqbalance while games are pinned with
+// irqbalance = true, and lifts the ban otherwise. The banned list is kept in
+// the state file so a ban left by a crashed daemon is lifted on the next
+// start. A failed change is logged once and not retried until the wanted
+// ban changes, since each attempt restarts irqbalance. The ban is a runtime
+// drop-in on irqbalance.service only; an EnvironmentFile= of the unit that
+// sets the same variables wins over it, which is logged as a warning.
+func (d *daemon) syncIRQ(pinned bool) {
+	want := ""
+	if pinned && d.r.cfg.IRQBalance {
+		want = d.st.GameCPUs
+	}
+	if want == d.st.IRQBalanceBanned || (d.r.irqFailed && want == d.r.irqFailedFor) {
+		return
+	}
+	var (
+		eff irqbalance.Effective
+		err error
+	)
+	switch {
+	case d.r.dryRun:
+		log.Printf("dry-run: irqbalance ban %q", want)
+	case want == "":
+		ctx, cancel := systemdctl.DefaultContext()
+		err = irqbalance.Unban(ctx)
+		cancel()
+	default:
+		ctx, cancel := systemdctl.DefaultContext()
+		eff, err = irqbalance.Ban(ctx, want)
+		cancel()
+	}
+	switch {
+	case errors.Is(err, irqbalance.ErrNotRunning):
+		if want != "" {
+			log.Printf("irqbalance: %v; interrupts are left alone", err)
+			d.r.irqFailed, d.r.irqFailedFor = true, want
+			return
+		}
+	case err != nil:
+		logging.Warnf(nil, "irqbalance: %v (the user needs polkit rights to manage %s, see the docs)", err, irqbalance.Unit)
+		d.r.irqFailed, d.r.irqFailedFor = true, want
+		return
+	case want == "":
+		log.Printf("irqbalance: ban lifted")
+	case eff.File != "":
+		logging.Warnf(nil, "irqbalance: ban of game cpus %s is overridden by %s (effective: %s)", want, eff.File, eff)
+	default:
+		log.Printf("irqbalance: game cpus %s banned from interrupts (%s)", want, eff)
+	}
+	d.r.irqFailed = false
+	d.st.IRQBalanceBanned = want
+	if err := state.Save(d.statePath, *d.st); err != nil {
+		log.Printf("save state: %v", err)
+	}
+}
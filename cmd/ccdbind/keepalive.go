@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/procscan"
+)
+
+// sawGames records the games of a tick that found some. A game coming back
+// while the pin is held by restart_keepalive ends the window.
+func (r *runtime) sawGames(gameIDs []string) {
+	if !r.keepUntil.IsZero() {
+		back := make([]string, 0, len(gameIDs))
+		for _, id := range gameIDs {
+			for _, prev := range r.lastGames {
+				if id == prev {
+					back = append(back, id)
+					break
+				}
+			}
+		}
+		if len(back) > 0 {
+			logging.Infof(nil, "game %s restarted within restart_keepalive; keeping the pin", strings.Join(back, ","))
+		} else {
+			logging.Infof(nil, "game %s started within restart_keepalive; keeping the pin", strings.Join(gameIDs, ","))
+		}
+		r.keepUntil = time.Time{}
+	}
+	r.lastGames = gameIDs
+}
+
+// keepPin reports whether handleTick should leave the pin applied although
+// no games are running, because the last one exited less than
+// restart_keepalive ago. The first call after the exit opens the window.
+func (r *runtime) keepPin(now time.Time) bool {
+	if r.cfg.RestartKeepalive <= 0 {
+		return false
+	}
+	if r.keepUntil.IsZero() {
+		r.keepUntil = now.Add(r.cfg.RestartKeepalive)
+		logging.Infof(nil, "no games active; keeping the pin for %s in case %s restarts", r.cfg.RestartKeepalive, strings.Join(r.lastGames, ","))
+		return true
+	}
+	return now.Before(r.keepUntil)
+}
+
+// delayPin reports whether handleTick should not pin yet although games are
+// running, because they were first seen less than pin_delay ago. The wait
+// starts with the first tick that finds games while nothing is pinned and
+// starts over once none are left. Games joining an applied pin, and pin
+// requests, manual pins and GameMode registrations, which say outright that
+// a game runs, are not held.
+func (r *runtime) delayPin(games map[string][]procscan.GameProcess, pinned bool, now time.Time) bool {
+	if r.cfg.PinDelay <= 0 || pinned || len(games) == 0 || explicitGame(games) {
+		r.pinAt = time.Time{}
+		return false
+	}
+	if r.pinAt.IsZero() {
+		r.pinAt = now.Add(r.cfg.PinDelay)
+		ids := make([]string, 0, len(games))
+		for id := range games {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		logging.Infof(nil, "game %s detected; pinning in %s unless it exits", strings.Join(ids, ","), r.cfg.PinDelay)
+	}
+	if now.Before(r.pinAt) {
+		return true
+	}
+	r.pinAt = time.Time{}
+	return false
+}
+
+// explicitGame reports whether a process of games was named as a game by a
+// pin request, a manual pin or GameMode rather than found by the scan.
+func explicitGame(games map[string][]procscan.GameProcess) bool {
+	for _, procs := range games {
+		for _, p := range procs {
+			switch p.IDSource {
+			case "request", "manual", gameModeSource:
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/cpuload"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// Thresholds for lending GAME CPUs to the OS slices. CPUs are lent when the
+// OS CPUs stay at lendOSBusy or above for lendAfter while the GAME CPUs stay
+// at lendGameIdle or below, and returned when the widened OS set stays
+// below returnOSBusy for returnAfter, or at once when the game's remaining
+// CPUs reach returnGameBusy.
+const (
+	lendOSBusy     = 0.9
+	lendGameIdle   = 0.5
+	lendAfter      = 10 * time.Second
+	returnOSBusy   = 0.6
+	returnAfter    = 30 * time.Second
+	returnGameBusy = 0.8
+)
+
+// trackLending decides from one load sample whether to lend GAME CPUs to
+// the OS slices or return them, and records the lent CPUs in the state
+// file; handleTick widens the OS slices on the next tick. It reports
+// whether st changed.
+func (r *runtime) trackLending(prev, cur cpuload.Snapshot, st *state.File, now time.Time) bool {
+	if r.lendMax == 0 && st.LentCPUs == "" {
+		r.lendSince = time.Time{}
+		return false
+	}
+	_, osSet, _ := topology.CanonicalizeCPUList(st.OSCPUs)
+	_, gameSet, _ := topology.CanonicalizeCPUList(st.GameCPUs)
+	_, lentSet, _ := topology.CanonicalizeCPUList(st.LentCPUs)
+	kept := make([]int, 0, len(gameSet))
+	for _, c := range gameSet {
+		if !topology.ContainsCPU(lentSet, c) {
+			kept = append(kept, c)
+		}
+	}
+	if len(osSet) == 0 || len(kept) == 0 {
+		return false
+	}
+	osLoad := cpuload.Utilization(prev, cur, osSet)
+	gameLoad := cpuload.Utilization(prev, cur, kept)
+
+	held := func(cond bool, after time.Duration) bool {
+		if !cond {
+			r.lendSince = time.Time{}
+			return false
+		}
+		if r.lendSince.IsZero() {
+			r.lendSince = now
+		}
+		return now.Sub(r.lendSince) >= after
+	}
+
+	if st.LentCPUs == "" {
+		if !held(osLoad >= lendOSBusy && gameLoad <= lendGameIdle, lendAfter) {
+			return false
+		}
+		lent := lendable(st.GameCPUs, r.lendMax)
+		if lent == "" {
+			return false
+		}
+		r.lendSince = time.Time{}
+		st.LentCPUs = lent
+		logging.Infof(nil, "os cpus at %.0f%%, game cpus at %.0f%%: lending game cpus %s to the os slices", osLoad*100, gameLoad*100, lent)
+		return true
+	}
+
+	if gameLoad < returnGameBusy && !held(osLoad < returnOSBusy, returnAfter) {
+		return false
+	}
+	logging.Infof(nil, "os cpus at %.0f%%, game cpus at %.0f%%: returning lent cpus %s", osLoad*100, gameLoad*100, st.LentCPUs)
+	r.lendSince = time.Time{}
+	st.LentCPUs = ""
+	return true
+}
+
+// lendable returns the n highest GAME CPUs, leaving the game at least one.
+func lendable(gameCPUs string, n int) string {
+	_, cpus, err := topology.CanonicalizeCPUList(gameCPUs)
+	if err != nil || n <= 0 || len(cpus) <= n {
+		return ""
+	}
+	return topology.FormatCPUList(cpus[len(cpus)-n:])
+}
+
+// keepLent returns the CPUs still lent under the active games' lend_cpus:
+// none when they no longer allow lending, or when the lent CPUs are no
+// longer GAME CPUs.
+func keepLent(lent, gameCPUs string, n int) string {
+	_, lentSet, err := topology.CanonicalizeCPUList(lent)
+	if err != nil || len(lentSet) == 0 || len(lentSet) > n {
+		return ""
+	}
+	_, gameSet, _ := topology.CanonicalizeCPUList(gameCPUs)
+	for _, c := range lentSet {
+		if !topology.ContainsCPU(gameSet, c) {
+			return ""
+		}
+	}
+	return lent
+}
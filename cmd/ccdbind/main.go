@@ -3,30 +3,184 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"os"
 	"os/signal"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/Reidond/ccdbind/internal/boost"
+	"github.com/Reidond/ccdbind/internal/clock"
 	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/cpuload"
+	"github.com/Reidond/ccdbind/internal/gamemode"
+	"github.com/Reidond/ccdbind/internal/gpu"
+	"github.com/Reidond/ccdbind/internal/health"
+	"github.com/Reidond/ccdbind/internal/history"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/peercred"
+	"github.com/Reidond/ccdbind/internal/pinreq"
+	"github.com/Reidond/ccdbind/internal/pmqos"
+	"github.com/Reidond/ccdbind/internal/privs"
 	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/quirks"
+	"github.com/Reidond/ccdbind/internal/spread"
 	"github.com/Reidond/ccdbind/internal/state"
 	"github.com/Reidond/ccdbind/internal/systemdctl"
 	"github.com/Reidond/ccdbind/internal/topology"
 )
 
 type runtime struct {
+	settings
+
 	dryRun bool
+	uid    int
+	clock  clock.Clock // time source of the loop and its timers
+
+	quirkLogged  map[string]struct{}
+	lastDecision string
+
+	requestDir       string
+	requestOverrides map[string]quirks.Override
+	// requestScopes maps games to the scope a pin request says they already
+	// run in, see applyPinRequests.
+	requestScopes map[string]string
+
+	loadSample cpuload.Snapshot
+	osBusyFrom time.Time
+
+	lendMax   int       // lend_cpus of the active games, see trackLending
+	lendSince time.Time // when the current lend or return condition began
+
+	lastGames []string  // game IDs of the last tick that found games
+	keepUntil time.Time // end of the restart_keepalive window, see keepPin
+	pinAt     time.Time // end of the pin_delay wait, see delayPin
+
+	pidToUnit map[int]pidRecord
+	refused   map[int]struct{}
+	scopeMems map[string]string // AllowedMemoryNodes set on each game scope
+
+	boosted      map[int]boostRecord // see boostPIDs
+	notices      *noticer
+	schedRefused map[boost.Sched]bool
+
+	confirm *confirmer
+
+	metrics *daemonMetrics
+
+	qos       *pmqos.Request // held dma_latency, see syncQoS
+	qosFailed bool
+
+	irqFailed    bool   // see syncIRQ
+	powerFailed  bool   // see syncPower
+	irqFailedFor string // the ban that failed, "" for lifting it
+
+	smtFailed    bool // see syncSMT
+	smtFailedFor bool // the change that failed: true for switching off
+
+	spread       *spread.Balancer // see spreadThreads
+	spreadFailed bool
+
+	profileFailed    bool // see syncPowerProfile
+	profileFailedFor bool
+
+	undoArmed  bool // see syncUndo
+	undoFailed bool
+
+	guestSkipped map[string]struct{} // guest cgroups that could not be pinned
+
+	scanDenied bool // see checkScanAccess
+
+	gm            *gamemode.Client // see applyGameMode
+	gmUnavailable bool
+
+	// Manual overrides from `ccdbind pin` and `ccdbind unpin`.
+	forced   map[int]forcedPin
+	unpinned map[string]struct{}
+}
+
+// settings holds everything derived from the config file. It is swapped as a
+// whole when `ccdbind config apply` pushes a new config.
+type settings struct {
+	cfg      config.Config
+	slices   []string
+	scanner  *procscan.Scanner
+	paranoid bool
+	topo     topology.Detector
 
 	osCPUs   string
 	gameCPUs string
+	osMems   string
+	gameMems string
 
-	pidToUnit map[int]pidRecord
+	quirks      quirks.DB
+	historyPath string
+
+	// ownSlice is the top-level slice ccdbind.service runs in, which
+	// pin_all_slices leaves alone; "" when not run as a service.
+	ownSlice string
+}
+
+func newSettings(cfg config.Config, uid int, forceParanoid bool) (settings, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 2 * time.Second
+	}
+	topo, err := detector(cfg)
+	if err != nil {
+		return settings{}, err
+	}
+	osCPUs, gameCPUs, err := splitCPUs(cfg, topo)
+	if err != nil {
+		return settings{}, err
+	}
+	cfg, osCPUs, gameCPUs, err = withoutReserved(cfg, osCPUs, gameCPUs, reservations(cfg, uid))
+	if err != nil {
+		return settings{}, err
+	}
+	paranoid := forceParanoid || cfg.Paranoid
+	for _, entry := range cfg.PinSlices {
+		if !systemdctl.IsCgroupPath(entry) {
+			continue
+		}
+		if _, err := path.Match(entry, ""); err != nil {
+			return settings{}, fmt.Errorf("pin_slices %q: %w", entry, err)
+		}
+		if paranoid && !procscan.InUserManager("/"+strings.Trim(entry, "/")+"/", uid) {
+			return settings{}, fmt.Errorf("pin_slices %q is outside the user manager's cgroup subtree (paranoid mode)", entry)
+		}
+	}
+	s := settings{
+		cfg:      cfg,
+		slices:   slicesToPin(cfg),
+		scanner:  newScanner(cfg, uid),
+		paranoid: paranoid,
+		topo:     topo,
+		osCPUs:   osCPUs,
+		gameCPUs: gameCPUs,
+		quirks:   loadQuirks(cfg),
+	}
+	if cg, err := procscan.Cgroup(os.Getpid()); err == nil {
+		s.ownSlice = serviceSlice(cg)
+	}
+	if cfg.PinMemoryNodes {
+		s.osMems = resolveMemoryNodes(topo, osCPUs)
+		s.gameMems = resolveMemoryNodes(topo, gameCPUs)
+	}
+	if cfg.RecordHistory {
+		if p, err := history.DefaultPath(); err == nil {
+			s.historyPath = p
+		}
+	}
+	return s, nil
 }
 
 type pidRecord struct {
@@ -37,9 +191,66 @@ type pidRecord struct {
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 
-	if len(os.Args) > 1 && os.Args[1] == "status" {
-		runStatus(os.Args[2:])
-		return
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "status":
+			runStatus(os.Args[2:])
+			return
+		case "completion":
+			runCompletion(os.Args[2:])
+			return
+		case completeCmd:
+			runComplete(os.Args[2:])
+			return
+		case "restore-all":
+			runRestoreAll(os.Args[2:])
+			return
+		case "install":
+			runInstall(os.Args[2:])
+			return
+		case "uninstall":
+			runUninstall(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "quirks":
+			runQuirks(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "bench-topology":
+			runBenchTopology(os.Args[2:])
+			return
+		case "topology":
+			runTopology(os.Args[2:])
+			return
+		case "config":
+			runConfig(os.Args[2:])
+			return
+		case "pin":
+			runPin(os.Args[2:])
+			return
+		case "unpin":
+			runUnpin(os.Args[2:])
+			return
+		case "pause":
+			runPause(os.Args[2:])
+			return
+		case "resume":
+			runResume(os.Args[2:])
+			return
+		case "toggle":
+			runToggle(os.Args[2:])
+			return
+		case "hotkey-daemon":
+			runHotkeyDaemon(os.Args[2:])
+			return
+		case "undo-watch":
+			runUndoWatch(os.Args[2:])
+			return
+		}
 	}
 
 	runDaemon(os.Args[1:])
@@ -53,9 +264,37 @@ func runDaemon(args []string) {
 		flagPrintTopo = fs.Bool("print-topology", false, "print detected CPU topology and exit")
 		flagDryRun    = fs.Bool("dry-run", false, "log actions without mutating systemd state")
 		flagDumpState = fs.Bool("dump-state", false, "print persisted state JSON and exit")
+		flagParanoid  = fs.Bool("paranoid", false, "refuse to move processes outside the user manager's cgroup subtree")
+		flagIfRunning = fs.String("if-running", "exit", "when another instance is running: exit|takeover|status")
+		flagLogLevel  = fs.String("log-level", "", "log level: error|warning|info|debug (default: config log_level)")
+		flagScanBench = fs.Int("scan-bench", 0, "run N process scans at the poll interval, print their timings and exit")
 	)
 	_ = fs.Parse(args)
 
+	// Under systemd, log to the journal with structured fields.
+	logging.Setup("ccdbind")
+	var logLevel *logging.Level
+	if *flagLogLevel != "" {
+		l, err := logging.ParseLevel(*flagLogLevel)
+		if err != nil {
+			fatal(err)
+		}
+		logLevel = &l
+	}
+	var debugDomains []logging.Domain
+	if v := strings.TrimSpace(os.Getenv("CCDBIND_DEBUG")); v != "" {
+		ds, err := logging.ParseDomains(v)
+		if err != nil {
+			fatal(fmt.Errorf("CCDBIND_DEBUG: %w", err))
+		}
+		debugDomains = ds
+	}
+
+	// Nothing ccdbind does needs a capability; see "Privileges" in README.md.
+	if err := privs.Drop(); err != nil {
+		log.Printf("drop privileges: %v", err)
+	}
+
 	defaultCfgPath, err := config.DefaultConfigPath()
 	if err != nil {
 		fatal(err)
@@ -87,29 +326,102 @@ func runDaemon(args []string) {
 	if *flagInterval > 0 {
 		cfg.Interval = *flagInterval
 	}
-	if cfg.Interval <= 0 {
-		cfg.Interval = 2 * time.Second
+	if logLevel != nil {
+		cfg.LogLevel = *logLevel
+	}
+	if debugDomains != nil {
+		cfg.Debug = debugDomains
 	}
+	logging.SetLevel(cfg.LogLevel)
+	logging.SetDomains(cfg.Debug)
 
-	r := &runtime{dryRun: *flagDryRun, pidToUnit: map[int]pidRecord{}}
+	if *flagScanBench > 0 {
+		runScanBench(cfg, os.Getuid(), *flagScanBench)
+		return
+	}
 
-	effectiveOS, effectiveGame, err := resolveCPUs(cfg)
+	r := &runtime{
+		dryRun:      *flagDryRun,
+		uid:         os.Getuid(),
+		clock:       clock.Real,
+		pidToUnit:   map[int]pidRecord{},
+		refused:     map[int]struct{}{},
+		quirkLogged: map[string]struct{}{},
+		metrics:     newDaemonMetrics(),
+	}
+	r.settings, err = newSettings(cfg, r.uid, *flagParanoid)
 	if err != nil {
 		fatal(err)
 	}
-	r.osCPUs = effectiveOS
-	r.gameCPUs = effectiveGame
 
 	if *flagPrintTopo {
+		if res, err := r.topo.Detect(topology.PreferCache); err == nil {
+			for _, c := range res.Clusters {
+				fmt.Printf("CLUSTER_%d=%s\n", c.ID, c.CPUs)
+				if c.L3KB > 0 {
+					fmt.Printf("CLUSTER_%d_L3_KB=%d\n", c.ID, c.L3KB)
+				}
+				if c.MaxFreqKHz > 0 {
+					fmt.Printf("CLUSTER_%d_MAX_FREQ_KHZ=%d\n", c.ID, c.MaxFreqKHz)
+				}
+				if c.Nodes != "" {
+					fmt.Printf("CLUSTER_%d_NODES=%s\n", c.ID, c.Nodes)
+				}
+			}
+		}
 		fmt.Printf("OS_CPUS=%s\n", r.osCPUs)
 		fmt.Printf("GAME_CPUS=%s\n", r.gameCPUs)
+		if r.osMems != "" {
+			fmt.Printf("OS_MEMORY_NODES=%s\n", r.osMems)
+		}
+		if r.gameMems != "" {
+			fmt.Printf("GAME_MEMORY_NODES=%s\n", r.gameMems)
+		}
+		if gpus, err := gpu.List(); err == nil {
+			for _, g := range gpus {
+				fmt.Printf("GPU=%s vendor=%s integrated=%v numa_node=%d local_cpus=%s\n", g.Name, g.VendorName(), g.Integrated, g.NUMANode, g.LocalCPUs)
+			}
+		}
 		return
 	}
 
-	uid := os.Getuid()
-	slices := slicesToPin(cfg)
+	switch *flagIfRunning {
+	case "exit", "takeover", "status":
+	default:
+		fatal(fmt.Errorf("invalid --if-running=%q (expected exit|takeover|status)", *flagIfRunning))
+	}
+	lockPath, err := instanceLockPath()
+	if err != nil {
+		fatal(err)
+	}
+	release, holder, err := lockInstance(lockPath)
+	if errors.Is(err, errAlreadyRunning) {
+		switch *flagIfRunning {
+		case "takeover":
+			release, err = takeOver(lockPath, holder, 15*time.Second)
+		case "status":
+			log.Printf("ccdbind already running (pid %d); showing status instead", holder)
+			runStatus([]string{"--config", configPath})
+			return
+		default:
+			err = fmt.Errorf("ccdbind already running (pid %d); stop it, use --if-running=takeover to replace it, or run `ccdbind status`", holder)
+		}
+	}
+	if err != nil {
+		fatal(err)
+	}
+	defer release()
 
-	sys := systemdctl.Systemctl{DryRun: r.dryRun}
+	sys, err := newSystemctl(r.cfg, r.uid, r.dryRun)
+	if err != nil {
+		fatal(err)
+	}
+	switch {
+	case sys.Cgroupfs != "" && r.cfg.Backend == config.BackendAuto:
+		logging.Warnf(nil, "user manager bus unreachable; writing cgroups under %s directly", sys.Cgroupfs)
+	case sys.Cgroupfs != "":
+		log.Printf("backend: cgroupfs under %s", sys.Cgroupfs)
+	}
 	// Best-effort: ensure game.slice exists/loads.
 	{
 		ctx2, cancel := systemdctl.DefaultContext()
@@ -117,64 +429,282 @@ func runDaemon(args []string) {
 		cancel()
 	}
 
-	mgr, err := systemdctl.NewUserManager(r.dryRun)
+	mgr, err := newUserManager(sys)
 	if err != nil {
-		fatal(fmt.Errorf("connect to user dbus: %w", err))
+		fatal(err)
 	}
 	defer mgr.Close()
+	defer r.closeConfirm()
+	// Slice properties go over the manager's connection rather than a
+	// systemctl process per call.
+	sys.Bus = mgr
+	// Unit signals let a game's exit be noticed when its scope goes away
+	// rather than at the next scan.
+	{
+		ctx2, cancel := systemdctl.DefaultContext()
+		if err := mgr.Subscribe(ctx2); err != nil {
+			logging.Warnf(nil, "%v; game exits are noticed by the scan only", err)
+		}
+		cancel()
+	}
 
-	scanner := procscan.NewScanner(uid, cfg.EnvKeys, cfg.ExeAllowlist, cfg.IgnoreExe)
-
-	st, err := state.Load(statePath)
+	st, recovered, err := loadState(statePath, sys, r.withDiscovered(context.Background(), sys, r.slices), r.osCPUs)
 	if err != nil {
 		fatal(err)
 	}
+	if recovered {
+		if err := state.Save(statePath, st); err != nil {
+			fatal(err)
+		}
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if err := restoreIfNeeded(ctx, scanner, sys, statePath, &st, slices); err != nil {
-		log.Printf("restoreIfNeeded: %v", err)
+	d := &daemon{r: r, sys: sys, mgr: mgr, configPath: configPath, statePath: statePath, st: &st, forceParanoid: *flagParanoid, interval: *flagInterval, logLevel: logLevel, debug: debugDomains, health: health.NewTracker(r.clock.Now())}
+	go watchdog(ctx, r.clock, d.health)
+
+	if err := restoreIfNeeded(ctx, r, sys, statePath, &st, r.withRecorded(ctx, sys, r.slices, st)); err != nil {
+		logging.Errorf(nil, "restoreIfNeeded: %v", err)
+	}
+	r.loadScopePIDs(st)
+
+	if dir, err := pinreq.DefaultDir(); err != nil {
+		log.Printf("pin requests disabled: %v", err)
+	} else if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Printf("pin requests disabled: %v", err)
+	} else {
+		r.requestDir = dir
+	}
+
+	d.armPause(ctx)
+
+	if r.cfg.MetricsListen != "" {
+		if err := serveMetrics(ctx, r.cfg.MetricsListen, r.metrics); err != nil {
+			log.Printf("metrics disabled: %v", err)
+		}
+	}
+
+	ctlc := make(chan loopRequest)
+	if sockPath, err := controlSocketPath(); err != nil {
+		log.Printf("control socket: %v", err)
+	} else if err := serveControl(ctx, sockPath, ctlc, d.health); err != nil {
+		log.Printf("control socket: %v", err)
+	}
+	if r.cfg.StatusSocket != "" {
+		if allow, err := peercred.Resolve(r.cfg.StatusAllow); err != nil {
+			log.Printf("status socket disabled: status_allow: %v", err)
+		} else if err := serveStatus(ctx, r.cfg.StatusSocket, allow, r.uid, statePath, d.health); err != nil {
+			log.Printf("status socket disabled: %v", err)
+		}
 	}
 
 	sigc := make(chan os.Signal, 2)
-	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM, handoverSignal)
+	var handover atomic.Bool
 	go func() {
-		<-sigc
-		log.Printf("signal received; shutting down")
+		if <-sigc == handoverSignal {
+			handover.Store(true)
+			log.Printf("takeover requested; handing over to the new instance")
+		} else {
+			log.Printf("signal received; shutting down")
+		}
 		cancel()
 	}()
 
-	ticker := time.NewTicker(cfg.Interval)
-	defer ticker.Stop()
+	now := r.clock.Now()
+	due := now.Add(firstTickDelay(now))
+	timer := r.clock.NewTimer(due.Sub(now))
+	defer timer.Stop()
 
-	log.Printf("ccdbind started interval=%s os_cpus=%q game_cpus=%q dry_run=%v", cfg.Interval, r.osCPUs, r.gameCPUs, r.dryRun)
+	// The watch ticker runs at a fixed period; a zero watch_interval only
+	// disables the work, so config apply can turn it on and off.
+	watch := r.clock.NewTicker(250 * time.Millisecond)
+	defer watch.Stop()
+	lastWatch := r.clock.Now()
+
+	// With proc events, games are picked up on exec and the timer only
+	// reconciles. proc_events = false via config apply falls back to polling;
+	// turning it on needs a restart.
+	var procEvents <-chan procscan.Event
+	if r.cfg.ProcEvents {
+		procEvents = listenProcEvents(ctx)
+	}
+	var eventTick <-chan time.Time
+
+	if r.cfg.DMALatency >= 0 {
+		if err := pmqos.Check(); err != nil {
+			log.Printf("dma_latency = %d will not take effect: %v", r.cfg.DMALatency, err)
+		}
+	}
+
+	log.Printf("ccdbind started interval=%s proc_events=%v os_cpus=%q game_cpus=%q os_mems=%q dry_run=%v paranoid=%v", r.cfg.Interval, procEvents != nil, r.osCPUs, r.gameCPUs, r.osMems, r.dryRun, r.paranoid)
 	for {
+		eventsActive := procEvents != nil && r.cfg.ProcEvents
 		select {
 		case <-ctx.Done():
+			r.syncQoS(false)
+			r.unboost()
+			if handover.Load() {
+				// The slices, irqbalance ban, SMT and power settings stay as
+				// the state file records them; the new instance carries on
+				// from there and arms its own undo unit.
+				if err := state.Save(statePath, st); err != nil {
+					logging.Errorf(nil, "save state for takeover: %v", err)
+				}
+				r.closeNotices()
+				d.syncUndo(false)
+				return
+			}
 			if st.PinApplied {
-				if err := restoreSlices(sys, slices, st.OriginalAllowedCPUs); err != nil {
-					log.Printf("restore on exit: %v", err)
+				slices := r.withRecorded(context.Background(), sys, r.slices, st)
+				restoreGuests(sys, &st)
+				adoptModifiedBeforeRestore(r.cfg.RestorePolicy, sys, slices, &st)
+				if err := restoreSlices(sys, slices, st); err != nil {
+					logging.Errorf(nil, "restore on exit: %v", err)
 				} else {
+					r.metrics.restores.Inc()
 					st.PinApplied = false
-					st.LastSuccessfulRestore = time.Now()
+					st.KeptAllowedCPUs = nil
+					st.LastSuccessfulRestore = r.clock.Now()
 					_ = state.Save(statePath, st)
 				}
 			}
+			r.notifyEvents(nil, &st, nil)
+			r.closeNotices()
+			d.syncIRQ(false)
+			d.syncSMT(false)
+			d.syncPower(false)
+			d.syncPowerProfile(false)
+			d.syncUndo(st.PinApplied)
 			return
-		case <-ticker.C:
-			games, err := scanner.Scan()
-			if err != nil {
-				log.Printf("scan: %v", err)
+		case req := <-ctlc:
+			req.reply <- d.handle(ctx, req.req)
+		case <-d.pauseC:
+			if err := d.resume(ctx); err != nil {
+				log.Printf("resume: %v", err)
+			}
+		case <-timer.C():
+			fired := due
+			next := jitteredInterval(r.pollInterval(eventsActive), r.cfg.IntervalJitter, rand.Float64())
+			due = r.clock.Now().Add(next)
+			timer.Reset(next)
+			d.timedTick(ctx, fired)
+		case ev, ok := <-procEvents:
+			if !ok {
+				procEvents = nil
+				next := jitteredInterval(r.cfg.Interval, r.cfg.IntervalJitter, rand.Float64())
+				due = r.clock.Now().Add(next)
+				timer.Reset(next)
+				continue
+			}
+			if eventsActive && eventTick == nil && r.eventNeedsTick(ev) {
+				eventTick = r.clock.After(eventDebounce)
+			}
+		case a, ok := <-r.answers():
+			if !ok {
+				log.Printf("confirm_games: notification connection lost; pinning unknown games without asking")
+				r.confirm.n, r.confirm.unavailable = nil, true
 				continue
 			}
-			if err := handleTick(ctx, r, sys, mgr, statePath, &st, slices, games); err != nil {
-				log.Printf("tick: %v", err)
+			if r.recordAnswer(a) {
+				d.timedTick(ctx, time.Time{})
+			}
+		case _, ok := <-r.gameModeChanges():
+			if !ok {
+				r.gameModeLost()
+				continue
+			}
+			if eventTick == nil {
+				eventTick = r.clock.After(eventDebounce)
+			}
+		case _, ok := <-d.unitChanges():
+			if !ok {
+				log.Printf("user manager bus connection lost; falling back to systemctl for slice properties")
+				d.busLost = true
+				continue
+			}
+			// Someone else changed a pinned slice; reassert without waiting
+			// for the next interval.
+			if eventTick == nil {
+				eventTick = r.clock.After(eventDebounce)
+			}
+		case unit, ok := <-d.unitRemovals():
+			if !ok {
+				log.Printf("user manager bus connection lost; falling back to systemctl for slice properties")
+				d.busLost = true
+				continue
+			}
+			if !r.tracksScope(unit) {
+				continue
+			}
+			logging.Debugf(logging.Fields{"UNIT": unit}, "%s went away; rescanning", unit)
+			if eventTick == nil {
+				eventTick = r.clock.After(eventDebounce)
+			}
+		case <-d.retryC:
+			d.retryC = nil
+			d.timedTick(ctx, time.Time{})
+		case <-d.keepaliveC:
+			d.keepaliveC = nil
+			d.timedTick(ctx, time.Time{})
+		case <-eventTick:
+			eventTick = nil
+			d.timedTick(ctx, time.Time{})
+		case now := <-watch.C():
+			// Exec events already cover late-spawned games.
+			if eventsActive || r.cfg.WatchInterval <= 0 || now.Sub(lastWatch) < r.cfg.WatchInterval {
+				continue
 			}
+			lastWatch = now
+			if !r.scanner.FastScan(now) {
+				continue
+			}
+			log.Printf("late-spawned game detected under a launcher; rescanning")
+			d.timedTick(ctx, time.Time{})
 		}
 	}
 }
 
+// jitteredInterval spreads the poll interval by ±pct percent so ccdbind does
+// not wake in lockstep with other pollers on a small OS CPU set. rnd is a
+// uniform value in [0, 1).
+func jitteredInterval(base time.Duration, pct int, rnd float64) time.Duration {
+	if pct <= 0 {
+		return base
+	}
+	spread := float64(base) * float64(pct) / 100
+	return base + time.Duration((rnd*2-1)*spread)
+}
+
+// firstTickDelay schedules the first tick at a random phase a few hundred
+// milliseconds past a whole second, away from the boundaries where
+// timer-driven daemons tend to cluster. Only the first tick is placed this
+// way: each later one is due an interval (spread by interval_jitter) after
+// the previous timer fired, so the phase wanders from there.
+func firstTickDelay(now time.Time) time.Duration {
+	phase := 300*time.Millisecond + rand.N(200*time.Millisecond)
+	return time.Second - time.Duration(now.Nanosecond()) + phase
+}
+
+// newScanner returns a scanner for uid set up from cfg, with its detectors.
+func newScanner(cfg config.Config, uid int) *procscan.Scanner {
+	s := procscan.NewScanner(uid, cfg.EnvKeys, cfg.ExeAllowlist, cfg.IgnoreExe, cfg.GameAliases)
+	ds := make([]procscan.Detector, 0, len(cfg.Detectors))
+	for _, name := range cfg.Detectors {
+		// Names were checked by config.Parse.
+		if d, err := procscan.NewDetector(name); err == nil {
+			ds = append(ds, d)
+		}
+	}
+	s.SetDetectors(ds)
+	s.SetOverlays(cfg.OverlayCPUs != "")
+	if cfg.ScanBackend == config.ScanCgroup {
+		s.UseCgroups(path.Join(systemdctl.CgroupRoot, fmt.Sprintf("user.slice/user-%d.slice", uid)))
+	}
+	return s
+}
+
 func slicesToPin(cfg config.Config) []string {
 	slices := append([]string{}, cfg.PinSlices...)
 	if cfg.PinSessionSlice {
@@ -187,7 +717,50 @@ func slicesToPin(cfg config.Config) []string {
 	return slices
 }
 
+// detector returns where cfg takes the CPU topology from: its
+// topology_snapshot, or sysfs.
+func detector(cfg config.Config) (topology.Detector, error) {
+	if cfg.TopologySnapshot == "" {
+		return topology.Sysfs{}, nil
+	}
+	snap, err := topology.LoadSnapshot(cfg.TopologySnapshot)
+	if err != nil {
+		return nil, fmt.Errorf("topology_snapshot: %w", err)
+	}
+	logging.Tracef(logging.Topology, nil, "using topology snapshot %s taken %s", cfg.TopologySnapshot, snap.Taken.Format(time.RFC3339))
+	return snap, nil
+}
+
+// resolveCPUs returns the OS and GAME sets from the overrides or topology
+// detection, with SMT siblings moved according to smt.
 func resolveCPUs(cfg config.Config) (string, string, error) {
+	topo, err := detector(cfg)
+	if err != nil {
+		return "", "", err
+	}
+	return splitCPUs(cfg, topo)
+}
+
+// splitCPUs is resolveCPUs with the topology read from topo.
+func splitCPUs(cfg config.Config, topo topology.Detector) (string, string, error) {
+	osCPUs, gameCPUs, err := detectCPUs(cfg, topo)
+	if err != nil || cfg.SMT == "" || cfg.SMT == topology.SMTIgnore {
+		return osCPUs, gameCPUs, err
+	}
+	cores, err := topo.DetectCores()
+	if err != nil {
+		logging.Warnf(nil, "smt = %q: %v; using the sets as they are", cfg.SMT, err)
+		return osCPUs, gameCPUs, nil
+	}
+	o, g, err := topology.ApplySMT(cfg.SMT, osCPUs, gameCPUs, cores)
+	if err != nil {
+		return "", "", err
+	}
+	logging.Tracef(logging.Topology, nil, "smt=%s os_cpus=%q game_cpus=%q", cfg.SMT, o, g)
+	return o, g, nil
+}
+
+func detectCPUs(cfg config.Config, topo topology.Detector) (string, string, error) {
 	if strings.TrimSpace(cfg.OSCPUsOverride) != "" && strings.TrimSpace(cfg.GameCPUsOverride) != "" {
 		osCanonical, _, err := topology.CanonicalizeCPUList(cfg.OSCPUsOverride)
 		if err != nil {
@@ -197,64 +770,189 @@ func resolveCPUs(cfg config.Config) (string, string, error) {
 		if err != nil {
 			return "", "", fmt.Errorf("invalid game_cpus override: %w", err)
 		}
+		logging.Tracef(logging.Topology, nil, "using overrides os_cpus=%q game_cpus=%q", osCanonical, gameCanonical)
 		return osCanonical, gameCanonical, nil
 	}
 
-	res, err := topology.Detect()
+	res, err := topo.Detect(cfg.Prefer)
 	if err != nil {
 		return "", "", err
 	}
+	logging.Tracef(logging.Topology, nil, "detected clusters=%v prefer=%s os_cpus=%q game_cpus=%q", res.Clusters, cfg.Prefer, res.OSCPUs, res.GameCPUs)
+	res = refineFromBench(res, cfg.Prefer)
+	logging.Tracef(logging.Topology, nil, "after bench results os_cpus=%q game_cpus=%q", res.OSCPUs, res.GameCPUs)
+	if cfg.Cluster >= 0 {
+		return topology.SelectCluster(res.Clusters, cfg.Cluster)
+	}
 	if res.GameCPUs == "" {
-		return "", "", fmt.Errorf("topology detection found only one list: %v", res.Lists)
+		return "", "", fmt.Errorf("topology detection found only one cluster: %v", res.Clusters)
+	}
+	gameCPUs := res.GameCPUs
+	if gpuApplies(cfg) {
+		g, err := selectGPU(cfg.GPU)
+		if err != nil {
+			return "", "", err
+		}
+		if near := topology.PreferLocal(res.Clusters, res.OSCPUs, g.LocalCPUs); near != "" {
+			gameCPUs = near
+		}
+		logging.Tracef(logging.Topology, nil, "gpu %s local cpus %q: game_cpus=%q", cfg.GPU, g.LocalCPUs, gameCPUs)
+	}
+	return res.OSCPUs, gameCPUs, nil
+}
+
+// selectGPU picks the GPU named by the gpu config key (DRM node, PCI slot or
+// vendor). On multi-GPU systems this keeps locality decisions tied to the
+// discrete card rather than the iGPU.
+func selectGPU(filter string) (gpu.GPU, error) {
+	gpus, err := gpu.List()
+	if err != nil {
+		return gpu.GPU{}, fmt.Errorf("list gpus: %w", err)
+	}
+	g, err := gpu.Select(gpus, filter)
+	if err != nil {
+		return gpu.GPU{}, fmt.Errorf("select gpu: %w", err)
+	}
+	return g, nil
+}
+
+// gpuApplies reports whether the gpu key narrows the game CPUs, which it does
+// unless os_cpus/game_cpus or cluster choose them.
+func gpuApplies(cfg config.Config) bool {
+	if strings.TrimSpace(cfg.GPU) == "" || cfg.Cluster >= 0 {
+		return false
+	}
+	return strings.TrimSpace(cfg.OSCPUsOverride) == "" || strings.TrimSpace(cfg.GameCPUsOverride) == ""
+}
+
+// loadQuirks returns the quirks from the config's [quirks] tables.
+func loadQuirks(cfg config.Config) quirks.DB {
+	return quirks.DB{}.WithOverrides(cfg.QuirkOverrides)
+}
+
+// resolveMemoryNodes maps a CPU list to its NUMA nodes. It returns "" on
+// single-node systems or when sysfs has no node information.
+func resolveMemoryNodes(topo topology.Detector, cpus string) string {
+	nodes, err := topo.DetectNodes()
+	if err != nil {
+		log.Printf("numa detection: %v", err)
+		return ""
+	}
+	mems, err := nodes.MemoryNodesFor(cpus)
+	if err != nil {
+		log.Printf("numa detection: %v", err)
+		return ""
 	}
-	return res.OSCPUs, res.GameCPUs, nil
+	return mems
 }
 
-func restoreIfNeeded(ctx context.Context, scanner *procscan.Scanner, sys systemdctl.Systemctl, statePath string, st *state.File, slices []string) error {
+func restoreIfNeeded(ctx context.Context, r *runtime, sys systemdctl.Systemctl, statePath string, st *state.File, slices []string) error {
 	if !st.PinApplied {
 		return nil
 	}
-	games, err := scanner.Scan()
+	games, err := r.scanner.Scan()
 	if err != nil {
 		return err
 	}
 	if len(games) > 0 {
 		return nil
 	}
-	if err := restoreSlices(sys, slices, st.OriginalAllowedCPUs); err != nil {
+	restoreGuests(sys, st)
+	adoptModifiedBeforeRestore(r.cfg.RestorePolicy, sys, slices, st)
+	if err := restoreSlices(sys, slices, *st); err != nil {
 		return err
 	}
 	st.PinApplied = false
-	st.LastSuccessfulRestore = time.Now()
+	st.KeptAllowedCPUs = nil
+	st.LastSuccessfulRestore = r.clock.Now()
 	return state.Save(statePath, *st)
 }
 
 func handleTick(ctx context.Context, r *runtime, sys systemdctl.Systemctl, mgr *systemdctl.UserManager, statePath string, st *state.File, slices []string, games map[string][]procscan.GameProcess) error {
+	if r.delayPin(games, st.PinApplied, r.clock.Now()) {
+		return nil
+	}
 	if len(games) == 0 {
+		if st.PinApplied && r.keepPin(r.clock.Now()) {
+			return nil
+		}
+		r.keepUntil = time.Time{}
 		if st.PinApplied {
 			log.Printf("no games active; restoring slices")
-			if err := restoreSlices(sys, slices, st.OriginalAllowedCPUs); err != nil {
+			slices = r.withRecorded(ctx, sys, slices, *st)
+			restoreGuests(sys, st)
+			adoptModifiedBeforeRestore(r.cfg.RestorePolicy, sys, slices, st)
+			if err := restoreSlices(sys, slices, *st); err != nil {
 				return err
 			}
+			r.metrics.restores.Inc()
 			st.PinApplied = false
-			st.LastSuccessfulRestore = time.Now()
+			st.KeptAllowedCPUs = nil
+			st.ScopeFailures = nil
+			st.ScopePIDs = nil
+			st.LentCPUs = ""
+			st.LastSuccessfulRestore = r.clock.Now()
 			if err := state.Save(statePath, *st); err != nil {
 				return err
 			}
 			r.pidToUnit = map[int]pidRecord{}
+			r.scopeMems = nil
+			r.unboost()
+			r.resetSpread()
+			recordDecision(r, decision{}, nil)
 		}
 		return nil
 	}
 
+	// Cgroup globs are matched afresh every tick so new session scopes are
+	// picked up while games run.
+	slices = systemdctl.ExpandCgroupGlobs(slices)
+	slices = r.withDiscovered(ctx, sys, slices)
+
+	gameIDs := make([]string, 0, len(games))
+	for gameID := range games {
+		gameIDs = append(gameIDs, gameID)
+	}
+	sort.Strings(gameIDs)
+	r.sawGames(gameIDs)
+
+	db := r.effectiveQuirks()
+	d := decide(db, r.cfg.Profiles, r.osCPUs, r.gameCPUs, gameIDs)
+	osCPUs, gameCPUs, osMems := d.OSCPUs, d.GameCPUs, r.osMems
+	switch {
+	case d.Swapped && osCPUs == r.gameCPUs:
+		osMems = r.gameMems
+	case osMems != "" && osCPUs != r.osCPUs:
+		// A profile moved the OS slices; follow with the memory nodes.
+		osMems = resolveMemoryNodes(r.topo, osCPUs)
+	}
+	r.lendMax = d.Lend
+	if lent := keepLent(st.LentCPUs, gameCPUs, d.Lend); lent != st.LentCPUs {
+		log.Printf("returning lent cpus %s", st.LentCPUs)
+		st.LentCPUs = lent
+	}
+	if st.LentCPUs != "" {
+		osCPUs = topology.UnionCPULists(osCPUs, st.LentCPUs)
+	}
+
+	if err := sys.Bus.Watch(ctx, slices); err != nil {
+		logging.Debugf(nil, "watch slices: %v", err)
+	}
 	currentAllowed, err := readAllowedCPUs(sys, slices)
 	if err != nil {
 		return err
 	}
+	if adoptModified(r.cfg.RestorePolicy, currentAllowed, st) {
+		if err := state.Save(statePath, *st); err != nil {
+			return err
+		}
+	}
+	pinSlices := unkept(slices, *st)
 
 	reapplyNeeded := !st.PinApplied
 	if st.PinApplied {
-		for _, unit := range slices {
-			if currentAllowed[unit] != r.osCPUs {
+		for _, unit := range pinSlices {
+			if currentAllowed[unit] != osCPUs {
 				reapplyNeeded = true
 				break
 			}
@@ -264,7 +962,7 @@ func handleTick(ctx context.Context, r *runtime, sys systemdctl.Systemctl, mgr *
 			if _, ok := st.OriginalAllowedCPUs[unit]; !ok {
 				// If the unit is already pinned but we lack an original, don't blindly
 				// snapshot the pinned value as an "original".
-				if currentAllowed[unit] != r.osCPUs {
+				if currentAllowed[unit] != osCPUs {
 					reapplyNeeded = true
 					break
 				}
@@ -282,6 +980,7 @@ func handleTick(ctx context.Context, r *runtime, sys systemdctl.Systemctl, mgr *
 			for unit, val := range currentAllowed {
 				orig[unit] = val
 			}
+			st.KeptAllowedCPUs = nil
 		} else {
 			for unit, val := range currentAllowed {
 				if _, ok := orig[unit]; ok {
@@ -289,7 +988,7 @@ func handleTick(ctx context.Context, r *runtime, sys systemdctl.Systemctl, mgr *
 				}
 				// Backfill originals only if the unit is not already pinned; otherwise
 				// fall back to clearing AllowedCPUs on restore.
-				if val != r.osCPUs {
+				if val != osCPUs {
 					orig[unit] = val
 				} else {
 					orig[unit] = ""
@@ -301,101 +1000,414 @@ func handleTick(ctx context.Context, r *runtime, sys systemdctl.Systemctl, mgr *
 		if st.PinApplied {
 			msg = "games active; reapplying pin"
 		}
-		log.Printf("%s slices=%v to os_cpus=%q", msg, slices, r.osCPUs)
-		for _, unit := range slices {
+		log.Printf("%s slices=%v to os_cpus=%q", msg, pinSlices, osCPUs)
+		if st.PinApplied && st.OSCPUs != "" && st.OSCPUs != osCPUs {
+			if err := handoff(sys, pinSlices, r.gameScopes(), st.OSCPUs, osCPUs, st.GameCPUs, gameCPUs); err != nil {
+				return err
+			}
+		}
+		for _, unit := range pinSlices {
 			ctx2, cancel := systemdctl.DefaultContext()
-			err := sys.SetAllowedCPUs(ctx2, unit, r.osCPUs)
+			err := sys.SetAllowedCPUs(ctx2, unit, osCPUs)
 			cancel()
 			if err != nil {
 				return err
 			}
 		}
+		if osMems != "" {
+			pinMemoryNodes(sys, slices, osMems, st)
+		}
 		st.PinApplied = true
 		st.OriginalAllowedCPUs = orig
-		st.OSCPUs = r.osCPUs
-		st.GameCPUs = r.gameCPUs
-		st.LastSuccessfulPinApply = time.Now()
+		st.OSCPUs = osCPUs
+		st.GameCPUs = gameCPUs
+		st.LastSuccessfulPinApply = r.clock.Now()
+		if err := state.Save(statePath, *st); err != nil {
+			return err
+		}
+	}
+	if syncGuests(r, sys, st, r.guestCPUs(osCPUs)) {
 		if err := state.Save(statePath, *st); err != nil {
 			return err
 		}
 	}
+	recordDecision(r, d, gameIDs)
 
 	alive := make(map[int]struct{}, 32)
-	gameIDs := make([]string, 0, len(games))
-	for gameID := range games {
-		gameIDs = append(gameIDs, gameID)
-	}
-	sort.Strings(gameIDs)
+	scanned := make(map[int]bool, 32)
+	now := r.clock.Now()
+	failing := map[string]bool{}
+	failuresChanged := false
+	var groups []spread.Group
 
 	for _, gameID := range gameIDs {
 		procs := games[gameID]
-		unit := systemdctl.UnitNameForGameID(gameID)
+		unit := r.scopeFor(gameID)
 		if len(procs) == 0 {
 			continue
 		}
+		q, _ := db.Lookup(gameID)
+		if _, seen := r.quirkLogged[gameID]; !seen && len(q.Flags()) > 0 {
+			r.quirkLogged[gameID] = struct{}{}
+			logging.Infof(logging.Fields{"GAME_ID": gameID}, "game %s quirks: %s", gameID, strings.Join(q.Flags(), ","))
+		}
+		if q.NoTouchGame {
+			continue
+		}
 
-		pids := make([]int, 0, len(procs))
-		newPIDs := make([]int, 0, len(procs))
-		pidStarts := make(map[int]uint64, len(procs))
+		// A game whose scope cannot be placed is retried with backoff; the
+		// others are handled regardless.
+		if !scopeRetryDue(st, gameID, now) {
+			failing[gameID] = true
+			continue
+		}
+		desc := fmt.Sprintf("ccdbind game %s", gameID)
+		prof := r.cfg.Profiles[gameID]
+		procs, overlay := splitOverlay(procs)
+		procs, procs32 := splitBits(procs, r.cpus32(osCPUs))
+		overlayCPUs := r.overlayCPUs(osCPUs)
+		if overlayCPUs == "" {
+			procs = append(procs, overlay...)
+			overlay = nil
+		}
+		err := pinScope(ctx, r, sys, mgr, unit, desc, procs, d.gameCPUsFor(gameID), prof, alive, scanned)
+		if err == nil && len(procs32) > 0 {
+			unit32 := systemdctl.UnitNameForGameID(gameID + "-32")
+			err = pinScope(ctx, r, sys, mgr, unit32, desc+" (32-bit)", procs32, r.cpus32(osCPUs), prof, alive, scanned)
+		}
+		if err == nil && len(overlay) > 0 {
+			unitOverlay := systemdctl.UnitNameForGameID(gameID + "-overlay")
+			err = pinScope(ctx, r, sys, mgr, unitOverlay, desc+" (overlay)", overlay, overlayCPUs, config.Profile{}, alive, scanned)
+		}
+		if err != nil {
+			recordScopeFailure(st, gameID, err, now)
+			failing[gameID] = true
+			failuresChanged = true
+			continue
+		}
+		g := spread.Group{CPUs: d.gameCPUsFor(gameID)}
 		for _, gp := range procs {
-			alive[gp.PID] = struct{}{}
-			pidStarts[gp.PID] = gp.StartTime
+			if _, ok := alive[gp.PID]; ok && gp.IDSource != procscan.IDSourceOverlay {
+				g.PIDs = append(g.PIDs, gp.PID)
+			}
+		}
+		groups = append(groups, g)
+	}
+	r.spreadThreads(groups)
+	if pruneScopeFailures(st, failing) {
+		failuresChanged = true
+	}
 
-			pids = append(pids, gp.PID)
+	for pid := range r.pidToUnit {
+		if _, ok := alive[pid]; !ok {
+			delete(r.pidToUnit, pid)
+		}
+	}
+	r.pruneBoosted(alive)
+	scopesChanged := r.syncScopePIDs(st)
+	if failuresChanged || scopesChanged {
+		if err := state.Save(statePath, *st); err != nil {
+			return err
+		}
+	}
+	for pid := range r.refused {
+		if !scanned[pid] {
+			delete(r.refused, pid)
+		}
+	}
 
-			rec, ok := r.pidToUnit[gp.PID]
-			if !ok || rec.unit != unit {
-				newPIDs = append(newPIDs, gp.PID)
-				continue
-			}
-			if rec.startTime == 0 || gp.StartTime == 0 {
-				newPIDs = append(newPIDs, gp.PID)
-				continue
-			}
-			if rec.startTime != gp.StartTime {
-				newPIDs = append(newPIDs, gp.PID)
-			}
+	return nil
+}
+
+// pinScope moves procs into unit (creating it under game.slice if needed)
+// and pins the unit to cpus. The profile's cpu_weight is set when the scope
+// is created and its priority boost on every newly placed process.
+func pinScope(ctx context.Context, r *runtime, sys systemdctl.Systemctl, mgr *systemdctl.UserManager, unit, desc string, procs []procscan.GameProcess, cpus string, prof config.Profile, alive map[int]struct{}, scanned map[int]bool) error {
+	pids := make([]int, 0, len(procs))
+	newPIDs := make([]int, 0, len(procs))
+	pidStarts := make(map[int]uint64, len(procs))
+	for _, gp := range procs {
+		scanned[gp.PID] = true
+		if r.paranoid && !r.ownsCgroup(gp.PID) {
+			continue
 		}
+		alive[gp.PID] = struct{}{}
+		pidStarts[gp.PID] = gp.StartTime
 
-		desc := fmt.Sprintf("ccdbind game %s", gameID)
-		ctx2, cancel := context.WithTimeout(ctx, 10*time.Second)
-		created, err := mgr.EnsureTransientScope(ctx2, unit, pids, "game.slice", desc)
-		cancel()
-		if err != nil {
-			return fmt.Errorf("EnsureTransientScope %s: %w", unit, err)
+		pids = append(pids, gp.PID)
+
+		rec, ok := r.pidToUnit[gp.PID]
+		if !ok || rec.unit != unit {
+			newPIDs = append(newPIDs, gp.PID)
+			continue
+		}
+		if rec.startTime == 0 || gp.StartTime == 0 {
+			newPIDs = append(newPIDs, gp.PID)
+			continue
+		}
+		if rec.startTime != gp.StartTime {
+			newPIDs = append(newPIDs, gp.PID)
 		}
+	}
 
-		ctx2, cancel = systemdctl.DefaultContext()
-		err = sys.SetAllowedCPUs(ctx2, unit, r.gameCPUs)
+	if len(pids) == 0 {
+		return nil
+	}
+
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Second)
+	created, err := mgr.EnsureTransientScope(ctx2, unit, pids, "game.slice", desc, uint64(prof.CPUWeight))
+	cancel()
+	if err != nil {
+		return fmt.Errorf("EnsureTransientScope %s: %w", unit, err)
+	}
+
+	ctx2, cancel = systemdctl.DefaultContext()
+	err = sys.SetAllowedCPUs(ctx2, unit, cpus)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("pin scope %s: %w", unit, err)
+	}
+	if created {
+		delete(r.scopeMems, unit)
+	}
+	r.pinScopeMemoryNodes(sys, unit, cpus)
+
+	r.boostPIDs(unit, newPIDs, pidStarts, r.boostFor(prof))
+
+	if created {
+		logging.Infof(logging.Fields{"UNIT": unit}, "created %s for %d pid(s) on cpus %s", unit, len(pids), cpus)
+		for _, pid := range pids {
+			r.pidToUnit[pid] = pidRecord{unit: unit, startTime: pidStarts[pid]}
+		}
+	} else if len(newPIDs) > 0 {
+		ctx2, cancel = context.WithTimeout(ctx, 5*time.Second)
+		err = mgr.AttachProcessesToUnit(ctx2, unit, "", newPIDs)
 		cancel()
 		if err != nil {
-			return fmt.Errorf("pin scope %s: %w", unit, err)
+			return fmt.Errorf("AttachProcessesToUnit %s: %w", unit, err)
+		}
+		for _, pid := range newPIDs {
+			r.pidToUnit[pid] = pidRecord{unit: unit, startTime: pidStarts[pid]}
+			logging.Tracef(logging.Pin, logging.Fields{"UNIT": unit, "PID": strconv.Itoa(pid)}, "attached pid %d to %s", pid, unit)
 		}
+	}
+	return nil
+}
 
-		if created {
-			for _, pid := range pids {
-				r.pidToUnit[pid] = pidRecord{unit: unit, startTime: pidStarts[pid]}
-			}
-		} else if len(newPIDs) > 0 {
-			ctx2, cancel = context.WithTimeout(ctx, 5*time.Second)
-			err = mgr.AttachProcessesToUnit(ctx2, unit, "", newPIDs)
+// cpus32 resolves the cpus_32bit setting against the current OS CPUs. It
+// returns "" when 32-bit processes should stay with the rest of the game.
+func (r *runtime) cpus32(osCPUs string) string {
+	switch v := r.cfg.CPUs32Bit; v {
+	case "", "game":
+		return ""
+	case "os":
+		return osCPUs
+	default:
+		return v
+	}
+}
+
+// overlayCPUs resolves the overlay_cpus setting against the current OS CPUs.
+// It returns "" when the overlay goes with the rest of the game; Scan only
+// reports overlays when the setting is on.
+func (r *runtime) overlayCPUs(osCPUs string) string {
+	switch v := r.cfg.OverlayCPUs; v {
+	case "", "game":
+		return ""
+	case "os":
+		return osCPUs
+	default:
+		return v
+	}
+}
+
+// splitOverlay separates the Steam overlay processes.
+func splitOverlay(procs []procscan.GameProcess) (rest, overlay []procscan.GameProcess) {
+	rest = make([]procscan.GameProcess, 0, len(procs))
+	for _, gp := range procs {
+		if gp.IDSource == procscan.IDSourceOverlay {
+			overlay = append(overlay, gp)
+			continue
+		}
+		rest = append(rest, gp)
+	}
+	return rest, overlay
+}
+
+// splitBits separates 32-bit processes when cpus32 is set.
+func splitBits(procs []procscan.GameProcess, cpus32 string) (rest, bits32 []procscan.GameProcess) {
+	if cpus32 == "" {
+		return procs, nil
+	}
+	rest = make([]procscan.GameProcess, 0, len(procs))
+	for _, gp := range procs {
+		if gp.Bits == 32 {
+			bits32 = append(bits32, gp)
+			continue
+		}
+		rest = append(rest, gp)
+	}
+	return rest, bits32
+}
+
+// ownsCgroup reports whether pid currently sits inside the user manager's
+// cgroup subtree. Paranoid mode refuses to move anything else, e.g. processes
+// in a system-level scope that merely run under the user's UID.
+func (r *runtime) ownsCgroup(pid int) bool {
+	cg, err := procscan.Cgroup(pid)
+	if err != nil {
+		return false
+	}
+	if !procscan.InUserManager(cg, r.uid) {
+		if _, seen := r.refused[pid]; !seen {
+			r.refused[pid] = struct{}{}
+			logging.Warnf(logging.Fields{"PID": strconv.Itoa(pid)}, "paranoid: skipping pid %d outside user manager (cgroup %s)", pid, cg)
+		}
+		return false
+	}
+	return true
+}
+
+const (
+	osSaturatedThreshold = 0.9
+	osSaturatedAfter     = 30 * time.Second
+)
+
+// trackOSLoad samples OS CPU utilization while pinned and records in the
+// state file when it has stayed above osSaturatedThreshold for
+// osSaturatedAfter, so `ccdbind status` can point at an undersized OS set.
+func trackOSLoad(r *runtime, statePath string, st *state.File) {
+	cur, err := cpuload.Sample()
+	if err != nil {
+		return
+	}
+	prev := r.loadSample
+	r.loadSample = cur
+	if !st.PinApplied {
+		r.osBusyFrom = time.Time{}
+		r.lendSince = time.Time{}
+		if !st.OSSaturatedSince.IsZero() {
+			st.OSSaturatedSince = time.Time{}
+			_ = state.Save(statePath, *st)
+		}
+		return
+	}
+	if prev == nil {
+		return
+	}
+	now := r.clock.Now()
+	if r.trackLending(prev, cur, st, now) {
+		_ = state.Save(statePath, *st)
+	}
+	_, cpus, err := topology.CanonicalizeCPUList(st.OSCPUs)
+	if err != nil || len(cpus) == 0 {
+		return
+	}
+
+	busy := cpuload.Utilization(prev, cur, cpus) >= osSaturatedThreshold
+	switch {
+	case busy && r.osBusyFrom.IsZero():
+		r.osBusyFrom = now
+	case busy && st.OSSaturatedSince.IsZero() && now.Sub(r.osBusyFrom) >= osSaturatedAfter:
+		st.OSSaturatedSince = r.osBusyFrom
+		logging.Warnf(nil, "os_cpus=%q above %.0f%% for %s; consider a larger OS set", st.OSCPUs, osSaturatedThreshold*100, now.Sub(r.osBusyFrom).Round(time.Second))
+		_ = state.Save(statePath, *st)
+	case !busy:
+		r.osBusyFrom = time.Time{}
+		if !st.OSSaturatedSince.IsZero() {
+			st.OSSaturatedSince = time.Time{}
+			_ = state.Save(statePath, *st)
+		}
+	}
+}
+
+// handoff moves running games and OS slices from one CPU split to another
+// without ever squeezing either side: OS slices and game scopes are first
+// widened to the union of old and new sets, then the scopes are narrowed to
+// the new GAME CPUs. The caller narrows the slices to the new OS CPUs last.
+func handoff(sys systemdctl.Systemctl, slices, scopes []string, oldOS, newOS, oldGame, newGame string) error {
+	wideOS := topology.UnionCPULists(oldOS, newOS)
+	wideGame := topology.UnionCPULists(oldGame, newGame)
+	log.Printf("handoff os_cpus %q -> %q, game_cpus %q -> %q", oldOS, newOS, oldGame, newGame)
+	steps := []struct {
+		units []string
+		cpus  string
+	}{
+		{slices, wideOS},
+		{scopes, wideGame},
+		{scopes, newGame},
+	}
+	for _, step := range steps {
+		for _, unit := range step.units {
+			ctx2, cancel := systemdctl.DefaultContext()
+			err := sys.SetAllowedCPUs(ctx2, unit, step.cpus)
 			cancel()
 			if err != nil {
-				return fmt.Errorf("AttachProcessesToUnit %s: %w", unit, err)
-			}
-			for _, pid := range newPIDs {
-				r.pidToUnit[pid] = pidRecord{unit: unit, startTime: pidStarts[pid]}
+				return fmt.Errorf("handoff %s: %w", unit, err)
 			}
 		}
 	}
+	return nil
+}
 
-	for pid := range r.pidToUnit {
-		if _, ok := alive[pid]; !ok {
-			delete(r.pidToUnit, pid)
+// syncScopePIDs copies the tracked scope members into st and reports
+// whether they changed.
+func (r *runtime) syncScopePIDs(st *state.File) bool {
+	same := len(st.ScopePIDs) == len(r.pidToUnit)
+	for pid, rec := range r.pidToUnit {
+		if !same {
+			break
 		}
+		same = st.ScopePIDs[pid] == state.ScopePID{Unit: rec.unit, StartTime: rec.startTime}
+	}
+	if same {
+		return false
 	}
+	st.ScopePIDs = nil
+	if len(r.pidToUnit) > 0 {
+		st.ScopePIDs = make(map[int]state.ScopePID, len(r.pidToUnit))
+		for pid, rec := range r.pidToUnit {
+			st.ScopePIDs[pid] = state.ScopePID{Unit: rec.unit, StartTime: rec.startTime}
+		}
+	}
+	return true
+}
 
-	return nil
+// loadScopePIDs resumes tracking the scope members saved by a previous run
+// that are still the same process and still in their scope.
+func (r *runtime) loadScopePIDs(st state.File) {
+	for pid, sp := range st.ScopePIDs {
+		if start, err := procscan.StartTime(pid); err != nil || (sp.StartTime != 0 && start != sp.StartTime) {
+			continue
+		}
+		if cg, err := procscan.Cgroup(pid); err != nil || !strings.HasSuffix(cg, "/"+sp.Unit) {
+			continue
+		}
+		r.pidToUnit[pid] = pidRecord{unit: sp.Unit, startTime: sp.StartTime}
+	}
+	if len(r.pidToUnit) > 0 {
+		logging.Debugf(nil, "resumed tracking %d pid(s) in %s", len(r.pidToUnit), strings.Join(r.gameScopes(), ", "))
+	}
+}
+
+// tracksScope reports whether unit holds tracked game PIDs.
+func (r *runtime) tracksScope(unit string) bool {
+	for _, rec := range r.pidToUnit {
+		if rec.unit == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// gameScopes returns the scope units currently holding tracked game PIDs.
+func (r *runtime) gameScopes() []string {
+	units := make([]string, 0, 4)
+	for _, rec := range r.pidToUnit {
+		units = append(units, rec.unit)
+	}
+	sort.Strings(units)
+	return dedupe(units)
 }
 
 func readAllowedCPUs(sys systemdctl.Systemctl, slices []string) (map[string]string, error) {
@@ -412,9 +1424,97 @@ func readAllowedCPUs(sys systemdctl.Systemctl, slices []string) (map[string]stri
 	return out, nil
 }
 
-func restoreSlices(sys systemdctl.Systemctl, slices []string, originals map[string]string) error {
+// pinScopeMemoryNodes keeps a game scope's memory on the NUMA nodes of its
+// CPUs when pin_memory_nodes is on. It is only called again once the nodes
+// change; a failure is logged and the CPUs stay pinned.
+func (r *runtime) pinScopeMemoryNodes(sys systemdctl.Systemctl, unit, cpus string) {
+	if !r.cfg.PinMemoryNodes {
+		return
+	}
+	var mems string
+	switch cpus {
+	case r.gameCPUs:
+		mems = r.gameMems
+	case r.osCPUs:
+		mems = r.osMems
+	default:
+		mems = resolveMemoryNodes(r.topo, cpus)
+	}
+	if mems == "" || r.scopeMems[unit] == mems {
+		return
+	}
+	if r.scopeMems == nil {
+		r.scopeMems = map[string]string{}
+	}
+	r.scopeMems[unit] = mems
+	ctx, cancel := systemdctl.DefaultContext()
+	err := sys.SetAllowedMemoryNodes(ctx, unit, mems)
+	cancel()
+	if err != nil {
+		logging.Warnf(logging.Fields{"UNIT": unit}, "pin AllowedMemoryNodes %s: %v", unit, err)
+	}
+}
+
+// pinMemoryNodes restricts the slices to the OS memory nodes. Originals are
+// snapshotted on the first pin and backfilled for units added later. Failures
+// are logged rather than returned: memory placement is an optional extra on
+// top of the CPU pin.
+func pinMemoryNodes(sys systemdctl.Systemctl, slices []string, mems string, st *state.File) {
+	if !st.PinApplied || st.OriginalAllowedMemoryNodes == nil {
+		st.OriginalAllowedMemoryNodes = map[string]string{}
+	}
 	for _, unit := range slices {
-		val := originals[unit]
+		if _, ok := st.OriginalAllowedMemoryNodes[unit]; !ok {
+			ctx2, cancel := systemdctl.DefaultContext()
+			val, err := sys.GetAllowedMemoryNodes(ctx2, unit)
+			cancel()
+			if err != nil {
+				logging.Warnf(logging.Fields{"UNIT": unit}, "read AllowedMemoryNodes %s: %v", unit, err)
+				continue
+			}
+			if val == mems {
+				val = ""
+			}
+			st.OriginalAllowedMemoryNodes[unit] = val
+		}
+		ctx2, cancel := systemdctl.DefaultContext()
+		err := sys.SetAllowedMemoryNodes(ctx2, unit, mems)
+		cancel()
+		if err != nil {
+			logging.Warnf(logging.Fields{"UNIT": unit}, "pin AllowedMemoryNodes %s: %v", unit, err)
+		}
+	}
+	st.OSMemoryNodes = mems
+}
+
+// restoreTargets resolves configured slices for a restore: cgroup globs
+// become the cgroups that were pinned under them, and cgroups that have
+// since disappeared are skipped.
+func restoreTargets(slices []string, st state.File) []string {
+	out := make([]string, 0, len(slices))
+	for _, entry := range slices {
+		if !systemdctl.IsCgroupPath(entry) {
+			out = append(out, entry)
+			continue
+		}
+		matched := make([]string, 0, 4)
+		for _, m := range []map[string]string{st.OriginalAllowedCPUs, st.OriginalAllowedMemoryNodes} {
+			for unit := range m {
+				if systemdctl.MatchCgroupGlob(entry, unit) {
+					matched = append(matched, unit)
+				}
+			}
+		}
+		sort.Strings(matched)
+		out = append(out, matched...)
+	}
+	return systemdctl.ExpandCgroupGlobs(dedupe(out))
+}
+
+func restoreSlices(sys systemdctl.Systemctl, slices []string, st state.File) error {
+	slices = restoreTargets(slices, st)
+	for _, unit := range slices {
+		val := st.OriginalAllowedCPUs[unit]
 		ctx2, cancel := systemdctl.DefaultContext()
 		err := sys.SetAllowedCPUs(ctx2, unit, val)
 		cancel()
@@ -422,6 +1522,18 @@ func restoreSlices(sys systemdctl.Systemctl, slices []string, originals map[stri
 			return err
 		}
 	}
+	for _, unit := range slices {
+		val, ok := st.OriginalAllowedMemoryNodes[unit]
+		if !ok {
+			continue
+		}
+		ctx2, cancel := systemdctl.DefaultContext()
+		err := sys.SetAllowedMemoryNodes(ctx2, unit, val)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -443,6 +1555,6 @@ func dedupe(in []string) []string {
 }
 
 func fatal(err error) {
-	log.Printf("fatal: %v", err)
+	logging.Errorf(nil, "fatal: %v", err)
 	os.Exit(1)
 }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// forcedPin is a process pinned with `ccdbind pin PID`. startTime guards
+// against the PID being reused once the process exits.
+type forcedPin struct {
+	gameID    string
+	startTime uint64
+}
+
+// manualGameID groups `ccdbind pin PID` processes given no game ID.
+const manualGameID = "manual"
+
+// handle runs a control request that changes daemon state on the main loop.
+func (d *daemon) handle(ctx context.Context, req controlRequest) error {
+	switch req.Op {
+	case "apply":
+		return d.apply(ctx, []byte(req.Config))
+	case "pin":
+		return d.pin(ctx, req.PID, req.GameID)
+	case "unpin":
+		return d.unpin()
+	case "pause":
+		return d.pause(req.For)
+	case "resume":
+		return d.resume(ctx)
+	case "toggle":
+		if d.st.Paused {
+			return d.resume(ctx)
+		}
+		return d.pause(req.For)
+	}
+	return fmt.Errorf("unknown op %q", req.Op)
+}
+
+// pin forces pid into a game scope until it exits. Without a pid it lifts a
+// previous unpin so the running games are pinned again.
+func (d *daemon) pin(ctx context.Context, pid int, gameID string) error {
+	if d.st.Paused {
+		return errors.New("automation is paused; run `ccdbind resume` first")
+	}
+	if pid == 0 {
+		d.r.unpinned = nil
+		log.Printf("manual pin: pinning active games again")
+		return d.tick(ctx)
+	}
+	if gameID == "" {
+		gameID = manualGameID
+	}
+	gp, err := d.r.scanner.Adopt(pid, gameID, "manual")
+	if err != nil {
+		return err
+	}
+	gameID = gp.GameID
+	if d.r.forced == nil {
+		d.r.forced = map[int]forcedPin{}
+	}
+	d.r.forced[pid] = forcedPin{gameID: gameID, startTime: gp.StartTime}
+	delete(d.r.unpinned, gameID)
+	logging.Infof(logging.Fields{"GAME_ID": gameID, "PID": strconv.Itoa(pid)}, "manual pin: pid %d as game %s", pid, gameID)
+	return d.tick(ctx)
+}
+
+// unpin restores the slices and game scopes now. Games running at this point
+// are left alone until they exit; games started later are pinned as usual.
+func (d *daemon) unpin() error {
+	games, err := d.r.scanner.Scan()
+	if err != nil {
+		return fmt.Errorf("scan: %w", err)
+	}
+	applyPinRequests(d.r, games)
+	d.r.unpinned = make(map[string]struct{}, len(games))
+	for id := range games {
+		d.r.unpinned[id] = struct{}{}
+	}
+	d.r.forced = nil
+	log.Printf("manual unpin: restoring; leaving %d active game(s) alone until they exit", len(games))
+	return d.forceRestore()
+}
+
+// pause restores everything and stops acting on games until resume, or
+// until dur has passed when it is positive. The pause survives restarts.
+func (d *daemon) pause(dur time.Duration) error {
+	d.st.Paused = true
+	d.st.PausedUntil = time.Time{}
+	d.pauseC = nil
+	if dur > 0 {
+		d.st.PausedUntil = d.r.clock.Now().Add(dur)
+		d.pauseC = d.r.clock.After(dur)
+		log.Printf("automation paused for %s", dur)
+	} else {
+		log.Printf("automation paused")
+	}
+	return d.forceRestore()
+}
+
+func (d *daemon) resume(ctx context.Context) error {
+	if !d.st.Paused {
+		return nil
+	}
+	d.st.Paused = false
+	d.st.PausedUntil = time.Time{}
+	d.pauseC = nil
+	if err := state.Save(d.statePath, *d.st); err != nil {
+		return err
+	}
+	log.Printf("automation resumed")
+	return d.tick(ctx)
+}
+
+// armPause schedules the end of a timed pause loaded from the state file.
+func (d *daemon) armPause(ctx context.Context) {
+	if !d.st.Paused || d.st.PausedUntil.IsZero() {
+		return
+	}
+	if now := d.r.clock.Now(); now.Before(d.st.PausedUntil) {
+		d.pauseC = d.r.clock.After(d.st.PausedUntil.Sub(now))
+		return
+	}
+	if err := d.resume(ctx); err != nil {
+		log.Printf("resume: %v", err)
+	}
+}
+
+// forceRestore returns the slices to their original CPUs and lets the game
+// scopes use every CPU again, whether or not games are running.
+func (d *daemon) forceRestore() error {
+	d.r.resetSpread()
+	for _, unit := range d.r.gameScopes() {
+		ctx2, cancel := systemdctl.DefaultContext()
+		err := d.sys.SetAllowedCPUs(ctx2, unit, "")
+		cancel()
+		if err != nil {
+			logging.Warnf(logging.Fields{"UNIT": unit}, "unpin %s: %v", unit, err)
+		}
+	}
+	restoreGuests(d.sys, d.st)
+	if d.st.PinApplied {
+		slices := d.r.withRecorded(context.Background(), d.sys, d.r.slices, *d.st)
+		adoptModifiedBeforeRestore(d.r.cfg.RestorePolicy, d.sys, slices, d.st)
+		if err := restoreSlices(d.sys, slices, *d.st); err != nil {
+			return err
+		}
+		d.r.metrics.restores.Inc()
+		d.st.PinApplied = false
+		d.st.KeptAllowedCPUs = nil
+		d.st.LastSuccessfulRestore = d.r.clock.Now()
+	}
+	d.st.ScopeFailures = nil
+	d.st.ScopePIDs = nil
+	d.st.LentCPUs = ""
+	d.r.syncQoS(false)
+	d.syncIRQ(false)
+	d.syncSMT(false)
+	d.syncPower(false)
+	d.syncPowerProfile(false)
+	d.syncUndo(d.st.PinApplied)
+	d.r.pidToUnit = map[int]pidRecord{}
+	d.r.scopeMems = nil
+	d.r.unboost()
+	d.r.keepUntil = time.Time{}
+	d.r.pinAt = time.Time{}
+	d.r.metrics.pinApplied.Set(0)
+	d.r.metrics.scopes.Set(0)
+	d.r.notifyEvents(nil, d.st, nil)
+	recordDecision(d.r, decision{}, nil)
+	return state.Save(d.statePath, *d.st)
+}
+
+// applyManual adds forced pins to the scanned games and drops games that were
+// running at the last unpin. Unpinned IDs are forgotten once they are gone.
+func (r *runtime) applyManual(games map[string][]procscan.GameProcess) {
+	for id := range r.unpinned {
+		if _, ok := games[id]; !ok {
+			delete(r.unpinned, id)
+		}
+	}
+	for pid, f := range r.forced {
+		gp, err := r.scanner.Adopt(pid, f.gameID, "manual")
+		if err != nil || gp.StartTime != f.startTime {
+			delete(r.forced, pid)
+			continue
+		}
+		moveProcess(games, gp)
+	}
+	for id := range r.unpinned {
+		delete(games, id)
+	}
+}
+
+func runPin(args []string) {
+	req := controlRequest{Op: "pin"}
+	switch len(args) {
+	case 2:
+		req.GameID = strings.TrimSpace(args[1])
+		fallthrough
+	case 1:
+		pid, err := strconv.Atoi(args[0])
+		if err != nil || pid <= 0 {
+			fatal(fmt.Errorf("invalid pid %q", args[0]))
+		}
+		req.PID = pid
+	case 0:
+	default:
+		fmt.Fprintln(os.Stderr, "usage: ccdbind pin [PID [GAME_ID]]")
+		os.Exit(2)
+	}
+	controlRun(req)
+	if req.PID == 0 {
+		fmt.Println("pinning active games")
+	} else {
+		fmt.Printf("pid %d pinned\n", req.PID)
+	}
+}
+
+func runUnpin(args []string) {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: ccdbind unpin")
+		os.Exit(2)
+	}
+	controlRun(controlRequest{Op: "unpin"})
+	fmt.Println("restored; running games are left unpinned until they exit")
+}
+
+func runPause(args []string) {
+	req := controlRequest{Op: "pause"}
+	switch len(args) {
+	case 1:
+		dur, err := time.ParseDuration(args[0])
+		if err != nil || dur <= 0 {
+			fatal(fmt.Errorf("invalid duration %q", args[0]))
+		}
+		req.For = dur
+	case 0:
+	default:
+		fmt.Fprintln(os.Stderr, "usage: ccdbind pause [DURATION]")
+		os.Exit(2)
+	}
+	controlRun(req)
+	if req.For > 0 {
+		fmt.Printf("paused for %s\n", req.For)
+	} else {
+		fmt.Println("paused; run `ccdbind resume` to continue")
+	}
+}
+
+func runResume(args []string) {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: ccdbind resume")
+		os.Exit(2)
+	}
+	controlRun(controlRequest{Op: "resume"})
+	fmt.Println("resumed")
+}
+
+// runToggle pauses a running daemon or resumes a paused one, so a single
+// desktop shortcut can do both.
+func runToggle(args []string) {
+	req := controlRequest{Op: "toggle"}
+	switch len(args) {
+	case 1:
+		dur, err := time.ParseDuration(args[0])
+		if err != nil || dur <= 0 {
+			fatal(fmt.Errorf("invalid duration %q", args[0]))
+		}
+		req.For = dur
+	case 0:
+	default:
+		fmt.Fprintln(os.Stderr, "usage: ccdbind toggle [PAUSE_DURATION]")
+		os.Exit(2)
+	}
+	controlRun(req)
+	fmt.Println(pausedState())
+}
+
+// pausedState describes the pause state the daemon last saved.
+func pausedState() string {
+	path, err := state.DefaultPath()
+	if err != nil {
+		return "toggled"
+	}
+	st, err := state.Load(path)
+	switch {
+	case err != nil:
+		return "toggled"
+	case !st.Paused:
+		return "resumed"
+	case !st.PausedUntil.IsZero():
+		return fmt.Sprintf("paused until %s", st.PausedUntil.Format(time.Kitchen))
+	}
+	return "paused"
+}
+
+// controlRun sends req to the daemon and exits on failure.
+func controlRun(req controlRequest) {
+	resp, err := controlCall(req, 5*time.Second)
+	if err != nil {
+		fatal(err)
+	}
+	if !resp.OK {
+		fatal(errors.New(strings.TrimSpace(resp.Error)))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/metrics"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// daemonMetrics are updated by the main loop and served on metrics_listen.
+// They are always collected; serving them is optional.
+type daemonMetrics struct {
+	reg        *metrics.Registry
+	pinApplied *metrics.Gauge
+	games      *metrics.Gauge
+	scopes     *metrics.Gauge
+	scan       *metrics.Summary
+	tick       *metrics.Summary
+	restores   *metrics.Counter
+}
+
+func newDaemonMetrics() *daemonMetrics {
+	reg := metrics.NewRegistry()
+	m := &daemonMetrics{
+		reg:        reg,
+		pinApplied: reg.Gauge("ccdbind_pin_applied", "Whether the OS slices are currently pinned (1) or restored (0)."),
+		games:      reg.Gauge("ccdbind_active_games", "Game IDs handled by the last tick."),
+		scopes:     reg.Gauge("ccdbind_game_scopes", "Game scopes holding tracked game processes."),
+		scan:       reg.Summary("ccdbind_scan_duration_seconds", "Time spent scanning /proc for games."),
+		tick:       reg.Summary("ccdbind_tick_duration_seconds", "Time spent in a full tick."),
+		restores:   reg.Counter("ccdbind_restores_total", "Slice restores performed."),
+	}
+	reg.CounterFunc("ccdbind_systemd_errors_total", "Failed systemctl, D-Bus and cgroupfs calls.", "op", systemdctl.ErrorCounts)
+	return m
+}
+
+// serveMetrics exposes m over HTTP at /metrics until ctx is cancelled.
+func serveMetrics(ctx context.Context, addr string, m *daemonMetrics) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.reg.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("metrics: %v", err)
+		}
+	}()
+	log.Printf("metrics listening on http://%s/metrics", ln.Addr())
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/notify"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/state"
+)
+
+// notice is one desktop notification, see notifyEvents.
+type notice struct {
+	summary string
+	body    string
+	urgency notify.Urgency
+}
+
+// noticeState remembers what was last reported, so each event is shown once.
+type noticeState struct {
+	pinned bool
+	games  map[string]bool // games reported as pinned
+	failed map[string]bool // games reported as failing; "" for the tick
+}
+
+// update compares the outcome of a tick with what was last reported and
+// returns the notices to show: games newly pinned, a tick or a game scope
+// failing for the first time, and the slices being restored. games holds
+// the active games, nil after a pause or unpin; err is the tick's error.
+func (s *noticeState) update(games map[string][]procscan.GameProcess, st *state.File, err error) []notice {
+	if s.games == nil {
+		s.games, s.failed = map[string]bool{}, map[string]bool{}
+	}
+	var out []notice
+	if err != nil {
+		if !s.failed[""] {
+			s.failed[""] = true
+			out = append(out, notice{summary: "Could not pin games", body: err.Error(), urgency: notify.Critical})
+		}
+	} else {
+		delete(s.failed, "")
+	}
+
+	ids := make([]string, 0, len(games))
+	for id := range games {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var pinned []string
+	for _, id := range ids {
+		if f, ok := st.ScopeFailures[id]; ok {
+			if !s.failed[id] {
+				s.failed[id] = true
+				out = append(out, notice{summary: "Could not pin " + gameLabel(id, games[id]), body: f.LastError, urgency: notify.Critical})
+			}
+			continue
+		}
+		delete(s.failed, id)
+		if st.PinApplied && err == nil && !s.games[id] {
+			s.games[id] = true
+			pinned = append(pinned, gameLabel(id, games[id]))
+		}
+	}
+	for id := range s.games {
+		if _, ok := games[id]; !ok {
+			delete(s.games, id)
+		}
+	}
+	for id := range s.failed {
+		if _, ok := games[id]; !ok && id != "" {
+			delete(s.failed, id)
+		}
+	}
+	if len(pinned) > 0 {
+		out = append(out, notice{
+			summary: "Pinned " + strings.Join(pinned, ", "),
+			body:    fmt.Sprintf("Game CPUs %s, OS CPUs %s", st.GameCPUs, st.OSCPUs),
+			urgency: notify.Low,
+		})
+	}
+	if s.pinned && !st.PinApplied {
+		out = append(out, notice{summary: "Restored CPU placement", body: "The OS slices may use every CPU again.", urgency: notify.Low})
+	}
+	s.pinned = st.PinApplied
+	return out
+}
+
+// gameLabel names a game by the executable of its main process and its ID.
+func gameLabel(id string, procs []procscan.GameProcess) string {
+	for _, p := range procs {
+		if p.Exe != "" && p.IDSource != procscan.IDSourceParent && p.IDSource != procscan.IDSourceOverlay {
+			return fmt.Sprintf("%s (%s)", p.Exe, id)
+		}
+	}
+	return "game " + id
+}
+
+// noticer sends notices from a goroutine of its own, so a slow notification
+// server never holds up a tick.
+type noticer struct {
+	noticeState
+	queue chan notice
+	done  chan struct{}
+}
+
+// notifyEvents reports the outcome of a tick with desktop notifications
+// when notifications is set, see noticeState.update.
+func (r *runtime) notifyEvents(games map[string][]procscan.GameProcess, st *state.File, err error) {
+	if r.notices == nil {
+		r.notices = &noticer{}
+	}
+	n := r.notices
+	out := n.update(games, st, err)
+	if !r.cfg.Notifications || len(out) == 0 {
+		return
+	}
+	if n.queue == nil {
+		n.queue = make(chan notice, 8)
+		n.done = make(chan struct{})
+		go sendNotices(n.queue, n.done)
+	}
+	for _, nt := range out {
+		select {
+		case n.queue <- nt:
+		default:
+			logging.Debugf(nil, "notifications: queue full, dropping %q", nt.summary)
+		}
+	}
+}
+
+// closeNotices sends the queued notices, waiting at most two seconds.
+func (r *runtime) closeNotices() {
+	if r.notices == nil || r.notices.queue == nil {
+		return
+	}
+	close(r.notices.queue)
+	select {
+	case <-r.notices.done:
+	case <-time.After(2 * time.Second):
+	}
+	r.notices.queue = nil
+}
+
+// sendNotices shows each notice in place of the previous low-urgency one, so
+// they do not pile up while failures stay visible. The session bus is dialed
+// on first use and again after an error.
+func sendNotices(queue <-chan notice, done chan<- struct{}) {
+	defer close(done)
+	var n *notify.Notifier
+	var last uint32
+	warned := false
+	for nt := range queue {
+		if n == nil {
+			var err error
+			if n, err = notify.Dial(); err != nil {
+				if !warned {
+					logging.Warnf(nil, "notifications: %v", err)
+					warned = true
+				}
+				continue
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		id, err := n.Notify(ctx, nt.summary, nt.body, nt.urgency, last)
+		cancel()
+		if err != nil {
+			if !warned {
+				logging.Warnf(nil, "notifications: %v", err)
+				warned = true
+			}
+			_ = n.Close()
+			n, last = nil, 0
+			continue
+		}
+		warned = false
+		last = 0
+		if nt.urgency == notify.Low {
+			last = id
+		}
+	}
+	if n != nil {
+		_ = n.Close()
+	}
+}
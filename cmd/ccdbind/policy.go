@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/history"
+	"github.com/Reidond/ccdbind/internal/quirks"
+)
+
+// decision is the outcome of the pinning policy for one set of active games.
+// It is kept free of side effects so `ccdbind replay` can evaluate recorded
+// events against the current config.
+type decision struct {
+	Pinned    bool
+	OSCPUs    string
+	GameCPUs  string
+	Swapped   bool
+	Untouched []string
+	// ProfileCPUs holds the scope CPUs of games whose profile sets game_cpus.
+	ProfileCPUs map[string]string
+	// Lend is how many GAME CPUs the OS slices may borrow: the smallest
+	// lend_cpus among the active games, 0 unless all of them set it.
+	Lend int
+}
+
+func decide(db quirks.DB, profiles map[string]config.Profile, osCPUs, gameCPUs string, gameIDs []string) decision {
+	gameIDs = withoutIgnored(profiles, gameIDs)
+	if len(gameIDs) == 0 {
+		return decision{}
+	}
+	d := decision{Pinned: true, OSCPUs: osCPUs, GameCPUs: gameCPUs}
+	if allPreferSwap(db, gameIDs) {
+		d.OSCPUs, d.GameCPUs, d.Swapped = gameCPUs, osCPUs, true
+	}
+	if cpus := sharedProfileOSCPUs(profiles, gameIDs); cpus != "" {
+		d.OSCPUs = cpus
+	}
+	for i, id := range gameIDs {
+		if n := profiles[id].LendCPUs; i == 0 || n < d.Lend {
+			d.Lend = n
+		}
+		if q, _ := db.Lookup(id); q.NoTouchGame {
+			d.Untouched = append(d.Untouched, id)
+		}
+		if cpus := profiles[id].GameCPUs; cpus != "" {
+			if d.ProfileCPUs == nil {
+				d.ProfileCPUs = map[string]string{}
+			}
+			d.ProfileCPUs[id] = cpus
+		}
+	}
+	return d
+}
+
+// gameCPUsFor returns the scope CPUs for gameID.
+func (d decision) gameCPUsFor(gameID string) string {
+	if cpus, ok := d.ProfileCPUs[gameID]; ok {
+		return cpus
+	}
+	return d.GameCPUs
+}
+
+// withoutIgnored drops game IDs whose profile sets ignore = true.
+func withoutIgnored(profiles map[string]config.Profile, gameIDs []string) []string {
+	out := make([]string, 0, len(gameIDs))
+	for _, id := range gameIDs {
+		if !profiles[id].Ignore {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// sharedProfileOSCPUs returns the os_cpus every active game's profile agrees
+// on, or "". Like prefers_swap, a partial or conflicting set keeps the
+// default, since the OS slices can only be pinned one way.
+func sharedProfileOSCPUs(profiles map[string]config.Profile, gameIDs []string) string {
+	shared := ""
+	for i, id := range gameIDs {
+		cpus := profiles[id].OSCPUs
+		if cpus == "" || (i > 0 && cpus != shared) {
+			return ""
+		}
+		shared = cpus
+	}
+	return shared
+}
+
+// allPreferSwap reports whether every active game is marked prefers_swap. A
+// mix of swapped and unswapped games keeps the default assignment, since the
+// OS slices can only be pinned one way.
+func allPreferSwap(db quirks.DB, gameIDs []string) bool {
+	if len(gameIDs) == 0 {
+		return false
+	}
+	for _, id := range gameIDs {
+		q, _ := db.Lookup(id)
+		if !q.PrefersSwap {
+			return false
+		}
+	}
+	return true
+}
+
+func (d decision) event(at time.Time, gameIDs []string) history.Event {
+	return history.Event{
+		Time:      at,
+		Games:     gameIDs,
+		Pinned:    d.Pinned,
+		OSCPUs:    d.OSCPUs,
+		GameCPUs:  d.GameCPUs,
+		Untouched: d.Untouched,
+	}
+}
+
+// diff describes how d differs from a recorded event. It returns "" when the
+// decisions match.
+func (d decision) diff(ev history.Event) string {
+	parts := make([]string, 0, 4)
+	if d.Pinned != ev.Pinned {
+		parts = append(parts, fmt.Sprintf("pinned: %v -> %v", ev.Pinned, d.Pinned))
+	}
+	if d.OSCPUs != ev.OSCPUs {
+		parts = append(parts, fmt.Sprintf("os_cpus: %q -> %q", ev.OSCPUs, d.OSCPUs))
+	}
+	if d.GameCPUs != ev.GameCPUs {
+		parts = append(parts, fmt.Sprintf("game_cpus: %q -> %q", ev.GameCPUs, d.GameCPUs))
+	}
+	was, now := sortedCopy(ev.Untouched), sortedCopy(d.Untouched)
+	if strings.Join(was, ",") != strings.Join(now, ",") {
+		parts = append(parts, fmt.Sprintf("untouched: %v -> %v", was, now))
+	}
+	return strings.Join(parts, " ")
+}
+
+func sortedCopy(in []string) []string {
+	out := append([]string(nil), in...)
+	sort.Strings(out)
+	return out
+}
+
+// recordDecision appends d to the history file when it differs from the last
+// recorded decision. Failures are logged: history is diagnostic only.
+func recordDecision(r *runtime, d decision, gameIDs []string) {
+	if r.historyPath == "" || r.dryRun {
+		return
+	}
+	key := strings.Join(gameIDs, ",") + "|" + d.OSCPUs + "|" + d.GameCPUs + "|" + strings.Join(d.Untouched, ",")
+	if key == r.lastDecision {
+		return
+	}
+	r.lastDecision = key
+	if err := history.Append(r.historyPath, d.event(r.clock.Now(), gameIDs)); err != nil {
+		log.Printf("history: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+	"sort"
+
+	"github.com/Reidond/ccdbind/internal/cpufreq"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// syncPower applies game_governor and game_epp to the game CPUs while games
+// are pinned and restores each CPU's previous setting otherwise. Originals
+// are kept in the state file, so a machine left in performance mode by a
+// crash is restored on the next start. A failure to switch is logged once
+// and not retried until the games exit.
+func (d *daemon) syncPower(pinned bool) {
+	target := cpufreq.Setting{Governor: d.r.cfg.GameGovernor, EPP: d.r.cfg.GameEPP}
+	// gamemoded switches the governor itself while it has games; two
+	// daemons saving and restoring each other's values would leave the
+	// wrong one behind.
+	gmGovernor := d.r.gameModeOwnsGovernor()
+	if gmGovernor {
+		target.Governor = ""
+	}
+	// CPUs taken offline by smt_off keep their saved setting until they
+	// come back.
+	online, onlineErr := cpufreq.Online()
+	offline := func(cpu int) bool {
+		return onlineErr == nil && !topology.ContainsCPU(online, cpu)
+	}
+	wanted := map[int]bool{}
+	if pinned && target != (cpufreq.Setting{}) {
+		cpus, _ := topology.ParseCPUList(d.st.GameCPUs)
+		for _, cpu := range cpus {
+			if !offline(cpu) {
+				wanted[cpu] = true
+			}
+		}
+	} else {
+		d.r.powerFailed = false
+	}
+	changed := false
+
+	restored := make([]int, 0, len(d.st.OriginalCPUFreq))
+	for cpu, orig := range d.st.OriginalCPUFreq {
+		if wanted[cpu] || offline(cpu) || (gmGovernor && orig.Governor != "") {
+			continue
+		}
+		if !d.r.dryRun {
+			if err := cpufreq.Write(cpu, orig); err != nil {
+				logging.Warnf(nil, "restore cpufreq: %v", err)
+			}
+		}
+		delete(d.st.OriginalCPUFreq, cpu)
+		restored = append(restored, cpu)
+		changed = true
+	}
+	if len(restored) > 0 {
+		sort.Ints(restored)
+		log.Printf("cpufreq: restored cpus %s", topology.FormatCPUList(restored))
+	}
+
+	switched := make([]int, 0, len(wanted))
+	for cpu := range wanted {
+		if d.r.powerFailed {
+			break
+		}
+		cur, err := cpufreq.Read(cpu)
+		if err != nil {
+			d.powerFailure(cpu, target, err)
+			break
+		}
+		if cpufreq.Merge(cur, target) == cur {
+			continue
+		}
+		_, known := d.st.OriginalCPUFreq[cpu]
+		if !known {
+			if d.st.OriginalCPUFreq == nil {
+				d.st.OriginalCPUFreq = map[int]cpufreq.Setting{}
+			}
+			if target.Governor == "" {
+				cur.Governor = ""
+			}
+			d.st.OriginalCPUFreq[cpu] = cur
+			changed = true
+		}
+		if d.r.dryRun {
+			if !known {
+				switched = append(switched, cpu)
+			}
+			continue
+		}
+		if err := cpufreq.Write(cpu, target); err != nil {
+			d.powerFailure(cpu, target, err)
+			break
+		}
+		switched = append(switched, cpu)
+	}
+	if len(switched) > 0 {
+		sort.Ints(switched)
+		prefix := ""
+		if d.r.dryRun {
+			prefix = "dry-run: "
+		}
+		log.Printf("%scpufreq: cpus %s set to governor=%q epp=%q", prefix, topology.FormatCPUList(switched), target.Governor, target.EPP)
+	}
+
+	if changed {
+		if err := state.Save(d.statePath, *d.st); err != nil {
+			log.Printf("save state: %v", err)
+		}
+	}
+}
+
+func (d *daemon) powerFailure(cpu int, target cpufreq.Setting, err error) {
+	if cerr := cpufreq.Check(cpu, target); cerr != nil {
+		err = cerr
+	}
+	logging.Warnf(nil, "cpufreq: %v; leaving the game cpus as they are", err)
+	d.r.powerFailed = true
+}
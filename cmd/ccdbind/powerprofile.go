@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/powerprofile"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// syncPowerProfile switches power-profiles-daemon to power_profile while
+// want holds and back to the previous profile otherwise. Both are kept in
+// the state file, so a profile left behind by a crashed daemon is switched
+// back at the next start. A profile changed by the user while games ran is
+// left as it is. A failed change is logged once and not retried until want
+// changes.
+//
+// It runs after syncPower: power-profiles-daemon rewrites the EPP of every
+// CPU, so the cpufreq originals are restored first and the profile switch
+// back then has the last word.
+func (d *daemon) syncPowerProfile(want bool) {
+	target := d.r.cfg.PowerProfile
+	if !want || target == "" {
+		want = false
+		if d.st.PowerProfile == "" {
+			d.r.profileFailed = false
+			return
+		}
+	} else if d.st.PowerProfile != "" {
+		return
+	}
+	if d.r.profileFailed && want == d.r.profileFailedFor {
+		return
+	}
+	ctx, cancel := systemdctl.DefaultContext()
+	defer cancel()
+
+	fail := func(err error) {
+		if errors.Is(err, powerprofile.ErrUnavailable) {
+			log.Printf("power profile: %v; leaving it alone", err)
+		} else {
+			logging.Warnf(nil, "power profile: %v", err)
+		}
+		d.r.profileFailed, d.r.profileFailedFor = true, want
+	}
+	if d.r.dryRun {
+		log.Printf("dry-run: power profile %q (active while pinned: %v)", target, want)
+	} else {
+		cur, err := powerprofile.Active(ctx)
+		if err != nil {
+			fail(err)
+			return
+		}
+		switch {
+		case want && cur == target:
+			log.Printf("power profile: already %q; leaving it alone", cur)
+			d.r.profileFailed, d.r.profileFailedFor = true, want
+			return
+		case want:
+			if err := powerprofile.Set(ctx, target); err != nil {
+				fail(err)
+				return
+			}
+			log.Printf("power profile: %q -> %q", cur, target)
+			d.st.OriginalPowerProfile = cur
+		case cur != d.st.PowerProfile:
+			log.Printf("power profile: changed to %q while games ran; leaving it", cur)
+		default:
+			if err := powerprofile.Set(ctx, d.st.OriginalPowerProfile); err != nil {
+				fail(err)
+				return
+			}
+			log.Printf("power profile: switched back to %q", d.st.OriginalPowerProfile)
+		}
+	}
+	d.r.profileFailed = false
+	if want {
+		d.st.PowerProfile = target
+	} else {
+		d.st.PowerProfile, d.st.OriginalPowerProfile = "", ""
+	}
+	if err := state.Save(d.statePath, *d.st); err != nil {
+		log.Printf("save state: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+
+	"github.com/Reidond/ccdbind/internal/pmqos"
+)
+
+// syncQoS holds the configured dma_latency while games are pinned and drops
+// it otherwise. A failure is logged when it first occurs and retried on the
+// next pin, so a udev rule added later takes effect without a restart.
+func (r *runtime) syncQoS(pinned bool) {
+	want := -1
+	if pinned && !r.dryRun {
+		want = r.cfg.DMALatency
+	}
+	if r.qos != nil && r.qos.Latency() == want {
+		return
+	}
+	if r.qos != nil {
+		if err := r.qos.Release(); err != nil {
+			log.Printf("cpu_dma_latency release: %v", err)
+		}
+		r.qos = nil
+		log.Printf("cpu_dma_latency released")
+	}
+	if want < 0 {
+		r.qosFailed = false
+		return
+	}
+	req, err := pmqos.Hold(want)
+	if err != nil {
+		if !r.qosFailed {
+			if cerr := pmqos.Check(); cerr != nil {
+				err = cerr
+			}
+			log.Printf("cpu_dma_latency %dus unavailable: %v", want, err)
+		}
+		r.qosFailed = true
+		return
+	}
+	r.qos, r.qosFailed = req, false
+	log.Printf("cpu_dma_latency held at %dus", want)
+}
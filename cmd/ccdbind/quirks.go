@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/quirks"
+)
+
+func runQuirks(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: ccdbind quirks list|show [flags]")
+		os.Exit(2)
+	}
+	sub, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("ccdbind quirks "+sub, flag.ExitOnError)
+	flagConfig := fs.String("config", "", "config file path (TOML). Default: XDG config path")
+	flagJSON := fs.Bool("json", false, "output JSON")
+	_ = fs.Parse(args)
+
+	configPath := strings.TrimSpace(*flagConfig)
+	if configPath == "" {
+		p, err := config.DefaultConfigPath()
+		if err != nil {
+			fatal(err)
+		}
+		configPath = p
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	switch sub {
+	case "list", "show":
+		db := loadQuirks(cfg)
+		ids := db.IDs()
+		if sub == "show" {
+			ids = fs.Args()
+			if len(ids) == 0 {
+				fatal(fmt.Errorf("usage: ccdbind quirks show APPID..."))
+			}
+		}
+		out := make(map[string]quirks.Quirk, len(ids))
+		for _, id := range ids {
+			if q, ok := db.Lookup(id); ok {
+				out[id] = q
+			}
+		}
+		if *flagJSON {
+			b, _ := json.MarshalIndent(out, "", "  ")
+			fmt.Println(string(b))
+			return
+		}
+		if len(ids) == 0 {
+			fmt.Println("no titles; add [quirks.\"APPID\"] tables to the config")
+		}
+		for _, id := range ids {
+			q, ok := out[id]
+			if !ok {
+				fmt.Printf("  %s: none\n", id)
+				continue
+			}
+			fmt.Printf("  %s: %s\n", id, strings.Join(q.Flags(), ","))
+		}
+	default:
+		fatal(fmt.Errorf("unknown quirks subcommand %q (expected list|show)", sub))
+	}
+}
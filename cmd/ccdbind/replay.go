@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/history"
+)
+
+type replayDiff struct {
+	Index int       `json:"index"`
+	Time  time.Time `json:"time"`
+	Games []string  `json:"games"`
+	Diff  string    `json:"diff"`
+}
+
+type replayOutput struct {
+	Events int          `json:"events"`
+	Diffs  []replayDiff `json:"diffs,omitempty"`
+}
+
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("ccdbind replay", flag.ExitOnError)
+	flagConfig := fs.String("config", "", "config file path (TOML). Default: XDG config path")
+	flagEvents := fs.String("events", "", "history file (JSON lines). Default: XDG state path")
+	flagJSON := fs.Bool("json", false, "output JSON")
+	_ = fs.Parse(args)
+
+	configPath := strings.TrimSpace(*flagConfig)
+	if configPath == "" {
+		p, err := config.DefaultConfigPath()
+		if err != nil {
+			fatal(err)
+		}
+		configPath = p
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	eventsPath := strings.TrimSpace(*flagEvents)
+	if eventsPath == "" {
+		p, err := history.DefaultPath()
+		if err != nil {
+			fatal(err)
+		}
+		eventsPath = p
+	}
+	events, err := history.Load(eventsPath)
+	if err != nil {
+		fatal(fmt.Errorf("read events: %w", err))
+	}
+
+	// The sets as the daemon has them, without reserved CPUs.
+	s, err := newSettings(cfg, os.Getuid(), false)
+	if err != nil {
+		fatal(err)
+	}
+
+	out := replayOutput{Events: len(events)}
+	for i, ev := range events {
+		d := decide(s.quirks, s.cfg.Profiles, s.osCPUs, s.gameCPUs, sortedCopy(ev.Games))
+		if diff := d.diff(ev); diff != "" {
+			out.Diffs = append(out.Diffs, replayDiff{Index: i, Time: ev.Time, Games: ev.Games, Diff: diff})
+		}
+	}
+
+	if *flagJSON {
+		b, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(b))
+	} else {
+		for _, d := range out.Diffs {
+			fmt.Printf("#%d %s games=%v %s\n", d.Index, d.Time.Format(time.RFC3339), d.Games, d.Diff)
+		}
+		fmt.Printf("%d events replayed, %d would differ\n", out.Events, len(out.Diffs))
+	}
+	if len(out.Diffs) > 0 {
+		os.Exit(1)
+	}
+}
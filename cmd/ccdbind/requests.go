@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/pinreq"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/quirks"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// applyPinRequests merges drop-file pin requests into the scanned games.
+// Requests for dead or foreign PIDs, or naming a scope the PID is not in, are
+// deleted. A requested PID that the
+// scanner already grouped under another ID is moved to the requested ID.
+// Request policies become per-game quirk overrides for this tick, and a
+// request's scope becomes the game's scope, see scopeFor.
+func applyPinRequests(r *runtime, games map[string][]procscan.GameProcess) {
+	r.requestOverrides = nil
+	r.requestScopes = nil
+	if r.requestDir == "" {
+		return
+	}
+	reqs, errs := pinreq.Load(r.requestDir)
+	for _, err := range errs {
+		logging.Warnf(nil, "pin request rejected: %v", err)
+	}
+	if len(reqs) == 0 {
+		return
+	}
+
+	t := true
+	for _, req := range reqs {
+		var gp procscan.GameProcess
+		err := checkRequestScope(req.PID, req.Scope)
+		if err == nil {
+			gp, err = r.scanner.Adopt(req.PID, req.GameID, "request")
+		}
+		if err != nil {
+			logging.Warnf(logging.Fields{"GAME_ID": req.GameID, "PID": strconv.Itoa(req.PID)}, "pin request %s: %v; removing", req.Path, err)
+			_ = req.Remove()
+			continue
+		}
+		moveProcess(games, gp)
+		if req.Scope != "" {
+			if r.requestScopes == nil {
+				r.requestScopes = map[string]string{}
+			}
+			r.requestScopes[gp.GameID] = req.Scope
+		}
+
+		var o quirks.Override
+		switch req.Policy {
+		case pinreq.PolicyNoTouchGame:
+			o.NoTouchGame = &t
+		case pinreq.PolicyPrefersSwap:
+			o.PrefersSwap = &t
+		default:
+			continue
+		}
+		if r.requestOverrides == nil {
+			r.requestOverrides = map[string]quirks.Override{}
+		}
+		r.requestOverrides[gp.GameID] = o
+	}
+}
+
+// checkRequestScope makes sure a request's scope holds its PID, so a drop
+// file cannot have an unrelated scope pinned to the game CPUs.
+func checkRequestScope(pid int, unit string) error {
+	if unit == "" {
+		return nil
+	}
+	cg, err := procscan.Cgroup(pid)
+	if err != nil {
+		return err
+	}
+	if !cgroupHasUnit(cg, unit) {
+		return fmt.Errorf("pid %d runs in %s, not in %s", pid, cg, unit)
+	}
+	return nil
+}
+
+// cgroupHasUnit reports whether unit is one of the cgroups along cg.
+func cgroupHasUnit(cg, unit string) bool {
+	for _, part := range strings.Split(strings.Trim(cg, "/"), "/") {
+		if part != "" && part == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeFor returns the scope of gameID: the one a launcher such as ccdpin
+// started it in and announced in a pin request, which the daemon pins
+// rather than move the game out of, or else game-<id>.scope.
+func (r *runtime) scopeFor(gameID string) string {
+	if unit, ok := r.requestScopes[gameID]; ok {
+		return unit
+	}
+	return systemdctl.UnitNameForGameID(gameID)
+}
+
+// moveProcess adds gp to games under gp.GameID, removing the same PID from
+// any other game the scanner grouped it under.
+func moveProcess(games map[string][]procscan.GameProcess, gp procscan.GameProcess) {
+	for id, procs := range games {
+		for i, p := range procs {
+			if p.PID == gp.PID {
+				games[id] = append(procs[:i:i], procs[i+1:]...)
+				break
+			}
+		}
+		if len(games[id]) == 0 {
+			delete(games, id)
+		}
+	}
+	games[gp.GameID] = append(games[gp.GameID], gp)
+}
+
+// effectiveQuirks layers request policies over the config's local overrides.
+func (r *runtime) effectiveQuirks() quirks.DB {
+	if len(r.requestOverrides) == 0 {
+		return r.quirks
+	}
+	merged := make(map[string]quirks.Override, len(r.cfg.QuirkOverrides)+len(r.requestOverrides))
+	for id, o := range r.cfg.QuirkOverrides {
+		merged[id] = o
+	}
+	for id, o := range r.requestOverrides {
+		merged[id] = o
+	}
+	return r.quirks.WithOverrides(merged)
+}
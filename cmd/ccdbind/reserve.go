@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/reserve"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// reservations returns the CPU reservations made outside ccdbind, leaving
+// out cgroups ccdbind pins itself so its own AllowedCPUs= is not mistaken
+// for one after a restart.
+func reservations(cfg config.Config, uid int) []reserve.Reservation {
+	var out []reserve.Reservation
+	for _, r := range reserve.Detect(uid) {
+		if r.Cgroup == "" || !pinsCgroup(cfg, r.Cgroup) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func pinsCgroup(cfg config.Config, cgroup string) bool {
+	for _, entry := range slicesToPin(cfg) {
+		if !systemdctl.IsCgroupPath(entry) {
+			continue
+		}
+		if ok, _ := path.Match(strings.Trim(entry, "/"), cgroup); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutReserved removes the reserved CPUs from the OS and game sets and
+// from the CPU lists of game profiles. Overlaps are logged; a set left
+// empty is an error, since there is nowhere to put its tasks.
+func withoutReserved(cfg config.Config, osCPUs, gameCPUs string, rs []reserve.Reservation) (config.Config, string, string, error) {
+	if len(rs) == 0 {
+		return cfg, osCPUs, gameCPUs, nil
+	}
+	reserved := reserve.CPUs(rs)
+	logging.Tracef(logging.Topology, nil, "reserved cpus %s", topology.FormatCPUList(reserved))
+	subtract := func(key, list string) (string, error) {
+		_, cpus, err := topology.CanonicalizeCPUList(list)
+		if err != nil || len(cpus) == 0 {
+			return list, err
+		}
+		kept := make([]int, 0, len(cpus))
+		for _, c := range cpus {
+			if !topology.ContainsCPU(reserved, c) {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == len(cpus) {
+			return list, nil
+		}
+		for _, r := range reserve.Overlapping(rs, cpus) {
+			logging.Warnf(nil, "%s %s overlap CPUs %s reserved by %s; leaving them out", key, list, r.CPUs, r.Source)
+		}
+		if len(kept) == 0 {
+			return "", fmt.Errorf("%s %s lie entirely in CPUs reserved by other isolators", key, list)
+		}
+		return topology.FormatCPUList(kept), nil
+	}
+
+	var err error
+	if osCPUs, err = subtract("os_cpus", osCPUs); err != nil {
+		return cfg, "", "", err
+	}
+	if gameCPUs, err = subtract("game_cpus", gameCPUs); err != nil {
+		return cfg, "", "", err
+	}
+	if len(cfg.Profiles) > 0 {
+		profiles := make(map[string]config.Profile, len(cfg.Profiles))
+		for id, p := range cfg.Profiles {
+			if p.GameCPUs, err = subtract(fmt.Sprintf("game %s game_cpus", id), p.GameCPUs); err != nil {
+				return cfg, "", "", err
+			}
+			if p.OSCPUs, err = subtract(fmt.Sprintf("game %s os_cpus", id), p.OSCPUs); err != nil {
+				return cfg, "", "", err
+			}
+			profiles[id] = p
+		}
+		cfg.Profiles = profiles
+	}
+	return cfg, osCPUs, gameCPUs, nil
+}
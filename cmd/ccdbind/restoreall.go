@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/clock"
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/cpufreq"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// runRestoreAll undoes everything the state file says ccdbind changed, for
+// when a crashed daemon left slices pinned: slice and guest originals, the
+// CPUs of game scopes and pin request scopes, the irqbalance ban, SMT,
+// cpufreq settings and the power profile. The state file is removed
+// afterwards. It refuses to run alongside the daemon, which would pin again
+// on its next tick.
+func runRestoreAll(args []string) {
+	fs := flag.NewFlagSet("ccdbind restore-all", flag.ExitOnError)
+	flagConfig := fs.String("config", "", "config file path (TOML). Default: XDG config path")
+	flagDryRun := fs.Bool("dry-run", false, "print what would be restored without changing anything")
+	flagStopScopes := fs.Bool("stop-scopes", false, "also stop the game scopes, ending the games still running in them")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dryRun := *flagDryRun
+
+	if lockPath, err := instanceLockPath(); err == nil {
+		release, holder, err := lockInstance(lockPath)
+		switch {
+		case errors.Is(err, errAlreadyRunning) && dryRun:
+			fmt.Printf("warning: ccdbind is running (pid %d); stop it before restoring\n", holder)
+		case errors.Is(err, errAlreadyRunning):
+			fatal(fmt.Errorf("%w (pid %d); stop it first, or run `ccdbind pause` to restore while it keeps running", err, holder))
+		case err != nil:
+			fatal(err)
+		default:
+			defer release()
+		}
+	}
+	if failed := restoreAll(*flagConfig, dryRun, *flagStopScopes); failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// restoreAll is restore-all with the instance lock already taken or given
+// up on. It returns how many steps failed.
+func restoreAll(configPath string, dryRun, stopScopes bool) int {
+	configPath = strings.TrimSpace(configPath)
+	if configPath == "" {
+		p, err := config.DefaultConfigPath()
+		if err != nil {
+			fatal(err)
+		}
+		configPath = p
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fatal(err)
+	}
+	statePath, err := state.DefaultPath()
+	if err != nil {
+		fatal(err)
+	}
+
+	sys, err := newSystemctl(cfg, os.Getuid(), dryRun)
+	if err != nil {
+		fatal(err)
+	}
+	// Without a usable state file, slices still on the OS set the daemon
+	// would have written are the ones to clear.
+	var osCPUs string
+	if s, err := newSettings(cfg, os.Getuid(), false); err == nil {
+		osCPUs = s.osCPUs
+	}
+	st, _, err := loadState(statePath, sys, slicesToPin(cfg), osCPUs)
+	if err != nil {
+		fatal(err)
+	}
+	failed := 0
+	step := func(what string, err error) {
+		if err != nil {
+			fmt.Printf("failed: %s: %v\n", what, err)
+			failed++
+			return
+		}
+		if dryRun {
+			fmt.Printf("would %s\n", what)
+		} else {
+			fmt.Println(what)
+		}
+	}
+
+	scopes, err := restoreAllScopes(sys, st)
+	if err != nil {
+		step("list game scopes", err)
+	}
+	for _, unit := range scopes {
+		ctx, cancel := systemdctl.DefaultContext()
+		err := sys.SetAllowedCPUs(ctx, unit, "")
+		if err == nil {
+			err = sys.SetAllowedMemoryNodes(ctx, unit, "")
+		}
+		cancel()
+		step("unpin "+unit, err)
+		if stopScopes {
+			ctx, cancel := systemdctl.DefaultContext()
+			err := sys.StopUnit(ctx, unit)
+			cancel()
+			step("stop "+unit, err)
+		}
+	}
+	ctx, cancel := systemdctl.DefaultContext()
+	err = sys.ResetFailed(ctx, "game-*.scope")
+	cancel()
+	if err != nil {
+		log.Printf("reset-failed game scopes: %v", err)
+	}
+
+	for _, cg := range sortedKeys(st.OriginalGuestCPUs) {
+		if !cgroupExists(cg) {
+			continue
+		}
+		ctx, cancel := systemdctl.DefaultContext()
+		err := sys.SetAllowedCPUs(ctx, cg, st.OriginalGuestCPUs[cg])
+		cancel()
+		step(fmt.Sprintf("restore guest %s to %q", cg, st.OriginalGuestCPUs[cg]), err)
+	}
+
+	for _, unit := range restoreAllTargets(sys, slicesToPin(cfg), st) {
+		orig := st.OriginalAllowedCPUs[unit]
+		ctx, cancel := systemdctl.DefaultContext()
+		err := sys.SetAllowedCPUs(ctx, unit, orig)
+		cancel()
+		step(fmt.Sprintf("restore %s AllowedCPUs to %q", unit, orig), err)
+		if mems, ok := st.OriginalAllowedMemoryNodes[unit]; ok {
+			ctx, cancel := systemdctl.DefaultContext()
+			err := sys.SetAllowedMemoryNodes(ctx, unit, mems)
+			cancel()
+			step(fmt.Sprintf("restore %s AllowedMemoryNodes to %q", unit, mems), err)
+		}
+	}
+
+	if dryRun {
+		if st.IRQBalanceBanned != "" {
+			step("lift the irqbalance ban on "+st.IRQBalanceBanned, nil)
+		}
+		if st.SMTDisabled != "" {
+			step("switch SMT back on ("+st.SMTDisabled+")", nil)
+		}
+		if len(st.OriginalCPUFreq) > 0 {
+			step("restore cpufreq on cpus "+cpufreqCPUs(st.OriginalCPUFreq), nil)
+		}
+		if st.PowerProfile != "" {
+			step("switch the power profile back to "+st.OriginalPowerProfile, nil)
+		}
+		step("remove "+statePath, nil)
+		return failed
+	}
+
+	// The daemon's own sync functions undo these, so the same methods and
+	// logging apply.
+	d := &daemon{
+		r:         &runtime{settings: settings{cfg: cfg}, uid: os.Getuid(), clock: clock.Real, metrics: newDaemonMetrics()},
+		sys:       sys,
+		statePath: statePath,
+		st:        &st,
+	}
+	d.syncIRQ(false)
+	if st.IRQBalanceBanned != "" {
+		step("lift the irqbalance ban", errors.New("see the log above"))
+	}
+	d.syncSMT(false)
+	if st.SMTDisabled != "" {
+		step("switch SMT back on", errors.New("see the log above"))
+	}
+	d.syncPower(false)
+	if len(st.OriginalCPUFreq) > 0 {
+		step("restore cpufreq on cpus "+cpufreqCPUs(st.OriginalCPUFreq), errors.New("see the log above"))
+	}
+	d.syncPowerProfile(false)
+	if st.PowerProfile != "" {
+		step("switch the power profile back to "+st.OriginalPowerProfile, errors.New("see the log above"))
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d step(s) failed; the state file is kept for another attempt\n", failed)
+		return failed
+	}
+	if err := state.Remove(statePath); err != nil {
+		fatal(err)
+	}
+	step("remove "+statePath, nil)
+	return 0
+}
+
+// restoreAllTargets returns every slice with a recorded original, plus the
+// configured slices that are still pinned to the recorded OS set without
+// one.
+func restoreAllTargets(sys systemdctl.Systemctl, slices []string, st state.File) []string {
+	recorded := make([]string, 0, len(st.OriginalAllowedCPUs))
+	recorded = append(recorded, sortedKeys(st.OriginalAllowedCPUs)...)
+	recorded = append(recorded, sortedKeys(st.OriginalAllowedMemoryNodes)...)
+	out := restoreTargets(dedupe(recorded), st)
+	seen := map[string]bool{}
+	for _, unit := range out {
+		seen[unit] = true
+	}
+	if st.OSCPUs == "" {
+		return out
+	}
+	for _, unit := range systemdctl.ExpandCgroupGlobs(slices) {
+		if seen[unit] {
+			continue
+		}
+		ctx, cancel := systemdctl.DefaultContext()
+		cur, err := sys.GetAllowedCPUs(ctx, unit)
+		cancel()
+		if err == nil && cur == st.OSCPUs {
+			out = append(out, unit)
+		}
+	}
+	return out
+}
+
+// restoreAllScopes returns the game scopes to unpin: every game-*.scope,
+// plus the scopes of pin requests recorded in st, such as ccdpin's, which
+// may have any name.
+func restoreAllScopes(sys systemdctl.Systemctl, st state.File) ([]string, error) {
+	recorded := map[string]bool{}
+	for _, sp := range st.ScopePIDs {
+		recorded[sp.Unit] = true
+	}
+	pattern := "game-*.scope"
+	if len(recorded) > 0 {
+		pattern = "*.scope"
+	}
+	ctx, cancel := systemdctl.DefaultContext()
+	units, err := sys.ListUnits(ctx, pattern)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(units))
+	for _, unit := range units {
+		if recorded[unit] || (strings.HasPrefix(unit, "game-") && strings.HasSuffix(unit, ".scope")) {
+			out = append(out, unit)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func cpufreqCPUs(m map[int]cpufreq.Setting) string {
+	cpus := make([]int, 0, len(m))
+	for cpu := range m {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return topology.FormatCPUList(cpus)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// adoptModified applies restore_policy = "keep-modified" to the pinned
+// slices' current AllowedCPUs. A slice someone else set to a value other
+// than our pin is kept at it: it is no longer pinned, and the value
+// replaces its original so the restore leaves it in place. A slice back at
+// its original (a restarted unit, say) was reset rather than modified and
+// is pinned again as before. It reports whether st changed.
+func adoptModified(policy string, current map[string]string, st *state.File) bool {
+	if policy != config.RestoreKeepModified || !st.PinApplied || st.OSCPUs == "" {
+		return false
+	}
+	changed := false
+	for _, unit := range sortedKeys(current) {
+		cur := current[unit]
+		if kept, ok := st.KeptAllowedCPUs[unit]; ok {
+			if cur != kept {
+				log.Printf("%s AllowedCPUs changed again to %q; keeping it", unit, cur)
+				st.KeptAllowedCPUs[unit] = cur
+				st.OriginalAllowedCPUs[unit] = cur
+				changed = true
+			}
+			continue
+		}
+		orig, hasOrig := st.OriginalAllowedCPUs[unit]
+		if cur == st.OSCPUs || (hasOrig && cur == orig) || (!hasOrig && cur == "") {
+			continue
+		}
+		log.Printf("%s AllowedCPUs changed to %q while pinned; keeping it and not restoring %q later (restore_policy = %q)", unit, cur, orig, policy)
+		if st.KeptAllowedCPUs == nil {
+			st.KeptAllowedCPUs = map[string]string{}
+		}
+		if st.OriginalAllowedCPUs == nil {
+			st.OriginalAllowedCPUs = map[string]string{}
+		}
+		st.KeptAllowedCPUs[unit] = cur
+		st.OriginalAllowedCPUs[unit] = cur
+		changed = true
+	}
+	return changed
+}
+
+// adoptModifiedBeforeRestore checks the slices for changes made since the
+// last tick right before they are restored. Slices that cannot be read are
+// restored as usual.
+func adoptModifiedBeforeRestore(policy string, sys systemdctl.Systemctl, slices []string, st *state.File) {
+	if policy != config.RestoreKeepModified || !st.PinApplied {
+		return
+	}
+	current := map[string]string{}
+	for _, unit := range restoreTargets(slices, *st) {
+		ctx, cancel := systemdctl.DefaultContext()
+		val, err := sys.GetAllowedCPUs(ctx, unit)
+		cancel()
+		if err == nil {
+			current[unit] = val
+		}
+	}
+	adoptModified(policy, current, st)
+}
+
+// unkept returns slices without those kept under restore_policy.
+func unkept(slices []string, st state.File) []string {
+	if len(st.KeptAllowedCPUs) == 0 {
+		return slices
+	}
+	out := make([]string, 0, len(slices))
+	for _, unit := range slices {
+		if _, ok := st.KeptAllowedCPUs[unit]; !ok {
+			out = append(out, unit)
+		}
+	}
+	return out
+}
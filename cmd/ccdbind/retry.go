@@ -0,0 +1,81 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/state"
+)
+
+const (
+	scopeRetryBase = time.Second
+	scopeRetryMax  = 30 * time.Second
+	// scopeFailurePersistent is the attempt count from which a failing game
+	// is reported as a warning in status.
+	scopeFailurePersistent = 3
+)
+
+// scopeBackoff returns the delay before the next attempt after attempts
+// failures: 1s, 2s, 4s, ... capped at scopeRetryMax.
+func scopeBackoff(attempts int) time.Duration {
+	d := scopeRetryBase
+	for i := 1; i < attempts && d < scopeRetryMax; i++ {
+		d *= 2
+	}
+	return min(d, scopeRetryMax)
+}
+
+// scopeRetryDue reports whether gameID may be placed now, i.e. it has no
+// recorded failure or its backoff has expired.
+func scopeRetryDue(st *state.File, gameID string, now time.Time) bool {
+	f, ok := st.ScopeFailures[gameID]
+	return !ok || !now.Before(f.NextRetry)
+}
+
+// recordScopeFailure notes a failed attempt to place gameID and schedules the
+// next one. User manager errors such as "transaction is destructive" usually
+// clear up within seconds, so only the first failure and the point where it
+// becomes persistent are logged.
+func recordScopeFailure(st *state.File, gameID string, err error, now time.Time) {
+	if st.ScopeFailures == nil {
+		st.ScopeFailures = map[string]state.ScopeFailure{}
+	}
+	f, ok := st.ScopeFailures[gameID]
+	if !ok {
+		f.Since = now
+	}
+	f.Attempts++
+	f.LastError = err.Error()
+	f.NextRetry = now.Add(scopeBackoff(f.Attempts))
+	st.ScopeFailures[gameID] = f
+	switch f.Attempts {
+	case 1:
+		logging.Warnf(logging.Fields{"GAME_ID": gameID}, "game %s: %v; retrying in %s", gameID, err, scopeBackoff(f.Attempts))
+	case scopeFailurePersistent:
+		logging.Errorf(logging.Fields{"GAME_ID": gameID}, "game %s: still failing after %d attempts: %v", gameID, f.Attempts, err)
+	}
+}
+
+// pruneScopeFailures forgets failures of games that are no longer active or
+// were placed successfully. It reports whether anything was removed.
+func pruneScopeFailures(st *state.File, keep map[string]bool) bool {
+	changed := false
+	for id := range st.ScopeFailures {
+		if !keep[id] {
+			delete(st.ScopeFailures, id)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// nextScopeRetry returns the earliest pending retry.
+func nextScopeRetry(st *state.File) (time.Time, bool) {
+	var next time.Time
+	for _, f := range st.ScopeFailures {
+		if next.IsZero() || f.NextRetry.Before(next) {
+			next = f.NextRetry
+		}
+	}
+	return next, !next.IsZero()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/Reidond/ccdbind/internal/i18n"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/procscan"
+)
+
+// checkScanAccess warns once when the last scan could not read most of the
+// user's processes, which otherwise looks like no game running.
+func (r *runtime) checkScanAccess() {
+	a := r.scanner.Access()
+	if !a.Broad() {
+		if r.scanDenied {
+			logging.Infof(nil, "process scan can read the user's processes again")
+		}
+		r.scanDenied = false
+		return
+	}
+	if r.scanDenied {
+		return
+	}
+	r.scanDenied = true
+	for _, w := range scanAccessWarnings(a) {
+		logging.Warnf(nil, "%s", w.English())
+	}
+}
+
+func scanAccessWarnings(a procscan.Access) []i18n.Message {
+	out := []i18n.Message{i18n.Msg("status.warn.scan_denied", a.Denied, a.Owned)}
+	for _, p := range procscan.Diagnose() {
+		out = append(out, i18n.Msg("status.warn.scan_problem", p.String()))
+	}
+	return out
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	goruntime "runtime"
+	"sort"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/config"
+)
+
+// runScanBench runs n scans at the poll interval, as the daemon would, and
+// prints how long each took and what it allocated.
+func runScanBench(cfg config.Config, uid, n int) {
+	scanner := newScanner(cfg, uid)
+	took := make([]time.Duration, 0, n)
+	var ms goruntime.MemStats
+	fmt.Printf("%-5s %10s %9s %6s %10s\n", "SCAN", "TIME", "PROCS", "GAMES", "ALLOC")
+	for i := 1; i <= n; i++ {
+		if i > 1 {
+			time.Sleep(cfg.Interval)
+		}
+		goruntime.ReadMemStats(&ms)
+		before := ms.TotalAlloc
+		start := time.Now()
+		games, err := scanner.Scan()
+		d := time.Since(start)
+		if err != nil {
+			fatal(err)
+		}
+		goruntime.ReadMemStats(&ms)
+		took = append(took, d)
+		fmt.Printf("%-5d %10s %9d %6d %9dK\n", i, d.Round(time.Microsecond), scanner.Access().Owned, len(games), (ms.TotalAlloc-before)>>10)
+	}
+	sort.Slice(took, func(i, j int) bool { return took[i] < took[j] })
+	fmt.Printf("min %s median %s max %s\n", took[0].Round(time.Microsecond), took[len(took)/2].Round(time.Microsecond), took[len(took)-1].Round(time.Microsecond))
+}
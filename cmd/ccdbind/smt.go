@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/smt"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// wantsSMTOff reports whether smt_off is set and one of games is marked
+// needs_smt_off.
+func (r *runtime) wantsSMTOff(games map[string][]procscan.GameProcess) bool {
+	if !r.cfg.SMTOff {
+		return false
+	}
+	db := r.effectiveQuirks()
+	for id := range games {
+		if q, _ := db.Lookup(id); q.NeedsSMTOff {
+			return true
+		}
+	}
+	return false
+}
+
+// syncSMT switches SMT off while want holds and back on otherwise. How it
+// was switched off is kept in the state file, so SMT left off by a crashed
+// daemon is switched back on at the next start. SMT that was already off is
+// left alone. A failed change is logged once and not retried until want
+// changes.
+func (d *daemon) syncSMT(want bool) {
+	if want == (d.st.SMTDisabled != "") || (d.r.smtFailed && want == d.r.smtFailedFor) {
+		return
+	}
+	ctx, cancel := systemdctl.DefaultContext()
+	defer cancel()
+	method := ""
+	switch {
+	case d.r.dryRun:
+		log.Printf("dry-run: smt off=%v", want)
+		if want {
+			method = smt.Sysfs
+		}
+	case want:
+		var err error
+		method, err = smt.Disable(ctx)
+		switch {
+		case errors.Is(err, smt.ErrUnsupported):
+			log.Printf("smt: %v; leaving it alone", err)
+			d.r.smtFailed, d.r.smtFailedFor = true, want
+			return
+		case err != nil:
+			logging.Warnf(nil, "smt: %v (the user needs write access to the control file or polkit rights for %s, see the docs)", err, smt.Unit)
+			d.r.smtFailed, d.r.smtFailedFor = true, want
+			return
+		case method == "":
+			log.Printf("smt: already off; leaving it alone")
+			d.r.smtFailed, d.r.smtFailedFor = true, want
+			return
+		}
+		log.Printf("smt: switched off (%s)", method)
+	default:
+		if err := smt.Enable(ctx, d.st.SMTDisabled); err != nil {
+			logging.Warnf(nil, "smt: switch back on: %v", err)
+			d.r.smtFailed, d.r.smtFailedFor = true, want
+			return
+		}
+		log.Printf("smt: switched back on")
+	}
+	d.r.smtFailed = false
+	d.st.SMTDisabled = method
+	if err := state.Save(d.statePath, *d.st); err != nil {
+		log.Printf("save state: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/spread"
+)
+
+// spreadThreads hands the games placed this tick to the spread_threads
+// balancer, creating it on first use. With the option off, threads it
+// pinned get their game's CPUs back. Failures are logged once per reason:
+// spreading is an optional extra on top of the scopes.
+func (r *runtime) spreadThreads(groups []spread.Group) {
+	if !r.cfg.SpreadThreads {
+		r.resetSpread()
+		return
+	}
+	if r.spread == nil {
+		if r.spreadFailed {
+			return
+		}
+		cores, err := r.topo.DetectCores()
+		if err != nil {
+			r.spreadFailed = true
+			logging.Warnf(nil, "spread_threads: %v; leaving threads alone", err)
+			return
+		}
+		r.spread = spread.New(cores)
+		r.spread.DryRun = r.dryRun
+		r.spread.Logf = log.Printf
+	}
+	moved, err := r.spread.Balance(groups, r.clock.Now())
+	if err != nil && !r.spreadFailed {
+		r.spreadFailed = true
+		logging.Warnf(nil, "spread_threads: %v", err)
+	}
+	if moved > 0 {
+		logging.Debugf(nil, "spread_threads: moved %d thread(s)", moved)
+	}
+}
+
+// resetSpread gives the threads spread_threads pinned their game's CPUs back.
+func (r *runtime) resetSpread() {
+	if r.spread != nil {
+		r.spread.Reset()
+		r.spread = nil
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// loadState loads the state file. When both it and its backup are corrupt,
+// the state is rebuilt from the live slices instead and recovered is set, so
+// the caller can save it over the damaged file.
+func loadState(path string, sys systemdctl.Systemctl, slices []string, osCPUs string) (st state.File, recovered bool, err error) {
+	st, err = state.Load(path)
+	if !errors.Is(err, state.ErrCorrupt) {
+		return st, false, err
+	}
+	logging.Errorf(nil, "%v; rebuilding it from the live slices", err)
+	return recoverState(sys, slices, osCPUs), true, nil
+}
+
+// recoverState reconstructs what a lost state file most likely said. A
+// slice whose AllowedCPUs is exactly osCPUs is taken to be pinned by
+// ccdbind; its original is unknown, so it is recorded as empty and the
+// restore clears AllowedCPUs. Other slices are left out.
+func recoverState(sys systemdctl.Systemctl, slices []string, osCPUs string) state.File {
+	st := state.File{Version: state.CurrentVersion, OriginalAllowedCPUs: map[string]string{}}
+	want, _, err := topology.CanonicalizeCPUList(osCPUs)
+	if err != nil || want == "" {
+		return st
+	}
+	for _, unit := range systemdctl.ExpandCgroupGlobs(slices) {
+		ctx, cancel := systemdctl.DefaultContext()
+		cur, err := sys.GetAllowedCPUs(ctx, unit)
+		cancel()
+		if err != nil {
+			logging.Warnf(logging.Fields{"UNIT": unit}, "recover state: %v", err)
+			continue
+		}
+		// systemctl show separates ranges with spaces.
+		if got, _, err := topology.CanonicalizeCPUList(strings.Join(strings.Fields(cur), ",")); err == nil && got == want {
+			st.OriginalAllowedCPUs[unit] = ""
+		}
+	}
+	if len(st.OriginalAllowedCPUs) > 0 {
+		st.PinApplied = true
+		st.OSCPUs = want
+		logging.Warnf(nil, "recover state: %d slice(s) still pinned to %s; they will be cleared on restore", len(st.OriginalAllowedCPUs), want)
+	}
+	return st
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -10,9 +12,16 @@ import (
 	"time"
 
 	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/cpuload"
+	"github.com/Reidond/ccdbind/internal/guests"
+	"github.com/Reidond/ccdbind/internal/health"
+	"github.com/Reidond/ccdbind/internal/i18n"
+	"github.com/Reidond/ccdbind/internal/pmqos"
 	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/reserve"
 	"github.com/Reidond/ccdbind/internal/state"
 	"github.com/Reidond/ccdbind/internal/systemdctl"
+	"github.com/Reidond/ccdbind/internal/topology"
 )
 
 type statusSlice struct {
@@ -22,12 +31,33 @@ type statusSlice struct {
 	ReadAllowedCPUErr string `json:"read_allowed_cpus_error,omitempty"`
 }
 
+type statusGuest struct {
+	Cgroup          string `json:"cgroup"`
+	Kind            string `json:"kind"` // vm|container
+	Runtime         string `json:"runtime"`
+	AllowedCPUs     string `json:"allowed_cpus"`
+	OriginalAllowed string `json:"original_allowed_cpus,omitempty"`
+	Pinned          bool   `json:"pinned"`
+}
+
 type statusGameProc struct {
 	PID         int    `json:"pid"`
 	Exe         string `json:"exe"`
 	GameID      string `json:"game_id"`
 	IDSource    string `json:"id_source"`
+	Bits        int    `json:"bits,omitempty"`
 	AllowedCPUs string `json:"allowed_cpus,omitempty"`
+	// Scope is the game scope holding the process and ExpectedCPUs its
+	// effective cpuset. Drifted is set when the process's affinity differs,
+	// e.g. after the game called sched_setaffinity itself.
+	Scope        string `json:"scope,omitempty"`
+	ExpectedCPUs string `json:"expected_cpus,omitempty"`
+	Drifted      bool   `json:"drifted,omitempty"`
+}
+
+type statusScope struct {
+	Unit        string `json:"unit"`
+	AllowedCPUs string `json:"allowed_cpus"`
 }
 
 type statusProgramSummary struct {
@@ -38,6 +68,13 @@ type statusProgramSummary struct {
 	SamplePIDs  []int  `json:"sample_pids"`
 }
 
+type statusClass struct {
+	Class       string  `json:"class"` // os|game
+	CPUs        string  `json:"cpus"`
+	Count       int     `json:"count"`
+	Utilization float64 `json:"utilization"` // 0..1 over the sample window
+}
+
 type statusOutput struct {
 	GeneratedAt time.Time `json:"generated_at"`
 	Filter      string    `json:"filter"`
@@ -45,14 +82,40 @@ type statusOutput struct {
 	ConfigPath string `json:"config_path"`
 	StatePath  string `json:"state_path"`
 
-	OSCPUs   string `json:"os_cpus,omitempty"`
-	GameCPUs string `json:"game_cpus,omitempty"`
+	OSCPUs   string                `json:"os_cpus,omitempty"`
+	GameCPUs string                `json:"game_cpus,omitempty"`
+	Clusters []topology.Cluster    `json:"clusters,omitempty"`
+	Budget   []statusClass         `json:"budget,omitempty"`
+	Reserved []reserve.Reservation `json:"reserved,omitempty"`
+	Warnings []string              `json:"warnings,omitempty"`
+
+	// Daemon is the running daemon's health; nil when it is not reachable.
+	Daemon *health.Snapshot `json:"daemon,omitempty"`
 
 	State  state.File             `json:"state"`
 	Slices []statusSlice          `json:"slices"`
+	Guests []statusGuest          `json:"guests,omitempty"`
+	Scopes []statusScope          `json:"scopes,omitempty"`
 	Games  []statusGameProc       `json:"games,omitempty"`
 	All    []statusProgramSummary `json:"all,omitempty"`
 	Errors []string               `json:"errors,omitempty"`
+
+	// Events lists the changes since the previous refresh with --watch.
+	Events []string `json:"events,omitempty"`
+
+	// warnings and errors are Warnings and Errors before formatting, for the
+	// human-readable output in the user's language; JSON stays English.
+	warnings, errors []i18n.Message
+}
+
+func (o *statusOutput) warn(m i18n.Message) {
+	o.warnings = append(o.warnings, m)
+	o.Warnings = append(o.Warnings, m.English())
+}
+
+func (o *statusOutput) fail(m i18n.Message) {
+	o.errors = append(o.errors, m)
+	o.Errors = append(o.Errors, m.English())
 }
 
 func runStatus(args []string) {
@@ -62,6 +125,9 @@ func runStatus(args []string) {
 	flagOnlyGames := fs.Bool("only-games", false, "alias for --filter=games")
 	flagAll := fs.Bool("all", false, "alias for --filter=all")
 	flagConfig := fs.String("config", "", "config file path (TOML). Default: XDG config path")
+	flagSample := fs.Duration("sample", time.Second, "CPU utilization sample window (0 disables)")
+	flagWatch := fs.Bool("watch", false, "refresh until interrupted, showing changes as they happen")
+	flagInterval := fs.Duration("interval", time.Second, "refresh interval for --watch")
 	_ = fs.Parse(args)
 
 	filter := strings.ToLower(strings.TrimSpace(*flagFilter))
@@ -100,21 +166,48 @@ func runStatus(args []string) {
 		fatal(err)
 	}
 
-	st, err := state.Load(statePath)
+	if *flagWatch {
+		if *flagInterval < 100*time.Millisecond {
+			fatal(fmt.Errorf("invalid --interval=%s (minimum 100ms)", *flagInterval))
+		}
+		watchStatus(cfg, configPath, statePath, filter, *flagInterval, *flagJSON)
+		return
+	}
+
+	out, err := collectStatus(cfg, configPath, statePath, filter, *flagSample)
 	if err != nil {
 		fatal(err)
 	}
+	if *flagJSON {
+		b, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+
+	printStatusHuman(out, false)
+}
+
+// collectStatus gathers one status snapshot. CPU utilization is sampled over
+// sample, so the call takes at least that long.
+func collectStatus(cfg config.Config, configPath, statePath, filter string, sample time.Duration) (statusOutput, error) {
+	st, err := state.Load(statePath)
+	stateErr := err
+	if errors.Is(err, state.ErrCorrupt) {
+		err = nil
+	}
+	if err != nil {
+		return statusOutput{}, err
+	}
 
 	osCPUs := strings.TrimSpace(st.OSCPUs)
 	gameCPUs := strings.TrimSpace(st.GameCPUs)
 	if osCPUs == "" || gameCPUs == "" {
-		resOS, resGame, err := resolveCPUs(cfg)
-		if err == nil {
+		if s, err := newSettings(cfg, os.Getuid(), false); err == nil {
 			if osCPUs == "" {
-				osCPUs = resOS
+				osCPUs = s.osCPUs
 			}
 			if gameCPUs == "" {
-				gameCPUs = resGame
+				gameCPUs = s.gameCPUs
 			}
 		}
 	}
@@ -128,9 +221,64 @@ func runStatus(args []string) {
 		GameCPUs:    gameCPUs,
 		State:       st,
 	}
+	if stateErr != nil {
+		out.warn(i18n.Msg("status.warn.state_unusable", stateErr))
+	}
+	if resp, err := controlCall(controlRequest{Op: "health"}, time.Second); err == nil && resp.OK {
+		out.Daemon = resp.Health
+	}
+	if topo, err := detector(cfg); err == nil {
+		if res, err := topo.Detect(topology.PreferCache); err == nil {
+			out.Clusters = res.Clusters
+		}
+	}
+	out.Budget = cpuBudget(osCPUs, gameCPUs, sample)
+	for _, c := range out.Budget {
+		if c.Class == "os" && sample > 0 && c.Utilization >= osSaturatedThreshold {
+			out.warn(i18n.Msg("status.warn.os_busy", c.Utilization*100, sample))
+		}
+	}
+	failedIDs := make([]string, 0, len(st.ScopeFailures))
+	for id := range st.ScopeFailures {
+		failedIDs = append(failedIDs, id)
+	}
+	sort.Strings(failedIDs)
+	for _, id := range failedIDs {
+		if f := st.ScopeFailures[id]; f.Attempts >= scopeFailurePersistent {
+			out.warn(i18n.Msg("status.warn.scope_failing", id, f.Since.Format(time.RFC3339), f.Attempts, f.LastError))
+		}
+	}
+	if cfg.DMALatency >= 0 {
+		if err := pmqos.Check(); err != nil {
+			out.warn(i18n.Msg("status.warn.dma_latency", err))
+		}
+	}
+	if st.Paused {
+		if st.PausedUntil.IsZero() {
+			out.warn(i18n.Msg("status.warn.paused"))
+		} else {
+			out.warn(i18n.Msg("status.warn.paused_until", st.PausedUntil.Format(time.RFC3339)))
+		}
+	}
+	out.Reserved = reservations(cfg, os.Getuid())
+	if resOS, resGame, err := resolveCPUs(cfg); err == nil {
+		for _, set := range []struct{ key, cpus string }{{"os_cpus", resOS}, {"game_cpus", resGame}} {
+			_, cpus, _ := topology.CanonicalizeCPUList(set.cpus)
+			for _, r := range reserve.Overlapping(out.Reserved, cpus) {
+				out.warn(i18n.Msg("status.warn.reserved_overlap", set.key, set.cpus, r.CPUs, r.Source))
+			}
+		}
+	}
+	for _, unit := range sortedKeys(st.KeptAllowedCPUs) {
+		out.warn(i18n.Msg("status.warn.kept", unit, st.KeptAllowedCPUs[unit]))
+	}
+	if !st.OSSaturatedSince.IsZero() {
+		out.warn(i18n.Msg("status.warn.os_saturated", osSaturatedThreshold*100, st.OSSaturatedSince.Format(time.RFC3339)))
+	}
 
-	sys := systemdctl.Systemctl{}
-	slices := slicesToPin(cfg)
+	sys, _ := newSystemctl(cfg, os.Getuid(), false)
+	slices := systemdctl.ExpandCgroupGlobs(slicesToPin(cfg))
+	slices = settings{cfg: cfg}.withDiscovered(context.Background(), sys, slices)
 	for _, unit := range slices {
 		ss := statusSlice{Unit: unit}
 		if st.OriginalAllowedCPUs != nil {
@@ -147,13 +295,42 @@ func runStatus(args []string) {
 		out.Slices = append(out.Slices, ss)
 	}
 
+	ctx2, cancel := systemdctl.DefaultContext()
+	scopes, err := sys.ListUnits(ctx2, "game-*.scope")
+	cancel()
+	if err != nil {
+		out.fail(i18n.Msg("status.fail.list_scopes", err))
+	}
+	for _, unit := range scopes {
+		ctx2, cancel := systemdctl.DefaultContext()
+		allowed, err := sys.GetAllowedCPUs(ctx2, unit)
+		cancel()
+		if err != nil {
+			continue
+		}
+		out.Scopes = append(out.Scopes, statusScope{Unit: unit, AllowedCPUs: allowed})
+	}
+
 	uid := os.Getuid()
+	for _, g := range guests.Detect(uid) {
+		sg := statusGuest{Cgroup: g.Cgroup, Kind: g.Kind, Runtime: g.Runtime}
+		sg.OriginalAllowed, sg.Pinned = st.OriginalGuestCPUs[g.Cgroup]
+		ctx2, cancel := systemdctl.DefaultContext()
+		sg.AllowedCPUs, _ = sys.GetAllowedCPUs(ctx2, g.Cgroup)
+		cancel()
+		out.Guests = append(out.Guests, sg)
+	}
 	{
-		scanner := procscan.NewScanner(uid, cfg.EnvKeys, cfg.ExeAllowlist, cfg.IgnoreExe)
+		scanner := newScanner(cfg, uid)
 		games, err := scanner.Scan()
 		if err != nil {
-			out.Errors = append(out.Errors, fmt.Sprintf("scan games: %v", err))
+			out.fail(i18n.Msg("status.fail.scan_games", err))
 		} else {
+			if a := scanner.Access(); a.Broad() {
+				for _, w := range scanAccessWarnings(a) {
+					out.warn(w)
+				}
+			}
 			gameIDs := make([]string, 0, len(games))
 			for id := range games {
 				gameIDs = append(gameIDs, id)
@@ -163,10 +340,14 @@ func runStatus(args []string) {
 				procs := games[gameID]
 				sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
 				for _, gp := range procs {
-					p := statusGameProc{PID: gp.PID, Exe: gp.Exe, GameID: gp.GameID, IDSource: gp.IDSource}
+					p := statusGameProc{PID: gp.PID, Exe: gp.Exe, GameID: gp.GameID, IDSource: gp.IDSource, Bits: gp.Bits}
 					if allowed, err := procscan.AllowedCPUs(gp.PID); err == nil {
 						p.AllowedCPUs = allowed
 					}
+					p.Scope, p.ExpectedCPUs = gameScopeCPUs(gp.PID)
+					if p.AllowedCPUs != "" && p.ExpectedCPUs != "" {
+						p.Drifted = topology.UnionCPULists(p.AllowedCPUs) != p.ExpectedCPUs
+					}
 					out.Games = append(out.Games, p)
 				}
 			}
@@ -176,7 +357,7 @@ func runStatus(args []string) {
 	if filter == "all" {
 		all, err := procscan.ScanUserCPUConstraints(uid)
 		if err != nil {
-			out.Errors = append(out.Errors, fmt.Sprintf("scan all processes: %v", err))
+			out.fail(i18n.Msg("status.fail.scan_all", err))
 		} else {
 			type key struct {
 				exe   string
@@ -222,69 +403,178 @@ func runStatus(args []string) {
 		}
 	}
 
-	if *flagJSON {
-		b, _ := json.MarshalIndent(out, "", "  ")
-		fmt.Println(string(b))
-		return
-	}
-
-	printStatusHuman(out)
+	return out, nil
 }
 
-func printStatusHuman(out statusOutput) {
-	fmt.Printf("state: %s\n", out.StatePath)
-	fmt.Printf("pin_applied: %v\n", out.State.PinApplied)
+// printStatusHuman prints out as text in the user's language. With color,
+// processes whose affinity drifted are highlighted.
+func printStatusHuman(out statusOutput, color bool) {
+	msg := i18n.Default()
+	msg.Println("status.state", out.StatePath)
+	msg.Println("status.pin_applied", out.State.PinApplied)
+	if len(out.Clusters) > 0 {
+		parts := make([]string, 0, len(out.Clusters))
+		for _, c := range out.Clusters {
+			part := fmt.Sprintf("%d=%s", c.ID, c.CPUs)
+			if c.L3KB > 0 {
+				part += fmt.Sprintf("(l3=%dMiB)", c.L3KB/1024)
+			}
+			if c.Nodes != "" {
+				part += fmt.Sprintf("(node=%s)", c.Nodes)
+			}
+			parts = append(parts, part)
+		}
+		msg.Println("status.clusters", strings.Join(parts, " "))
+	}
 	if out.OSCPUs != "" {
-		fmt.Printf("os_cpus: %s\n", out.OSCPUs)
+		msg.Println("status.os_cpus", out.OSCPUs)
 	}
 	if out.GameCPUs != "" {
-		fmt.Printf("game_cpus: %s\n", out.GameCPUs)
+		msg.Println("status.game_cpus", out.GameCPUs)
+	}
+	if out.State.OSMemoryNodes != "" {
+		msg.Println("status.os_memory_nodes", out.State.OSMemoryNodes)
+	}
+	if out.State.LentCPUs != "" {
+		msg.Println("status.lent_cpus", out.State.LentCPUs)
+	}
+	if out.State.IRQBalanceBanned != "" {
+		msg.Println("status.irqbalance_banned", out.State.IRQBalanceBanned)
+	}
+	if out.State.SMTDisabled != "" {
+		msg.Println("status.smt_disabled", out.State.SMTDisabled)
+	}
+	if out.State.PowerProfile != "" {
+		msg.Println("status.power_profile", out.State.PowerProfile, out.State.OriginalPowerProfile)
+	}
+	if len(out.Reserved) > 0 {
+		msg.Println("status.reserved")
+		for _, r := range out.Reserved {
+			msg.Println("status.reserved_entry", r.Source, r.CPUs)
+		}
+	}
+	if len(out.Budget) > 0 {
+		msg.Println("status.budget")
+		for _, c := range out.Budget {
+			msg.Println("status.budget_class", c.Class, c.Count, c.CPUs, c.Utilization*100)
+		}
+	}
+	for _, w := range out.warnings {
+		msg.Println("status.warning", msg.Format(w))
+	}
+	if h := out.Daemon; h != nil {
+		msg.Println("status.daemon")
+		msg.Println("status.uptime", time.Since(h.StartedAt).Round(time.Second))
+		msg.Println("status.runtime",
+			h.Goroutines, float64(h.HeapAlloc)/(1<<20), float64(h.HeapSys)/(1<<20), h.NumGC, h.LastGCPause, h.MaxGCPause)
+		msg.Println("status.ticks",
+			h.Ticks, h.SlowTicks, h.LastTickDuration.Round(time.Microsecond), h.MaxTickDuration.Round(time.Microsecond),
+			h.LastTickLag.Round(time.Microsecond), h.MaxTickLag.Round(time.Microsecond))
 	}
 
 	if len(out.Slices) > 0 {
-		fmt.Println("slices:")
+		msg.Println("status.slices")
 		for _, s := range out.Slices {
-			line := fmt.Sprintf("  %s: AllowedCPUs=%q", s.Unit, s.AllowedCPUs)
+			line := msg.Sprintf("status.slice", s.Unit, s.AllowedCPUs)
 			if s.ReadAllowedCPUErr != "" {
-				line = fmt.Sprintf("  %s: error=%s", s.Unit, s.ReadAllowedCPUErr)
+				line = msg.Sprintf("status.slice_error", s.Unit, s.ReadAllowedCPUErr)
 			}
 			if s.OriginalAllowed != "" || out.State.PinApplied {
-				line += fmt.Sprintf(" (original=%q)", s.OriginalAllowed)
+				line += msg.Sprintf("status.original", s.OriginalAllowed)
 			}
 			fmt.Println(line)
 		}
 	}
 
+	if len(out.Guests) > 0 {
+		msg.Println("status.guests")
+		for _, g := range out.Guests {
+			line := msg.Sprintf("status.guest", g.Runtime, g.Kind, g.Cgroup, g.AllowedCPUs)
+			if g.Pinned {
+				line += msg.Sprintf("status.original", g.OriginalAllowed)
+			}
+			fmt.Println(line)
+		}
+	}
+
+	if len(out.Scopes) > 0 {
+		msg.Println("status.scopes")
+		for _, s := range out.Scopes {
+			msg.Println("status.scope", s.Unit, s.AllowedCPUs)
+		}
+	}
+
 	if out.Filter == "games" || out.Filter == "all" {
 		if len(out.Games) == 0 {
-			fmt.Println("games: none")
+			msg.Println("status.games_none")
 		} else {
-			fmt.Println("games:")
+			msg.Println("status.games")
 			for _, g := range out.Games {
 				allowed := g.AllowedCPUs
 				if allowed == "" {
 					allowed = "?"
 				}
-				fmt.Printf("  pid=%d exe=%s game_id=%s src=%s allowed=%s\n", g.PID, g.Exe, g.GameID, g.IDSource, allowed)
+				line := msg.Sprintf("status.game", g.PID, g.Exe, g.GameID, g.IDSource, g.Bits, allowed)
+				if g.Scope != "" {
+					line += msg.Sprintf("status.game_scope", g.Scope)
+				}
+				if g.Drifted {
+					line += msg.Sprintf("status.drifted", g.ExpectedCPUs)
+					if color {
+						line = "\033[1;31m" + line + "\033[0m"
+					}
+				}
+				fmt.Println(line)
 			}
 		}
 	}
 
 	if out.Filter == "all" {
 		if len(out.All) == 0 {
-			fmt.Println("affected: none")
+			msg.Println("status.affected_none")
 		} else {
-			fmt.Println("affected:")
+			msg.Println("status.affected")
 			for _, s := range out.All {
-				fmt.Printf("  class=%s exe=%s count=%d allowed=%s pids=%v\n", s.Class, s.Exe, s.Count, s.AllowedCPUs, s.SamplePIDs)
+				msg.Println("status.affected_entry", s.Class, s.Exe, s.Count, s.AllowedCPUs, s.SamplePIDs)
 			}
 		}
 	}
 
 	if len(out.Errors) > 0 {
-		fmt.Println("errors:")
-		for _, e := range out.Errors {
-			fmt.Printf("  %s\n", e)
+		msg.Println("status.errors")
+		for _, e := range out.errors {
+			msg.Println("status.error", msg.Format(e))
+		}
+	}
+}
+
+// cpuBudget reports the size of each CPU class and, when window > 0, its
+// utilization sampled over window.
+func cpuBudget(osCPUs, gameCPUs string, window time.Duration) []statusClass {
+	classes := make([]statusClass, 0, 2)
+	sets := make([][]int, 0, 2)
+	for _, c := range []struct{ class, cpus string }{{"os", osCPUs}, {"game", gameCPUs}} {
+		canonical, cpus, err := topology.CanonicalizeCPUList(c.cpus)
+		if err != nil || len(cpus) == 0 {
+			continue
 		}
+		classes = append(classes, statusClass{Class: c.class, CPUs: canonical, Count: len(cpus)})
+		sets = append(sets, cpus)
+	}
+	if window <= 0 || len(classes) == 0 {
+		return classes
+	}
+	prev, err := cpuload.Sample()
+	if err != nil {
+		return classes
+	}
+	time.Sleep(window)
+	cur, err := cpuload.Sample()
+	if err != nil {
+		return classes
+	}
+	for i := range classes {
+		classes[i].Utilization = cpuload.Utilization(prev, cur, sets[i])
 	}
+	return classes
 }
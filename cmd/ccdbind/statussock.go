@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/health"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/peercred"
+	"github.com/Reidond/ccdbind/internal/state"
+)
+
+// publicStatus is what the status socket reveals: whether and how the CPUs
+// are split, but not which games run or anything about the user's
+// processes.
+type publicStatus struct {
+	PinApplied  bool      `json:"pin_applied"`
+	Paused      bool      `json:"paused"`
+	PausedUntil time.Time `json:"paused_until,omitempty"`
+	OSCPUs      string    `json:"os_cpus,omitempty"`
+	GameCPUs    string    `json:"game_cpus,omitempty"`
+	LentCPUs    string    `json:"lent_cpus,omitempty"`
+	GameScopes  int       `json:"game_scopes"`
+	OSSaturated bool      `json:"os_saturated"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	StartedAt time.Time `json:"started_at"`
+	LastTick  time.Time `json:"last_tick,omitempty"`
+}
+
+// serveStatus answers "status" requests on addr, a socket other local users
+// can reach, for those allow permits besides owner. Peers are identified by
+// SO_PEERCRED; no op that changes anything is served. The status is read
+// from the state file, which the main loop keeps current.
+func serveStatus(ctx context.Context, addr string, allow peercred.Allow, owner int, statePath string, tr *health.Tracker) error {
+	if !strings.HasPrefix(addr, "@") {
+		// Only replace a stale socket, never some other file.
+		if fi, err := os.Lstat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(addr)
+		}
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: addr, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(addr, "@") {
+		// Access is checked per connection instead.
+		if err := os.Chmod(addr, 0o666); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ln.AcceptUnix()
+			if err != nil {
+				return
+			}
+			go handleStatusConn(conn, allow, owner, statePath, tr)
+		}
+	}()
+	log.Printf("status socket listening on %s", addr)
+	return nil
+}
+
+func handleStatusConn(conn *net.UnixConn, allow peercred.Allow, owner int, statePath string, tr *health.Tracker) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	resp := controlResponse{}
+	cred, err := peercred.FromConn(conn)
+	switch {
+	case err != nil:
+		resp.Error = fmt.Sprintf("peer credentials: %v", err)
+	case cred.UID != owner && !allow.Permits(cred):
+		logging.Debugf(logging.Fields{"PID": strconv.Itoa(cred.PID)}, "status socket: refused uid %d gid %d", cred.UID, cred.GID)
+		resp.Error = "permission denied"
+	default:
+		var req controlRequest
+		if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+			resp.Error = fmt.Sprintf("decode request: %v", err)
+		} else if req.Op != "status" {
+			resp.Error = fmt.Sprintf("op %q not allowed on the read-only status socket", req.Op)
+		} else if st, err := state.Load(statePath); err != nil {
+			resp.Error = fmt.Sprintf("read state: %v", err)
+		} else {
+			ps := newPublicStatus(st, tr.Snapshot())
+			resp.OK, resp.Status = true, &ps
+		}
+	}
+	_ = json.NewEncoder(conn).Encode(resp)
+}
+
+func newPublicStatus(st state.File, h health.Snapshot) publicStatus {
+	units := map[string]bool{}
+	for _, sp := range st.ScopePIDs {
+		units[sp.Unit] = true
+	}
+	ps := publicStatus{
+		PinApplied:  st.PinApplied,
+		Paused:      st.Paused,
+		PausedUntil: st.PausedUntil,
+		GameScopes:  len(units),
+		OSSaturated: !st.OSSaturatedSince.IsZero(),
+		UpdatedAt:   st.UpdatedAt,
+		StartedAt:   h.StartedAt,
+		LastTick:    h.LastTick,
+	}
+	if st.PinApplied {
+		ps.OSCPUs, ps.GameCPUs, ps.LentCPUs = st.OSCPUs, st.GameCPUs, st.LentCPUs
+	}
+	return ps
+}
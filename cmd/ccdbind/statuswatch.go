@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/i18n"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// watchEvents is how many recent events the live view keeps on screen.
+const watchEvents = 12
+
+// watchStatus refreshes the status every interval until interrupted. On a
+// terminal the screen is redrawn with the recent events below; otherwise the
+// full status is printed once and then only the events, one per line. With
+// asJSON every refresh is one JSON object per line.
+func watchStatus(cfg config.Config, configPath, statePath, filter string, interval time.Duration, asJSON bool) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tty := isTerminal(os.Stdout)
+	color := tty && os.Getenv("NO_COLOR") == ""
+	msg := i18n.Default()
+	var prev *statusOutput
+	var events, recent []string
+	for ctx.Err() == nil {
+		start := time.Now()
+		// Utilization is sampled over the refresh interval itself.
+		out, err := collectStatus(cfg, configPath, statePath, filter, interval)
+		if err != nil {
+			fatal(err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		events = events[:0]
+		if prev != nil {
+			stamp := out.GeneratedAt.Format("15:04:05")
+			for _, e := range statusTransitions(*prev, out) {
+				out.Events = append(out.Events, stamp+" "+e.English())
+				events = append(events, stamp+" "+msg.Format(e))
+			}
+		}
+		recent = append(recent, events...)
+		if len(recent) > watchEvents {
+			recent = recent[len(recent)-watchEvents:]
+		}
+
+		switch {
+		case asJSON:
+			b, _ := json.Marshal(out)
+			fmt.Println(string(b))
+		case tty:
+			fmt.Print("\033[H\033[2J")
+			msg.Println("watch.header", interval, out.GeneratedAt.Format("15:04:05"))
+			fmt.Println()
+			printStatusHuman(out, color)
+			if len(recent) > 0 {
+				msg.Println("watch.events")
+				for _, e := range recent {
+					msg.Println("watch.event", e)
+				}
+			}
+		case prev == nil:
+			printStatusHuman(out, false)
+		default:
+			for _, e := range events {
+				fmt.Println(e)
+			}
+		}
+		prev = &out
+
+		// collectStatus already waited out the interval while sampling.
+		if rest := interval - time.Since(start); rest > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(rest):
+			}
+		}
+	}
+}
+
+// statusTransitions describes what changed from prev to cur: pin state,
+// games, game scopes and affinity drift.
+func statusTransitions(prev, cur statusOutput) []i18n.Message {
+	var out []i18n.Message
+	ps, cs := prev.State, cur.State
+	switch {
+	case !ps.PinApplied && cs.PinApplied:
+		out = append(out, i18n.Msg("watch.pinned", cs.OSCPUs))
+	case ps.PinApplied && !cs.PinApplied:
+		out = append(out, i18n.Msg("watch.restored"))
+	case cs.PinApplied && ps.OSCPUs != cs.OSCPUs:
+		out = append(out, i18n.Msg("watch.os_moved", ps.OSCPUs, cs.OSCPUs))
+	}
+	if ps.LentCPUs != cs.LentCPUs {
+		if cs.LentCPUs != "" {
+			out = append(out, i18n.Msg("watch.lent", cs.LentCPUs))
+		} else {
+			out = append(out, i18n.Msg("watch.returned", ps.LentCPUs))
+		}
+	}
+	if ps.Paused != cs.Paused {
+		if cs.Paused {
+			out = append(out, i18n.Msg("watch.paused"))
+		} else {
+			out = append(out, i18n.Msg("watch.resumed"))
+		}
+	}
+
+	games := func(o statusOutput) map[string][]string {
+		m := map[string][]string{}
+		for _, g := range o.Games {
+			m[g.GameID] = append(m[g.GameID], fmt.Sprint(g.PID))
+		}
+		return m
+	}
+	pg, cg := games(prev), games(cur)
+	for _, id := range sortedKeys(cg) {
+		if _, ok := pg[id]; !ok {
+			out = append(out, i18n.Msg("watch.game_started", id, strings.Join(cg[id], ",")))
+		}
+	}
+	for _, id := range sortedKeys(pg) {
+		if _, ok := cg[id]; !ok {
+			out = append(out, i18n.Msg("watch.game_exited", id))
+		}
+	}
+
+	scopes := func(o statusOutput) map[string]string {
+		m := map[string]string{}
+		for _, s := range o.Scopes {
+			m[s.Unit] = s.AllowedCPUs
+		}
+		return m
+	}
+	pscope, cscope := scopes(prev), scopes(cur)
+	for _, unit := range sortedKeys(cscope) {
+		old, ok := pscope[unit]
+		switch {
+		case !ok:
+			out = append(out, i18n.Msg("watch.scope_created", unit, cscope[unit]))
+		case old != cscope[unit]:
+			out = append(out, i18n.Msg("watch.scope_moved", unit, old, cscope[unit]))
+		}
+	}
+	for _, unit := range sortedKeys(pscope) {
+		if _, ok := cscope[unit]; !ok {
+			out = append(out, i18n.Msg("watch.scope_gone", unit))
+		}
+	}
+
+	drifted := map[int]bool{}
+	for _, g := range prev.Games {
+		drifted[g.PID] = g.Drifted
+	}
+	for _, g := range cur.Games {
+		was, seen := drifted[g.PID]
+		switch {
+		case g.Drifted && !was:
+			out = append(out, i18n.Msg("watch.drifted", g.PID, g.Exe, g.AllowedCPUs, g.ExpectedCPUs))
+		case !g.Drifted && was && seen:
+			out = append(out, i18n.Msg("watch.back", g.PID, g.Exe, g.AllowedCPUs))
+		}
+	}
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// gameScopeCPUs returns the game scope holding pid and the effective cpuset
+// of pid's cgroup, or empty strings when pid is not in a game scope.
+func gameScopeCPUs(pid int) (string, string) {
+	cg, err := procscan.Cgroup(pid)
+	if err != nil {
+		return "", ""
+	}
+	scope := ""
+	for _, part := range strings.Split(cg, "/") {
+		if strings.HasPrefix(part, "game-") && strings.HasSuffix(part, ".scope") {
+			scope = part
+		}
+	}
+	if scope == "" {
+		return "", ""
+	}
+	data, err := os.ReadFile(filepath.Join(systemdctl.CgroupRoot, cg, "cpuset.cpus.effective"))
+	if err != nil {
+		return scope, ""
+	}
+	return scope, topology.UnionCPULists(strings.TrimSpace(string(data)))
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,800 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/boost"
+	"github.com/Reidond/ccdbind/internal/clock"
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// The tick scenarios drive handleTick through a sequence of scans against a
+// cgroupfs tree in a temporary directory, standing in for the user manager.
+// Each step may advance the clock, change the tree behind the daemon's back
+// or break a cgroup so writes to it fail, then runs one tick with the games
+// found by the scan and checks the result.
+
+const (
+	tickBase     = "user.slice/user-1000.slice/user@1000.service"
+	tickOSCPUs   = "0-3"
+	tickGameCPUs = "4-7"
+)
+
+var tickSlices = []string{"app.slice", "background.slice"}
+
+type tickScenario struct {
+	name  string
+	cfg   func(*config.Config)
+	steps []tickStep
+}
+
+// tickStep is one tick. The fields before games are applied first, in
+// order.
+type tickStep struct {
+	advance time.Duration
+	// set writes AllowedCPUs of slices as another program would.
+	set map[string]string
+	// breaks and fixes are units whose cgroup stops or resumes accepting
+	// writes; a "/" selects a file inside the unit's cgroup.
+	breaks, fixes []string
+	// announced maps games to the scope a pin request says they run in.
+	announced map[string]string
+	games     map[string][]procscan.GameProcess
+	want      tickWant
+}
+
+// tickWant is checked after the tick. Nil maps are not checked.
+type tickWant struct {
+	err    bool
+	pinned bool
+	// slices and scopes map units to their AllowedCPUs.
+	slices, scopes map[string]string
+	// pids maps tracked PIDs to their scope.
+	pids map[int]string
+	// failing maps games with a pending retry to their failed attempts.
+	failing map[string]int
+}
+
+// games builds the scan result of a step from game(...) entries.
+func games(gs ...map[string][]procscan.GameProcess) map[string][]procscan.GameProcess {
+	out := map[string][]procscan.GameProcess{}
+	for _, g := range gs {
+		for id, procs := range g {
+			out[id] = append(out[id], procs...)
+		}
+	}
+	return out
+}
+
+// game returns gameID with pids, each started at its PID as start time.
+func game(gameID string, pids ...int) map[string][]procscan.GameProcess {
+	procs := make([]procscan.GameProcess, 0, len(pids))
+	for _, pid := range pids {
+		procs = append(procs, procscan.GameProcess{PID: pid, StartTime: uint64(pid), GameID: gameID})
+	}
+	return map[string][]procscan.GameProcess{gameID: procs}
+}
+
+func scope(gameID string) string { return systemdctl.UnitNameForGameID(gameID) }
+
+// slicesAt is the AllowedCPUs of every slice set to cpus.
+func slicesAt(cpus string) map[string]string {
+	out := map[string]string{}
+	for _, s := range tickSlices {
+		out[s] = cpus
+	}
+	return out
+}
+
+func TestHandleTickScenarios(t *testing.T) {
+	for _, sc := range []tickScenario{
+		{
+			name: "pin and restore",
+			steps: []tickStep{
+				{games: games(), want: tickWant{slices: slicesAt("")}},
+				{games: games(game("a", 10, 11)), want: tickWant{
+					pinned: true,
+					slices: slicesAt(tickOSCPUs),
+					scopes: map[string]string{scope("a"): tickGameCPUs},
+					pids:   map[int]string{10: scope("a"), 11: scope("a")},
+				}},
+				{games: games(game("a", 10, 11)), want: tickWant{pinned: true, slices: slicesAt(tickOSCPUs)}},
+				{games: games(), want: tickWant{slices: slicesAt(""), pids: map[int]string{}}},
+			},
+		},
+		{
+			name: "games come and go between ticks",
+			steps: []tickStep{
+				{games: games(game("a", 10), game("b", 20)), want: tickWant{
+					pinned: true,
+					pids:   map[int]string{10: scope("a"), 20: scope("b")},
+				}},
+				{games: games(game("a", 10, 12)), want: tickWant{
+					pinned: true,
+					pids:   map[int]string{10: scope("a"), 12: scope("a")},
+				}},
+				{games: games(game("b", 21)), want: tickWant{
+					pinned: true,
+					slices: slicesAt(tickOSCPUs),
+					pids:   map[int]string{21: scope("b")},
+				}},
+				{games: games(), want: tickWant{slices: slicesAt(""), pids: map[int]string{}}},
+			},
+		},
+		{
+			name: "a reused PID is placed again",
+			steps: []tickStep{
+				{games: games(game("a", 10)), want: tickWant{pinned: true, pids: map[int]string{10: scope("a")}}},
+				{games: map[string][]procscan.GameProcess{"b": {{PID: 10, StartTime: 99, GameID: "b"}}}, want: tickWant{
+					pinned: true,
+					pids:   map[int]string{10: scope("b")},
+				}},
+			},
+		},
+		{
+			name: "one game's scope fails, the other is placed",
+			steps: []tickStep{
+				{breaks: []string{scope("b") + "/cgroup.procs"}, games: games(game("a", 10), game("b", 20)), want: tickWant{
+					pinned:  true,
+					slices:  slicesAt(tickOSCPUs),
+					pids:    map[int]string{10: scope("a")},
+					failing: map[string]int{"b": 1},
+				}},
+				{advance: 500 * time.Millisecond, fixes: []string{scope("b") + "/cgroup.procs"}, games: games(game("a", 10), game("b", 20)), want: tickWant{
+					pinned:  true,
+					pids:    map[int]string{10: scope("a")},
+					failing: map[string]int{"b": 1},
+				}},
+				{advance: 500 * time.Millisecond, games: games(game("a", 10), game("b", 20)), want: tickWant{
+					pinned:  true,
+					pids:    map[int]string{10: scope("a"), 20: scope("b")},
+					failing: map[string]int{},
+				}},
+			},
+		},
+		{
+			name: "a failing game that exits is forgotten",
+			steps: []tickStep{
+				{breaks: []string{scope("b") + "/cgroup.procs"}, games: games(game("a", 10), game("b", 20)), want: tickWant{
+					pinned:  true,
+					failing: map[string]int{"b": 1},
+				}},
+				{advance: 2 * time.Second, games: games(game("a", 10), game("b", 20)), want: tickWant{
+					pinned:  true,
+					failing: map[string]int{"b": 2},
+				}},
+				{games: games(game("a", 10)), want: tickWant{pinned: true, failing: map[string]int{}}},
+			},
+		},
+		{
+			name: "a slice that cannot be pinned fails the tick",
+			steps: []tickStep{
+				{breaks: []string{"background.slice"}, games: games(game("a", 10)), want: tickWant{err: true}},
+				{fixes: []string{"background.slice"}, games: games(game("a", 10)), want: tickWant{
+					pinned: true,
+					slices: slicesAt(tickOSCPUs),
+					pids:   map[int]string{10: scope("a")},
+				}},
+			},
+		},
+		{
+			name: "a slice reset while pinned is pinned again",
+			steps: []tickStep{
+				{games: games(game("a", 10)), want: tickWant{pinned: true, slices: slicesAt(tickOSCPUs)}},
+				{set: map[string]string{"app.slice": ""}, games: games(game("a", 10)), want: tickWant{
+					pinned: true,
+					slices: slicesAt(tickOSCPUs),
+				}},
+			},
+		},
+		{
+			name: "a slice modified while pinned is kept",
+			steps: []tickStep{
+				{games: games(game("a", 10)), want: tickWant{pinned: true}},
+				{set: map[string]string{"app.slice": "0-1"}, games: games(game("a", 10)), want: tickWant{
+					pinned: true,
+					slices: map[string]string{"app.slice": "0-1", "background.slice": tickOSCPUs},
+				}},
+				{games: games(), want: tickWant{slices: map[string]string{"app.slice": "0-1", "background.slice": ""}}},
+			},
+		},
+		{
+			name: "original restore policy pins a modified slice again",
+			cfg:  func(c *config.Config) { c.RestorePolicy = config.RestoreOriginal },
+			steps: []tickStep{
+				{games: games(game("a", 10)), want: tickWant{pinned: true}},
+				{set: map[string]string{"app.slice": "0-1"}, games: games(game("a", 10)), want: tickWant{
+					pinned: true,
+					slices: slicesAt(tickOSCPUs),
+				}},
+				{games: games(), want: tickWant{slices: slicesAt("")}},
+			},
+		},
+		{
+			name: "the overlay gets a scope of its own",
+			cfg:  func(c *config.Config) { c.OverlayCPUs = "os" },
+			steps: []tickStep{
+				{games: map[string][]procscan.GameProcess{"a": {
+					{PID: 10, StartTime: 10, GameID: "a"},
+					{PID: 11, StartTime: 11, GameID: "a", IDSource: procscan.IDSourceOverlay},
+				}}, want: tickWant{
+					pinned: true,
+					scopes: map[string]string{scope("a"): tickGameCPUs, scope("a-overlay"): tickOSCPUs},
+					pids:   map[int]string{10: scope("a"), 11: scope("a-overlay")},
+				}},
+			},
+		},
+		{
+			name: "a game in an announced scope stays there",
+			steps: []tickStep{
+				{announced: map[string]string{"a": "ccdpin-5.scope"}, games: games(game("a", 10)), want: tickWant{
+					pinned: true,
+					scopes: map[string]string{"ccdpin-5.scope": tickGameCPUs, scope("a"): ""},
+					pids:   map[int]string{10: "ccdpin-5.scope"},
+				}},
+				{announced: map[string]string{"a": "ccdpin-5.scope"}, games: games(game("a", 10, 11)), want: tickWant{
+					pinned: true,
+					pids:   map[int]string{10: "ccdpin-5.scope", 11: "ccdpin-5.scope"},
+				}},
+			},
+		},
+		{
+			name: "pin delay waits for games to persist",
+			cfg:  func(c *config.Config) { c.PinDelay = 5 * time.Second },
+			steps: []tickStep{
+				{games: games(game("a", 10)), want: tickWant{slices: slicesAt(""), pids: map[int]string{}}},
+				{advance: 2 * time.Second, games: games(), want: tickWant{slices: slicesAt("")}},
+				{advance: 4 * time.Second, games: games(game("a", 11)), want: tickWant{slices: slicesAt(""), pids: map[int]string{}}},
+				{advance: 4 * time.Second, games: games(game("a", 11), game("b", 20)), want: tickWant{slices: slicesAt("")}},
+				{advance: time.Second, games: games(game("a", 11), game("b", 20)), want: tickWant{
+					pinned: true,
+					slices: slicesAt(tickOSCPUs),
+					pids:   map[int]string{11: scope("a"), 20: scope("b")},
+				}},
+				// Joining an applied pin does not wait.
+				{games: games(game("a", 11), game("c", 30)), want: tickWant{
+					pinned: true,
+					pids:   map[int]string{11: scope("a"), 30: scope("c")},
+				}},
+			},
+		},
+		{
+			name: "pin delay does not hold a pin request",
+			cfg:  func(c *config.Config) { c.PinDelay = 5 * time.Second },
+			steps: []tickStep{
+				{games: map[string][]procscan.GameProcess{"a": {{PID: 10, StartTime: 10, GameID: "a", IDSource: "request"}}}, want: tickWant{
+					pinned: true,
+					pids:   map[int]string{10: scope("a")},
+				}},
+			},
+		},
+		{
+			name: "restart keepalive holds the pin",
+			cfg:  func(c *config.Config) { c.RestartKeepalive = 10 * time.Second },
+			steps: []tickStep{
+				{games: games(game("a", 10)), want: tickWant{pinned: true}},
+				{advance: 2 * time.Second, games: games(), want: tickWant{pinned: true, slices: slicesAt(tickOSCPUs)}},
+				{advance: 5 * time.Second, games: games(game("a", 30)), want: tickWant{
+					pinned: true,
+					pids:   map[int]string{30: scope("a")},
+				}},
+				{advance: 2 * time.Second, games: games(), want: tickWant{pinned: true}},
+				{advance: 9 * time.Second, games: games(), want: tickWant{pinned: true}},
+				{advance: 2 * time.Second, games: games(), want: tickWant{slices: slicesAt("")}},
+			},
+		},
+	} {
+		t.Run(sc.name, func(t *testing.T) {
+			runTickScenario(t, sc)
+		})
+	}
+}
+
+// tickHarness is the daemon state a scenario runs against.
+type tickHarness struct {
+	t         *testing.T
+	r         *runtime
+	sys       systemdctl.Systemctl
+	mgr       *systemdctl.UserManager
+	clk       *clock.Fake
+	statePath string
+	st        state.File
+}
+
+func runTickScenario(t *testing.T, sc tickScenario) {
+	h := newTickHarness(t, sc.cfg)
+	for i, step := range sc.steps {
+		h.clk.Advance(step.advance)
+		for unit, cpus := range step.set {
+			h.write(unit, "cpuset.cpus", cpus)
+		}
+		for _, u := range step.breaks {
+			h.setBroken(u, true)
+		}
+		for _, u := range step.fixes {
+			h.setBroken(u, false)
+		}
+		h.r.requestScopes = step.announced
+		for _, unit := range step.announced {
+			h.ensureScope(unit)
+		}
+		for id := range step.games {
+			for _, suffix := range []string{"", "-32", "-overlay"} {
+				h.ensureScope(scope(id + suffix))
+			}
+		}
+		err := handleTick(context.Background(), h.r, h.sys, h.mgr, h.statePath, &h.st, tickSlices, step.games)
+		h.settleScopes()
+		h.check(i, step.want, err)
+	}
+}
+
+func newTickHarness(t *testing.T, tweak func(*config.Config)) *tickHarness {
+	root := t.TempDir()
+	old := systemdctl.CgroupRoot
+	systemdctl.CgroupRoot = root
+	t.Cleanup(func() { systemdctl.CgroupRoot = old })
+
+	cfg := config.Default()
+	cfg.RestartKeepalive = 0
+	if tweak != nil {
+		tweak(&cfg)
+	}
+	sys := systemdctl.Systemctl{Cgroupfs: tickBase}
+	h := &tickHarness{
+		t:         t,
+		sys:       sys,
+		mgr:       systemdctl.NewCgroupfsManager(sys),
+		clk:       clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)),
+		statePath: filepath.Join(t.TempDir(), "state.json"),
+	}
+	h.r = &runtime{
+		settings: settings{
+			cfg:      cfg,
+			slices:   tickSlices,
+			topo:     &topology.Snapshot{Version: topology.SnapshotVersion, Clusters: topology.Clusters([]string{tickOSCPUs, tickGameCPUs})},
+			osCPUs:   tickOSCPUs,
+			gameCPUs: tickGameCPUs,
+		},
+		uid:         1000,
+		clock:       h.clk,
+		pidToUnit:   map[int]pidRecord{},
+		refused:     map[int]struct{}{},
+		quirkLogged: map[string]struct{}{},
+		metrics:     newDaemonMetrics(),
+	}
+	h.write("", "cgroup.subtree_control", "cpuset cpu")
+	for _, s := range append([]string{"game.slice"}, tickSlices...) {
+		h.write(s, "cgroup.subtree_control", "cpuset cpu")
+		h.write(s, "cpuset.cpus", "")
+	}
+	return h
+}
+
+// dir returns the cgroup directory of a slice or a game scope.
+func (h *tickHarness) dir(unit string) string {
+	if strings.HasSuffix(unit, ".scope") {
+		return filepath.Join(systemdctl.CgroupRoot, tickBase, "game.slice", unit)
+	}
+	return filepath.Join(systemdctl.CgroupRoot, tickBase, systemdctl.SlicePath(unit))
+}
+
+func (h *tickHarness) write(unit, file, data string) {
+	h.t.Helper()
+	p := filepath.Join(h.dir(unit), file)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		h.t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(p, []byte(data+"\n"), 0o644); err != nil {
+		h.t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func (h *tickHarness) read(unit, file string) string {
+	h.t.Helper()
+	b, err := os.ReadFile(filepath.Join(h.dir(unit), file))
+	if err != nil {
+		h.t.Fatalf("ReadFile: %v", err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// ensureScope creates the files the kernel provides in a new cgroup before
+// the daemon creates the scope, so a missing scope reads as empty.
+func (h *tickHarness) ensureScope(unit string) {
+	for _, f := range []string{"cgroup.procs", "cpuset.cpus", "cgroup.events"} {
+		if _, err := os.Lstat(filepath.Join(h.dir(unit), f)); err != nil {
+			h.write(unit, f, "")
+		}
+	}
+}
+
+// setBroken replaces a cgroup file with a directory, which fails reads and
+// writes, or puts the file back. A unit without a file selects cpuset.cpus.
+func (h *tickHarness) setBroken(target string, broken bool) {
+	h.t.Helper()
+	unit, file, ok := strings.Cut(target, "/")
+	if !ok {
+		file = "cpuset.cpus"
+	}
+	if strings.HasSuffix(unit, ".scope") {
+		h.ensureScope(unit)
+	}
+	p := filepath.Join(h.dir(unit), file)
+	if err := os.RemoveAll(p); err != nil {
+		h.t.Fatalf("RemoveAll: %v", err)
+	}
+	if broken {
+		if err := os.Mkdir(p, 0o755); err != nil {
+			h.t.Fatalf("Mkdir: %v", err)
+		}
+		return
+	}
+	h.write(unit, file, "")
+}
+
+// settleScopes marks the scopes holding tracked PIDs populated and the
+// others empty, as the kernel would once processes moved or exited.
+func (h *tickHarness) settleScopes() {
+	held := map[string]bool{}
+	for _, rec := range h.r.pidToUnit {
+		held[rec.unit] = true
+	}
+	entries, _ := os.ReadDir(h.dir("game.slice"))
+	for _, e := range entries {
+		if !e.IsDir() || path.Ext(e.Name()) != ".scope" {
+			continue
+		}
+		v := "0"
+		if held[e.Name()] {
+			v = "1"
+		}
+		h.write(e.Name(), "cgroup.events", "populated "+v)
+	}
+}
+
+func (h *tickHarness) check(step int, want tickWant, err error) {
+	h.t.Helper()
+	if (err != nil) != want.err {
+		h.t.Fatalf("step %d: handleTick error = %v, want error %v", step, err, want.err)
+	}
+	if h.st.PinApplied != want.pinned {
+		h.t.Fatalf("step %d: PinApplied = %v, want %v", step, h.st.PinApplied, want.pinned)
+	}
+	saved, lerr := state.Load(h.statePath)
+	if lerr == nil && saved.PinApplied != h.st.PinApplied {
+		h.t.Fatalf("step %d: saved PinApplied = %v, in memory %v", step, saved.PinApplied, h.st.PinApplied)
+	}
+	for unit, cpus := range want.slices {
+		if got := h.read(unit, "cpuset.cpus"); got != cpus {
+			h.t.Fatalf("step %d: %s AllowedCPUs = %q, want %q", step, unit, got, cpus)
+		}
+	}
+	for unit, cpus := range want.scopes {
+		if got := h.read(unit, "cpuset.cpus"); got != cpus {
+			h.t.Fatalf("step %d: %s AllowedCPUs = %q, want %q", step, unit, got, cpus)
+		}
+	}
+	if want.pids != nil {
+		got := map[int]string{}
+		for pid, rec := range h.r.pidToUnit {
+			got[pid] = rec.unit
+		}
+		if !sameMap(got, want.pids) {
+			h.t.Fatalf("step %d: tracked pids = %v, want %v", step, got, want.pids)
+		}
+		if len(h.st.ScopePIDs) != len(want.pids) {
+			h.t.Fatalf("step %d: state has %d scope pids, want %d", step, len(h.st.ScopePIDs), len(want.pids))
+		}
+	}
+	if want.failing != nil {
+		got := map[string]int{}
+		for id, f := range h.st.ScopeFailures {
+			got[id] = f.Attempts
+		}
+		if !sameMap(got, want.failing) {
+			h.t.Fatalf("step %d: scope failures = %v, want %v", step, got, want.failing)
+		}
+	}
+}
+
+func sameMap[K comparable, V comparable](a, b map[K]V) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// TestRecoverState rebuilds a lost state file from the slices and checks
+// that the next idle tick clears the pin it found.
+func TestRecoverState(t *testing.T) {
+	h := newTickHarness(t, nil)
+	h.write("app.slice", "cpuset.cpus", tickOSCPUs)
+	h.write("background.slice", "cpuset.cpus", "0-1")
+	if err := os.WriteFile(h.statePath, []byte(`{"version": 2, "pin_applied": tr`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	st, recovered, err := loadState(h.statePath, h.sys, tickSlices, tickOSCPUs)
+	if err != nil || !recovered {
+		t.Fatalf("loadState: recovered=%v err=%v", recovered, err)
+	}
+	if !st.PinApplied || st.OSCPUs != tickOSCPUs || !sameMap(st.OriginalAllowedCPUs, map[string]string{"app.slice": ""}) {
+		t.Fatalf("recovered state = %+v", st)
+	}
+
+	h.st = st
+	if err := handleTick(context.Background(), h.r, h.sys, h.mgr, h.statePath, &h.st, tickSlices, nil); err != nil {
+		t.Fatalf("handleTick: %v", err)
+	}
+	if got := h.read("app.slice", "cpuset.cpus"); got != "" {
+		t.Fatalf("app.slice cpuset.cpus = %q after restore", got)
+	}
+	if got := h.read("background.slice", "cpuset.cpus"); got != "0-1" {
+		t.Fatalf("background.slice cpuset.cpus = %q, want it untouched", got)
+	}
+	if _, err := state.Load(h.statePath); err != nil {
+		t.Fatalf("state after restore: %v", err)
+	}
+}
+
+// TestUndoAfterExit walks the crash restore unit through the daemon's
+// exit: waiting out a pending restart, but not a crash loop, leaving the
+// restore to a daemon that came back, and keeping the instance lock through
+// its own restore.
+func TestUndoAfterExit(t *testing.T) {
+	pinned := state.File{PinApplied: true}
+	for _, tc := range []struct {
+		name     string
+		states   []string // ActiveState/SubState per query; the last repeats
+		lockErr  error
+		st       state.File
+		restored bool
+		want     string
+	}{
+		{"crashed", []string{"failed/failed"}, nil, pinned, true,
+			"state lock load restore release"},
+		{"restarted", []string{"activating/auto-restart", "activating/auto-restart", "active/running"}, errAlreadyRunning, pinned, false,
+			"state sleep state sleep state lock"},
+		{"restart gave up", []string{"activating/auto-restart", "failed/failed"}, nil, pinned, true,
+			"state sleep state lock load restore release"},
+		{"nothing pinned", []string{"inactive/dead"}, nil, state.File{}, false,
+			"state lock load release"},
+		{"crash loop", []string{"activating/auto-restart"}, nil, pinned, true,
+			strings.Repeat("state sleep ", int(undoRestartWait/undoPoll)) + "state lock load restore release"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var log []string
+			queries := 0
+			steps := undoSteps{
+				serviceState: func() (string, string, error) {
+					log = append(log, "state")
+					st := tc.states[min(queries, len(tc.states)-1)]
+					queries++
+					active, sub, _ := strings.Cut(st, "/")
+					return active, sub, nil
+				},
+				lock: func() (func(), int, error) {
+					log = append(log, "lock")
+					if tc.lockErr != nil {
+						return nil, 42, tc.lockErr
+					}
+					return func() { log = append(log, "release") }, 0, nil
+				},
+				loadState: func() (state.File, error) {
+					log = append(log, "load")
+					return tc.st, nil
+				},
+				restore: func() int {
+					log = append(log, "restore")
+					return 0
+				},
+				sleep: func(time.Duration) { log = append(log, "sleep") },
+			}
+			if got := undoAfterExit(1234, steps); got != tc.restored {
+				t.Errorf("restored = %v, want %v", got, tc.restored)
+			}
+			if got := strings.Join(log, " "); got != tc.want {
+				t.Errorf("steps = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestRestoreAllScopes checks that restore-all unpins the scopes adopted
+// from pin requests along with the game-*.scope ones, and leaves other
+// scopes alone.
+func TestRestoreAllScopes(t *testing.T) {
+	h := newTickHarness(t, nil)
+	for _, unit := range []string{scope("a"), "run-u42.scope", "ccdpin-4242.scope", "other.scope"} {
+		h.ensureScope(unit)
+		h.write(unit, "cgroup.events", "populated 1")
+		h.write(unit, "cpuset.cpus", tickGameCPUs)
+	}
+	st := state.File{ScopePIDs: map[int]state.ScopePID{
+		10: {Unit: "run-u42.scope", StartTime: 1},
+		11: {Unit: "ccdpin-4242.scope", StartTime: 2},
+		12: {Unit: "gone.scope", StartTime: 3},
+	}}
+
+	got, err := restoreAllScopes(h.sys, st)
+	if err != nil {
+		t.Fatalf("restoreAllScopes: %v", err)
+	}
+	want := []string{"ccdpin-4242.scope", scope("a"), "run-u42.scope"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("restoreAllScopes = %v, want %v", got, want)
+	}
+
+	got, err = restoreAllScopes(h.sys, state.File{})
+	if err != nil || strings.Join(got, " ") != scope("a") {
+		t.Fatalf("restoreAllScopes without pin requests = %v, %v", got, err)
+	}
+}
+
+// TestPinAllSlices pins the top-level slices found at pin time, including
+// one started while the game runs, and restores the recorded originals. The
+// daemon's own slice and session.slice are left alone.
+func TestPinAllSlices(t *testing.T) {
+	h := newTickHarness(t, func(c *config.Config) {
+		c.PinAllSlices = true
+		c.ExcludeSlices = []string{"priv*.slice"}
+	})
+	h.r.ownSlice = "tools.slice"
+	live := func(unit, cpus string) {
+		h.write(unit, "cgroup.events", "populated 1")
+		h.write(unit, "cpuset.cpus", cpus)
+	}
+	live("nix.slice", "0-7")
+	live("nix-builds.slice", "")
+	live("private.slice", "")
+	live("session.slice", "")
+	live("tools.slice", "")
+	tick := func(step int, gs map[string][]procscan.GameProcess, want tickWant) {
+		t.Helper()
+		for id := range gs {
+			for _, suffix := range []string{"", "-32", "-overlay"} {
+				h.ensureScope(scope(id + suffix))
+			}
+		}
+		err := handleTick(context.Background(), h.r, h.sys, h.mgr, h.statePath, &h.st, tickSlices, gs)
+		h.settleScopes()
+		h.check(step, want, err)
+	}
+
+	tick(0, games(game("a", 10)), tickWant{pinned: true, slices: map[string]string{
+		"app.slice":        tickOSCPUs,
+		"nix.slice":        tickOSCPUs,
+		"nix-builds.slice": "",
+		"private.slice":    "",
+		"session.slice":    "",
+		"tools.slice":      "",
+		"game.slice":       "",
+	}})
+	live("late.slice", "")
+	tick(1, games(game("a", 10)), tickWant{pinned: true, slices: map[string]string{"late.slice": tickOSCPUs}})
+	if got := h.st.OriginalAllowedCPUs["nix.slice"]; got != "0-7" {
+		t.Fatalf("nix.slice original = %q, want 0-7", got)
+	}
+	tick(2, games(), tickWant{slices: map[string]string{
+		"app.slice":  "",
+		"nix.slice":  "0-7",
+		"late.slice": "",
+	}})
+
+	h.r.cfg.PinSessionSlice = true
+	if !h.r.coversSlice("session.slice") {
+		t.Fatal("session.slice not covered with pin_session_slice")
+	}
+}
+
+// TestCgroupHasUnit checks which scopes a pin request may name for a PID.
+func TestCgroupHasUnit(t *testing.T) {
+	const cg = "/user.slice/user-1000.slice/user@1000.service/app.slice/ccdpin-42.scope"
+	for _, tc := range []struct {
+		unit string
+		want bool
+	}{
+		{"ccdpin-42.scope", true},
+		{"app.slice", true},
+		{"ccdpin-4.scope", false},
+		{"game-570.scope", false},
+		{"", false},
+	} {
+		if got := cgroupHasUnit(cg, tc.unit); got != tc.want {
+			t.Errorf("cgroupHasUnit(%q)=%v, want %v", tc.unit, got, tc.want)
+		}
+	}
+}
+
+func TestServiceSlice(t *testing.T) {
+	for _, tc := range []struct{ cgroup, want string }{
+		{"/user.slice/user-1000.slice/user@1000.service/app.slice/ccdbind.service", "app.slice"},
+		{"/user.slice/user-1000.slice/user@1000.service/tools.slice/tools-ccd.slice/ccdbind.service", "tools.slice"},
+		{"/user.slice/user-1000.slice/user@1000.service/ccdbind.service", ""},
+		{"/user.slice/user-1000.slice/user@1000.service/app.slice/app-org.gnome.Terminal.slice/vte-spawn-1.scope", ""},
+		{"/system.slice/ccdbind.service", ""},
+		{"", ""},
+	} {
+		if got := serviceSlice(tc.cgroup); got != tc.want {
+			t.Errorf("serviceSlice(%q)=%q, want %q", tc.cgroup, got, tc.want)
+		}
+	}
+}
+
+// TestBoostFor checks how profiles override and switch off the global
+// priority boost.
+func TestBoostFor(t *testing.T) {
+	nice, profNice := -5, -10
+	r := &runtime{settings: settings{cfg: config.Config{Boost: boost.Settings{
+		Nice:   &nice,
+		IOPrio: boost.IOPrio{Class: boost.IOClassBestEffort},
+		Sched:  boost.Sched{Policy: boost.SchedISO},
+	}}}}
+
+	if got := r.boostFor(config.Profile{}); got.Nice != &nice || got.IOPrio.String() != "best-effort:0" || got.Sched.String() != "iso" {
+		t.Fatalf("global boost = %+v", got)
+	}
+	got := r.boostFor(config.Profile{Nice: &profNice, IOPrio: boost.IOPrio{Class: boost.IOClassIdle}})
+	if *got.Nice != -10 || got.IOPrio.String() != "idle" || got.Sched.String() != "iso" {
+		t.Fatalf("profile boost = %+v", got)
+	}
+	if got := r.boostFor(config.Profile{Nice: &profNice, NoBoost: true}); !got.Empty() {
+		t.Fatalf("boost = false gave %+v", got)
+	}
+}
+
+// TestNoticeState walks the notifications through a game session: pin,
+// a second game whose scope fails, the failure clearing, and the restore.
+func TestNoticeState(t *testing.T) {
+	var s noticeState
+	summaries := func(ns []notice) string {
+		var out []string
+		for _, n := range ns {
+			out = append(out, n.summary)
+		}
+		return strings.Join(out, "; ")
+	}
+	st := &state.File{PinApplied: true, GameCPUs: tickGameCPUs, OSCPUs: tickOSCPUs}
+	one := map[string][]procscan.GameProcess{"730": {{PID: 10, Exe: "cs2"}}}
+	two := map[string][]procscan.GameProcess{"730": one["730"], "440": {{PID: 20}}}
+
+	steps := []struct {
+		games    map[string][]procscan.GameProcess
+		failures map[string]state.ScopeFailure
+		err      error
+		pinned   bool
+		want     string
+	}{
+		{one, nil, nil, true, "Pinned cs2 (730)"},
+		{one, nil, nil, true, ""},
+		{two, map[string]state.ScopeFailure{"440": {LastError: "boom"}}, nil, true, "Could not pin game 440"},
+		{two, map[string]state.ScopeFailure{"440": {LastError: "boom"}}, nil, true, ""},
+		{two, nil, nil, true, "Pinned game 440"},
+		{two, nil, os.ErrPermission, true, "Could not pin games"},
+		{two, nil, os.ErrPermission, true, ""},
+		{nil, nil, nil, false, "Restored CPU placement"},
+		{one, nil, nil, true, "Pinned cs2 (730)"},
+	}
+	for i, step := range steps {
+		st.ScopeFailures, st.PinApplied = step.failures, step.pinned
+		if got := summaries(s.update(step.games, st, step.err)); got != step.want {
+			t.Fatalf("step %d: notices %q, want %q", i, got, step.want)
+		}
+	}
+}
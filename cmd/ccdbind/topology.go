@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// runTopology implements `ccdbind topology dump` and `ccdbind topology load`:
+// saving the detected topology as a snapshot, and showing what ccdbind would
+// make of one. topology_snapshot in the config points the daemon at a saved
+// snapshot instead of sysfs.
+func runTopology(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: ccdbind topology dump|load [flags]")
+		os.Exit(2)
+	}
+	sub, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("ccdbind topology "+sub, flag.ExitOnError)
+	switch sub {
+	case "dump":
+		flagSysfs := fs.String("sysfs", "", "read this sysfs tree instead of /sys")
+		flagOut := fs.String("o", "", "write the snapshot to this file instead of stdout")
+		_ = fs.Parse(args)
+
+		snap, err := topology.Dump(topology.Sysfs{Root: strings.TrimSpace(*flagSysfs)})
+		if err != nil {
+			fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := snap.Write(&buf); err != nil {
+			fatal(err)
+		}
+		if *flagOut == "" {
+			_, _ = os.Stdout.Write(buf.Bytes())
+			return
+		}
+		if err := writeFileAtomic(*flagOut, buf.Bytes()); err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "saved %d clusters to %s\n", len(snap.Clusters), *flagOut)
+	case "load":
+		flagPrefer := fs.String("prefer", topology.PreferCache, "GAME cluster on asymmetric CPUs: cache|frequency")
+		flagJSON := fs.Bool("json", false, "output JSON")
+		_ = fs.Parse(args)
+		if fs.NArg() != 1 {
+			fatal(fmt.Errorf("usage: ccdbind topology load [--prefer cache|frequency] [--json] FILE"))
+		}
+		if !topology.ValidPrefer(*flagPrefer) {
+			fatal(fmt.Errorf("invalid --prefer %q (expected cache or frequency)", *flagPrefer))
+		}
+
+		snap, err := topology.LoadSnapshot(fs.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		res, err := snap.Detect(*flagPrefer)
+		if err != nil {
+			fatal(err)
+		}
+		if *flagJSON {
+			b, _ := json.MarshalIndent(struct {
+				Taken    time.Time          `json:"taken"`
+				Clusters []topology.Cluster `json:"clusters"`
+				OSCPUs   string             `json:"os_cpus"`
+				GameCPUs string             `json:"game_cpus"`
+				Cores    int                `json:"cores"`
+				Nodes    int                `json:"nodes"`
+			}{snap.Taken, res.Clusters, res.OSCPUs, res.GameCPUs, len(snap.Cores), len(snap.Nodes)}, "", "  ")
+			fmt.Println(string(b))
+			return
+		}
+		fmt.Printf("snapshot taken %s: %d clusters, %d cores, %d NUMA nodes\n", snap.Taken.Format("2006-01-02 15:04:05 MST"), len(res.Clusters), len(snap.Cores), len(snap.Nodes))
+		for _, c := range res.Clusters {
+			fmt.Printf("CLUSTER_%d=%s\n", c.ID, c.CPUs)
+			if c.L3KB > 0 {
+				fmt.Printf("CLUSTER_%d_L3_KB=%d\n", c.ID, c.L3KB)
+			}
+			if c.MaxFreqKHz > 0 {
+				fmt.Printf("CLUSTER_%d_MAX_FREQ_KHZ=%d\n", c.ID, c.MaxFreqKHz)
+			}
+			if c.Nodes != "" {
+				fmt.Printf("CLUSTER_%d_NODES=%s\n", c.ID, c.Nodes)
+			}
+		}
+		fmt.Printf("OS_CPUS=%s\n", res.OSCPUs)
+		fmt.Printf("GAME_CPUS=%s\n", res.GameCPUs)
+	default:
+		fmt.Fprintf(os.Stderr, "ccdbind topology: unknown command %q (expected dump or load)\n", sub)
+		os.Exit(2)
+	}
+}
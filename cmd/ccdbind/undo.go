@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// undoUnit is the transient service that restores the slices should the
+// daemon die while they are pinned.
+const undoUnit = "ccdbind-undo.service"
+
+// undoPoll is how often the undo unit checks on the daemon.
+const undoPoll = time.Second
+
+// undoRestartWait bounds how long the undo unit waits for a pending restart
+// of the daemon: a few of the shipped unit's RestartSec=2s, so a crash loop
+// or a start hanging in activating does not keep the slices pinned.
+const undoRestartWait = 15 * time.Second
+
+// syncUndo keeps undoUnit running while games are pinned with
+// crash_restore = true, and stops it otherwise. The unit is started through
+// systemd-run, outside the daemon's own cgroup, so it outlives a SIGKILL or
+// an OOM kill of the daemon; when the daemon goes away without a clean
+// restore, it runs `ccdbind restore-all`. A failed start is logged once and
+// not retried until games are pinned again.
+func (d *daemon) syncUndo(pinned bool) {
+	want := pinned && d.r.cfg.CrashRestore
+	if !want {
+		d.r.undoFailed = false
+	}
+	if want == d.r.undoArmed || (want && d.r.undoFailed) {
+		return
+	}
+	ctx, cancel := systemdctl.DefaultContext()
+	defer cancel()
+	switch {
+	case d.r.dryRun:
+		log.Printf("dry-run: crash restore unit %s running: %v", undoUnit, want)
+	case want:
+		// A unit left by a crashed daemon is still watching it.
+		_ = d.sys.StopUnit(ctx, undoUnit)
+		if err := startUndo(ctx, d.configPath); err != nil {
+			logging.Warnf(nil, "crash_restore: %v; the slices stay pinned should ccdbind die", err)
+			d.r.undoFailed = true
+			return
+		}
+		logging.Debugf(nil, "crash_restore: %s started", undoUnit)
+	default:
+		if err := d.sys.StopUnit(ctx, undoUnit); err != nil {
+			logging.Warnf(nil, "crash_restore: stop %s: %v", undoUnit, err)
+		}
+	}
+	d.r.undoArmed = want
+}
+
+// startUndo starts undoUnit watching this process.
+func startUndo(ctx context.Context, configPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	pid := os.Getpid()
+	start, err := procscan.StartTime(pid)
+	if err != nil {
+		return err
+	}
+	args := []string{"--user", "--unit=" + undoUnit, "--collect", "--quiet", "--description=ccdbind crash restore"}
+	// The unit must find the same state file and instance lock.
+	for _, k := range []string{"XDG_RUNTIME_DIR", "XDG_STATE_HOME", "HOME"} {
+		if v := os.Getenv(k); v != "" {
+			args = append(args, "--setenv="+k+"="+v)
+		}
+	}
+	args = append(args, "--", exe, "undo-watch", "--pid", strconv.Itoa(pid), "--start", strconv.FormatUint(start, 10), "--config", configPath)
+	out, err := exec.CommandContext(ctx, "systemd-run", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("systemd-run: %s", msg)
+		}
+		return fmt.Errorf("systemd-run: %w", err)
+	}
+	return nil
+}
+
+// runUndoWatch implements the hidden `ccdbind undo-watch` run by undoUnit:
+// it waits for the daemon to exit and, unless the daemon restored the slices
+// or has been started again, runs restore-all. The daemon stops the unit
+// after a clean restore, so this normally never gets past the wait.
+func runUndoWatch(args []string) {
+	fs := flag.NewFlagSet("ccdbind undo-watch", flag.ExitOnError)
+	flagPID := fs.Int("pid", 0, "daemon PID to watch")
+	flagStart := fs.Uint64("start", 0, "start time of the daemon PID, in clock ticks since boot")
+	flagConfig := fs.String("config", "", "config file path (TOML). Default: XDG config path")
+	_ = fs.Parse(args)
+	if *flagPID <= 0 || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	logging.Setup("ccdbind")
+
+	for {
+		start, err := procscan.StartTime(*flagPID)
+		if err != nil || start != *flagStart {
+			break
+		}
+		time.Sleep(undoPoll)
+	}
+
+	lockPath, err := instanceLockPath()
+	if err != nil {
+		fatal(err)
+	}
+	statePath, err := state.DefaultPath()
+	if err != nil {
+		fatal(err)
+	}
+	undoAfterExit(*flagPID, undoSteps{
+		serviceState: func() (string, string, error) { return unitState(serviceUnitName) },
+		lock:         func() (func(), int, error) { return lockInstance(lockPath) },
+		loadState:    func() (state.File, error) { return state.Load(statePath) },
+		restore:      func() int { return restoreAll(*flagConfig, false, false) },
+		sleep:        time.Sleep,
+	})
+}
+
+// undoSteps are what undoAfterExit does to the system, swapped out in tests.
+type undoSteps struct {
+	serviceState func() (active, sub string, err error)
+	lock         func() (release func(), holder int, err error)
+	loadState    func() (state.File, error)
+	restore      func() (failed int)
+	sleep        func(time.Duration)
+}
+
+// undoAfterExit restores what the exited daemon pid left pinned. While
+// systemd is about to restart ccdbind.service it waits, for at most
+// undoRestartWait, and it leaves the restore to a daemon holding the
+// instance lock. Otherwise it keeps the lock
+// through the restore, so a daemon starting meanwhile cannot pin underneath
+// it. It reports whether it ran restore-all.
+func undoAfterExit(pid int, s undoSteps) bool {
+	for waited := time.Duration(0); ; waited += undoPoll {
+		active, sub, err := s.serviceState()
+		if err != nil || (sub != "auto-restart" && active != "activating") {
+			break
+		}
+		if waited >= undoRestartWait {
+			logging.Warnf(nil, "crash restore: ccdbind.service still %s/%s after %s; restoring anyway", active, sub, undoRestartWait)
+			break
+		}
+		s.sleep(undoPoll)
+	}
+
+	release, holder, err := s.lock()
+	if errors.Is(err, errAlreadyRunning) {
+		log.Printf("crash restore: ccdbind is running again (pid %d); leaving the restore to it", holder)
+		return false
+	}
+	if err != nil {
+		fatal(err)
+	}
+	defer release()
+
+	if st, err := s.loadState(); err == nil && !pinLeftover(st) {
+		log.Printf("crash restore: ccdbind (pid %d) exited with nothing pinned", pid)
+		return false
+	}
+	logging.Warnf(nil, "crash restore: ccdbind (pid %d) exited without restoring; running restore-all", pid)
+	if failed := s.restore(); failed > 0 {
+		logging.Warnf(nil, "crash restore: %d step(s) failed; run `ccdbind restore-all` again", failed)
+	}
+	return true
+}
+
+// unitState returns the ActiveState and SubState of a user unit.
+func unitState(unit string) (string, string, error) {
+	ctx, cancel := systemdctl.DefaultContext()
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "systemctl", "--user", "show", "-p", "ActiveState", "-p", "SubState", unit).Output()
+	if err != nil {
+		return "", "", err
+	}
+	var active, sub string
+	for _, line := range strings.Split(string(out), "\n") {
+		if v, ok := strings.CutPrefix(line, "ActiveState="); ok {
+			active = v
+		} else if v, ok := strings.CutPrefix(line, "SubState="); ok {
+			sub = v
+		}
+	}
+	return active, sub, nil
+}
+
+// pinLeftover reports whether st records a change restore-all would undo.
+func pinLeftover(st state.File) bool {
+	return st.PinApplied || len(st.OriginalGuestCPUs) > 0 || st.IRQBalanceBanned != "" ||
+		st.SMTDisabled != "" || len(st.OriginalCPUFreq) > 0 || st.PowerProfile != ""
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/gpu"
+	"github.com/Reidond/ccdbind/internal/i18n"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// verifyDrift is one difference between what ccdbind wants and what the
+// system shows.
+type verifyDrift struct {
+	Kind     string `json:"kind"` // slice|scope|game|state|gpu
+	Subject  string `json:"subject"`
+	Detail   string `json:"detail"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+type verifyOutput struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	ConfigPath  string        `json:"config_path"`
+	StatePath   string        `json:"state_path"`
+	PinApplied  bool          `json:"pin_applied"`
+	GPU         *gpu.GPU      `json:"gpu,omitempty"`
+	Drift       []verifyDrift `json:"drift,omitempty"`
+	Errors      []string      `json:"errors,omitempty"`
+}
+
+func (o *verifyOutput) add(kind, subject, detail, expected, actual string) {
+	o.Drift = append(o.Drift, verifyDrift{Kind: kind, Subject: subject, Detail: detail, Expected: expected, Actual: actual})
+}
+
+// runVerify compares the desired pinning (config, topology and the state
+// file) with systemd and /proc. It exits 1 when drift is found and 2 when
+// the check itself was incomplete, so it can serve as a health check.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("ccdbind verify", flag.ExitOnError)
+	flagJSON := fs.Bool("json", false, "output JSON")
+	flagConfig := fs.String("config", "", "config file path (TOML). Default: XDG config path")
+	_ = fs.Parse(args)
+
+	configPath := strings.TrimSpace(*flagConfig)
+	if configPath == "" {
+		p, err := config.DefaultConfigPath()
+		if err != nil {
+			fatal(err)
+		}
+		configPath = p
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fatal(err)
+	}
+	statePath, err := state.DefaultPath()
+	if err != nil {
+		fatal(err)
+	}
+	st, err := state.Load(statePath)
+	if err != nil {
+		fatal(err)
+	}
+
+	out := verify(cfg, st)
+	out.ConfigPath = configPath
+	out.StatePath = statePath
+
+	if *flagJSON {
+		b, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(b))
+	} else {
+		msg := i18n.Default()
+		if out.GPU != nil {
+			msg.Println("verify.gpu", out.GPU.Name, out.GPU.LocalCPUs)
+		}
+		for _, d := range out.Drift {
+			line := msg.Sprintf("verify.drift", d.Kind, d.Subject, d.Detail)
+			switch {
+			case d.Expected != "":
+				line += msg.Sprintf("verify.expected", d.Expected, d.Actual)
+			case d.Actual != "":
+				line += msg.Sprintf("verify.actual", d.Actual)
+			}
+			fmt.Println(line)
+		}
+		for _, e := range out.Errors {
+			msg.Println("verify.error", e)
+		}
+		msg.Println("verify.summary", len(out.Drift), len(out.Errors))
+	}
+	switch {
+	case len(out.Drift) > 0:
+		os.Exit(1)
+	case len(out.Errors) > 0:
+		os.Exit(2)
+	}
+}
+
+func verify(cfg config.Config, st state.File) verifyOutput {
+	out := verifyOutput{GeneratedAt: time.Now(), PinApplied: st.PinApplied}
+	uid := os.Getuid()
+	sys, _ := newSystemctl(cfg, uid, false)
+
+	s, err := newSettings(cfg, uid, false)
+	if err != nil {
+		out.Errors = append(out.Errors, fmt.Sprintf("resolve desired cpus: %v", err))
+		s = settings{
+			cfg:     cfg,
+			slices:  slicesToPin(cfg),
+			scanner: newScanner(cfg, uid),
+			quirks:  loadQuirks(cfg),
+		}
+	} else if gpuApplies(cfg) {
+		// The game CPUs should be near the GPU the config selects; when no
+		// cluster is, detection keeps every non-OS CPU.
+		if g, err := selectGPU(cfg.GPU); err == nil {
+			out.GPU = &g
+			if g.LocalCPUs != "" && topology.IntersectCPULists(s.gameCPUs, g.LocalCPUs) == "" {
+				out.add("gpu", g.Name, "game CPUs are not local to the selected GPU", g.LocalCPUs, s.gameCPUs)
+			}
+		}
+	}
+
+	games, err := s.scanner.Scan()
+	if err != nil {
+		out.Errors = append(out.Errors, fmt.Sprintf("scan games: %v", err))
+	}
+	gameIDs := make([]string, 0, len(games))
+	for id := range games {
+		gameIDs = append(gameIDs, id)
+	}
+	sort.Strings(gameIDs)
+
+	// The OS set the daemon should have chosen for the games running now.
+	// Games it was told about by gamemode or `ccdbind pin` are not visible
+	// here, so the comparison is only made for games the scan finds.
+	if st.PinApplied && !st.Paused && s.osCPUs != "" && len(gameIDs) > 0 {
+		d := decide(s.quirks, s.cfg.Profiles, s.osCPUs, s.gameCPUs, gameIDs)
+		want := d.OSCPUs
+		if st.LentCPUs != "" {
+			want = topology.UnionCPULists(want, st.LentCPUs)
+		}
+		if d.Pinned && want != st.OSCPUs {
+			out.add("state", "os_cpus", "the daemon pinned a different OS set than the config gives; reload it with `ccdbind config apply`", want, st.OSCPUs)
+		}
+	}
+
+	// Slices: pinned to the OS set while pinned, back at their originals
+	// otherwise.
+	slices := systemdctl.ExpandCgroupGlobs(s.slices)
+	if st.PinApplied {
+		slices = s.withDiscovered(context.Background(), sys, slices)
+	} else {
+		slices = s.withRecorded(context.Background(), sys, slices, st)
+	}
+	for _, unit := range slices {
+		ctx, cancel := systemdctl.DefaultContext()
+		actual, err := sys.GetAllowedCPUs(ctx, unit)
+		cancel()
+		if err != nil {
+			out.Errors = append(out.Errors, fmt.Sprintf("read %s: %v", unit, err))
+			continue
+		}
+		orig, hasOrig := st.OriginalAllowedCPUs[unit]
+		kept, isKept := st.KeptAllowedCPUs[unit]
+		switch {
+		case st.PinApplied && isKept:
+			if actual != kept {
+				out.add("slice", unit, "changed since ccdbind last kept it under restore_policy", kept, actual)
+			}
+		case st.PinApplied && actual != st.OSCPUs:
+			out.add("slice", unit, "AllowedCPUs differ from the pinned OS set", st.OSCPUs, actual)
+		case !st.PinApplied && hasOrig && actual != orig:
+			out.add("slice", unit, "not restored to its original AllowedCPUs", orig, actual)
+		case !st.PinApplied && !hasOrig && actual != "" && actual == st.OSCPUs:
+			out.add("slice", unit, "still pinned to the OS set although nothing is pinned", "", actual)
+		}
+	}
+
+	// State originals for slices that are gone or no longer configured.
+	for _, unit := range sortedKeys(st.OriginalAllowedCPUs) {
+		switch {
+		case !configuredSlice(s.slices, unit) && !s.coversSlice(unit):
+			out.add("state", unit, "original AllowedCPUs kept for a slice no longer in pin_slices", "", st.OriginalAllowedCPUs[unit])
+		case systemdctl.IsCgroupPath(unit) && !cgroupExists(unit):
+			out.add("state", unit, "original AllowedCPUs kept for a cgroup that no longer exists", "", st.OriginalAllowedCPUs[unit])
+		}
+	}
+	for _, cg := range sortedKeys(st.OriginalGuestCPUs) {
+		if !cgroupExists(cg) {
+			out.add("state", cg, "original cpuset kept for a guest cgroup that no longer exists", "", st.OriginalGuestCPUs[cg])
+		}
+	}
+	tracked := map[string]bool{}
+	pids := make([]int, 0, len(st.ScopePIDs))
+	for pid := range st.ScopePIDs {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		sp := st.ScopePIDs[pid]
+		if start, err := procscan.StartTime(pid); err != nil || (sp.StartTime != 0 && start != sp.StartTime) {
+			out.add("state", fmt.Sprintf("pid %d", pid), "tracked in "+sp.Unit+" but the process is gone", "", "")
+			continue
+		}
+		tracked[sp.Unit] = true
+	}
+
+	// Game processes belong in a scope under game.slice while pinned, with
+	// their affinity inside the scope's cpuset.
+	wantScopes := map[string]string{}
+	if st.PinApplied && !st.Paused && s.gameCPUs != "" {
+		d := decide(s.quirks, s.cfg.Profiles, s.osCPUs, s.gameCPUs, gameIDs)
+		for _, id := range gameIDs {
+			if s.cfg.Profiles[id].Ignore {
+				continue
+			}
+			if q, _ := s.quirks.Lookup(id); q.NoTouchGame {
+				continue
+			}
+			unit := systemdctl.UnitNameForGameID(id)
+			wantScopes[unit] = d.gameCPUsFor(id)
+			tracked[unit] = true
+			tracked[systemdctl.UnitNameForGameID(id+"-32")] = true
+			for _, gp := range games[id] {
+				subject := fmt.Sprintf("pid %d (%s)", gp.PID, gp.Exe)
+				cg, err := procscan.Cgroup(gp.PID)
+				if err != nil {
+					continue
+				}
+				if !strings.Contains(cg+"/", "/game.slice/") {
+					out.add("game", subject, "game "+id+" runs outside game.slice", "game.slice/"+unit, cg)
+					continue
+				}
+				scope, expected := gameScopeCPUs(gp.PID)
+				allowed, err := procscan.AllowedCPUs(gp.PID)
+				if err != nil || expected == "" {
+					continue
+				}
+				if actual := topology.UnionCPULists(allowed); actual != expected {
+					out.add("game", subject, "affinity outside its scope "+scope, expected, actual)
+				}
+			}
+		}
+	}
+
+	// Scopes: every game scope should hold a tracked or detected game, and
+	// pinned scopes should have the CPUs chosen for their game.
+	ctx, cancel := systemdctl.DefaultContext()
+	scopes, err := sys.ListUnits(ctx, "game-*.scope")
+	cancel()
+	if err != nil {
+		out.Errors = append(out.Errors, fmt.Sprintf("list game scopes: %v", err))
+	}
+	for _, unit := range scopes {
+		if !tracked[unit] {
+			out.add("scope", unit, "orphaned: no detected or tracked game runs in it", "", "")
+			continue
+		}
+		want, ok := wantScopes[unit]
+		if !ok || want == "" {
+			continue
+		}
+		ctx, cancel := systemdctl.DefaultContext()
+		actual, err := sys.GetAllowedCPUs(ctx, unit)
+		cancel()
+		if err != nil {
+			out.Errors = append(out.Errors, fmt.Sprintf("read %s: %v", unit, err))
+			continue
+		}
+		if actual != want {
+			out.add("scope", unit, "AllowedCPUs differ from the game's CPUs", want, actual)
+		}
+	}
+	return out
+}
+
+// configuredSlice reports whether unit is one of the configured slices or
+// matches one of their cgroup globs.
+func configuredSlice(slices []string, unit string) bool {
+	for _, entry := range slices {
+		if systemdctl.MatchCgroupGlob(entry, unit) {
+			return true
+		}
+	}
+	return false
+}
+
+func cgroupExists(cg string) bool {
+	_, err := os.Stat(filepath.Join(systemdctl.CgroupRoot, strings.Trim(cg, "/")))
+	return err == nil
+}
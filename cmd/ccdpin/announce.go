@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/gameid"
+	"github.com/Reidond/ccdbind/internal/pinreq"
+)
+
+// scopeUnit names the systemd-run scope of this ccdpin, so it can be
+// announced to ccdbind before the daemon's next scan.
+func scopeUnit() string {
+	return "ccdpin-" + strconv.Itoa(os.Getpid()) + ".scope"
+}
+
+// announce tells ccdbind through a pin request that the game with pid runs
+// in unit, so the daemon pins that scope instead of creating game-<id>.scope
+// and moving the game out from under systemd-run. It returns a func that
+// withdraws the request once the game exits; without a game ID in the
+// environment there is nothing to announce.
+func announce(unit string, pid int, debug bool) func() {
+	id := launchGameID(os.Environ())
+	if id == "" {
+		debugf(debug, "no game id in the environment; not announcing %s", unit)
+		return func() {}
+	}
+	dir, err := pinreq.DefaultDir()
+	if err != nil {
+		debugf(debug, "announce %s: %v", unit, err)
+		return func() {}
+	}
+	req, err := pinreq.Write(dir, strings.TrimSuffix(unit, ".scope"), pinreq.Request{PID: pid, GameID: id, Scope: unit})
+	if err != nil {
+		warnf("announce %s to ccdbind: %v", unit, err)
+		return func() {}
+	}
+	debugf(debug, "announced %s for game %s (pid %d) to ccdbind", unit, id, pid)
+	return func() { _ = req.Remove() }
+}
+
+// launchGameID returns the game ID in env under the first of ccdbind's
+// env_keys that has one, as the daemon's scanner would find it.
+func launchGameID(env []string) string {
+	keys := config.Default().EnvKeys
+	if path, err := config.DefaultConfigPath(); err == nil {
+		if cfg, err := config.Load(path); err == nil {
+			keys = cfg.EnvKeys
+		}
+	}
+	return gameIDIn(env, keys)
+}
+
+// gameIDIn returns the game ID in env under the first of keys that has one.
+func gameIDIn(env, keys []string) string {
+	vals := make(map[string]string, len(env))
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			vals[k] = v
+		}
+	}
+	for _, k := range keys {
+		if id := gameid.Normalize(vals[k]); id != "" {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/abtest"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// benchVariant is one way of launching the command under test; a nil r runs
+// it without pinning.
+type benchVariant struct {
+	name string
+	r    *resolved
+}
+
+type benchRun struct {
+	Variant  string         `json:"variant"`
+	Exit     int            `json:"exit"`
+	Seconds  float64        `json:"seconds"`
+	Frames   *abtest.Frames `json:"frames,omitempty"`
+	FrameLog string         `json:"frame_log,omitempty"`
+}
+
+type benchVariantReport struct {
+	Name    string          `json:"name"`
+	Runs    int             `json:"runs"`
+	Failed  int             `json:"failed"` // runs with a non-zero exit code
+	Seconds abtest.Summary  `json:"seconds"`
+	AvgFPS  *abtest.Summary `json:"avg_fps,omitempty"`
+	Low1    *abtest.Summary `json:"low_1,omitempty"`
+	// VsUnpinned compares each metric with the unpinned runs.
+	VsUnpinned map[string]abtest.Comparison `json:"vs_unpinned,omitempty"`
+}
+
+type benchReport struct {
+	Command  []string             `json:"command"`
+	GameCPUs string               `json:"game_cpus"`
+	OSCPUs   string               `json:"os_cpus"`
+	Runs     []benchRun           `json:"runs"`
+	Variants []benchVariantReport `json:"variants"`
+}
+
+// runBench implements `ccdpin bench`: it runs the command alternately with
+// and without pinning, and optionally with the CPU sets swapped, then
+// compares wall time and frame times between the variants.
+func runBench(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("ccdpin bench", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	var opts options
+	addPinFlags(fs, &opts)
+	runs := fs.Int("runs", 3, "runs per variant (at least 2)")
+	withSwapped := fs.Bool("with-swapped", false, "also run with the OS and GAME CPUs swapped")
+	cooldown := fs.Duration("cooldown", 5*time.Second, "pause between runs")
+	mangohud := fs.Bool("mangohud", false, "record frame times with MangoHud")
+	frameGlob := fs.String("frametimes", "", "glob of frame time logs the command writes (MangoHud or PresentMon CSV); the newest one written during a run is used")
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(out, "usage: ccdpin bench [flags] -- COMMAND [args...]")
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, "Runs COMMAND repeatedly, alternating between unpinned and pinned, and")
+		fmt.Fprintln(out, "compares the runs. COMMAND must run the game until it exits, e.g. a")
+		fmt.Fprintln(out, "built-in benchmark that quits when done.")
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, "flags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	cmd := fs.Args()
+	if len(cmd) == 0 {
+		fmt.Fprintln(errOut, "ccdpin bench: no command provided")
+		return 2
+	}
+	if *runs < 2 {
+		fmt.Fprintln(errOut, "ccdpin bench: --runs must be at least 2 to compare variants")
+		return 2
+	}
+	r, err := resolve(opts)
+	if err != nil {
+		fmt.Fprintf(errOut, "ccdpin bench: %v\n", err)
+		return 1
+	}
+	logging.SetLevel(r.logLevel)
+
+	variants := []benchVariant{{name: "unpinned"}, {name: "pinned", r: &r}}
+	if *withSwapped {
+		if r.osCPUs == "" {
+			fmt.Fprintln(errOut, "ccdpin bench: --with-swapped needs OS CPUs")
+			return 1
+		}
+		sw := r
+		sw.osCPUs, sw.gameCPUs = r.gameCPUs, r.osCPUs
+		if r.gameMems != "" {
+			sw.gameMems = ""
+			if nodes, err := r.topo.DetectNodes(); err == nil {
+				sw.gameMems, _ = nodes.MemoryNodesFor(sw.gameCPUs)
+			}
+		}
+		variants = append(variants, benchVariant{name: "swapped", r: &sw})
+	}
+
+	var logRoot string
+	if *mangohud {
+		dir, err := logDir()
+		if err == nil {
+			dir = filepath.Join(dir, "bench", time.Now().Format("20060102-150405"))
+			err = os.MkdirAll(dir, 0o755)
+		}
+		if err != nil {
+			fmt.Fprintf(errOut, "ccdpin bench: mangohud log dir: %v\n", err)
+			return 1
+		}
+		logRoot = dir
+		fmt.Fprintf(errOut, "ccdpin bench: MangoHud logs go to %s\n", dir)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigc := make(chan os.Signal, 2)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	go func() {
+		select {
+		case <-sigc:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	sys := systemdctl.Systemctl{}
+	mangohudConfig := os.Getenv("MANGOHUD_CONFIG")
+	report := benchReport{Command: cmd, GameCPUs: r.gameCPUs, OSCPUs: r.osCPUs}
+	total := *runs * len(variants)
+	for round := 0; round < *runs && ctx.Err() == nil; round++ {
+		// Rotate the order every round so no variant always runs first,
+		// right after the cooldown.
+		for k := range variants {
+			v := variants[(round+k)%len(variants)]
+			if len(report.Runs) > 0 && !sleepCtx(ctx, *cooldown) {
+				break
+			}
+			n := len(report.Runs) + 1
+			fmt.Fprintf(errOut, "ccdpin bench: run %d/%d: %s\n", n, total, v.name)
+
+			var patterns []string
+			if logRoot != "" {
+				dir := filepath.Join(logRoot, fmt.Sprintf("%02d-%s", n, v.name))
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					warnf("mangohud log dir: %v", err)
+				}
+				os.Setenv("MANGOHUD", "1")
+				os.Setenv("MANGOHUD_CONFIG", joinMangoHudConfig(mangohudConfig, "output_folder="+dir, "autostart_log=1"))
+				patterns = append(patterns, filepath.Join(dir, "*.csv"))
+			}
+			if *frameGlob != "" {
+				patterns = append(patterns, *frameGlob)
+			}
+
+			run := benchOnce(ctx, sys, v, cmd, patterns)
+			if ctx.Err() != nil {
+				fmt.Fprintln(errOut, "ccdpin bench: interrupted; the last run is not counted")
+				break
+			}
+			fmt.Fprintf(errOut, "ccdpin bench: run %d/%d: %s exited %d after %.1fs\n", n, total, v.name, run.Exit, run.Seconds)
+			report.Runs = append(report.Runs, run)
+		}
+	}
+	if len(report.Runs) == 0 {
+		return 130
+	}
+
+	report.Variants = summarizeBench(variants, report.Runs)
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return 1
+		}
+	} else {
+		printBench(out, report)
+	}
+	if ctx.Err() != nil {
+		return 130
+	}
+	return 0
+}
+
+// benchOnce runs cmd once as variant v and reads the frame time log it left
+// in patterns, if any.
+func benchOnce(ctx context.Context, sys systemdctl.Systemctl, v benchVariant, cmd []string, patterns []string) benchRun {
+	run := benchRun{Variant: v.name}
+	before := logFiles(patterns)
+	start := time.Now()
+	if v.r == nil {
+		run.Exit = runCmd(ctx, cmd[0], cmd[1:], "", "", false, nil)
+	} else {
+		run.Exit = launch(ctx, sys, *v.r, cmd)
+	}
+	run.Seconds = time.Since(start).Seconds()
+
+	path := newestLog(patterns, before)
+	if path == "" {
+		if len(patterns) > 0 {
+			warnf("no frame time log found for this run")
+		}
+		return run
+	}
+	ms, err := abtest.ReadFrameTimes(path)
+	if err != nil {
+		warnf("frame times: %v", err)
+		return run
+	}
+	if len(ms) > 0 {
+		f := abtest.FrameStats(ms)
+		run.Frames = &f
+		run.FrameLog = path
+	}
+	return run
+}
+
+// logFiles maps the files matching patterns to their modification times.
+// MangoHud's summary files are skipped.
+func logFiles(patterns []string) map[string]time.Time {
+	files := map[string]time.Time{}
+	for _, p := range patterns {
+		matches, _ := filepath.Glob(p)
+		for _, m := range matches {
+			if strings.HasSuffix(m, "_summary.csv") {
+				continue
+			}
+			if fi, err := os.Stat(m); err == nil && fi.Mode().IsRegular() {
+				files[m] = fi.ModTime()
+			}
+		}
+	}
+	return files
+}
+
+// newestLog returns the newest file matching patterns that is new or was
+// modified since before was taken, "" if there is none. Comparing with a
+// snapshot rather than the start time copes with coarse file timestamps.
+func newestLog(patterns []string, before map[string]time.Time) string {
+	var best string
+	var bestAt time.Time
+	for path, at := range logFiles(patterns) {
+		if prev, ok := before[path]; ok && !at.After(prev) {
+			continue
+		}
+		if best == "" || at.After(bestAt) {
+			best, bestAt = path, at
+		}
+	}
+	return best
+}
+
+// joinMangoHudConfig appends options to a MANGOHUD_CONFIG value.
+func joinMangoHudConfig(base string, opts ...string) string {
+	base = strings.TrimSpace(base)
+	if base == "" {
+		return strings.Join(opts, ",")
+	}
+	return base + "," + strings.Join(opts, ",")
+}
+
+// sleepCtx waits for d and reports whether ctx is still live.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func summarizeBench(variants []benchVariant, runs []benchRun) []benchVariantReport {
+	type sample struct{ seconds, fps, low1 []float64 }
+	samples := map[string]*sample{}
+	failed := map[string]int{}
+	for _, run := range runs {
+		s := samples[run.Variant]
+		if s == nil {
+			s = &sample{}
+			samples[run.Variant] = s
+		}
+		if run.Exit != 0 {
+			failed[run.Variant]++
+		}
+		s.seconds = append(s.seconds, run.Seconds)
+		if run.Frames != nil {
+			s.fps = append(s.fps, run.Frames.AvgFPS)
+			s.low1 = append(s.low1, run.Frames.Low1)
+		}
+	}
+
+	base := samples["unpinned"]
+	var out []benchVariantReport
+	for _, v := range variants {
+		s := samples[v.name]
+		if s == nil {
+			continue
+		}
+		rep := benchVariantReport{Name: v.name, Runs: len(s.seconds), Failed: failed[v.name], Seconds: abtest.Summarize(s.seconds)}
+		if len(s.fps) > 0 {
+			fps, low := abtest.Summarize(s.fps), abtest.Summarize(s.low1)
+			rep.AvgFPS, rep.Low1 = &fps, &low
+		}
+		if base != nil && v.name != "unpinned" {
+			rep.VsUnpinned = map[string]abtest.Comparison{"seconds": abtest.Compare(base.seconds, s.seconds)}
+			if len(base.fps) > 0 && len(s.fps) > 0 {
+				rep.VsUnpinned["avg_fps"] = abtest.Compare(base.fps, s.fps)
+				rep.VsUnpinned["low_1"] = abtest.Compare(base.low1, s.low1)
+			}
+		}
+		out = append(out, rep)
+	}
+	return out
+}
+
+func printBench(out io.Writer, rep benchReport) {
+	fmt.Fprintf(out, "game_cpus=%s os_cpus=%s\n\n", rep.GameCPUs, rep.OSCPUs)
+	fmt.Fprintf(out, "%-10s %4s  %-18s %-18s %s\n", "variant", "runs", "wall (s)", "avg fps", "1% low fps")
+	for _, v := range rep.Variants {
+		fps, low := "-", "-"
+		if v.AvgFPS != nil {
+			fps = fmt.Sprintf("%.1f ± %.1f", v.AvgFPS.Mean, v.AvgFPS.Stddev)
+			low = fmt.Sprintf("%.1f ± %.1f", v.Low1.Mean, v.Low1.Stddev)
+		}
+		fmt.Fprintf(out, "%-10s %4d  %-18s %-18s %s\n", v.Name, v.Runs, fmt.Sprintf("%.2f ± %.2f", v.Seconds.Mean, v.Seconds.Stddev), fps, low)
+	}
+	fmt.Fprintln(out)
+	for _, v := range rep.Variants {
+		if v.VsUnpinned == nil {
+			continue
+		}
+		parts := []string{"wall " + formatComparison(v.VsUnpinned["seconds"])}
+		if c, ok := v.VsUnpinned["avg_fps"]; ok {
+			parts = append(parts, "avg fps "+formatComparison(c), "1% low "+formatComparison(v.VsUnpinned["low_1"]))
+		}
+		fmt.Fprintf(out, "%s vs unpinned: %s\n", v.Name, strings.Join(parts, ", "))
+	}
+	for _, v := range rep.Variants {
+		if v.Failed > 0 {
+			fmt.Fprintf(out, "warning: %d %s run(s) exited non-zero\n", v.Failed, v.Name)
+		}
+	}
+	fmt.Fprintln(out, "p is the chance of a difference this large from noise alone (Welch's t-test); below 0.05 the difference is likely real.")
+}
+
+func formatComparison(c abtest.Comparison) string {
+	return fmt.Sprintf("%+.1f%% (p=%.3f)", c.Delta*100, c.P)
+}
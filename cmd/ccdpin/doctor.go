@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/i18n"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// minAllowedCPUsVersion is the first systemd release with AllowedCPUs=.
+const minAllowedCPUsVersion = 244
+
+// cgroupRoot is where the unified cgroup hierarchy is mounted, and
+// selfCgroup lists this process's cgroups; tests point them elsewhere.
+var (
+	cgroupRoot = "/sys/fs/cgroup"
+	selfCgroup = "/proc/self/cgroup"
+)
+
+// Check outcomes, from best to worst.
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// check is the outcome of one doctor check. Fix says what to do about a
+// warning or failure.
+type check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"`
+
+	// name, detail and fix are Name, Detail and Fix before formatting, for
+	// the human-readable output in the user's language; JSON stays English.
+	name, detail, fix i18n.Message
+}
+
+// newCheck returns a check; fix is left out when its Key is empty.
+func newCheck(name i18n.Message, status string, detail, fix i18n.Message) check {
+	c := check{Name: name.English(), Status: status, Detail: detail.English(), name: name, detail: detail, fix: fix}
+	if fix.Key != "" {
+		c.Fix = fix.English()
+	}
+	return c
+}
+
+// detailErr is a check detail that is just err.
+func detailErr(err error) i18n.Message { return i18n.Msg("doctor.error", err) }
+
+// runDoctor implements `ccdpin doctor`: it checks what ccdpin needs to pin
+// a game and reports what is missing. It exits 1 when a check fails.
+func runDoctor(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("ccdpin doctor", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	var opts options
+	addPinFlags(fs, &opts)
+	asJSON := fs.Bool("json", false, "print the checks as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(out, "usage: ccdpin doctor [flags]")
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, "Checks what ccdpin needs to pin games with the given flags.")
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, "flags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	checks := doctorChecks(context.Background(), opts)
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(checks); err != nil {
+			return 1
+		}
+	} else {
+		printChecks(out, checks)
+	}
+	for _, c := range checks {
+		if c.Status == checkFail {
+			return 1
+		}
+	}
+	return 0
+}
+
+func doctorChecks(ctx context.Context, opts options) []check {
+	var checks []check
+	r, err := resolve(opts)
+	switch {
+	case err != nil:
+		checks = append(checks, newCheck(i18n.Msg("doctor.cpu_split.name"), checkFail, detailErr(err), i18n.Msg("doctor.cpu_split.fix")))
+	case r.osCPUs == "":
+		checks = append(checks, newCheck(i18n.Msg("doctor.cpu_split.name"), checkWarn, i18n.Msg("doctor.cpu_split.no_os", r.gameCPUs), i18n.Msg("doctor.cpu_split.no_os_fix")))
+	default:
+		checks = append(checks, newCheck(i18n.Msg("doctor.cpu_split.name"), checkOK, i18n.Msg("doctor.cpu_split.ok", r.gameCPUs, r.osCPUs), i18n.Message{}))
+	}
+
+	checks = append(checks, cgroupV2Check())
+	checks = append(checks, delegationCheck(os.Getuid()))
+
+	version, verr := systemdVersion(ctx)
+	if verr != nil {
+		detail := detailErr(verr)
+		var unset []string
+		for _, k := range []string{"XDG_RUNTIME_DIR", "DBUS_SESSION_BUS_ADDRESS"} {
+			if os.Getenv(k) == "" {
+				unset = append(unset, k)
+			}
+		}
+		switch len(unset) {
+		case 1:
+			detail = i18n.Msg("doctor.session.unset", verr, unset[0])
+		case 2:
+			detail = i18n.Msg("doctor.session.unset_both", verr, unset[0], unset[1])
+		}
+		checks = append(checks, newCheck(i18n.Msg("doctor.session.name"), checkFail, detail, i18n.Msg("doctor.session.fix")))
+	} else {
+		checks = append(checks, newCheck(i18n.Msg("doctor.session.name"), checkOK, i18n.Msg("doctor.session.ok", version), i18n.Message{}))
+		if version < minAllowedCPUsVersion {
+			checks = append(checks, newCheck(i18n.Msg("doctor.allowed_cpus.name"), checkFail, i18n.Msg("doctor.allowed_cpus.old", version), i18n.Msg("doctor.allowed_cpus.fix", minAllowedCPUsVersion)))
+		} else {
+			checks = append(checks, newCheck(i18n.Msg("doctor.allowed_cpus.name"), checkOK, i18n.Msg("doctor.allowed_cpus.ok", version, minAllowedCPUsVersion), i18n.Message{}))
+		}
+	}
+
+	if hasBinary("systemd-run") {
+		checks = append(checks, newCheck(i18n.Msg("doctor.systemd_run.name"), checkOK, i18n.Msg("doctor.systemd_run.ok"), i18n.Message{}))
+	} else {
+		checks = append(checks, newCheck(i18n.Msg("doctor.systemd_run.name"), checkWarn, i18n.Msg("doctor.systemd_run.missing"), i18n.Msg("doctor.systemd_run.fix")))
+	}
+
+	if err == nil && !r.noOSPin && verr == nil {
+		missing, err := missingSlices(ctx, systemdctl.Systemctl{}, r.osSlices)
+		switch {
+		case err != nil:
+			checks = append(checks, newCheck(i18n.Msg("doctor.os_slices.name"), checkWarn, detailErr(err), i18n.Message{}))
+		case len(missing) > 0:
+			checks = append(checks, newCheck(i18n.Msg("doctor.os_slices.name"), checkWarn, i18n.Msg("doctor.os_slices.missing", strings.Join(missing, " ")), i18n.Msg("doctor.os_slices.fix")))
+		default:
+			checks = append(checks, newCheck(i18n.Msg("doctor.os_slices.name"), checkOK, i18n.Msg("doctor.os_slices.ok", strings.Join(r.osSlices, " ")), i18n.Message{}))
+		}
+	}
+
+	checks = append(checks, stateDirCheck())
+	return checks
+}
+
+// systemdVersion returns the major version of the user manager.
+func systemdVersion(ctx context.Context) (int, error) {
+	if !hasBinary("systemctl") {
+		return 0, errors.New("systemctl not found")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	b, err := exec.CommandContext(ctx, "systemctl", "--user", "show", "-p", "Version", "--value").Output()
+	if err != nil {
+		return 0, fmt.Errorf("user manager unreachable: %w", err)
+	}
+	return parseSystemdVersion(string(b))
+}
+
+// parseSystemdVersion returns the major version from the Version property,
+// such as "256.7-1-arch" or "252 (252.22-1~deb12u1)".
+func parseSystemdVersion(v string) (int, error) {
+	v = strings.TrimSpace(v)
+	end := 0
+	for end < len(v) && v[end] >= '0' && v[end] <= '9' {
+		end++
+	}
+	n, err := strconv.Atoi(v[:end])
+	if err != nil {
+		return 0, fmt.Errorf("unexpected systemd version %q", v)
+	}
+	return n, nil
+}
+
+func cgroupV2Check() check {
+	b, err := os.ReadFile(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	if err != nil {
+		return newCheck(i18n.Msg("doctor.cgroup_v2.name"), checkFail, i18n.Msg("doctor.cgroup_v2.missing", cgroupRoot), i18n.Msg("doctor.cgroup_v2.missing_fix"))
+	}
+	if !hasController(string(b), "cpuset") {
+		return newCheck(i18n.Msg("doctor.cgroup_v2.name"), checkFail, i18n.Msg("doctor.cgroup_v2.no_cpuset"), i18n.Msg("doctor.cgroup_v2.no_cpuset_fix"))
+	}
+	return newCheck(i18n.Msg("doctor.cgroup_v2.name"), checkOK, i18n.Msg("doctor.cgroup_v2.ok"), i18n.Message{})
+}
+
+// delegationCheck checks that the user manager may use the cpuset
+// controller, which AllowedCPUs= on user units needs.
+func delegationCheck(uid int) check {
+	cg := userManagerCgroup(uid)
+	b, err := os.ReadFile(filepath.Join(cgroupRoot, cg, "cgroup.controllers"))
+	if err != nil {
+		return newCheck(i18n.Msg("doctor.delegation.name"), checkWarn, detailErr(err), i18n.Message{})
+	}
+	if !hasController(string(b), "cpuset") {
+		return newCheck(i18n.Msg("doctor.delegation.name"), checkFail,
+			i18n.Msg("doctor.delegation.missing", cg, strings.TrimSpace(string(b))), i18n.Msg("doctor.delegation.fix"))
+	}
+	return newCheck(i18n.Msg("doctor.delegation.name"), checkOK, i18n.Msg("doctor.delegation.ok", cg), i18n.Message{})
+}
+
+// userManagerCgroup returns the cgroup of the user manager, taken from this
+// process's cgroup when it runs below it.
+func userManagerCgroup(uid int) string {
+	unit := fmt.Sprintf("user@%d.service", uid)
+	if f, err := os.Open(selfCgroup); err == nil {
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			path, ok := strings.CutPrefix(sc.Text(), "0::")
+			if !ok {
+				continue
+			}
+			if i := strings.Index(path, "/"+unit); i >= 0 {
+				return path[:i+1+len(unit)]
+			}
+		}
+	}
+	return fmt.Sprintf("/user.slice/user-%d.slice/%s", uid, unit)
+}
+
+func hasController(list, name string) bool {
+	for _, c := range strings.Fields(list) {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func stateDirCheck() check {
+	dir, err := defaultStateDir()
+	if err == nil {
+		err = os.MkdirAll(dir, 0o755)
+	}
+	if err == nil {
+		var f *os.File
+		if f, err = os.CreateTemp(dir, ".doctor-*"); err == nil {
+			f.Close()
+			err = os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		return newCheck(i18n.Msg("doctor.state_dir.name"), checkFail, detailErr(err), i18n.Msg("doctor.state_dir.fix"))
+	}
+	return newCheck(i18n.Msg("doctor.state_dir.name"), checkOK, i18n.Msg("doctor.state_dir.ok", dir), i18n.Message{})
+}
+
+// printChecks prints checks as text in the user's language.
+func printChecks(out io.Writer, checks []check) {
+	msg := i18n.Default()
+	status := map[string]string{
+		checkOK:   msg.Sprintf("doctor.ok"),
+		checkWarn: msg.Sprintf("doctor.warn"),
+		checkFail: msg.Sprintf("doctor.fail"),
+	}
+	for _, c := range checks {
+		fmt.Fprintln(out, msg.Sprintf("doctor.line", status[c.Status], msg.Format(c.name), msg.Format(c.detail)))
+		if c.fix.Key != "" {
+			fmt.Fprintln(out, msg.Sprintf("doctor.fix", "", msg.Format(c.fix)))
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+func TestParseSystemdVersion(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"256.7-1-arch\n", 256, true},
+		{"252 (252.22-1~deb12u1)", 252, true},
+		{"244", 244, true},
+		{"  255\n", 255, true},
+		{"", 0, false},
+		{"v255", 0, false},
+	} {
+		got, err := parseSystemdVersion(tc.in)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("parseSystemdVersion(%q)=%d, %v; want %d, ok=%v", tc.in, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestHasController(t *testing.T) {
+	for _, tc := range []struct {
+		list string
+		want bool
+	}{
+		{"cpuset cpu io memory pids\n", true},
+		{"cpuset", true},
+		{"cpu io memory", false},
+		{"cpusets cpu", false},
+		{"", false},
+	} {
+		if got := hasController(tc.list, "cpuset"); got != tc.want {
+			t.Errorf("hasController(%q)=%v, want %v", tc.list, got, tc.want)
+		}
+	}
+}
+
+func TestUserManagerCgroup(t *testing.T) {
+	const fallback = "/user.slice/user-1000.slice/user@1000.service"
+	for _, tc := range []struct {
+		name    string
+		content string // "" leaves the file out
+		want    string
+	}{
+		{"missing", "", fallback},
+		{"below the manager", "0::/user.slice/user-1000.slice/user@1000.service/app.slice/app-steam.scope\n", fallback},
+		{"nested manager path", "0::/machine.slice/c.scope/user.slice/user-1000.slice/user@1000.service/app.slice/x.scope\n", "/machine.slice/c.scope/user.slice/user-1000.slice/user@1000.service"},
+		{"other user", "0::/user.slice/user-1001.slice/user@1001.service/app.slice/x.scope\n", fallback},
+		{"v1 lines only", "12:cpuset:/\n1:name=systemd:/user.slice/user-1000.slice/user@1000.service/x.scope\n", fallback},
+		{"session scope", "0::/user.slice/user-1000.slice/session-2.scope\n", fallback},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cgroup")
+			if tc.content != "" {
+				if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			setSelfCgroup(t, path)
+			if got := userManagerCgroup(1000); got != tc.want {
+				t.Fatalf("userManagerCgroup=%q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestCgroupChecks runs the cgroup v2 and delegation checks over a temp
+// cgroup tree.
+func TestCgroupChecks(t *testing.T) {
+	const manager = "user.slice/user-1000.slice/user@1000.service"
+	for _, tc := range []struct {
+		name            string
+		root, delegated string // cgroup.controllers; "-" leaves the file out
+		v2, delegation  string
+		v2Fix, delegFix bool
+	}{
+		{"cgroup v1", "-", "-", checkFail, checkWarn, true, false},
+		{"no cpuset", "cpu io memory pids", "-", checkFail, checkWarn, true, false},
+		{"not delegated", "cpuset cpu io memory pids", "cpu memory pids", checkOK, checkFail, false, true},
+		{"delegated", "cpuset cpu io memory pids", "cpuset cpu memory pids", checkOK, checkOK, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			setCgroupRoot(t, root)
+			setSelfCgroup(t, filepath.Join(root, "no-such-file"))
+			for dir, controllers := range map[string]string{"": tc.root, manager: tc.delegated} {
+				if controllers == "-" {
+					continue
+				}
+				if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(root, dir, "cgroup.controllers"), []byte(controllers+"\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			v2 := cgroupV2Check()
+			if v2.Status != tc.v2 || (v2.Fix != "") != tc.v2Fix {
+				t.Errorf("cgroupV2Check=%+v, want status %s fix %v", v2, tc.v2, tc.v2Fix)
+			}
+			d := delegationCheck(1000)
+			if d.Status != tc.delegation || (d.Fix != "") != tc.delegFix {
+				t.Errorf("delegationCheck=%+v, want status %s fix %v", d, tc.delegation, tc.delegFix)
+			}
+			if d.Status == checkOK && d.Detail != "/"+manager {
+				t.Errorf("delegationCheck detail=%q, want the manager cgroup", d.Detail)
+			}
+		})
+	}
+}
+
+func TestPrintTopologyJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		r    resolved
+		want map[string]any
+	}{
+		{
+			name: "minimal",
+			r:    resolved{gameCPUs: "0-7", dmaLatency: -1},
+			want: map[string]any{
+				"clusters":  []any{},
+				"os_cpus":   "",
+				"game_cpus": "0-7",
+				"os_slices": nil,
+				"no_os_pin": false,
+				"no_scope":  false,
+			},
+		},
+		{
+			name: "full",
+			r: resolved{
+				clusters:   []topology.Cluster{{ID: 0, CPUs: "0-7", L3KB: 98304}, {ID: 1, CPUs: "8-15", L3KB: 32768}},
+				osCPUs:     "8-15",
+				gameCPUs:   "0-7",
+				gameMems:   "0",
+				osSlices:   []string{"app.slice", "background.slice"},
+				profile:    "steam:1091500",
+				noScope:    true,
+				warmup:     30 * time.Second,
+				dmaLatency: 0,
+			},
+			want: map[string]any{
+				"clusters": []any{
+					map[string]any{"id": 0.0, "cpus": "0-7", "l3_kb": 98304.0},
+					map[string]any{"id": 1.0, "cpus": "8-15", "l3_kb": 32768.0},
+				},
+				"os_cpus":     "8-15",
+				"game_cpus":   "0-7",
+				"game_mems":   "0",
+				"os_slices":   []any{"app.slice", "background.slice"},
+				"profile":     "steam:1091500",
+				"no_os_pin":   false,
+				"no_scope":    true,
+				"warmup":      "30s",
+				"dma_latency": 0.0,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := printTopologyJSON(&buf, tc.r); err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("%v in %s", err, buf.String())
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %#v\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func setCgroupRoot(t *testing.T, dir string) {
+	t.Helper()
+	old := cgroupRoot
+	cgroupRoot = dir
+	t.Cleanup(func() { cgroupRoot = old })
+}
+
+func setSelfCgroup(t *testing.T, path string) {
+	t.Helper()
+	old := selfCgroup
+	selfCgroup = path
+	t.Cleanup(func() { selfCgroup = old })
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// flatpakEnvPrefixes are forwarded into the sandbox with --env so launch
+// options like PROTON_ENABLE_HDR=1 reach Flatpak-installed games.
+var flatpakEnvPrefixes = []string{"PROTON_", "DXVK_", "VKD3D_", "WINE", "MANGOHUD", "STEAM_", "SteamAppId", "SteamGameId", "ENABLE_", "__GL_", "RADV_"}
+
+// flatpakApp reports whether cmd is `flatpak run [opts] APP ...` and returns
+// the app ID and the index of the "run" argument.
+func flatpakApp(cmd []string) (appID string, runIdx int, ok bool) {
+	if len(cmd) < 3 || filepath.Base(cmd[0]) != "flatpak" {
+		return "", 0, false
+	}
+	runIdx = -1
+	for i, arg := range cmd[1:] {
+		if arg == "run" {
+			runIdx = i + 1
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return "", 0, false
+		}
+	}
+	if runIdx < 0 {
+		return "", 0, false
+	}
+	for _, arg := range cmd[runIdx+1:] {
+		if !strings.HasPrefix(arg, "-") {
+			return arg, runIdx, true
+		}
+	}
+	return "", 0, false
+}
+
+// flatpakCommand inserts --env=K=V for the forwarded variables right after
+// "run", leaving the rest of the command untouched.
+func flatpakCommand(cmd []string, runIdx int, env []string) []string {
+	out := make([]string, 0, len(cmd)+8)
+	out = append(out, cmd[:runIdx+1]...)
+	for _, kv := range env {
+		k, _, found := strings.Cut(kv, "=")
+		if !found || k == "" {
+			continue
+		}
+		for _, p := range flatpakEnvPrefixes {
+			if strings.HasPrefix(k, p) {
+				out = append(out, "--env="+kv)
+				break
+			}
+		}
+	}
+	return append(out, cmd[runIdx+1:]...)
+}
+
+// pinFlatpakScope waits for the scope Flatpak creates for appID
+// (app-flatpak-APPID-PID.scope under app.slice) and pins it to gameCPUs.
+// Flatpak moves the sandbox out of ccdpin's own scope, so that pin alone
+// would not apply to the game.
+func pinFlatpakScope(ctx context.Context, sys systemdctl.Systemctl, appID string, gameCPUs string, debug bool) {
+	pattern := "app-flatpak-" + appID + "-*.scope"
+	pinned := map[string]struct{}{}
+	deadline := time.Now().Add(2 * time.Minute)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ctx2, cancel := systemdctl.DefaultContext()
+		units, err := sys.ListUnits(ctx2, pattern)
+		cancel()
+		if err != nil {
+			debugf(debug, "flatpak scope lookup: %v", err)
+			continue
+		}
+		for _, unit := range units {
+			if _, ok := pinned[unit]; ok {
+				continue
+			}
+			ctx2, cancel := systemdctl.DefaultContext()
+			err := sys.SetAllowedCPUs(ctx2, unit, gameCPUs)
+			cancel()
+			if err != nil {
+				warnf("pin flatpak scope %s: %v", unit, err)
+				continue
+			}
+			pinned[unit] = struct{}{}
+			logInfo("pinned flatpak scope %s to %s", unit, gameCPUs)
+		}
+	}
+}
+
+func withoutSlice(slices []string, drop string) []string {
+	out := make([]string, 0, len(slices))
+	for _, s := range slices {
+		if s != drop {
+			out = append(out, s)
+		}
+	}
+	return out
+}
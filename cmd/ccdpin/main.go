@@ -12,12 +12,17 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
 	"runtime/debug"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/Reidond/ccdbind/internal/affinity"
+	"github.com/Reidond/ccdbind/internal/bench"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/pmqos"
 	"github.com/Reidond/ccdbind/internal/systemdctl"
 	"github.com/Reidond/ccdbind/internal/topology"
 )
@@ -30,39 +35,78 @@ const (
 	envNoScope  = "STEAM_CCD_NO_SCOPE"
 	envOSSlices = "STEAM_CCD_OS_SLICES"
 	envDebug    = "STEAM_CCD_DEBUG"
+	envPrefer   = "STEAM_CCD_PREFER"
+	envWarmup   = "STEAM_CCD_WARMUP"
+	envLatency  = "STEAM_CCD_DMA_LATENCY"
+	envLogLevel = "STEAM_CCD_LOG_LEVEL"
+	envPinMem   = "STEAM_CCD_PIN_MEMORY"
+	envTopology = "STEAM_CCD_TOPOLOGY"
 )
 
 // logFile is the global log file handle for crash logging.
 var logFile *os.File
 
 type options struct {
-	print bool
-	swap  bool
+	print        bool
+	json         bool
+	swap         bool
+	createSlices bool
 
 	noOSPin bool
 	noScope bool
+	pinMem  bool
 
 	gameCPUs string
 	osCPUs   string
+	prefer   string
+	topology string // snapshot path, see topology.LoadSnapshot
+
+	warmup     time.Duration
+	dmaLatency int // microseconds, -1 leaves cpuidle alone
+	logLevel   string
+
+	configPath string
+	profile    string
 }
 
 type resolved struct {
 	osCPUs   string
 	gameCPUs string
-	ccds     []string
+	gameMems string // NUMA nodes of the game CPUs with --pin-memory
+	clusters []topology.Cluster
+	topo     topology.Detector
 
 	noOSPin  bool
 	noScope  bool
 	osSlices []string
+	profile  string // game ID of the ccdbind profile applied
 	debug    bool
+
+	warmup     time.Duration
+	dmaLatency int
+	logLevel   logging.Level
 }
 
 func main() {
 	// Set up crash logging before anything else
 	setupLogging()
+	if logFile == nil {
+		// Without a log file, info lines must not end up on the game's stderr.
+		log.SetOutput(io.Discard)
+	}
+	logging.Setup("ccdpin")
 	defer closeLogging()
 	defer recoverPanic()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			os.Exit(runBench(os.Args[2:], os.Stdout, os.Stderr))
+		case "doctor":
+			os.Exit(runDoctor(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	opts, cmd, err := parseArgs(os.Args[1:], os.Stdout, os.Stderr)
 	if err != nil {
 		fatal(err)
@@ -72,11 +116,24 @@ func main() {
 	if err != nil {
 		fatal(err)
 	}
+	logging.SetLevel(r.logLevel)
 
 	if opts.print {
+		if opts.json {
+			if err := printTopologyJSON(os.Stdout, r); err != nil {
+				fatal(err)
+			}
+			return
+		}
 		printTopology(r)
 		return
 	}
+	if opts.createSlices {
+		if err := runCreateSlices(context.Background(), r.osSlices); err != nil {
+			fatal(err)
+		}
+		return
+	}
 	if len(cmd) == 0 {
 		fatal(errors.New("no command provided"))
 	}
@@ -91,12 +148,29 @@ func main() {
 		cancel()
 	}()
 
-	logInfo("game_cpus=%s os_cpus=%s no_os_pin=%v", r.gameCPUs, r.osCPUs, r.noOSPin)
+	os.Exit(launch(ctx, systemdctl.Systemctl{}, r, cmd))
+}
+
+// launch pins the OS slices, runs cmd on the GAME CPUs and restores the
+// slices once it exits. It returns the command's exit code.
+func launch(ctx context.Context, sys systemdctl.Systemctl, r resolved, cmd []string) int {
+	if appID, runIdx, ok := flatpakApp(cmd); ok {
+		// Flatpak puts the sandbox in its own scope under app.slice, so pinning
+		// app.slice to the OS CPUs would cap the game there too.
+		r.osSlices = withoutSlice(r.osSlices, "app.slice")
+		cmd = flatpakCommand(cmd, runIdx, os.Environ())
+		if !r.noScope {
+			go pinFlatpakScope(ctx, sys, appID, r.gameCPUs, r.debug)
+		}
+		debugf(r.debug, "flatpak app %s: not pinning app.slice, will pin its scope", appID)
+	}
+
+	logInfo("game_cpus=%s os_cpus=%s game_mems=%s no_os_pin=%v profile=%s", r.gameCPUs, r.osCPUs, r.gameMems, r.noOSPin, r.profile)
 	logInfo("command: %v", cmd)
 
-	sys := systemdctl.Systemctl{}
 	cleanup := func() {}
 	if !r.noOSPin {
+		checkSlices(ctx, sys, r.osSlices, r.debug)
 		pin, err := newSlicePinManager(sys, r.osSlices, r.osCPUs, r.debug)
 		if err != nil {
 			warnf("os slice pin disabled: %v", err)
@@ -110,13 +184,34 @@ func main() {
 		}
 	}
 
+	// The latency request lasts while the file is open, so it is held until
+	// the game exits.
+	var qos *pmqos.Request
+	var err error
+	if r.dmaLatency >= 0 {
+		if qos, err = pmqos.Hold(r.dmaLatency); err != nil {
+			warnf("cpu_dma_latency disabled: %v", err)
+		} else {
+			logInfo("holding cpu wakeup latency <= %dus", qos.Latency())
+		}
+	}
+	if r.warmup > 0 {
+		if err := warmup(r.gameCPUs, r.warmup); err != nil {
+			warnf("warmup: %v", err)
+		}
+		debugf(r.debug, "warmed up %s for %s", r.gameCPUs, r.warmup)
+	}
+
 	startTime := time.Now()
 	logInfo("launching game...")
-	exitCode := runGame(ctx, sys, r.gameCPUs, cmd, r.debug, r.noScope)
+	exitCode := runGame(ctx, sys, r.gameCPUs, r.gameMems, cmd, r.debug, r.noScope)
 	duration := time.Since(startTime)
 	logInfo("game exited with code %d after %v", exitCode, duration)
+	if qos != nil {
+		_ = qos.Release()
+	}
 	cleanup()
-	os.Exit(exitCode)
+	return exitCode
 }
 
 func parseArgs(args []string, out io.Writer, errOut io.Writer) (options, []string, error) {
@@ -124,19 +219,19 @@ func parseArgs(args []string, out io.Writer, errOut io.Writer) (options, []strin
 	fs.SetOutput(errOut)
 	var opts options
 	fs.BoolVar(&opts.print, "print", false, "print detected topology and selected CPU sets")
-	fs.BoolVar(&opts.swap, "swap", false, "swap OS and GAME CPU assignments")
-	fs.BoolVar(&opts.noOSPin, "no-os-pin", false, "do not pin OS slices")
-	fs.BoolVar(&opts.noScope, "no-scope", false, "skip systemd-run scope (use taskset only, for anti-cheat games)")
-	fs.StringVar(&opts.gameCPUs, "game-cpus", "", "override GAME CPU list")
-	fs.StringVar(&opts.osCPUs, "os-cpus", "", "override OS CPU list")
+	fs.BoolVar(&opts.json, "json", false, "with --print, print JSON")
+	fs.BoolVar(&opts.createSlices, "create-slices", false, "create the OS slices missing from the user manager and exit")
+	addPinFlags(fs, &opts)
 	fs.Usage = func() {
 		fmt.Fprintln(out, "usage: ccdpin [flags] [--] COMMAND [args...]")
+		fmt.Fprintln(out, "       ccdpin bench [flags] -- COMMAND [args...]")
+		fmt.Fprintln(out, "       ccdpin doctor [--json]")
 		fmt.Fprintln(out, "")
 		fmt.Fprintln(out, "flags:")
 		fs.PrintDefaults()
 		fmt.Fprintln(out, "")
 		fmt.Fprintln(out, "environment overrides (compat):")
-		fmt.Fprintf(out, "  %s, %s, %s, %s, %s, %s, %s\n", envGameCPUs, envOSCPUs, envSwap, envNoOSPin, envNoScope, envOSSlices, envDebug)
+		fmt.Fprintf(out, "  %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s\n", envGameCPUs, envOSCPUs, envSwap, envNoOSPin, envNoScope, envOSSlices, envPrefer, envWarmup, envLatency, envPinMem, envTopology, envDebug, envLogLevel, envConfig, envProfile)
 	}
 
 	if err := fs.Parse(args); err != nil {
@@ -145,13 +240,42 @@ func parseArgs(args []string, out io.Writer, errOut io.Writer) (options, []strin
 	return opts, fs.Args(), nil
 }
 
+// addPinFlags registers the flags that decide how a command is pinned,
+// shared by the launcher and bench.
+func addPinFlags(fs *flag.FlagSet, opts *options) {
+	fs.BoolVar(&opts.swap, "swap", false, "swap OS and GAME CPU assignments")
+	fs.BoolVar(&opts.noOSPin, "no-os-pin", false, "do not pin OS slices")
+	fs.BoolVar(&opts.noScope, "no-scope", false, "skip systemd-run scope and only set the CPU affinity (for anti-cheat games)")
+	fs.BoolVar(&opts.pinMem, "pin-memory", false, "on NUMA systems, keep the game's memory on the GAME CPUs' nodes")
+	fs.StringVar(&opts.gameCPUs, "game-cpus", "", "override GAME CPU list")
+	fs.StringVar(&opts.osCPUs, "os-cpus", "", "override OS CPU list")
+	fs.StringVar(&opts.prefer, "prefer", "", "GAME cluster on asymmetric CPUs: cache|frequency (default cache)")
+	fs.StringVar(&opts.topology, "topology", "", "read the CPU topology from this snapshot (ccdbind topology dump) instead of sysfs")
+	fs.DurationVar(&opts.warmup, "warmup", 0, "busy the GAME CPUs for this long before launch (e.g. 300ms, max 5s)")
+	fs.IntVar(&opts.dmaLatency, "dma-latency", -1, "hold this CPU wakeup latency limit in µs via /dev/cpu_dma_latency while the game runs")
+	fs.StringVar(&opts.configPath, "config", "", "take CPU sets, slices and profiles from this ccdbind config file")
+	fs.StringVar(&opts.profile, "profile", "", "apply this game's [game.\"ID\"] profile from ccdbind's config (default: the game ID in the environment with --config)")
+	fs.StringVar(&opts.logLevel, "log-level", "", "log level: error|warning|info|debug (default info, debug with STEAM_CCD_DEBUG)")
+}
+
 func resolve(opts options) (resolved, error) {
 	debug := parseBoolEnv(envDebug)
 	noOSPin := opts.noOSPin || parseBoolEnv(envNoOSPin)
 	noScope := opts.noScope || parseBoolEnv(envNoScope)
 	swap := opts.swap || parseBoolEnv(envSwap)
+	pinMem := opts.pinMem || parseBoolEnv(envPinMem)
+
+	// Flags and environment variables win over ccdbind's config.
+	sh, fromConfig, err := loadShared(opts)
+	if err != nil {
+		return resolved{}, err
+	}
+	pinMem = pinMem || sh.pinMem
 
 	osSlices := parseSlicesEnv(os.Getenv(envOSSlices))
+	if len(osSlices) == 0 {
+		osSlices = sh.osSlices
+	}
 	if len(osSlices) == 0 {
 		osSlices = []string{"app.slice", "background.slice", "session.slice"}
 	}
@@ -160,21 +284,107 @@ func resolve(opts options) (resolved, error) {
 	if osCPUs == "" {
 		osCPUs = strings.TrimSpace(os.Getenv(envOSCPUs))
 	}
+	if osCPUs == "" {
+		osCPUs = sh.osCPUs
+	}
 	gameCPUs := strings.TrimSpace(opts.gameCPUs)
 	if gameCPUs == "" {
 		gameCPUs = strings.TrimSpace(os.Getenv(envGameCPUs))
 	}
+	if gameCPUs == "" {
+		gameCPUs = sh.gameCPUs
+	}
 
 	// Match the script behavior:
 	// - If both OS+GAME are provided explicitly, use them.
 	// - Otherwise auto-detect and fill missing.
+	prefer := strings.ToLower(strings.TrimSpace(opts.prefer))
+	if prefer == "" {
+		prefer = strings.ToLower(strings.TrimSpace(os.Getenv(envPrefer)))
+	}
+	if prefer == "" {
+		prefer = sh.prefer
+	}
+	if prefer == "" {
+		prefer = topology.PreferCache
+	}
+	if !topology.ValidPrefer(prefer) {
+		return resolved{}, fmt.Errorf("invalid prefer %q (expected cache or frequency)", prefer)
+	}
+
+	warm := opts.warmup
+	if v := strings.TrimSpace(os.Getenv(envWarmup)); warm == 0 && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return resolved{}, fmt.Errorf("invalid %s %q: %w", envWarmup, v, err)
+		}
+		warm = d
+	}
+	if warm < 0 || warm > maxWarmup {
+		return resolved{}, fmt.Errorf("invalid warmup %s (expected 0 to %s)", warm, maxWarmup)
+	}
+	latency := opts.dmaLatency
+	if v := strings.TrimSpace(os.Getenv(envLatency)); latency < 0 && v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return resolved{}, fmt.Errorf("invalid %s %q", envLatency, v)
+		}
+		latency = n
+	}
+	if latency < 0 && fromConfig {
+		latency = sh.latency
+	}
+
+	level := logging.Info
+	if debug {
+		level = logging.Debug
+	}
+	levelName := strings.TrimSpace(opts.logLevel)
+	if levelName == "" {
+		levelName = strings.TrimSpace(os.Getenv(envLogLevel))
+	}
+	if levelName != "" {
+		l, err := logging.ParseLevel(levelName)
+		if err != nil {
+			return resolved{}, err
+		}
+		level = l
+	}
+
+	var topo topology.Detector = topology.Sysfs{}
+	snapshot := strings.TrimSpace(opts.topology)
+	if snapshot == "" {
+		snapshot = strings.TrimSpace(os.Getenv(envTopology))
+	}
+	if snapshot == "" {
+		snapshot = sh.topology
+	}
+
 	var det topology.Result
 	needDetect := opts.print || osCPUs == "" || gameCPUs == "" || swap
+	if snapshot != "" && (needDetect || pinMem) {
+		snap, err := topology.LoadSnapshot(snapshot)
+		if err != nil {
+			return resolved{}, fmt.Errorf("topology snapshot: %w", err)
+		}
+		topo = snap
+	}
 	if needDetect {
-		res, err := topology.Detect()
+		res, err := topo.Detect(prefer)
 		if err != nil {
 			return resolved{}, err
 		}
+		if path, err := bench.DefaultPath(); err == nil {
+			if saved, err := bench.Load(path); err == nil {
+				res = bench.Refine(res, prefer, saved)
+			}
+		}
+		if fromConfig && sh.cluster >= 0 {
+			res.OSCPUs, res.GameCPUs, err = topology.SelectCluster(res.Clusters, sh.cluster)
+			if err != nil {
+				return resolved{}, err
+			}
+		}
 		det = res
 	}
 	if osCPUs == "" {
@@ -187,7 +397,6 @@ func resolve(opts options) (resolved, error) {
 		return resolved{}, fmt.Errorf("could not resolve GAME_CPUS")
 	}
 
-	var err error
 	if strings.TrimSpace(osCPUs) != "" {
 		osCPUs, _, err = topology.CanonicalizeCPUList(osCPUs)
 		if err != nil {
@@ -206,14 +415,31 @@ func resolve(opts options) (resolved, error) {
 		osCPUs, gameCPUs = gameCPUs, osCPUs
 	}
 
-	return resolved{osCPUs: osCPUs, gameCPUs: gameCPUs, ccds: det.Lists, noOSPin: noOSPin, noScope: noScope, osSlices: osSlices, debug: debug}, nil
+	var gameMems string
+	if pinMem {
+		if nodes, err := topo.DetectNodes(); err == nil {
+			gameMems, _ = nodes.MemoryNodesFor(gameCPUs)
+		}
+	}
+
+	return resolved{osCPUs: osCPUs, gameCPUs: gameCPUs, gameMems: gameMems, clusters: det.Clusters, topo: topo, noOSPin: noOSPin, noScope: noScope, osSlices: osSlices, profile: sh.profile, debug: debug, warmup: warm, dmaLatency: latency, logLevel: level}, nil
 }
 
 func printTopology(r resolved) {
-	if len(r.ccds) > 0 {
-		fmt.Println("Detected CCD CPU groups:")
-		for i, s := range r.ccds {
-			fmt.Printf("  CCD[%d] = %s\n", i, strings.TrimSpace(s))
+	if len(r.clusters) > 0 {
+		fmt.Println("Detected CPU clusters (shared L3):")
+		for _, c := range r.clusters {
+			info := ""
+			if c.L3KB > 0 {
+				info += fmt.Sprintf(" L3=%dMiB", c.L3KB/1024)
+			}
+			if c.MaxFreqKHz > 0 {
+				info += fmt.Sprintf(" max=%.2fGHz", float64(c.MaxFreqKHz)/1e6)
+			}
+			if c.Nodes != "" {
+				info += " node=" + c.Nodes
+			}
+			fmt.Printf("  cluster[%d] = %s%s\n", c.ID, c.CPUs, info)
 		}
 		fmt.Println("")
 	}
@@ -222,9 +448,54 @@ func printTopology(r resolved) {
 		fmt.Printf("  OS_CPUS   = %s\n", r.osCPUs)
 	}
 	fmt.Printf("  GAME_CPUS = %s\n", r.gameCPUs)
+	if r.gameMems != "" {
+		fmt.Printf("  GAME_MEMS = %s\n", r.gameMems)
+	}
 	if len(r.osSlices) > 0 {
 		fmt.Printf("  OS_SLICES = %s\n", strings.Join(r.osSlices, " "))
 	}
+	if r.profile != "" {
+		fmt.Printf("  PROFILE   = %s\n", r.profile)
+	}
+}
+
+// topologyJSON is the --print --json output.
+type topologyJSON struct {
+	Clusters   []topology.Cluster `json:"clusters"`
+	OSCPUs     string             `json:"os_cpus"`
+	GameCPUs   string             `json:"game_cpus"`
+	GameMems   string             `json:"game_mems,omitempty"`
+	OSSlices   []string           `json:"os_slices"`
+	Profile    string             `json:"profile,omitempty"`
+	NoOSPin    bool               `json:"no_os_pin"`
+	NoScope    bool               `json:"no_scope"`
+	Warmup     string             `json:"warmup,omitempty"`
+	DMALatency *int               `json:"dma_latency,omitempty"`
+}
+
+func printTopologyJSON(w io.Writer, r resolved) error {
+	out := topologyJSON{
+		Clusters: r.clusters,
+		OSCPUs:   r.osCPUs,
+		GameCPUs: r.gameCPUs,
+		GameMems: r.gameMems,
+		OSSlices: r.osSlices,
+		Profile:  r.profile,
+		NoOSPin:  r.noOSPin,
+		NoScope:  r.noScope,
+	}
+	if out.Clusters == nil {
+		out.Clusters = []topology.Cluster{}
+	}
+	if r.warmup > 0 {
+		out.Warmup = r.warmup.String()
+	}
+	if r.dmaLatency >= 0 {
+		out.DMALatency = &r.dmaLatency
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
 }
 
 func parseSlicesEnv(v string) []string {
@@ -267,7 +538,10 @@ func parseBoolEnv(k string) bool {
 	}
 }
 
-func runGame(ctx context.Context, sys systemdctl.Systemctl, gameCPUs string, cmd []string, debug bool, noScope bool) int {
+// runGame runs cmd on gameCPUs and, when gameMems is set, with its memory on
+// those NUMA nodes: through the scope's AllowedMemoryNodes, or an inherited
+// MPOL_BIND memory policy without a scope.
+func runGame(ctx context.Context, sys systemdctl.Systemctl, gameCPUs, gameMems string, cmd []string, debug bool, noScope bool) int {
 	userSystemd := userSystemdAvailable(ctx)
 	if userSystemd && !noScope {
 		ctx2, cancel := systemdctl.DefaultContext()
@@ -276,31 +550,36 @@ func runGame(ctx context.Context, sys systemdctl.Systemctl, gameCPUs string, cmd
 	}
 
 	if userSystemd && hasBinary("systemd-run") && !noScope {
+		// systemd-run execs the command in the scope, so its PID is the
+		// game's and its exit status is passed through unchanged.
+		unit := scopeUnit()
+		var withdraw func()
+		defer func() {
+			if withdraw != nil {
+				withdraw()
+			}
+		}()
+		started := func(pid int) { withdraw = announce(unit, pid, debug) }
 		args := []string{
 			"--user",
 			"--scope",
 			"--quiet",
+			"--unit=" + unit,
 			"--slice=game.slice",
 			"-p", "AllowedCPUs=" + gameCPUs,
 		}
+		if gameMems != "" {
+			args = append(args, "-p", "AllowedMemoryNodes="+gameMems)
+		}
 		args = append(args, systemdRunSetenvArgs()...)
 		args = append(args, "--")
-		if hasBinary("taskset") {
-			args = append(args, "taskset", "-c", gameCPUs)
-			args = append(args, cmd...)
-			return runCmd(ctx, "systemd-run", args, debug)
-		}
 		args = append(args, cmd...)
-		return runCmd(ctx, "systemd-run", args, debug)
-	}
-
-	if hasBinary("taskset") {
-		args := append([]string{"-c", gameCPUs}, cmd...)
-		return runCmd(ctx, "taskset", args, debug)
+		// systemd-run inherits our affinity, which it keeps across exec in
+		// addition to the scope's AllowedCPUs.
+		return runCmd(ctx, "systemd-run", args, gameCPUs, "", debug, started)
 	}
 
-	warnf("neither systemd-run nor taskset available; running without pin")
-	return runCmd(ctx, cmd[0], cmd[1:], debug)
+	return runCmd(ctx, cmd[0], cmd[1:], gameCPUs, gameMems, debug, nil)
 }
 
 func systemdRunSetenvArgs() []string {
@@ -342,10 +621,14 @@ func userSystemdAvailable(ctx context.Context) bool {
 	return cmd.Run() == nil
 }
 
-func runCmd(ctx context.Context, bin string, args []string, debug bool) int {
+// runCmd runs bin and returns its exit code. A non-empty cpus restricts the
+// child's affinity with sched_setaffinity, so no taskset binary is needed;
+// a non-empty mems binds its memory to those NUMA nodes. A non-nil started
+// is called with the child's PID once it runs.
+func runCmd(ctx context.Context, bin string, args []string, cpus, mems string, debug bool, started func(pid int)) int {
 	fullCmd := bin + " " + strings.Join(args, " ")
-	logInfo("exec: %s", fullCmd)
-	debugf(debug, "exec: %s", fullCmd)
+	logInfo("exec: %s (cpus=%s)", fullCmd, cpus)
+	debugf(debug, "exec: %s (cpus=%s)", fullCmd, cpus)
 	c := exec.CommandContext(ctx, bin, args...)
 	c.Stdin = os.Stdin
 
@@ -377,7 +660,14 @@ func runCmd(ctx context.Context, bin string, args []string, debug bool) int {
 		c.Stderr = os.Stderr
 	}
 
-	if err := c.Run(); err != nil {
+	err := startPinned(c, cpus, mems, debug)
+	if err == nil {
+		if started != nil {
+			started(c.Process.Pid)
+		}
+		err = c.Wait()
+	}
+	if err != nil {
 		var ee *exec.ExitError
 		if errors.As(err, &ee) {
 			if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
@@ -400,6 +690,57 @@ func runCmd(ctx context.Context, bin string, args []string, debug bool) int {
 	return 0
 }
 
+// startPinned starts c with its affinity limited to cpus and its memory
+// bound to the nodes in mems. Both are set on the locked OS thread that
+// forks the child, so the child inherits them from its first instruction;
+// the affinity is then set again on the child's PID in case the fork
+// happened elsewhere. The memory policy cannot be set on another process.
+func startPinned(c *exec.Cmd, cpus, mems string, debug bool) error {
+	if cpus == "" && mems == "" {
+		return c.Start()
+	}
+	set, err := topology.ParseCPUList(cpus)
+	if cpus != "" && (err != nil || len(set) == 0) {
+		warnf("invalid cpu list %q; running without pin", cpus)
+		set = nil
+	}
+	nodes, err := topology.ParseCPUList(mems)
+	if mems != "" && (err != nil || len(nodes) == 0) {
+		warnf("invalid memory node list %q; running without memory binding", mems)
+		nodes = nil
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	orig, origErr := affinity.Get(0)
+	if len(set) > 0 {
+		if err := affinity.Set(0, set); err != nil {
+			warnf("sched_setaffinity: %v; running without pin", err)
+		}
+	}
+	if len(nodes) > 0 {
+		if err := affinity.BindMemory(nodes); err != nil {
+			warnf("set_mempolicy: %v; running without memory binding", err)
+		}
+	}
+	startErr := c.Start()
+	if len(set) > 0 && origErr == nil {
+		_ = affinity.Set(0, orig)
+	}
+	if len(nodes) > 0 {
+		_ = affinity.BindMemory(nil)
+	}
+	if startErr != nil {
+		return startErr
+	}
+	if len(set) > 0 {
+		if err := affinity.Set(c.Process.Pid, set); err != nil {
+			debugf(debug, "sched_setaffinity(%d): %v", c.Process.Pid, err)
+		}
+	}
+	return nil
+}
+
 func hasBinary(name string) bool {
 	_, err := exec.LookPath(name)
 	return err == nil
@@ -455,55 +796,55 @@ func recoverPanic() {
 		stack := debug.Stack()
 		msg := fmt.Sprintf("PANIC: %v\n%s", r, stack)
 
-		// Write to log file if available
+		logging.Errorf(nil, "%s", msg)
 		if logFile != nil {
-			log.Printf("%s", msg)
 			logFile.Sync()
 		}
-
-		// Also write to stderr
-		fmt.Fprintf(os.Stderr, "ccdpin: %s\n", msg)
+		if !logging.Journal() {
+			fmt.Fprintf(os.Stderr, "ccdpin: %s\n", msg)
+		}
 		os.Exit(2)
 	}
 }
 
-// logError writes an error to the log file (if available) and stderr.
+// logError writes an error to the log file or the journal.
 func logError(err error) {
-	if logFile != nil {
-		log.Printf("ERROR: %v", err)
-	}
+	logging.Errorf(nil, "%v", err)
 }
 
-// logInfo writes an informational message to the log file only (not stderr).
+// logInfo writes an informational message to the log file or the journal,
+// never to plain stderr where it would mix with the game's output.
 func logInfo(format string, args ...any) {
-	if logFile != nil {
-		log.Printf("INFO: "+format, args...)
-	}
+	logging.Infof(nil, format, args...)
 }
 
 func fatal(err error) {
 	logError(err)
-	fmt.Fprintln(os.Stderr, "ccdpin:", err)
+	if !logging.Journal() {
+		fmt.Fprintln(os.Stderr, "ccdpin:", err)
+	}
 	os.Exit(2)
 }
 
+// warnf logs a warning and, unless stderr already goes to the journal, also
+// prints it there.
 func warnf(format string, args ...any) {
 	msg := fmt.Sprintf(format, args...)
-	if logFile != nil {
-		log.Printf("WARN: %s", msg)
+	logging.Warnf(nil, "%s", msg)
+	if !logging.Journal() {
+		fmt.Fprintf(os.Stderr, "ccdpin: %s\n", msg)
 	}
-	fmt.Fprintf(os.Stderr, "ccdpin: %s\n", msg)
 }
 
 func debugf(debug bool, format string, args ...any) {
-	if !debug {
+	if !debug && !logging.Enabled(logging.Debug) {
 		return
 	}
 	msg := fmt.Sprintf(format, args...)
-	if logFile != nil {
-		log.Printf("DEBUG: %s", msg)
+	logging.Debugf(nil, "%s", msg)
+	if debug && !logging.Journal() {
+		fmt.Fprintf(os.Stderr, "ccdpin: %s\n", msg)
 	}
-	fmt.Fprintf(os.Stderr, "ccdpin: %s\n", msg)
 }
 
 type pinState struct {
@@ -687,7 +1028,15 @@ func (m *slicePinManager) pinSlicesLocked(_ context.Context, st *pinState) error
 	pinned := make([]string, 0, len(m.slices))
 	current := map[string]string{}
 	for _, unit := range m.slices {
+		// systemctl show succeeds for units it does not know.
 		ctx2, cancel := systemdctl.DefaultContext()
+		loaded, err := m.sys.UnitLoaded(ctx2, unit)
+		cancel()
+		if err == nil && !loaded {
+			logInfo("skipping slice %s: not loaded in the user manager", unit)
+			continue
+		}
+		ctx2, cancel = systemdctl.DefaultContext()
 		val, err := m.sys.GetAllowedCPUs(ctx2, unit)
 		cancel()
 		if err != nil {
@@ -698,7 +1047,7 @@ func (m *slicePinManager) pinSlicesLocked(_ context.Context, st *pinState) error
 		current[unit] = val
 	}
 	if len(pinned) == 0 {
-		return fmt.Errorf("no OS slices could be pinned")
+		return fmt.Errorf("no OS slices could be pinned; run `ccdpin --create-slices` if %s do not exist", strings.Join(m.slices, " "))
 	}
 
 	st.OriginalAllowedCPUs = make(map[string]string, len(current))
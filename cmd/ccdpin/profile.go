@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+const (
+	envConfig  = "STEAM_CCD_CONFIG"
+	envProfile = "STEAM_CCD_PROFILE"
+)
+
+// shared is what ccdpin takes from ccdbind's config: the settings both tools
+// mean the same by, with the game's [game."APPID"] profile applied.
+type shared struct {
+	osCPUs   string
+	gameCPUs string
+	prefer   string
+	cluster  int
+	osSlices []string
+	pinMem   bool
+	latency  int
+	topology string
+	// profile is the game ID whose profile applied, "" for none.
+	profile string
+}
+
+// loadShared reads ccdbind's config when --config or --profile (or their
+// environment variables) ask for it; ok is false otherwise. The profile is
+// the one named by --profile, or else the game ID in the environment as
+// ccdbind would find it.
+func loadShared(opts options) (s shared, ok bool, err error) {
+	path := strings.TrimSpace(opts.configPath)
+	if path == "" {
+		path = strings.TrimSpace(os.Getenv(envConfig))
+	}
+	id := strings.TrimSpace(opts.profile)
+	if id == "" {
+		id = strings.TrimSpace(os.Getenv(envProfile))
+	}
+	if path == "" && id == "" {
+		return shared{}, false, nil
+	}
+	if path == "" {
+		if path, err = config.DefaultConfigPath(); err != nil {
+			return shared{}, false, err
+		}
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return shared{}, false, fmt.Errorf("config %s: %w", path, err)
+	}
+
+	s = shared{
+		osCPUs:   cfg.OSCPUsOverride,
+		gameCPUs: cfg.GameCPUsOverride,
+		prefer:   cfg.Prefer,
+		cluster:  cfg.Cluster,
+		osSlices: unitSlices(cfg),
+		pinMem:   cfg.PinMemoryNodes,
+		latency:  cfg.DMALatency,
+		topology: cfg.TopologySnapshot,
+	}
+	named := id != ""
+	if !named {
+		id = gameIDIn(os.Environ(), cfg.EnvKeys)
+	}
+	id = cfg.GameAliases.Resolve(id)
+	if prof, found := cfg.Profiles[id]; found {
+		s.profile = id
+		if prof.GameCPUs != "" {
+			s.gameCPUs = prof.GameCPUs
+		}
+		if prof.OSCPUs != "" {
+			s.osCPUs = prof.OSCPUs
+		}
+	} else if named {
+		return shared{}, false, fmt.Errorf("no [game.%q] profile in %s", id, path)
+	}
+	return s, true, nil
+}
+
+// unitSlices returns the slices ccdbind pins that ccdpin can pin too: the
+// unit names of pin_slices, without cgroup paths, and session.slice with
+// pin_session_slice.
+func unitSlices(cfg config.Config) []string {
+	out := make([]string, 0, len(cfg.PinSlices)+1)
+	for _, s := range cfg.PinSlices {
+		if !systemdctl.IsCgroupPath(s) && strings.HasSuffix(s, ".slice") {
+			out = append(out, s)
+		}
+	}
+	if cfg.PinSessionSlice && !slices.Contains(out, "session.slice") {
+		out = append(out, "session.slice")
+	}
+	return out
+}
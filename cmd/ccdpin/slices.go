@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// sliceMarker heads the slice units written by ccdpin.
+const sliceMarker = "# Generated by ccdpin --create-slices."
+
+// sliceDescriptions are the descriptions systemd gives its standard user
+// slices, which it ships since v247. Minimal sessions and older systemd
+// lack some of them.
+var sliceDescriptions = map[string]string{
+	"app.slice":        "User Application Slice",
+	"background.slice": "User Background Tasks Slice",
+	"session.slice":    "User Core Session Slice",
+}
+
+// slicesCheckedPath is written once the OS slices were found or dealt with,
+// so later launches skip the check.
+func slicesCheckedPath() (string, error) {
+	dir, err := defaultStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "slices-checked"), nil
+}
+
+func markSlicesChecked() error {
+	path, err := slicesCheckedPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(time.Now().Format(time.RFC3339)+"\n"), 0o644)
+}
+
+func userUnitDir() (string, error) {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, "systemd", "user"), nil
+}
+
+func sliceUnit(name string) string {
+	desc := sliceDescriptions[name]
+	if desc == "" {
+		desc = "ccdpin OS slice " + strings.TrimSuffix(name, ".slice")
+	}
+	return sliceMarker + `
+[Unit]
+Description=` + desc + `
+Documentation=man:systemd.special(7)
+`
+}
+
+// missingSlices returns the slices the user manager does not know.
+func missingSlices(ctx context.Context, sys systemdctl.Systemctl, slices []string) ([]string, error) {
+	var missing []string
+	for _, unit := range slices {
+		ctx2, cancel := context.WithTimeout(ctx, 2*time.Second)
+		ok, err := sys.UnitLoaded(ctx2, unit)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			missing = append(missing, unit)
+		}
+	}
+	return missing, nil
+}
+
+// createSlices writes unit files for slices, reloads the user manager and
+// starts them. An existing unit file is left alone.
+func createSlices(ctx context.Context, sys systemdctl.Systemctl, slices []string) error {
+	dir, err := userUnitDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, unit := range slices {
+		path := filepath.Join(dir, unit)
+		if _, err := os.Stat(path); err == nil {
+			logInfo("%s exists; not overwriting it", path)
+			continue
+		}
+		if err := os.WriteFile(path, []byte(sliceUnit(unit)), 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "ccdpin: wrote %s\n", path)
+	}
+	ctx2, cancel := systemdctl.DefaultContext()
+	err = sys.DaemonReload(ctx2)
+	cancel()
+	if err != nil {
+		return err
+	}
+	for _, unit := range slices {
+		ctx2, cancel := systemdctl.DefaultContext()
+		err := sys.StartUnit(ctx2, unit)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSlices runs on launches until the OS slices have been verified. When
+// some are missing ccdpin would pin nothing, so it offers to create them if
+// it runs in a terminal, and otherwise says how to. The check is not
+// repeated once every slice exists or the user has answered.
+func checkSlices(ctx context.Context, sys systemdctl.Systemctl, slices []string, debug bool) {
+	marker, err := slicesCheckedPath()
+	if err != nil {
+		return
+	}
+	if _, err := os.Stat(marker); err == nil {
+		return
+	}
+	missing, err := missingSlices(ctx, sys, slices)
+	if err != nil {
+		debugf(debug, "slice check: %v", err)
+		return
+	}
+	if len(missing) == 0 {
+		_ = markSlicesChecked()
+		return
+	}
+	list := strings.Join(missing, " ")
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		warnf("user slices %s do not exist, so they cannot be pinned to the OS CPUs; run `ccdpin --create-slices` to create them", list)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "ccdpin: the user manager has no %s; ccdpin pins these to keep the desktop off the game's CPUs.\nccdpin: create them now? [y/N] ", list)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	_ = markSlicesChecked()
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		fmt.Fprintln(os.Stderr, "ccdpin: not creating them; run `ccdpin --create-slices` later to do so")
+		return
+	}
+	if err := createSlices(ctx, sys, missing); err != nil {
+		warnf("create slices: %v", err)
+	}
+}
+
+// runCreateSlices creates the missing OS slices for --create-slices.
+func runCreateSlices(ctx context.Context, slices []string) error {
+	sys := systemdctl.Systemctl{}
+	missing, err := missingSlices(ctx, sys, slices)
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		fmt.Printf("all OS slices exist: %s\n", strings.Join(slices, " "))
+		return nil
+	}
+	if err := createSlices(ctx, sys, missing); err != nil {
+		return err
+	}
+	_ = markSlicesChecked()
+	fmt.Printf("created %s\n", strings.Join(missing, " "))
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
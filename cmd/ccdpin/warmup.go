@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/affinity"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// maxWarmup bounds --warmup; boost clocks ramp within milliseconds, so
+// anything longer only delays the game.
+const maxWarmup = 5 * time.Second
+
+// warmupSink keeps the spin loops from being optimized away.
+var warmupSink atomic.Uint64
+
+// warmup keeps every CPU in cpus busy for d so they leave deep idle states
+// and reach boost clocks before the game starts. Each spinner locks its own
+// thread and never unlocks it, so the runtime discards the pinned thread
+// instead of reusing it for ccdpin.
+func warmup(cpus string, d time.Duration) error {
+	set, err := topology.ParseCPUList(cpus)
+	if err != nil || len(set) == 0 {
+		return fmt.Errorf("invalid cpu list %q", cpus)
+	}
+	deadline := time.Now().Add(d)
+	var wg sync.WaitGroup
+	errs := make([]error, len(set))
+	for i, cpu := range set {
+		wg.Add(1)
+		go func(i, cpu int) {
+			defer wg.Done()
+			runtime.LockOSThread()
+			if err := affinity.Set(0, []int{cpu}); err != nil {
+				errs[i] = fmt.Errorf("cpu %d: %w", cpu, err)
+				return
+			}
+			x := uint64(cpu) + 1
+			for time.Now().Before(deadline) {
+				for j := 0; j < 1<<14; j++ {
+					x = x*6364136223846793005 + 1442695040888963407
+				}
+			}
+			warmupSink.Add(x)
+		}(i, cpu)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
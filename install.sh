@@ -6,7 +6,9 @@
 #   curl -fsSL https://raw.githubusercontent.com/Reidond/ccdbind/main/install.sh | bash
 #   VERSION=v1.0.0 bash install.sh
 #
-# This script is rootless and installs to ~/.local by default.
+# This script is rootless and installs to ~/.local by default. With --system
+# (implied when run as root) it also installs the ccdbind-smt-off.service
+# system unit used by smt_off, through sudo when not root.
 
 set -euo pipefail
 
@@ -20,6 +22,7 @@ PREFIX="${PREFIX:-${HOME}/.local}"
 BINDIR="${BINDIR:-${PREFIX}/bin}"
 CONFIGDIR="${CONFIGDIR:-${XDG_CONFIG_HOME:-${HOME}/.config}/ccdbind}"
 SYSTEMD_USER_DIR="${SYSTEMD_USER_DIR:-${XDG_CONFIG_HOME:-${HOME}/.config}/systemd/user}"
+SYSTEMD_SYSTEM_DIR="${SYSTEMD_SYSTEM_DIR:-/etc/systemd/system}"
 
 # Version to install (empty = latest)
 VERSION="${VERSION:-}"
@@ -28,6 +31,7 @@ VERSION="${VERSION:-}"
 DRY_RUN="${DRY_RUN:-0}"
 SKIP_SERVICE="${SKIP_SERVICE:-0}"
 FORCE="${FORCE:-0}"
+SYSTEM_UNITS="${SYSTEM_UNITS:-0}"
 
 # Temp directory for downloads
 TMPDIR="${TMPDIR:-/tmp}"
@@ -65,6 +69,7 @@ ${BOLD}Options:${NC}
     -n, --dry-run       Print actions without executing
     -f, --force         Overwrite existing files without prompting
     -S, --skip-service  Skip systemd service setup
+    --system            Also install the system unit for smt_off (uses sudo)
     --prefix=PATH       Install prefix (default: ~/.local)
     --bindir=PATH       Binary directory (default: PREFIX/bin)
     --configdir=PATH    Config directory (default: ~/.config/ccdbind)
@@ -74,6 +79,7 @@ ${BOLD}Environment:${NC}
     PREFIX              Install prefix
     BINDIR              Binary directory
     CONFIGDIR           Config directory
+    SYSTEM_UNITS        Set to 1 for --system
     NO_COLOR            Disable colored output
 
 ${BOLD}Examples:${NC}
@@ -82,6 +88,7 @@ ${BOLD}Examples:${NC}
     VERSION=v1.0.0 ${PROG_NAME}           # Install specific version (env)
     ${PROG_NAME} --prefix=/opt/ccdbind    # Custom install location
     ${PROG_NAME} --dry-run                # Preview installation
+    ${PROG_NAME} --system                 # Include the smt_off system unit
 EOF
     exit 0
 }
@@ -235,6 +242,24 @@ install_file() {
     run install -m "$mode" "$src" "$dst"
 }
 
+# Install the system units, as root or through sudo
+install_system_units() {
+    local src="$1"
+    local sudo=()
+
+    if [[ "${EUID}" -ne 0 ]]; then
+        has_cmd sudo || die "--system needs root or sudo"
+        sudo=(sudo)
+    fi
+
+    info "Installing systemd system units..."
+    run ${sudo[@]+"${sudo[@]}"} install -Dm644 "${src}/systemd/system/ccdbind-smt-off.service" "${SYSTEMD_SYSTEM_DIR}/ccdbind-smt-off.service"
+    if has_cmd systemctl; then
+        run ${sudo[@]+"${sudo[@]}"} systemctl daemon-reload
+    fi
+    info "Allow your user to start ccdbind-smt-off.service with polkit; see the smt_off docs"
+}
+
 # Cleanup temporary files
 cleanup() {
     if [[ -n "${WORK_DIR:-}" ]] && [[ -d "$WORK_DIR" ]]; then
@@ -253,6 +278,7 @@ main() {
             -n|--dry-run)       DRY_RUN=1 ;;
             -f|--force)         FORCE=1 ;;
             -S|--skip-service)  SKIP_SERVICE=1 ;;
+            --system)           SYSTEM_UNITS=1 ;;
             --prefix=*)         PREFIX="$(parse_arg "$1")"; BINDIR="${PREFIX}/bin" ;;
             --bindir=*)         BINDIR="$(parse_arg "$1")" ;;
             --configdir=*)      CONFIGDIR="$(parse_arg "$1")" ;;
@@ -262,6 +288,8 @@ main() {
         shift
     done
 
+    [[ "${EUID}" -ne 0 ]] || SYSTEM_UNITS=1
+
     # Validate environment
     check_deps
 
@@ -318,8 +346,13 @@ main() {
     # Install systemd units
     info "Installing systemd user units..."
     install_file 644 "${extract_dir}/systemd/user/ccdbind.service" "${SYSTEMD_USER_DIR}/ccdbind.service"
+    install_file 644 "${extract_dir}/systemd/user/ccdbind-hotkey.service" "${SYSTEMD_USER_DIR}/ccdbind-hotkey.service"
     install_file 644 "${extract_dir}/systemd/user/game.slice" "${SYSTEMD_USER_DIR}/game.slice"
 
+    if [[ "$SYSTEM_UNITS" == "1" ]]; then
+        install_system_units "$extract_dir"
+    fi
+
     # Install config if not exists
     if [[ ! -f "${CONFIGDIR}/config.toml" ]]; then
         info "Installing default configuration..."
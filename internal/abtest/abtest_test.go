@@ -0,0 +1,75 @@
+package abtest
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func near(a, b, tol float64) bool { return math.Abs(a-b) <= tol }
+
+func TestSummarize(t *testing.T) {
+	s := Summarize([]float64{4, 1, 3, 2, 5})
+	if s.N != 5 || s.Mean != 3 || s.Median != 3 || s.Min != 1 || s.Max != 5 || !near(s.Stddev, math.Sqrt(2.5), 1e-12) {
+		t.Fatalf("summary = %+v", s)
+	}
+	if s := Summarize(nil); s != (Summary{}) {
+		t.Fatalf("empty summary = %+v", s)
+	}
+	if got := Percentile([]float64{10, 20, 30, 40}, 50); got != 25 {
+		t.Fatalf("median of four = %v", got)
+	}
+}
+
+func TestWelch(t *testing.T) {
+	// t = -5.599 with 7.83 degrees of freedom.
+	a := []float64{19.1, 20.3, 18.7, 21.0, 19.8}
+	b := []float64{22.4, 23.1, 21.9, 24.0, 22.7}
+	if p := Welch(a, b); !near(p, 0.00055241, 1e-7) {
+		t.Fatalf("p = %v", p)
+	}
+	if p := Welch(a, a); !near(p, 1, 1e-12) {
+		t.Fatalf("identical samples p = %v", p)
+	}
+	if p := Welch([]float64{1}, b); p != 1 {
+		t.Fatalf("single value p = %v", p)
+	}
+	if p := Welch([]float64{1, 1}, []float64{2, 2}); p != 0 {
+		t.Fatalf("constant samples p = %v", p)
+	}
+	c := Compare([]float64{100, 100}, []float64{110, 110})
+	if !near(c.Delta, 0.1, 1e-12) || c.P != 0 {
+		t.Fatalf("compare = %+v", c)
+	}
+}
+
+func TestParseFrameTimes(t *testing.T) {
+	mangohud := `os,cpu,gpu,ram,kernel,driver,cpuscheduler
+Arch Linux,AMD Ryzen 9 7950X3D,Radeon RX 7900 XTX,64 GB,6.10.0,Mesa 24.1,
+fps,frametime,cpu_load,gpu_load,cpu_temp,gpu_temp,elapsed
+60.1,16.6,30,90,60,70,1000
+59.9,16.7,31,91,60,70,2000
+30.0,33.3,29,90,60,70,3000
+`
+	ms, err := ParseFrameTimes(strings.NewReader(mangohud))
+	if err != nil || len(ms) != 3 || ms[2] != 33.3 {
+		t.Fatalf("mangohud: %v %v", ms, err)
+	}
+
+	presentmon := "Application,ProcessID,SwapChainAddress,Runtime,MsBetweenPresents,Dropped\n" +
+		"game.exe,123,0x1,DXGI,10.0,0\n" +
+		"game.exe,123,0x1,DXGI,bad,0\n" +
+		"game.exe,123,0x1,DXGI,20.0,0\n"
+	ms, err = ParseFrameTimes(strings.NewReader(presentmon))
+	if err != nil || len(ms) != 2 {
+		t.Fatalf("presentmon: %v %v", ms, err)
+	}
+	f := FrameStats(ms)
+	if f.Count != 2 || !near(f.AvgFPS, 1000.0/15, 1e-9) || !near(f.Low1, 1000/19.9, 1e-9) {
+		t.Fatalf("frames = %+v", f)
+	}
+
+	if _, err := ParseFrameTimes(strings.NewReader("a,b\n1,2\n")); err == nil {
+		t.Fatal("expected an error without a frame time column")
+	}
+}
@@ -0,0 +1,101 @@
+package abtest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// frameTimeColumns are the CSV columns holding frame times in milliseconds:
+// MangoHud's frametime, PresentMon 1.x's MsBetweenPresents and PresentMon
+// 2.x's FrameTime. Matching ignores case.
+var frameTimeColumns = []string{"frametime", "msbetweenpresents"}
+
+// Frames describes the frame times of one run.
+type Frames struct {
+	Count  int     `json:"count"`
+	AvgFPS float64 `json:"avg_fps"`
+	// Low1 and Low01 are the 1% and 0.1% lows: the frame rate matching the
+	// 99th and 99.9th percentile frame time.
+	Low1  float64 `json:"low_1"`
+	Low01 float64 `json:"low_0_1"`
+}
+
+// FrameStats computes frame metrics from frame times in milliseconds.
+func FrameStats(ms []float64) Frames {
+	f := Frames{Count: len(ms)}
+	if len(ms) == 0 {
+		return f
+	}
+	if mean := Summarize(ms).Mean; mean > 0 {
+		f.AvgFPS = 1000 / mean
+	}
+	if p := Percentile(ms, 99); p > 0 {
+		f.Low1 = 1000 / p
+	}
+	if p := Percentile(ms, 99.9); p > 0 {
+		f.Low01 = 1000 / p
+	}
+	return f
+}
+
+// ParseFrameTimes reads the frame times of a MangoHud or PresentMon CSV log.
+// Lines before the header (MangoHud's system information) are skipped, as
+// are rows whose frame time does not parse.
+func ParseFrameTimes(r io.Reader) ([]float64, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	col := -1
+	var ms []float64
+	for sc.Scan() {
+		fields := strings.Split(strings.TrimSpace(sc.Text()), ",")
+		if col < 0 {
+			col = frameTimeColumn(fields)
+			continue
+		}
+		if col >= len(fields) {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(fields[col]), 64)
+		if err != nil || v <= 0 {
+			continue
+		}
+		ms = append(ms, v)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if col < 0 {
+		return nil, fmt.Errorf("no frame time column (expected one of %s)", strings.Join(frameTimeColumns, ", "))
+	}
+	return ms, nil
+}
+
+func frameTimeColumn(header []string) int {
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		for _, want := range frameTimeColumns {
+			if h == want {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// ReadFrameTimes parses the frame time log at path, see ParseFrameTimes.
+func ReadFrameTimes(path string) ([]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ms, err := ParseFrameTimes(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ms, nil
+}
@@ -0,0 +1,60 @@
+// Package affinity wraps sched_setaffinity/sched_getaffinity without
+// depending on external tools such as taskset.
+package affinity
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// maskWords covers CPUs 0-1023, the kernel's default CONFIG_NR_CPUS ceiling
+// on distribution kernels.
+const maskWords = 16
+
+type mask [maskWords]uint64
+
+func maskOf(cpus []int) (mask, error) {
+	var m mask
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= maskWords*64 {
+			return mask{}, errors.New("cpu out of range")
+		}
+		m[cpu/64] |= 1 << (uint(cpu) % 64)
+	}
+	return m, nil
+}
+
+// Set sets the affinity of pid (or of the calling thread when pid is 0).
+func Set(pid int, cpus []int) error {
+	if len(cpus) == 0 {
+		return errors.New("empty cpu set")
+	}
+	m, err := maskOf(cpus)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(pid), unsafe.Sizeof(m), uintptr(unsafe.Pointer(&m)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Get returns the CPUs pid (or the calling thread when pid is 0) may run on.
+func Get(pid int) ([]int, error) {
+	var m mask
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, uintptr(pid), unsafe.Sizeof(m), uintptr(unsafe.Pointer(&m)))
+	if errno != 0 {
+		return nil, errno
+	}
+	out := make([]int, 0, 64)
+	for w, bits := range m {
+		for b := 0; b < 64; b++ {
+			if bits&(1<<uint(b)) != 0 {
+				out = append(out, w*64+b)
+			}
+		}
+	}
+	return out, nil
+}
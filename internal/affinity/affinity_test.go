@@ -0,0 +1,47 @@
+package affinity
+
+import (
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestSetAndGetCurrentThread(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	orig, err := Get(0)
+	if err != nil || len(orig) == 0 {
+		t.Fatalf("Get: %v %v", orig, err)
+	}
+	defer Set(0, orig)
+
+	if err := Set(0, orig[:1]); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	got, err := Get(0)
+	if err != nil || !reflect.DeepEqual(got, orig[:1]) {
+		t.Fatalf("Get after Set: got=%v err=%v", got, err)
+	}
+	if err := Set(0, nil); err == nil {
+		t.Fatalf("expected error for empty set")
+	}
+}
+
+func TestBindMemory(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := BindMemory([]int{0}); err == syscall.ENOSYS {
+		t.Skip("kernel without NUMA support")
+	} else if err != nil {
+		t.Fatalf("BindMemory: %v", err)
+	}
+	if err := BindMemory(nil); err != nil {
+		t.Fatalf("BindMemory(nil): %v", err)
+	}
+	if err := BindMemory([]int{4096}); err == nil {
+		t.Fatalf("expected error for out of range node")
+	}
+}
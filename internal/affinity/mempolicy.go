@@ -0,0 +1,34 @@
+package affinity
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	mpolDefault = 0
+	mpolBind    = 2
+)
+
+// BindMemory restricts the calling thread's memory allocations to the NUMA
+// nodes given (MPOL_BIND, like `numactl --membind`); no nodes restores the
+// default policy. The policy is inherited by children forked from the
+// thread and kept across exec.
+func BindMemory(nodes []int) error {
+	mode, m := mpolDefault, mask{}
+	if len(nodes) > 0 {
+		var err error
+		if m, err = maskOf(nodes); err != nil {
+			return errors.New("memory node out of range")
+		}
+		mode = mpolBind
+	}
+	// maxnode is one more than the number of bits, a historical quirk every
+	// libnuma caller repeats.
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SET_MEMPOLICY, uintptr(mode), uintptr(unsafe.Pointer(&m)), maskWords*64+1)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+// Package bench measures how clusters actually behave: cache-line handoff
+// latency between clusters, dependent-load latency over a working set, and
+// copy bandwidth per cluster. The results fill in for sysfs on CPUs whose
+// clusters report identical cache sizes.
+package bench
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/affinity"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// Cluster holds the per-cluster measurements.
+type Cluster struct {
+	ID   int    `json:"id"`
+	CPUs string `json:"cpus"`
+	// ChaseNs is the average latency of a dependent load over ChaseBytes.
+	ChaseNs float64 `json:"chase_ns"`
+	// BandwidthGBs is the copy bandwidth with every CPU of the cluster busy.
+	BandwidthGBs float64 `json:"bandwidth_gbs"`
+	// GPULocal marks clusters that intersect the local CPUs of Results.GPU.
+	GPULocal bool `json:"gpu_local,omitempty"`
+}
+
+type Results struct {
+	MeasuredAt time.Time `json:"measured_at"`
+	ChaseBytes int       `json:"chase_bytes"`
+	Clusters   []Cluster `json:"clusters"`
+	// LatencyNs[i][j] is the one-way cache-line handoff latency between the
+	// first CPU of cluster i and a CPU of cluster j (the second CPU of i on
+	// the diagonal). 0 means not measured.
+	LatencyNs [][]float64 `json:"latency_ns"`
+	// GPU is the DRM node selected by the gpu config key, if any.
+	GPU string `json:"gpu,omitempty"`
+}
+
+type Options struct {
+	ChaseBytes  int // working set for the pointer chase
+	ChaseSteps  int
+	PingRounds  int
+	StreamBytes int // per CPU, for each of source and destination
+}
+
+// DefaultOptions uses a 64 MiB chase: larger than a regular 32 MiB CCD L3,
+// smaller than a 96 MiB V-Cache one.
+func DefaultOptions() Options {
+	return Options{
+		ChaseBytes:  64 << 20,
+		ChaseSteps:  2_000_000,
+		PingRounds:  100_000,
+		StreamBytes: 8 << 20,
+	}
+}
+
+func DefaultPath() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(base, "ccdbind", "bench.json"), nil
+}
+
+func Load(path string) (Results, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Results{}, err
+	}
+	var r Results
+	if err := json.Unmarshal(b, &r); err != nil {
+		return Results{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return r, nil
+}
+
+func Save(path string, r Results) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Run benchmarks every cluster and every pair of clusters.
+func Run(clusters []topology.Cluster, opts Options) (Results, error) {
+	if len(clusters) == 0 {
+		return Results{}, errors.New("no clusters")
+	}
+	res := Results{MeasuredAt: time.Now(), ChaseBytes: opts.ChaseBytes, LatencyNs: make([][]float64, len(clusters))}
+	cpus := make([][]int, len(clusters))
+	for i, c := range clusters {
+		set, err := topology.ParseCPUList(c.CPUs)
+		if err != nil || len(set) == 0 {
+			return Results{}, fmt.Errorf("cluster %d: bad cpu list %q", c.ID, c.CPUs)
+		}
+		cpus[i] = set
+	}
+
+	for i, c := range clusters {
+		bc := Cluster{ID: c.ID, CPUs: c.CPUs}
+		var err error
+		if bc.ChaseNs, err = Chase(cpus[i][0], opts.ChaseBytes, opts.ChaseSteps); err != nil {
+			return Results{}, fmt.Errorf("cluster %d chase: %w", c.ID, err)
+		}
+		if bc.BandwidthGBs, err = Stream(cpus[i], opts.StreamBytes); err != nil {
+			return Results{}, fmt.Errorf("cluster %d stream: %w", c.ID, err)
+		}
+		res.Clusters = append(res.Clusters, bc)
+
+		res.LatencyNs[i] = make([]float64, len(clusters))
+		for j := range clusters {
+			peer := cpus[j][0]
+			if i == j {
+				if len(cpus[i]) < 2 {
+					continue
+				}
+				peer = cpus[i][1]
+			}
+			if res.LatencyNs[i][j], err = PingPong(cpus[i][0], peer, opts.PingRounds); err != nil {
+				return Results{}, fmt.Errorf("ping-pong %d/%d: %w", c.ID, clusters[j].ID, err)
+			}
+		}
+	}
+	return res, nil
+}
+
+// pin locks the calling goroutine to its OS thread and that thread to cpu.
+// The thread is never unlocked, so the runtime discards it once the
+// goroutine exits instead of reusing a pinned thread.
+func pin(cpu int) error {
+	runtime.LockOSThread()
+	return affinity.Set(0, []int{cpu})
+}
+
+// PingPong bounces a cache line between cpus a and b and returns the average
+// one-way latency in nanoseconds.
+func PingPong(a, b, rounds int) (float64, error) {
+	if rounds <= 0 || a == b {
+		return 0, errors.New("need two distinct cpus and a positive round count")
+	}
+	var (
+		turn   atomic.Int32
+		failed atomic.Bool
+		ready  sync.WaitGroup
+		ns     float64
+	)
+	errc := make(chan error, 2)
+	ready.Add(2)
+	side := func(cpu int, fn func()) {
+		err := pin(cpu)
+		if err != nil {
+			failed.Store(true)
+		}
+		ready.Done()
+		ready.Wait()
+		if err == nil && !failed.Load() {
+			fn()
+		}
+		errc <- err
+	}
+	go side(b, func() {
+		for i := 0; i < rounds; i++ {
+			for turn.Load() != 1 {
+			}
+			turn.Store(0)
+		}
+	})
+	go side(a, func() {
+		start := time.Now()
+		for i := 0; i < rounds; i++ {
+			turn.Store(1)
+			for turn.Load() != 0 {
+			}
+		}
+		ns = float64(time.Since(start).Nanoseconds()) / float64(rounds) / 2
+	})
+	err := errors.Join(<-errc, <-errc)
+	if err != nil {
+		return 0, err
+	}
+	return ns, nil
+}
+
+// sink keeps the chase result live.
+var sink uint64
+
+// Chase walks a random cyclic permutation of cache lines over a working set
+// of size bytes on cpu and returns the average latency per load.
+func Chase(cpu, size, steps int) (float64, error) {
+	const line = 64 / 8 // uint64 slots per cache line
+	lines := size / 64
+	if lines < 2 || steps <= 0 {
+		return 0, errors.New("working set too small")
+	}
+
+	var ns float64
+	errc := make(chan error, 1)
+	go func() {
+		if err := pin(cpu); err != nil {
+			errc <- err
+			return
+		}
+		buf := make([]uint64, lines*line)
+		// Sattolo's algorithm yields a single cycle through every line, so
+		// the hardware prefetcher cannot guess the next address.
+		perm := make([]int, lines)
+		for i := range perm {
+			perm[i] = i
+		}
+		for i := lines - 1; i > 0; i-- {
+			j := rand.IntN(i)
+			perm[i], perm[j] = perm[j], perm[i]
+		}
+		for i := 0; i < lines; i++ {
+			buf[perm[i]*line] = uint64(perm[(i+1)%lines] * line)
+		}
+
+		p := uint64(0)
+		for i := 0; i < lines; i++ { // warm up
+			p = buf[p]
+		}
+		start := time.Now()
+		for i := 0; i < steps; i++ {
+			p = buf[p]
+		}
+		elapsed := time.Since(start)
+		sink = p
+		ns = float64(elapsed.Nanoseconds()) / float64(steps)
+		errc <- nil
+	}()
+	if err := <-errc; err != nil {
+		return 0, err
+	}
+	return ns, nil
+}
+
+// Stream copies size bytes per CPU on every CPU in cpus at once and returns
+// the aggregate bandwidth in GB/s (read plus write).
+func Stream(cpus []int, size int) (float64, error) {
+	if len(cpus) == 0 || size <= 0 {
+		return 0, errors.New("nothing to measure")
+	}
+	const passes = 4
+	var wg sync.WaitGroup
+	var ready, start sync.WaitGroup
+	ready.Add(len(cpus))
+	start.Add(1)
+	errs := make(chan error, len(cpus))
+	for _, cpu := range cpus {
+		wg.Add(1)
+		go func(cpu int) {
+			defer wg.Done()
+			err := pin(cpu)
+			src := make([]byte, size)
+			dst := make([]byte, size)
+			copy(dst, src) // fault the pages in before timing
+			ready.Done()
+			start.Wait()
+			if err != nil {
+				errs <- err
+				return
+			}
+			for i := 0; i < passes; i++ {
+				copy(dst, src)
+			}
+		}(cpu)
+	}
+	ready.Wait()
+	t0 := time.Now()
+	start.Done()
+	wg.Wait()
+	elapsed := time.Since(t0)
+	close(errs)
+	if err := <-errs; err != nil {
+		return 0, err
+	}
+	bytes := float64(2*size*passes) * float64(len(cpus))
+	return bytes / elapsed.Seconds() / 1e9, nil
+}
+
+// FastestCache returns the cluster whose pointer chase is at least 10% faster
+// than every other one. Results are only used when they were taken on the
+// same cluster layout as current.
+func (r Results) FastestCache(current []topology.Cluster) (int, bool) {
+	if len(r.Clusters) != len(current) || len(current) < 2 {
+		return 0, false
+	}
+	for i, c := range current {
+		if r.Clusters[i].ID != c.ID || r.Clusters[i].CPUs != c.CPUs || r.Clusters[i].ChaseNs <= 0 {
+			return 0, false
+		}
+	}
+	best := 0
+	for i, c := range r.Clusters {
+		if c.ChaseNs < r.Clusters[best].ChaseNs {
+			best = i
+		}
+	}
+	for i, c := range r.Clusters {
+		if i != best && r.Clusters[best].ChaseNs > 0.9*c.ChaseNs {
+			return 0, false
+		}
+	}
+	return r.Clusters[best].ID, true
+}
+
+// Refine applies saved results to a detected topology when sysfs could not
+// tell the clusters apart for prefer = "cache". Other prefer values and
+// layouts sysfs already decided are returned unchanged.
+func Refine(res topology.Result, prefer string, saved Results) topology.Result {
+	if prefer != topology.PreferCache {
+		return res
+	}
+	if _, _, ok := topology.SelectPreferred(res.Clusters, prefer); ok {
+		return res
+	}
+	id, ok := saved.FastestCache(res.Clusters)
+	if !ok {
+		return res
+	}
+	osCPUs, gameCPUs, err := topology.SelectCluster(res.Clusters, id)
+	if err != nil {
+		return res
+	}
+	res.OSCPUs, res.GameCPUs = osCPUs, gameCPUs
+	return res
+}
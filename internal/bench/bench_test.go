@@ -0,0 +1,59 @@
+package bench
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+func TestKernels(t *testing.T) {
+	if ns, err := Chase(0, 1<<20, 10000); err != nil || ns <= 0 {
+		t.Fatalf("Chase = %v, %v", ns, err)
+	}
+	if gbs, err := Stream([]int{0}, 1<<20); err != nil || gbs <= 0 {
+		t.Fatalf("Stream = %v, %v", gbs, err)
+	}
+	if _, err := PingPong(0, 0, 10); err == nil {
+		t.Fatalf("expected PingPong on one cpu to be rejected")
+	}
+	if runtime.NumCPU() < 2 {
+		t.Skip("PingPong needs two CPUs")
+	}
+	if ns, err := PingPong(0, 1, 1000); err != nil || ns <= 0 {
+		t.Fatalf("PingPong = %v, %v", ns, err)
+	}
+}
+
+func TestRefineUsesChaseWhenSysfsTies(t *testing.T) {
+	clusters := []topology.Cluster{{ID: 0, CPUs: "0-7", L3KB: 32768}, {ID: 1, CPUs: "8-15", L3KB: 32768}}
+	res := topology.Result{OSCPUs: "0-7", GameCPUs: "8-15", Clusters: clusters}
+	saved := Results{Clusters: []Cluster{{ID: 0, CPUs: "0-7", ChaseNs: 12}, {ID: 1, CPUs: "8-15", ChaseNs: 80}}}
+
+	path := filepath.Join(t.TempDir(), "bench.json")
+	if err := Save(path, saved); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	got := Refine(res, topology.PreferCache, loaded)
+	if got.GameCPUs != "0-7" || got.OSCPUs != "8-15" {
+		t.Fatalf("Refine: os=%q game=%q", got.OSCPUs, got.GameCPUs)
+	}
+	if got := Refine(res, topology.PreferFrequency, loaded); got.GameCPUs != "8-15" {
+		t.Fatalf("expected frequency preference to ignore chase results, got %q", got.GameCPUs)
+	}
+
+	loaded.Clusters[1].ChaseNs = 13
+	if got := Refine(res, topology.PreferCache, loaded); got.GameCPUs != "8-15" {
+		t.Fatalf("expected close results to keep the default, got %q", got.GameCPUs)
+	}
+	loaded.Clusters[1].CPUs = "8-11"
+	if _, ok := loaded.FastestCache(clusters); ok {
+		t.Fatalf("expected results from another layout to be ignored")
+	}
+}
@@ -0,0 +1,309 @@
+// Package boost raises the scheduling priority of game threads: their nice
+// value, I/O priority and scheduling policy. The values each thread had
+// before are kept so they can be put back when the game is unpinned.
+package boost
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// I/O scheduling classes, see ioprio_set(2).
+const (
+	IOClassRealtime   = 1
+	IOClassBestEffort = 2
+	IOClassIdle       = 3
+)
+
+// Scheduling policies, see sched(7). SCHED_ISO exists only in kernels
+// carrying the MuQSS or BMQ/PDS patches; mainline rejects it with EINVAL.
+const (
+	SchedOther = 0
+	SchedRR    = 2
+	SchedISO   = 4
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	rlimitRTPrio     = 14
+)
+
+// IOPrio is an I/O scheduling class and level. The zero value leaves the
+// I/O priority alone.
+type IOPrio struct {
+	Class int // IOClassRealtime, IOClassBestEffort or IOClassIdle
+	Level int // 0 (highest) to 7; unused for IOClassIdle
+}
+
+// ParseIOPrio parses "realtime:N", "best-effort:N" or "idle", N being 0-7.
+// The level defaults to 4, as with ionice.
+func ParseIOPrio(s string) (IOPrio, error) {
+	name, level, hasLevel := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	var p IOPrio
+	switch name {
+	case "realtime", "rt":
+		p.Class = IOClassRealtime
+	case "best-effort", "be":
+		p.Class = IOClassBestEffort
+	case "idle":
+		if hasLevel {
+			return IOPrio{}, fmt.Errorf("invalid ioprio %q (idle takes no level)", s)
+		}
+		return IOPrio{Class: IOClassIdle}, nil
+	default:
+		return IOPrio{}, fmt.Errorf("invalid ioprio %q (expected realtime:N, best-effort:N or idle)", s)
+	}
+	p.Level = 4
+	if hasLevel {
+		n, err := strconv.Atoi(level)
+		if err != nil || n < 0 || n > 7 {
+			return IOPrio{}, fmt.Errorf("invalid ioprio %q (level must be 0-7)", s)
+		}
+		p.Level = n
+	}
+	return p, nil
+}
+
+func (p IOPrio) String() string {
+	switch p.Class {
+	case IOClassRealtime:
+		return fmt.Sprintf("realtime:%d", p.Level)
+	case IOClassBestEffort:
+		return fmt.Sprintf("best-effort:%d", p.Level)
+	case IOClassIdle:
+		return "idle"
+	}
+	return ""
+}
+
+func (p IOPrio) value() int { return p.Class<<ioprioClassShift | p.Level }
+
+// Sched is a scheduling policy and its real-time priority. The zero value
+// leaves the policy alone.
+type Sched struct {
+	Policy   int // SchedRR or SchedISO
+	Priority int // 1-99 for SchedRR, 0 for SchedISO
+}
+
+// ParseSched parses "iso" or "rr[:N]", N being 1-99. The priority defaults
+// to 1, the lowest real-time priority.
+func ParseSched(s string) (Sched, error) {
+	name, prio, hasPrio := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	switch name {
+	case "iso":
+		if hasPrio {
+			return Sched{}, fmt.Errorf("invalid sched %q (iso takes no priority)", s)
+		}
+		return Sched{Policy: SchedISO}, nil
+	case "rr":
+		p := Sched{Policy: SchedRR, Priority: 1}
+		if hasPrio {
+			n, err := strconv.Atoi(prio)
+			if err != nil || n < 1 || n > 99 {
+				return Sched{}, fmt.Errorf("invalid sched %q (priority must be 1-99)", s)
+			}
+			p.Priority = n
+		}
+		return p, nil
+	}
+	return Sched{}, fmt.Errorf("invalid sched %q (expected iso or rr[:N])", s)
+}
+
+func (s Sched) String() string {
+	switch s.Policy {
+	case SchedRR:
+		return fmt.Sprintf("rr:%d", s.Priority)
+	case SchedISO:
+		return "iso"
+	}
+	return ""
+}
+
+// Settings is what a game's threads are boosted to. Unset parts are left
+// alone.
+type Settings struct {
+	Nice   *int
+	IOPrio IOPrio
+	Sched  Sched
+}
+
+// Empty reports whether s changes nothing.
+func (s Settings) Empty() bool {
+	return s.Nice == nil && s.IOPrio == (IOPrio{}) && s.Sched == (Sched{})
+}
+
+// Check reports why s.Sched cannot be applied by this process, or nil.
+// SCHED_RR needs an RLIMIT_RTPRIO of at least the priority (set with
+// LimitRTPRIO= or pam_limits); the daemon drops CAP_SYS_NICE at startup
+// along with every other capability, even when run as root.
+func (s Settings) Check() error {
+	if s.Sched.Policy != SchedRR {
+		return nil
+	}
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(rlimitRTPrio, &rl); err != nil {
+		return fmt.Errorf("RLIMIT_RTPRIO: %w", err)
+	}
+	if rl.Cur < uint64(s.Sched.Priority) {
+		return fmt.Errorf("SCHED_RR priority %d exceeds RLIMIT_RTPRIO %d; raise LimitRTPRIO= or lower sched", s.Sched.Priority, rl.Cur)
+	}
+	return nil
+}
+
+// Thread is the scheduling state of one thread.
+type Thread struct {
+	Nice     int
+	IOPrio   int // raw ioprio value; 0 follows the nice value
+	Policy   int
+	Priority int
+}
+
+// Saved maps thread IDs of one process to their state before the boost.
+type Saved map[int]Thread
+
+// Apply boosts every thread of pid to s and returns what the threads had
+// before. Parts that fail are reported in the error; the rest is applied.
+// Threads created later inherit the values from their creator.
+func Apply(pid int, s Settings) (Saved, error) {
+	tids, err := threads(pid)
+	if err != nil {
+		return nil, err
+	}
+	saved := Saved{}
+	var errs []error
+	for _, tid := range tids {
+		cur, err := read(tid)
+		if err != nil {
+			continue // exited
+		}
+		saved[tid] = cur
+		if s.Nice != nil {
+			if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, *s.Nice); err != nil {
+				errs = append(errs, fmt.Errorf("nice %d: %w", *s.Nice, err))
+			}
+		}
+		if s.IOPrio != (IOPrio{}) {
+			if err := setIOPrio(tid, s.IOPrio.value()); err != nil {
+				errs = append(errs, fmt.Errorf("ioprio %s: %w", s.IOPrio, err))
+			}
+		}
+		if s.Sched != (Sched{}) {
+			err := setScheduler(tid, s.Sched.Policy, s.Sched.Priority)
+			if errors.Is(err, syscall.EINVAL) && s.Sched.Policy == SchedISO {
+				err = errors.New("SCHED_ISO is not supported by this kernel")
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("sched %s: %w", s.Sched, err))
+			}
+		}
+	}
+	return saved, firstPerKind(errs)
+}
+
+// Restore puts the threads of pid back to saved. Threads created after the
+// boost get the values pid itself had.
+func Restore(pid int, saved Saved) error {
+	tids, err := threads(pid)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, tid := range tids {
+		t, ok := saved[tid]
+		if !ok {
+			if t, ok = saved[pid]; !ok {
+				continue
+			}
+		}
+		if err := setScheduler(tid, t.Policy, t.Priority); err != nil && !errors.Is(err, syscall.ESRCH) {
+			errs = append(errs, fmt.Errorf("sched: %w", err))
+		}
+		if err := setIOPrio(tid, t.IOPrio); err != nil && !errors.Is(err, syscall.ESRCH) {
+			errs = append(errs, fmt.Errorf("ioprio: %w", err))
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, t.Nice); err != nil && !errors.Is(err, syscall.ESRCH) {
+			errs = append(errs, fmt.Errorf("nice: %w", err))
+		}
+	}
+	return firstPerKind(errs)
+}
+
+// firstPerKind keeps one error per failing setting so a game with hundreds
+// of threads does not produce hundreds of identical messages.
+func firstPerKind(errs []error) error {
+	seen := map[string]bool{}
+	var out []error
+	for _, err := range errs {
+		kind, _, _ := strings.Cut(err.Error(), " ")
+		kind = strings.TrimSuffix(kind, ":")
+		if !seen[kind] {
+			seen[kind] = true
+			out = append(out, err)
+		}
+	}
+	return errors.Join(out...)
+}
+
+// threads lists the thread IDs of pid.
+func threads(pid int) ([]int, error) {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+	tids := make([]int, 0, len(entries))
+	for _, e := range entries {
+		if tid, err := strconv.Atoi(e.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
+
+// read returns the current scheduling state of tid.
+func read(tid int) (Thread, error) {
+	var t Thread
+	// The raw getpriority syscall returns 20-nice.
+	prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, tid)
+	if err != nil {
+		return t, err
+	}
+	t.Nice = 20 - prio
+	r, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+	if errno != 0 {
+		return t, errno
+	}
+	t.IOPrio = int(r)
+	r, _, errno = syscall.Syscall(syscall.SYS_SCHED_GETSCHEDULER, uintptr(tid), 0, 0)
+	if errno != 0 {
+		return t, errno
+	}
+	// Drop SCHED_RESET_ON_FORK.
+	t.Policy = int(r) &^ 0x40000000
+	var param int32
+	if _, _, errno = syscall.Syscall(syscall.SYS_SCHED_GETPARAM, uintptr(tid), uintptr(unsafe.Pointer(&param)), 0); errno != 0 {
+		return t, errno
+	}
+	t.Priority = int(param)
+	return t, nil
+}
+
+func setIOPrio(tid, value int) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(value)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func setScheduler(tid, policy, priority int) error {
+	param := int32(priority)
+	if _, _, errno := syscall.Syscall(syscall.SYS_SCHED_SETSCHEDULER, uintptr(tid), uintptr(policy), uintptr(unsafe.Pointer(&param))); errno != 0 {
+		return errno
+	}
+	return nil
+}
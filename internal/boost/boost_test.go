@@ -0,0 +1,96 @@
+package boost
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestParseIOPrio(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want IOPrio
+		ok   bool
+	}{
+		{"realtime:0", IOPrio{IOClassRealtime, 0}, true},
+		{"RT", IOPrio{IOClassRealtime, 4}, true},
+		{" best-effort:7 ", IOPrio{IOClassBestEffort, 7}, true},
+		{"be", IOPrio{IOClassBestEffort, 4}, true},
+		{"idle", IOPrio{Class: IOClassIdle}, true},
+		{"idle:3", IOPrio{}, false},
+		{"best-effort:8", IOPrio{}, false},
+		{"best-effort:x", IOPrio{}, false},
+		{"fast", IOPrio{}, false},
+	} {
+		got, err := ParseIOPrio(tc.in)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("ParseIOPrio(%q) = %+v, %v", tc.in, got, err)
+		}
+		if tc.ok {
+			if back, err := ParseIOPrio(got.String()); err != nil || back != got {
+				t.Errorf("ParseIOPrio(%q) does not round-trip: %+v, %v", got, back, err)
+			}
+		}
+	}
+}
+
+func TestParseSched(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Sched
+		ok   bool
+	}{
+		{"iso", Sched{Policy: SchedISO}, true},
+		{"rr", Sched{SchedRR, 1}, true},
+		{"RR:50", Sched{SchedRR, 50}, true},
+		{"rr:0", Sched{}, false},
+		{"rr:100", Sched{}, false},
+		{"iso:1", Sched{}, false},
+		{"fifo", Sched{}, false},
+	} {
+		got, err := ParseSched(tc.in)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("ParseSched(%q) = %+v, %v", tc.in, got, err)
+		}
+		if tc.ok {
+			if back, err := ParseSched(got.String()); err != nil || back != got {
+				t.Errorf("ParseSched(%q) does not round-trip: %+v, %v", got, back, err)
+			}
+		}
+	}
+}
+
+func TestApplyRestore(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("restoring a lower nice value needs root")
+	}
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer func() { _ = cmd.Process.Kill(); _ = cmd.Wait() }()
+	pid := cmd.Process.Pid
+
+	before, err := read(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nice := 7
+	saved, err := Apply(pid, Settings{Nice: &nice, IOPrio: IOPrio{IOClassBestEffort, 6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved[pid] != before {
+		t.Fatalf("saved %+v, want %+v", saved[pid], before)
+	}
+	got, _ := read(pid)
+	if got.Nice != 7 || got.IOPrio != (IOPrio{IOClassBestEffort, 6}).value() {
+		t.Fatalf("boosted to %+v", got)
+	}
+	if err := Restore(pid, saved); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := read(pid); got != before {
+		t.Fatalf("restored to %+v, want %+v", got, before)
+	}
+}
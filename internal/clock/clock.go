@@ -0,0 +1,223 @@
+// Package clock abstracts the time source of the daemon loop, so tick
+// scheduling, debouncing, grace periods and backoff can be driven by a Fake
+// in tests instead of waiting on the wall clock.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the subset of package time the daemon uses.
+type Clock interface {
+	Now() time.Time
+	// After is time.After.
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a *time.Timer with the channel behind a method.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a *time.Ticker with the channel behind a method.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// Fake is a Clock that only moves when told to. Timers, tickers and After
+// channels fire from Advance and Set, in deadline order, each with the fake
+// time it was due at. Like the time package's, their channels hold one
+// value and a ticker drops ticks nobody received.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// NewFake returns a Fake set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+type fakeWaiter struct {
+	c      chan time.Time
+	at     time.Time
+	period time.Duration // >0 for tickers
+	active bool
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return &fakeTimer{f: f, w: f.add(d, 0)}
+}
+
+// NewTicker panics on a non-positive d, like time.NewTicker.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return &fakeTicker{f: f, w: f.add(d, d)}
+}
+
+func (f *Fake) add(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWaiter{c: make(chan time.Time, 1), at: f.now.Add(d), period: period, active: true}
+	f.waiters = append(f.waiters, w)
+	f.fireLocked()
+	return w
+}
+
+// Advance moves the clock forward by d and fires what came due.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t, which must not be before the current time, and
+// fires what came due.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		next := f.nextLocked()
+		if next == nil || next.at.After(t) {
+			break
+		}
+		f.now = next.at
+		f.fireLocked()
+	}
+	if t.After(f.now) {
+		f.now = t
+	}
+}
+
+// Pending returns how many timers and tickers are waiting to fire.
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, w := range f.waiters {
+		if w.active {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *Fake) nextLocked() *fakeWaiter {
+	var next *fakeWaiter
+	for _, w := range f.waiters {
+		if w.active && (next == nil || w.at.Before(next.at)) {
+			next = w
+		}
+	}
+	return next
+}
+
+// fireLocked sends on every waiter due at f.now and drops the finished
+// ones.
+func (f *Fake) fireLocked() {
+	sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+	kept := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.active && !w.at.After(f.now) {
+			select {
+			case w.c <- w.at:
+			default:
+			}
+			if w.period > 0 {
+				for !w.at.After(f.now) {
+					w.at = w.at.Add(w.period)
+				}
+			} else {
+				w.active = false
+			}
+		}
+		if w.active {
+			kept = append(kept, w)
+		}
+	}
+	f.waiters = kept
+}
+
+func (f *Fake) stop(w *fakeWaiter) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	was := w.active
+	w.active = false
+	kept := f.waiters[:0]
+	for _, o := range f.waiters {
+		if o != w {
+			kept = append(kept, o)
+		}
+	}
+	f.waiters = kept
+	return was
+}
+
+func (f *Fake) reset(w *fakeWaiter, d time.Duration) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	was := w.active
+	w.at = f.now.Add(d)
+	if !w.active {
+		w.active = true
+		f.waiters = append(f.waiters, w)
+	}
+	f.fireLocked()
+	return was
+}
+
+type fakeTimer struct {
+	f *Fake
+	w *fakeWaiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time        { return t.w.c }
+func (t *fakeTimer) Stop() bool                 { return t.f.stop(t.w) }
+func (t *fakeTimer) Reset(d time.Duration) bool { return t.f.reset(t.w, d) }
+
+type fakeTicker struct {
+	f *Fake
+	w *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+func (t *fakeTicker) Stop()               { t.f.stop(t.w) }
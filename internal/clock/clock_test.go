@@ -0,0 +1,91 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func received(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	f := NewFake(epoch)
+	tm := f.NewTimer(2 * time.Second)
+	f.Advance(time.Second)
+	if _, ok := received(tm.C()); ok {
+		t.Fatalf("timer fired early")
+	}
+	f.Advance(5 * time.Second)
+	if at, ok := received(tm.C()); !ok || !at.Equal(epoch.Add(2*time.Second)) {
+		t.Fatalf("timer = %v, %v; want the due time", at, ok)
+	}
+	if !f.Now().Equal(epoch.Add(6 * time.Second)) {
+		t.Fatalf("Now = %v", f.Now())
+	}
+	if f.Pending() != 0 {
+		t.Fatalf("fired timer still pending")
+	}
+
+	if tm.Reset(time.Second) {
+		t.Fatalf("Reset of a fired timer reported it active")
+	}
+	if !tm.Stop() {
+		t.Fatalf("Stop of a pending timer reported it inactive")
+	}
+	f.Advance(time.Minute)
+	if _, ok := received(tm.C()); ok {
+		t.Fatalf("stopped timer fired")
+	}
+	tm.Reset(0)
+	if _, ok := received(tm.C()); !ok {
+		t.Fatalf("timer reset to 0 did not fire")
+	}
+}
+
+func TestFakeAfterOrder(t *testing.T) {
+	f := NewFake(epoch)
+	late := f.After(3 * time.Second)
+	early := f.After(time.Second)
+	var order []time.Duration
+	f.Advance(10 * time.Second)
+	for _, c := range []<-chan time.Time{early, late} {
+		at, ok := received(c)
+		if !ok {
+			t.Fatalf("After did not fire")
+		}
+		order = append(order, at.Sub(epoch))
+	}
+	if order[0] != time.Second || order[1] != 3*time.Second {
+		t.Fatalf("fire times = %v", order)
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(epoch)
+	tk := f.NewTicker(time.Second)
+	f.Advance(time.Second)
+	if at, ok := received(tk.C()); !ok || !at.Equal(epoch.Add(time.Second)) {
+		t.Fatalf("first tick = %v, %v", at, ok)
+	}
+	// Unreceived ticks are dropped, keeping the first one.
+	f.Advance(3 * time.Second)
+	if at, ok := received(tk.C()); !ok || !at.Equal(epoch.Add(2*time.Second)) {
+		t.Fatalf("tick after a gap = %v, %v", at, ok)
+	}
+	if _, ok := received(tk.C()); ok {
+		t.Fatalf("ticker buffered more than one tick")
+	}
+	tk.Stop()
+	f.Advance(time.Minute)
+	if _, ok := received(tk.C()); ok {
+		t.Fatalf("stopped ticker ticked")
+	}
+}
@@ -3,47 +3,290 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/Reidond/ccdbind/internal/boost"
+	"github.com/Reidond/ccdbind/internal/exematch"
+	"github.com/Reidond/ccdbind/internal/gameid"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/peercred"
+	"github.com/Reidond/ccdbind/internal/powerprofile"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/quirks"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// Values for restore_policy.
+const (
+	// RestoreKeepModified leaves a slice whose AllowedCPUs someone else
+	// changed while it was pinned at that value, and restores it to that
+	// value rather than to the one from before the pin.
+	RestoreKeepModified = "keep-modified"
+	// RestoreOriginal pins such a slice again and restores the value from
+	// before the pin.
+	RestoreOriginal = "original"
+)
+
+// Values for backend.
+const (
+	// BackendAuto uses systemd and falls back to cgroupfs when the user
+	// manager's bus is unreachable.
+	BackendAuto = "auto"
+	// BackendSystemd always goes through the user manager.
+	BackendSystemd = "systemd"
+	// BackendCgroupfs writes the cgroup files directly and creates the game
+	// scopes as plain cgroups.
+	BackendCgroupfs = "cgroupfs"
+)
+
+// Values for scan_backend.
+const (
+	// ScanProc lists /proc and reads the environment of every user process.
+	ScanProc = "proc"
+	// ScanCgroup lists the user's cgroups and reads the environment only
+	// where a launcher may have passed the env keys on.
+	ScanCgroup = "cgroup"
 )
 
 type Config struct {
-	Interval         time.Duration
-	EnvKeys          []string
-	ExeAllowlist     []string
-	IgnoreExe        []string
+	Interval       time.Duration
+	IntervalJitter int           // percent, 0 disables
+	WatchInterval  time.Duration // launcher fast-scan period, 0 disables
+	// ProcEvents enables exec/exit notifications from the kernel proc
+	// connector; polling then only reconciles every ReconcileInterval.
+	ProcEvents        bool
+	ReconcileInterval time.Duration
+	EnvKeys           []string
+	ExeAllowlist      []string
+	IgnoreExe         []string
+	// Detectors names the procscan detectors tried, in order, on processes
+	// the env keys and the allowlist miss.
+	Detectors        []string
 	IgnoreFile       string
+	AllowFile        string
 	PinSessionSlice  bool
 	PinSlices        []string
+	PinMemoryNodes   bool
 	OSCPUsOverride   string
 	GameCPUsOverride string
+	// PinAllSlices also pins every top-level slice the user manager has at
+	// pin time, except game.slice and those matching ExcludeSlices.
+	PinAllSlices  bool
+	ExcludeSlices []string
+	// Cluster selects the GAME cluster by ID; -1 means auto.
+	Cluster int
+	// Prefer picks the GAME cluster on asymmetric parts: "cache" or
+	// "frequency". Ignored when Cluster is set.
+	Prefer string
+	// SMT decides how SMT siblings are split between the OS and GAME sets,
+	// see the topology.SMT* values.
+	SMT string
+	// TopologySnapshot is a topology snapshot (`ccdbind topology dump`)
+	// used in place of sysfs; "" reads sysfs.
+	TopologySnapshot string
+	// RestorePolicy is RestoreKeepModified or RestoreOriginal.
+	RestorePolicy string
+	// RestartKeepalive keeps the pin applied this long after the last game
+	// exits, so a game restarting after an update or a crash does not cause
+	// a restore and a re-pin; 0 restores right away. restore_delay sets it
+	// too.
+	RestartKeepalive time.Duration
+	// PinDelay holds off the pin until games have run this long, so a
+	// launcher that starts and exits a helper does not cause a pin and a
+	// restore; 0 pins right away.
+	PinDelay time.Duration
+	// Backend is BackendAuto, BackendSystemd or BackendCgroupfs.
+	Backend string
+	// ScanBackend is ScanProc or ScanCgroup.
+	ScanBackend string
+	GPU         string
+	// CPUs32Bit places 32-bit game processes: "" or "game" keeps them with
+	// the game, "os" uses the OS CPUs, anything else is a CPU list.
+	CPUs32Bit string
+	// OverlayCPUs places the Steam overlay of each game: "" leaves it with
+	// the Steam client, "game" puts it with the game, "os" on the OS CPUs in
+	// a scope of its own, anything else is a CPU list.
+	OverlayCPUs string
+	// GuestCPUs pins VM and container cgroups while games run: "" leaves
+	// them alone, "os" uses the OS CPUs, anything else is a CPU list.
+	GuestCPUs     string
+	RecordHistory bool
+	Paranoid      bool
+	// DMALatency is the CPU wakeup latency limit in microseconds held through
+	// /dev/cpu_dma_latency while games are pinned; -1 disables.
+	DMALatency int
+	// GameGovernor and GameEPP are set on the game CPUs while games are
+	// pinned; empty leaves the current value.
+	GameGovernor string
+	GameEPP      string
+	// PowerProfile is the power-profiles-daemon profile switched to while
+	// games are pinned; empty leaves it alone.
+	PowerProfile string
+	// IRQBalance bans the game CPUs in irqbalance while games are pinned.
+	IRQBalance bool
+	// SMTOff switches SMT off while a game marked needs_smt_off is pinned.
+	SMTOff bool
+	// SpreadThreads keeps the busiest threads of each game on distinct
+	// physical cores within its CPUs. Experimental.
+	SpreadThreads bool
+	// GameMode treats games registered with gamemoded as games and leaves
+	// the governor and nice values to it where it is configured to set them.
+	GameMode bool
+	// Boost raises the priority of every game thread (game_nice,
+	// game_ioprio, game_sched); profiles override it per game.
+	Boost boost.Settings
+	// LogLevel filters daemon log output; --log-level overrides it.
+	LogLevel logging.Level
+	// Debug enables debug output for single subsystems below the debug
+	// level; CCDBIND_DEBUG overrides it.
+	Debug []logging.Domain
+	// MetricsListen is the host:port to serve Prometheus metrics on; empty
+	// disables the endpoint.
+	MetricsListen string
+	// StatusSocket is the unix socket serving read-only status to other
+	// local users ("@name" for the abstract namespace); empty disables it.
+	// StatusAllow lists who may connect besides the daemon's user.
+	StatusSocket string
+	StatusAllow  []string
+	// ConfirmGames holds back game processes whose exe is on neither list
+	// until the user answers a desktop notification; the answer is appended
+	// to AllowFile or IgnoreFile.
+	ConfirmGames bool
+	// Notifications shows a desktop notification when games are pinned, a
+	// pin fails and the slices are restored.
+	Notifications bool
+	// CrashRestore starts a helper unit while games are pinned that runs
+	// `ccdbind restore-all` should the daemon die without restoring.
+	CrashRestore bool
+
+	// QuirkOverrides holds per-title workarounds from [quirks."APPID"].
+	QuirkOverrides map[string]quirks.Override
+
+	// Profiles holds per-game settings from [game."APPID"] tables.
+	Profiles map[string]Profile
+	// GameAliases maps game IDs to the logical game listing them in
+	// [aliases]; profiles, quirks and scopes use the logical ID.
+	GameAliases gameid.Aliases
+}
+
+// Profile overrides placement for one game ID. Zero values mean "use the
+// global setting".
+type Profile struct {
+	GameCPUs  string // canonical CPU list for the game's scope
+	OSCPUs    string // canonical CPU list for the OS slices while it runs
+	Ignore    bool   // do not treat the title as a game at all
+	CPUWeight int    // CPUWeight= of the game scope, 1-10000
+	Nice      *int   // nice value applied to the game's threads
+	// IOPrio and Sched override game_ioprio and game_sched; NoBoost
+	// (boost = false) leaves the game's priorities alone altogether.
+	IOPrio  boost.IOPrio
+	Sched   boost.Sched
+	NoBoost bool
+	// LendCPUs is how many GAME CPUs the OS slices may borrow while the OS
+	// CPUs are saturated and the game leaves its CPUs idle; 0 never lends.
+	LendCPUs int
+}
+
+type tomlQuirk struct {
+	NoTouchGame *bool `toml:"no_touch_game"`
+	PrefersSwap *bool `toml:"prefers_swap"`
+	NeedsSMTOff *bool `toml:"needs_smt_off"`
+}
+
+type tomlProfile struct {
+	GameCPUs  string `toml:"game_cpus"`
+	OSCPUs    string `toml:"os_cpus"`
+	Ignore    *bool  `toml:"ignore"`
+	CPUWeight *int   `toml:"cpu_weight"`
+	Nice      *int   `toml:"nice"`
+	IOPrio    string `toml:"ioprio"`
+	Sched     string `toml:"sched"`
+	Boost     *bool  `toml:"boost"`
+	LendCPUs  *int   `toml:"lend_cpus"`
 }
 
 type tomlConfig struct {
-	Interval         string   `toml:"interval"`
-	EnvKeys          []string `toml:"env_keys"`
-	ExeAllowlist     []string `toml:"exe_allowlist"`
-	IgnoreExe        []string `toml:"ignore_exe"`
-	IgnoreFile       string   `toml:"ignore_file"`
-	PinSessionSlice  *bool    `toml:"pin_session_slice"`
-	PinSlices        []string `toml:"pin_slices"`
-	OSCPUsOverride   string   `toml:"os_cpus"`
-	GameCPUsOverride string   `toml:"game_cpus"`
+	Interval         string    `toml:"interval"`
+	IntervalJitter   *int      `toml:"interval_jitter"`
+	WatchInterval    string    `toml:"watch_interval"`
+	ProcEvents       *bool     `toml:"proc_events"`
+	Reconcile        string    `toml:"reconcile_interval"`
+	EnvKeys          []string  `toml:"env_keys"`
+	ExeAllowlist     []string  `toml:"exe_allowlist"`
+	IgnoreExe        []string  `toml:"ignore_exe"`
+	Detectors        *[]string `toml:"detectors"`
+	IgnoreFile       string    `toml:"ignore_file"`
+	AllowFile        string    `toml:"allow_file"`
+	ConfirmGames     *bool     `toml:"confirm_games"`
+	Notifications    *bool     `toml:"notifications"`
+	CrashRestore     *bool     `toml:"crash_restore"`
+	PinSessionSlice  *bool     `toml:"pin_session_slice"`
+	PinSlices        []string  `toml:"pin_slices"`
+	PinAllSlices     *bool     `toml:"pin_all_slices"`
+	ExcludeSlices    []string  `toml:"exclude_slices"`
+	PinMemoryNodes   *bool     `toml:"pin_memory_nodes"`
+	OSCPUsOverride   string    `toml:"os_cpus"`
+	GameCPUsOverride string    `toml:"game_cpus"`
+	Cluster          *int      `toml:"cluster"`
+	Prefer           string    `toml:"prefer"`
+	SMT              string    `toml:"smt"`
+	TopologySnapshot string    `toml:"topology_snapshot"`
+	RestorePolicy    string    `toml:"restore_policy"`
+	RestartKeepalive string    `toml:"restart_keepalive"`
+	RestoreDelay     string    `toml:"restore_delay"`
+	PinDelay         string    `toml:"pin_delay"`
+	Backend          string    `toml:"backend"`
+	ScanBackend      string    `toml:"scan_backend"`
+	GPU              string    `toml:"gpu"`
+	CPUs32Bit        string    `toml:"cpus_32bit"`
+	OverlayCPUs      string    `toml:"overlay_cpus"`
+	GuestCPUs        string    `toml:"guest_cpus"`
+	RecordHistory    *bool     `toml:"record_history"`
+	Paranoid         *bool     `toml:"paranoid"`
+	DMALatency       *int      `toml:"dma_latency"`
+	IRQBalance       *bool     `toml:"irqbalance"`
+	SMTOff           *bool     `toml:"smt_off"`
+	SpreadThreads    *bool     `toml:"spread_threads"`
+	GameMode         *bool     `toml:"gamemode"`
+	GameGovernor     string    `toml:"game_governor"`
+	GameEPP          string    `toml:"game_epp"`
+	PowerProfile     string    `toml:"power_profile"`
+	GameNice         *int      `toml:"game_nice"`
+	GameIOPrio       string    `toml:"game_ioprio"`
+	GameSched        string    `toml:"game_sched"`
+	MetricsListen    string    `toml:"metrics_listen"`
+	StatusSocket     string    `toml:"status_socket"`
+	StatusAllow      []string  `toml:"status_allow"`
+	LogLevel         string    `toml:"log_level"`
+	Debug            []string  `toml:"debug"`
+
+	Quirks map[string]tomlQuirk `toml:"quirks"`
+
+	Game    map[string]tomlProfile `toml:"game"`
+	Aliases map[string][]string    `toml:"aliases"`
 }
 
 func Default() Config {
 	return Config{
-		Interval: 2 * time.Second,
+		Interval:          2 * time.Second,
+		WatchInterval:     250 * time.Millisecond,
+		ProcEvents:        true,
+		GameMode:          true,
+		CrashRestore:      true,
+		ReconcileInterval: 30 * time.Second,
 		EnvKeys: []string{
 			"SteamAppId",
 			"SteamGameId",
 			"STEAM_COMPAT_APP_ID",
 		},
 		ExeAllowlist: nil,
+		Detectors:    []string{procscan.DetectLauncherEnv, procscan.DetectWine},
 		IgnoreExe: []string{
 			"steam",
 			"steamwebhelper",
@@ -73,6 +316,16 @@ func Default() Config {
 			"app.slice",
 			"background.slice",
 		},
+		Cluster:          -1,
+		Prefer:           topology.PreferCache,
+		SMT:              topology.SMTIgnore,
+		RestorePolicy:    RestoreKeepModified,
+		RestartKeepalive: 10 * time.Second,
+		Backend:          BackendAuto,
+		ScanBackend:      ScanProc,
+		DMALatency:       -1,
+		LogLevel:         logging.Info,
+		RecordHistory:    true,
 	}
 }
 
@@ -100,50 +353,365 @@ func DefaultIgnorePath() (string, error) {
 	return filepath.Join(base, "ccdbind", "ignore.txt"), nil
 }
 
+func DefaultAllowPath() (string, error) {
+	ignorePath, err := DefaultIgnorePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(ignorePath), "allow.txt"), nil
+}
+
 func Load(path string) (Config, error) {
-	cfg := Default()
+	var data []byte
 	if path != "" {
-		data, err := os.ReadFile(path)
+		b, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return Config{}, err
+		}
+		data = b
+	}
+	return Parse(data)
+}
+
+// Parse builds a Config from TOML data on top of the defaults, then merges
+// the ignore file. Empty data yields the defaults.
+func Parse(data []byte) (Config, error) {
+	cfg := Default()
+	var tc tomlConfig
+	if _, err := toml.Decode(string(data), &tc); err != nil {
+		return Config{}, err
+	}
+
+	if tc.Interval != "" {
+		d, err := time.ParseDuration(tc.Interval)
 		if err != nil {
-			if !errors.Is(err, os.ErrNotExist) {
-				return Config{}, err
-			}
-		} else {
-			var tc tomlConfig
-			if _, err := toml.Decode(string(data), &tc); err != nil {
-				return Config{}, err
+			return Config{}, fmt.Errorf("invalid interval %q: %w", tc.Interval, err)
+		}
+		cfg.Interval = d
+	}
+	if tc.IntervalJitter != nil {
+		if *tc.IntervalJitter < 0 || *tc.IntervalJitter > 50 {
+			return Config{}, fmt.Errorf("invalid interval_jitter %d (expected 0-50)", *tc.IntervalJitter)
+		}
+		cfg.IntervalJitter = *tc.IntervalJitter
+	}
+	if tc.WatchInterval != "" {
+		d, err := time.ParseDuration(tc.WatchInterval)
+		if err != nil || d < 0 || (d > 0 && d < 250*time.Millisecond) {
+			return Config{}, fmt.Errorf("invalid watch_interval %q (expected 0 or at least 250ms)", tc.WatchInterval)
+		}
+		cfg.WatchInterval = d
+	}
+	if tc.ProcEvents != nil {
+		cfg.ProcEvents = *tc.ProcEvents
+	}
+	if tc.Reconcile != "" {
+		d, err := time.ParseDuration(tc.Reconcile)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid reconcile_interval %q", tc.Reconcile)
+		}
+		cfg.ReconcileInterval = d
+	}
+	if len(tc.EnvKeys) > 0 {
+		cfg.EnvKeys = dedupeNonEmpty(tc.EnvKeys, nil)
+	}
+	if len(tc.ExeAllowlist) > 0 {
+		cfg.ExeAllowlist = dedupeNonEmpty(tc.ExeAllowlist, exematch.Normalize)
+		if _, err := exematch.Compile(cfg.ExeAllowlist); err != nil {
+			return Config{}, fmt.Errorf("exe_allowlist: %w", err)
+		}
+	}
+	if len(tc.IgnoreExe) > 0 {
+		cfg.IgnoreExe = dedupeNonEmpty(tc.IgnoreExe, exematch.Normalize)
+		if _, err := exematch.Compile(cfg.IgnoreExe); err != nil {
+			return Config{}, fmt.Errorf("ignore_exe: %w", err)
+		}
+	}
+	if tc.Detectors != nil {
+		cfg.Detectors = dedupeNonEmpty(*tc.Detectors, strings.ToLower)
+		for _, name := range cfg.Detectors {
+			if _, err := procscan.NewDetector(name); err != nil {
+				return Config{}, fmt.Errorf("invalid detectors entry %q (expected one of %s)", name, strings.Join(procscan.DetectorNames, ", "))
 			}
-
-			if tc.Interval != "" {
-				d, err := time.ParseDuration(tc.Interval)
-				if err != nil {
-					return Config{}, fmt.Errorf("invalid interval %q: %w", tc.Interval, err)
-				}
-				cfg.Interval = d
+		}
+	}
+	if tc.IgnoreFile != "" {
+		cfg.IgnoreFile = strings.TrimSpace(tc.IgnoreFile)
+	}
+	if tc.AllowFile != "" {
+		cfg.AllowFile = strings.TrimSpace(tc.AllowFile)
+	}
+	if tc.ConfirmGames != nil {
+		cfg.ConfirmGames = *tc.ConfirmGames
+	}
+	if tc.Notifications != nil {
+		cfg.Notifications = *tc.Notifications
+	}
+	if tc.CrashRestore != nil {
+		cfg.CrashRestore = *tc.CrashRestore
+	}
+	if tc.PinSessionSlice != nil {
+		cfg.PinSessionSlice = *tc.PinSessionSlice
+	}
+	if len(tc.PinSlices) > 0 {
+		cfg.PinSlices = dedupeNonEmpty(tc.PinSlices, nil)
+	}
+	if tc.PinAllSlices != nil {
+		cfg.PinAllSlices = *tc.PinAllSlices
+	}
+	cfg.ExcludeSlices = dedupeNonEmpty(tc.ExcludeSlices, nil)
+	for _, s := range cfg.ExcludeSlices {
+		if _, err := filepath.Match(s, ""); err != nil || strings.Contains(s, "/") {
+			return Config{}, fmt.Errorf("invalid exclude_slices entry %q (expected a slice name or glob)", s)
+		}
+	}
+	if tc.PinMemoryNodes != nil {
+		cfg.PinMemoryNodes = *tc.PinMemoryNodes
+	}
+	if tc.OSCPUsOverride != "" {
+		cfg.OSCPUsOverride = strings.TrimSpace(tc.OSCPUsOverride)
+	}
+	if tc.GameCPUsOverride != "" {
+		cfg.GameCPUsOverride = strings.TrimSpace(tc.GameCPUsOverride)
+	}
+	if tc.Cluster != nil {
+		if *tc.Cluster < 0 {
+			return Config{}, fmt.Errorf("invalid cluster %d", *tc.Cluster)
+		}
+		cfg.Cluster = *tc.Cluster
+	}
+	if v := strings.ToLower(strings.TrimSpace(tc.RestorePolicy)); v != "" {
+		if v != RestoreKeepModified && v != RestoreOriginal {
+			return Config{}, fmt.Errorf("invalid restore_policy %q (expected keep-modified or original)", tc.RestorePolicy)
+		}
+		cfg.RestorePolicy = v
+	}
+	if tc.RestartKeepalive != "" && tc.RestoreDelay != "" {
+		return Config{}, errors.New("restore_delay and restart_keepalive are the same setting; set one")
+	}
+	for key, v := range map[string]string{"restart_keepalive": tc.RestartKeepalive, "restore_delay": tc.RestoreDelay} {
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > 10*time.Minute {
+			return Config{}, fmt.Errorf("invalid %s %q (expected a duration up to 10m)", key, v)
+		}
+		cfg.RestartKeepalive = d
+	}
+	if tc.PinDelay != "" {
+		d, err := time.ParseDuration(tc.PinDelay)
+		if err != nil || d < 0 || d > time.Minute {
+			return Config{}, fmt.Errorf("invalid pin_delay %q (expected a duration up to 1m)", tc.PinDelay)
+		}
+		cfg.PinDelay = d
+	}
+	if v := strings.ToLower(strings.TrimSpace(tc.Backend)); v != "" {
+		if v != BackendAuto && v != BackendSystemd && v != BackendCgroupfs {
+			return Config{}, fmt.Errorf("invalid backend %q (expected auto, systemd or cgroupfs)", tc.Backend)
+		}
+		cfg.Backend = v
+	}
+	if v := strings.ToLower(strings.TrimSpace(tc.ScanBackend)); v != "" {
+		if v != ScanProc && v != ScanCgroup {
+			return Config{}, fmt.Errorf("invalid scan_backend %q (expected proc or cgroup)", tc.ScanBackend)
+		}
+		cfg.ScanBackend = v
+	}
+	if v := strings.ToLower(strings.TrimSpace(tc.Prefer)); v != "" {
+		if !topology.ValidPrefer(v) {
+			return Config{}, fmt.Errorf("invalid prefer %q (expected cache or frequency)", tc.Prefer)
+		}
+		cfg.Prefer = v
+	}
+	if v := strings.ToLower(strings.TrimSpace(tc.SMT)); v != "" {
+		if !topology.ValidSMT(v) {
+			return Config{}, fmt.Errorf("invalid smt %q (expected pair, game-physical-only or ignore)", tc.SMT)
+		}
+		cfg.SMT = v
+	}
+	cfg.TopologySnapshot = expandTilde(strings.TrimSpace(tc.TopologySnapshot))
+	if tc.GPU != "" {
+		cfg.GPU = strings.TrimSpace(tc.GPU)
+	}
+	if v := strings.ToLower(strings.TrimSpace(tc.CPUs32Bit)); v != "" {
+		if v != "game" && v != "os" {
+			canonical, cpus, err := topology.CanonicalizeCPUList(v)
+			if err != nil || len(cpus) == 0 {
+				return Config{}, fmt.Errorf("invalid cpus_32bit %q (expected game, os or a CPU list)", tc.CPUs32Bit)
 			}
-			if len(tc.EnvKeys) > 0 {
-				cfg.EnvKeys = dedupeNonEmpty(tc.EnvKeys, nil)
+			v = canonical
+		}
+		cfg.CPUs32Bit = v
+	}
+	if v := strings.ToLower(strings.TrimSpace(tc.OverlayCPUs)); v != "" {
+		if v != "game" && v != "os" {
+			canonical, cpus, err := topology.CanonicalizeCPUList(v)
+			if err != nil || len(cpus) == 0 {
+				return Config{}, fmt.Errorf("invalid overlay_cpus %q (expected game, os or a CPU list)", tc.OverlayCPUs)
 			}
-			if len(tc.ExeAllowlist) > 0 {
-				cfg.ExeAllowlist = dedupeNonEmpty(tc.ExeAllowlist, strings.ToLower)
+			v = canonical
+		}
+		cfg.OverlayCPUs = v
+	}
+	if v := strings.ToLower(strings.TrimSpace(tc.GuestCPUs)); v != "" {
+		if v != "os" {
+			canonical, cpus, err := topology.CanonicalizeCPUList(v)
+			if err != nil || len(cpus) == 0 {
+				return Config{}, fmt.Errorf("invalid guest_cpus %q (expected os or a CPU list)", tc.GuestCPUs)
 			}
-			if len(tc.IgnoreExe) > 0 {
-				cfg.IgnoreExe = dedupeNonEmpty(tc.IgnoreExe, strings.ToLower)
+			v = canonical
+		}
+		cfg.GuestCPUs = v
+	}
+	if tc.RecordHistory != nil {
+		cfg.RecordHistory = *tc.RecordHistory
+	}
+	if tc.Paranoid != nil {
+		cfg.Paranoid = *tc.Paranoid
+	}
+	if tc.DMALatency != nil {
+		if *tc.DMALatency < 0 {
+			return Config{}, fmt.Errorf("invalid dma_latency %d (expected microseconds >= 0)", *tc.DMALatency)
+		}
+		cfg.DMALatency = *tc.DMALatency
+	}
+	for _, v := range []struct {
+		key, value string
+		dst        *string
+	}{
+		{"game_governor", tc.GameGovernor, &cfg.GameGovernor},
+		{"game_epp", tc.GameEPP, &cfg.GameEPP},
+	} {
+		s := strings.TrimSpace(v.value)
+		if s == "" {
+			continue
+		}
+		if !validSysfsValue(s) {
+			return Config{}, fmt.Errorf("invalid %s %q", v.key, v.value)
+		}
+		*v.dst = s
+	}
+	if v := strings.ToLower(strings.TrimSpace(tc.PowerProfile)); v != "" {
+		if !powerprofile.Valid(v) {
+			return Config{}, fmt.Errorf("invalid power_profile %q (expected performance, balanced or power-saver)", tc.PowerProfile)
+		}
+		cfg.PowerProfile = v
+	}
+	if tc.GameNice != nil {
+		if *tc.GameNice < -20 || *tc.GameNice > 19 {
+			return Config{}, fmt.Errorf("invalid game_nice %d (expected -20 to 19)", *tc.GameNice)
+		}
+		n := *tc.GameNice
+		cfg.Boost.Nice = &n
+	}
+	if strings.TrimSpace(tc.GameIOPrio) != "" {
+		p, err := boost.ParseIOPrio(tc.GameIOPrio)
+		if err != nil {
+			return Config{}, fmt.Errorf("game_ioprio: %w", err)
+		}
+		cfg.Boost.IOPrio = p
+	}
+	if strings.TrimSpace(tc.GameSched) != "" {
+		p, err := boost.ParseSched(tc.GameSched)
+		if err != nil {
+			return Config{}, fmt.Errorf("game_sched: %w", err)
+		}
+		cfg.Boost.Sched = p
+	}
+	if tc.IRQBalance != nil {
+		cfg.IRQBalance = *tc.IRQBalance
+	}
+	if tc.SMTOff != nil {
+		cfg.SMTOff = *tc.SMTOff
+	}
+	if tc.SpreadThreads != nil {
+		cfg.SpreadThreads = *tc.SpreadThreads
+	}
+	if tc.GameMode != nil {
+		cfg.GameMode = *tc.GameMode
+	}
+	if tc.LogLevel != "" {
+		l, err := logging.ParseLevel(tc.LogLevel)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.LogLevel = l
+	}
+	if len(tc.Debug) > 0 {
+		ds, err := logging.ParseDomains(strings.Join(tc.Debug, ","))
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Debug = ds
+	}
+	if v := strings.TrimSpace(tc.MetricsListen); v != "" {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			return Config{}, fmt.Errorf("invalid metrics_listen %q (expected host:port)", tc.MetricsListen)
+		}
+		cfg.MetricsListen = v
+	}
+	if v := strings.TrimSpace(tc.StatusSocket); v != "" {
+		if v == "@" || (!strings.HasPrefix(v, "@") && !filepath.IsAbs(v)) {
+			return Config{}, fmt.Errorf("invalid status_socket %q (expected @name or an absolute path)", tc.StatusSocket)
+		}
+		cfg.StatusSocket = v
+	}
+	for _, e := range tc.StatusAllow {
+		if !peercred.ValidEntry(e) {
+			return Config{}, fmt.Errorf("invalid status_allow entry %q (expected *, user, uid, @group or @gid)", e)
+		}
+	}
+	cfg.StatusAllow = tc.StatusAllow
+	if len(tc.Quirks) > 0 {
+		cfg.QuirkOverrides = make(map[string]quirks.Override, len(tc.Quirks))
+		for id, q := range tc.Quirks {
+			id = gameid.Normalize(id)
+			if id == "" {
+				continue
 			}
-			if tc.IgnoreFile != "" {
-				cfg.IgnoreFile = strings.TrimSpace(tc.IgnoreFile)
+			cfg.QuirkOverrides[id] = quirks.Override{NoTouchGame: q.NoTouchGame, PrefersSwap: q.PrefersSwap, NeedsSMTOff: q.NeedsSMTOff}
+		}
+	}
+
+	if len(tc.Game) > 0 {
+		cfg.Profiles = make(map[string]Profile, len(tc.Game))
+		for id, tp := range tc.Game {
+			id = gameid.Normalize(id)
+			if id == "" {
+				continue
 			}
-			if tc.PinSessionSlice != nil {
-				cfg.PinSessionSlice = *tc.PinSessionSlice
+			p, err := parseProfile(tp)
+			if err != nil {
+				return Config{}, fmt.Errorf("game %q: %w", id, err)
 			}
-			if len(tc.PinSlices) > 0 {
-				cfg.PinSlices = dedupeNonEmpty(tc.PinSlices, nil)
+			cfg.Profiles[id] = p
+		}
+	}
+
+	if len(tc.Aliases) > 0 {
+		cfg.GameAliases = gameid.Aliases{}
+		for logical, ids := range tc.Aliases {
+			logical = gameid.Normalize(logical)
+			if logical == "" {
+				return Config{}, fmt.Errorf("invalid aliases: empty game ID")
 			}
-			if tc.OSCPUsOverride != "" {
-				cfg.OSCPUsOverride = strings.TrimSpace(tc.OSCPUsOverride)
+			for _, id := range ids {
+				id = gameid.Normalize(id)
+				if id == "" {
+					return Config{}, fmt.Errorf("invalid aliases %q: empty game ID", logical)
+				}
+				if prev, ok := cfg.GameAliases[id]; ok && prev != logical {
+					return Config{}, fmt.Errorf("invalid aliases: %q is listed for both %q and %q", id, prev, logical)
+				}
+				if id != logical {
+					cfg.GameAliases[id] = logical
+				}
 			}
-			if tc.GameCPUsOverride != "" {
-				cfg.GameCPUsOverride = strings.TrimSpace(tc.GameCPUsOverride)
+		}
+		for _, logical := range cfg.GameAliases {
+			if other, chained := cfg.GameAliases[logical]; chained {
+				return Config{}, fmt.Errorf("invalid aliases: %q is itself an alias of %q", logical, other)
 			}
 		}
 	}
@@ -158,7 +726,28 @@ func Load(path string) (Config, error) {
 	cfg.IgnoreFile = expandTilde(cfg.IgnoreFile)
 
 	if extra, err := loadIgnoreFile(cfg.IgnoreFile); err == nil {
-		cfg.IgnoreExe = dedupeNonEmpty(append(cfg.IgnoreExe, extra...), strings.ToLower)
+		cfg.IgnoreExe = dedupeNonEmpty(append(cfg.IgnoreExe, extra...), exematch.Normalize)
+		if _, err := exematch.Compile(cfg.IgnoreExe); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", cfg.IgnoreFile, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return Config{}, err
+	}
+
+	if strings.TrimSpace(cfg.AllowFile) == "" {
+		allowPath, err := DefaultAllowPath()
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.AllowFile = allowPath
+	}
+	cfg.AllowFile = expandTilde(cfg.AllowFile)
+
+	if extra, err := loadIgnoreFile(cfg.AllowFile); err == nil {
+		cfg.ExeAllowlist = dedupeNonEmpty(append(cfg.ExeAllowlist, extra...), exematch.Normalize)
+		if _, err := exematch.Compile(cfg.ExeAllowlist); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", cfg.AllowFile, err)
+		}
 	} else if !errors.Is(err, os.ErrNotExist) {
 		return Config{}, err
 	}
@@ -166,6 +755,64 @@ func Load(path string) (Config, error) {
 	return cfg, nil
 }
 
+func parseProfile(tp tomlProfile) (Profile, error) {
+	var p Profile
+	for _, f := range []struct {
+		key string
+		in  string
+		out *string
+	}{{"game_cpus", tp.GameCPUs, &p.GameCPUs}, {"os_cpus", tp.OSCPUs, &p.OSCPUs}} {
+		if strings.TrimSpace(f.in) == "" {
+			continue
+		}
+		canonical, cpus, err := topology.CanonicalizeCPUList(f.in)
+		if err != nil || len(cpus) == 0 {
+			return Profile{}, fmt.Errorf("invalid %s %q", f.key, f.in)
+		}
+		*f.out = canonical
+	}
+	if tp.Ignore != nil {
+		p.Ignore = *tp.Ignore
+	}
+	if tp.CPUWeight != nil {
+		if *tp.CPUWeight < 1 || *tp.CPUWeight > 10000 {
+			return Profile{}, fmt.Errorf("invalid cpu_weight %d (expected 1-10000)", *tp.CPUWeight)
+		}
+		p.CPUWeight = *tp.CPUWeight
+	}
+	if tp.Nice != nil {
+		if *tp.Nice < -20 || *tp.Nice > 19 {
+			return Profile{}, fmt.Errorf("invalid nice %d (expected -20 to 19)", *tp.Nice)
+		}
+		n := *tp.Nice
+		p.Nice = &n
+	}
+	if strings.TrimSpace(tp.IOPrio) != "" {
+		io, err := boost.ParseIOPrio(tp.IOPrio)
+		if err != nil {
+			return Profile{}, err
+		}
+		p.IOPrio = io
+	}
+	if strings.TrimSpace(tp.Sched) != "" {
+		sc, err := boost.ParseSched(tp.Sched)
+		if err != nil {
+			return Profile{}, err
+		}
+		p.Sched = sc
+	}
+	if tp.Boost != nil {
+		p.NoBoost = !*tp.Boost
+	}
+	if tp.LendCPUs != nil {
+		if *tp.LendCPUs < 0 || *tp.LendCPUs > 8 {
+			return Profile{}, fmt.Errorf("invalid lend_cpus %d (expected 0-8)", *tp.LendCPUs)
+		}
+		p.LendCPUs = *tp.LendCPUs
+	}
+	return p, nil
+}
+
 func dedupeNonEmpty(in []string, transform func(string) string) []string {
 	seen := make(map[string]struct{}, len(in))
 	out := make([]string, 0, len(in))
@@ -206,6 +853,31 @@ func loadIgnoreFile(path string) ([]string, error) {
 	return out, nil
 }
 
+// AppendListFile appends entry as a new line to an allow or ignore file,
+// creating the file and its directory if needed.
+func AppendListFile(path, entry string) error {
+	entry = strings.TrimSpace(entry)
+	if entry == "" || strings.ContainsAny(entry, "\n#") {
+		return fmt.Errorf("invalid list entry %q", entry)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Start on a fresh line if the file was edited without a trailing newline.
+	if b, err := os.ReadFile(path); err == nil && len(b) > 0 && b[len(b)-1] != '\n' {
+		entry = "\n" + entry
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(entry + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func expandTilde(path string) string {
 	path = strings.TrimSpace(path)
 	if path == "" || !strings.HasPrefix(path, "~") {
@@ -226,3 +898,14 @@ func expandTilde(path string) string {
 	}
 	return path
 }
+
+// validSysfsValue reports whether s looks like a cpufreq governor or EPP
+// name, e.g. "performance" or "balance_performance".
+func validSysfsValue(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -3,7 +3,13 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/boost"
+	"github.com/Reidond/ccdbind/internal/logging"
 )
 
 func TestLoad_MissingFileReturnsDefault(t *testing.T) {
@@ -14,6 +20,12 @@ func TestLoad_MissingFileReturnsDefault(t *testing.T) {
 	if cfg.Interval <= 0 {
 		t.Fatalf("expected default interval to be set")
 	}
+	if cfg.Cluster != -1 {
+		t.Fatalf("expected default cluster to be auto, got %d", cfg.Cluster)
+	}
+	if cfg.DMALatency != -1 {
+		t.Fatalf("expected dma_latency to be off by default, got %d", cfg.DMALatency)
+	}
 }
 
 func TestLoad_ParsesTOMLAndIgnoreFile(t *testing.T) {
@@ -26,18 +38,82 @@ func TestLoad_ParsesTOMLAndIgnoreFile(t *testing.T) {
 	}
 
 	ignorePath := filepath.Join(confDir, "ignore.txt")
-	if err := os.WriteFile(ignorePath, []byte("# comment\nsteam\ncustom-helper\n"), 0o644); err != nil {
+	if err := os.WriteFile(ignorePath, []byte("# comment\nsteam\ncustom-helper\n^Wine\\d+\n*.EXE\n"), 0o644); err != nil {
 		t.Fatalf("WriteFile(ignore): %v", err)
 	}
 
 	path := filepath.Join(dir, "config.toml")
 	if err := os.WriteFile(path, []byte(`interval = "5s"
+interval_jitter = 10
+watch_interval = "0s"
+proc_events = false
+reconcile_interval = "1m"
 env_keys = ["SteamAppId", "STEAM_COMPAT_APP_ID"]
 exe_allowlist = ["Foo", "bar"]
+detectors = ["wine", "Fullscreen"]
+confirm_games = true
+notifications = true
+crash_restore = false
 pin_session_slice = true
 pin_slices = ["app.slice"]
+pin_all_slices = true
+exclude_slices = ["nix-*.slice", " "]
+pin_memory_nodes = true
 os_cpus = "0-7"
 game_cpus = "8-15"
+cluster = 1
+gpu = "card1"
+cpus_32bit = "0-3, 5"
+overlay_cpus = "OS"
+guest_cpus = "os"
+prefer = "Frequency"
+smt = "game-physical-only"
+topology_snapshot = "/etc/ccdbind/topology.json"
+record_history = false
+paranoid = true
+dma_latency = 20
+irqbalance = true
+smt_off = true
+spread_threads = true
+gamemode = false
+game_governor = "performance"
+game_epp = "performance"
+power_profile = "Performance"
+game_nice = -10
+game_ioprio = "best-effort:0"
+game_sched = "rr:5"
+metrics_listen = "127.0.0.1:9477"
+status_socket = "@ccdbind-status"
+restore_policy = "original"
+restart_keepalive = "30s"
+pin_delay = "3s"
+backend = "cgroupfs"
+scan_backend = "Cgroup"
+status_allow = ["@wheel", "1001"]
+log_level = "debug"
+debug = ["scan", "pin"]
+
+[quirks."42"]
+no_touch_game = true
+
+[game."427520"]
+game_cpus = "0-15"
+nice = -5
+ioprio = "realtime:2"
+sched = "iso"
+
+[game."730"]
+game_cpus = "8-15"
+os_cpus = "0-7"
+cpu_weight = 500
+lend_cpus = 2
+boost = false
+
+[game."99"]
+ignore = true
+
+[aliases]
+"1245620" = ["2778580", "eldenring.exe"]
 `), 0o644); err != nil {
 		t.Fatalf("WriteFile(config): %v", err)
 	}
@@ -49,21 +125,186 @@ game_cpus = "8-15"
 	if got := cfg.Interval.String(); got != "5s" {
 		t.Fatalf("interval mismatch: %s", got)
 	}
+	if cfg.ProcEvents || cfg.ReconcileInterval != time.Minute {
+		t.Fatalf("proc events mismatch: %v %s", cfg.ProcEvents, cfg.ReconcileInterval)
+	}
+	if cfg.WatchInterval != 0 {
+		t.Fatalf("expected watch_interval to be disabled, got %s", cfg.WatchInterval)
+	}
+	if cfg.IntervalJitter != 10 {
+		t.Fatalf("interval_jitter mismatch: %d", cfg.IntervalJitter)
+	}
 	if !cfg.PinSessionSlice {
 		t.Fatalf("expected PinSessionSlice=true")
 	}
+	if !cfg.PinMemoryNodes {
+		t.Fatalf("expected PinMemoryNodes=true")
+	}
 	if len(cfg.PinSlices) != 1 || cfg.PinSlices[0] != "app.slice" {
 		t.Fatalf("unexpected PinSlices: %#v", cfg.PinSlices)
 	}
+	if !cfg.PinAllSlices || len(cfg.ExcludeSlices) != 1 || cfg.ExcludeSlices[0] != "nix-*.slice" {
+		t.Fatalf("unexpected pin_all_slices/exclude_slices: %v %#v", cfg.PinAllSlices, cfg.ExcludeSlices)
+	}
 	if cfg.OSCPUsOverride != "0-7" || cfg.GameCPUsOverride != "8-15" {
 		t.Fatalf("override mismatch: os=%q game=%q", cfg.OSCPUsOverride, cfg.GameCPUsOverride)
 	}
+	if cfg.RecordHistory || !cfg.Paranoid {
+		t.Fatalf("unexpected RecordHistory=%v Paranoid=%v", cfg.RecordHistory, cfg.Paranoid)
+	}
+	if cfg.DMALatency != 20 {
+		t.Fatalf("dma_latency mismatch: %d", cfg.DMALatency)
+	}
+	if !cfg.IRQBalance {
+		t.Fatalf("expected irqbalance to be enabled")
+	}
+	if !cfg.SMTOff {
+		t.Fatalf("expected smt_off to be enabled")
+	}
+	if !cfg.SpreadThreads {
+		t.Fatalf("expected spread_threads to be enabled")
+	}
+	if cfg.GameMode {
+		t.Fatalf("expected gamemode to be disabled")
+	}
+	if cfg.GameGovernor != "performance" || cfg.GameEPP != "performance" {
+		t.Fatalf("game_governor/game_epp mismatch: %q %q", cfg.GameGovernor, cfg.GameEPP)
+	}
+	if cfg.PowerProfile != "performance" {
+		t.Fatalf("power_profile mismatch: %q", cfg.PowerProfile)
+	}
+	if cfg.LogLevel != logging.Debug {
+		t.Fatalf("log_level mismatch: %v", cfg.LogLevel)
+	}
+	if len(cfg.Debug) != 2 || cfg.Debug[0] != logging.Scan || cfg.Debug[1] != logging.Pin {
+		t.Fatalf("debug mismatch: %v", cfg.Debug)
+	}
+	if cfg.MetricsListen != "127.0.0.1:9477" {
+		t.Fatalf("metrics_listen mismatch: %q", cfg.MetricsListen)
+	}
+	if cfg.RestorePolicy != RestoreOriginal {
+		t.Fatalf("restore_policy mismatch: %q", cfg.RestorePolicy)
+	}
+	if cfg.RestartKeepalive != 30*time.Second {
+		t.Fatalf("restart_keepalive mismatch: %s", cfg.RestartKeepalive)
+	}
+	if cfg.PinDelay != 3*time.Second {
+		t.Fatalf("pin_delay mismatch: %s", cfg.PinDelay)
+	}
+	if c, err := Parse([]byte(`restore_delay = "20s"`)); err != nil || c.RestartKeepalive != 20*time.Second {
+		t.Fatalf("restore_delay = %s, %v", c.RestartKeepalive, err)
+	}
+	if cfg.Backend != BackendCgroupfs {
+		t.Fatalf("backend mismatch: %q", cfg.Backend)
+	}
+	if cfg.ScanBackend != ScanCgroup {
+		t.Fatalf("scan_backend mismatch: %q", cfg.ScanBackend)
+	}
+	if cfg.StatusSocket != "@ccdbind-status" || len(cfg.StatusAllow) != 2 || cfg.StatusAllow[0] != "@wheel" {
+		t.Fatalf("status_socket/status_allow mismatch: %q %v", cfg.StatusSocket, cfg.StatusAllow)
+	}
+	if cfg.Cluster != 1 {
+		t.Fatalf("cluster mismatch: %d", cfg.Cluster)
+	}
+	if cfg.Prefer != "frequency" {
+		t.Fatalf("prefer mismatch: %q", cfg.Prefer)
+	}
+	if cfg.SMT != "game-physical-only" {
+		t.Fatalf("smt mismatch: %q", cfg.SMT)
+	}
+	if cfg.TopologySnapshot != "/etc/ccdbind/topology.json" {
+		t.Fatalf("topology_snapshot mismatch: %q", cfg.TopologySnapshot)
+	}
+	if cfg.CPUs32Bit != "0-3,5" {
+		t.Fatalf("cpus_32bit mismatch: %q", cfg.CPUs32Bit)
+	}
+	if cfg.OverlayCPUs != "os" {
+		t.Fatalf("overlay_cpus mismatch: %q", cfg.OverlayCPUs)
+	}
+	if cfg.GuestCPUs != "os" {
+		t.Fatalf("guest_cpus mismatch: %q", cfg.GuestCPUs)
+	}
+	if cfg.GPU != "card1" {
+		t.Fatalf("gpu mismatch: %q", cfg.GPU)
+	}
 	if !contains(cfg.IgnoreExe, "custom-helper") {
 		t.Fatalf("expected ignore list to include ignore.txt entries")
 	}
+	if !contains(cfg.IgnoreExe, `^Wine\d+`) || !contains(cfg.IgnoreExe, "*.exe") {
+		t.Fatalf("expected regular expressions to keep their case and globs to be lowercased: %v", cfg.IgnoreExe)
+	}
+	if q, ok := cfg.QuirkOverrides["42"]; !ok || q.NoTouchGame == nil || !*q.NoTouchGame || q.PrefersSwap != nil {
+		t.Fatalf("unexpected quirk override: %+v", cfg.QuirkOverrides)
+	}
+	if cfg.Boost.Nice == nil || *cfg.Boost.Nice != -10 || cfg.Boost.IOPrio != (boost.IOPrio{Class: boost.IOClassBestEffort}) || cfg.Boost.Sched != (boost.Sched{Policy: boost.SchedRR, Priority: 5}) {
+		t.Fatalf("boost mismatch: %+v", cfg.Boost)
+	}
+	if p := cfg.Profiles["427520"]; p.GameCPUs != "0-15" || p.Nice == nil || *p.Nice != -5 || p.IOPrio.String() != "realtime:2" || p.Sched.String() != "iso" || p.NoBoost {
+		t.Fatalf("unexpected profile 427520: %+v", p)
+	}
+	if p := cfg.Profiles["730"]; p.OSCPUs != "0-7" || p.CPUWeight != 500 || p.Nice != nil || p.LendCPUs != 2 || !p.NoBoost {
+		t.Fatalf("unexpected profile 730: %+v", p)
+	}
+	if !cfg.Profiles["99"].Ignore {
+		t.Fatalf("expected profile 99 to be ignored")
+	}
+	if cfg.GameAliases.Resolve("2778580") != "1245620" || cfg.GameAliases.Resolve("eldenring.exe") != "1245620" {
+		t.Fatalf("unexpected aliases: %v", cfg.GameAliases)
+	}
 	if !contains(cfg.ExeAllowlist, "foo") {
 		t.Fatalf("expected allowlist to be normalized to lower-case")
 	}
+	if !reflect.DeepEqual(cfg.Detectors, []string{"wine", "fullscreen"}) {
+		t.Fatalf("detectors mismatch: %v", cfg.Detectors)
+	}
+	if !cfg.Notifications {
+		t.Fatalf("expected notifications to be enabled")
+	}
+	if cfg.CrashRestore {
+		t.Fatalf("expected crash_restore to be disabled")
+	}
+	if !cfg.ConfirmGames || cfg.AllowFile != filepath.Join(confDir, "allow.txt") {
+		t.Fatalf("unexpected ConfirmGames=%v AllowFile=%q", cfg.ConfirmGames, cfg.AllowFile)
+	}
+}
+
+func TestAppendListFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	path := filepath.Join(dir, "ccdbind", "allow.txt")
+
+	if err := AppendListFile(path, "Game.exe"); err != nil {
+		t.Fatalf("AppendListFile: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	f.WriteString("# hand edit without newline")
+	f.Close()
+	if err := AppendListFile(path, "other"); err != nil {
+		t.Fatalf("AppendListFile: %v", err)
+	}
+	if err := AppendListFile(path, "bad\nentry"); err == nil {
+		t.Fatalf("expected error for multi-line entry")
+	}
+
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !contains(cfg.ExeAllowlist, "game.exe") || !contains(cfg.ExeAllowlist, "other") {
+		t.Fatalf("expected allow.txt entries in allowlist: %#v", cfg.ExeAllowlist)
+	}
+}
+
+func TestParse_RejectsInvalidValues(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	for _, data := range []string{`interval = "soon"`, `cluster = -2`, `interval_jitter = 80`, `pin_slices = "app.slice"`, `exclude_slices = ["user.slice/x.slice"]`, `exclude_slices = ["[x"]`, `cpus_32bit = "fast"`, `overlay_cpus = "steam"`, `guest_cpus = "vm"`, `reconcile_interval = "0s"`, `prefer = "big"`, `smt = "off"`, `restore_policy = "newest"`, `detectors = ["x11"]`, `exe_allowlist = ["[a-"]`, `ignore_exe = ["^(wine"]`, `restart_keepalive = "-1s"`, `restart_keepalive = "1h"`, "restart_keepalive = \"1s\"\nrestore_delay = \"1s\"", `pin_delay = "2m"`, `backend = "cgroup"`, `scan_backend = "cgroupfs"`, `dma_latency = -1`, `irqbalance = "yes"`, `smt_off = 1`, `spread_threads = "on"`, `gamemode = "auto"`, `game_governor = "../x"`, `game_epp = "Fast Mode"`, `power_profile = "turbo"`, `metrics_listen = "9477"`, `status_socket = "status.sock"`, `status_allow = ["a b"]`, `log_level = "loud"`, `debug = ["gpu"]`, `game_nice = -21`, `game_ioprio = "fast"`, `game_sched = "fifo"`, "[game.\"1\"]\nnice = 30", "[game.\"1\"]\nioprio = \"idle:1\"", "[game.\"1\"]\nsched = \"rr:0\"", "[game.\"1\"]\nlend_cpus = 9", "[game.\"1\"]\ngame_cpus = \"x\"", "[aliases]\na = [\"1\"]\nb = [\"1\"]", "[aliases]\na = [\"b\"]\nb = [\"c\"]", "[aliases]\na = [\"0\"]"} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Fatalf("expected error for %q", data)
+		}
+	}
 }
 
 func TestLoad_IgnoreFileWithoutConfig(t *testing.T) {
@@ -86,6 +327,14 @@ func TestLoad_IgnoreFileWithoutConfig(t *testing.T) {
 	if !contains(cfg.IgnoreExe, "custom-helper") {
 		t.Fatalf("expected ignore list to include ignore.txt entries")
 	}
+
+	if err := os.WriteFile(ignorePath, []byte("^(wine\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(ignore): %v", err)
+	}
+	_, err = Load(filepath.Join(dir, "missing-config.toml"))
+	if err == nil || !strings.Contains(err.Error(), ignorePath) || !strings.Contains(err.Error(), `"^(wine"`) {
+		t.Fatalf("expected an error naming ignore.txt and the pattern, got %v", err)
+	}
 }
 
 func contains(list []string, item string) bool {
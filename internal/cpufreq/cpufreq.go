@@ -0,0 +1,140 @@
+// Package cpufreq reads and sets the cpufreq governor and energy performance
+// preference (EPP, e.g. under amd-pstate or intel_pstate active mode) of
+// single CPUs.
+package cpufreq
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// Root is the sysfs CPU directory.
+var Root = "/sys/devices/system/cpu"
+
+const (
+	governorFile = "scaling_governor"
+	eppFile      = "energy_performance_preference"
+)
+
+// Setting is a CPU's governor and EPP. An empty field is absent or, when
+// writing, left alone.
+type Setting struct {
+	Governor string `json:"governor,omitempty"`
+	EPP      string `json:"epp,omitempty"`
+}
+
+func path(cpu int, file string) string {
+	return filepath.Join(Root, "cpu"+strconv.Itoa(cpu), "cpufreq", file)
+}
+
+// Read returns the current setting of cpu. EPP is empty on drivers without
+// it.
+func Read(cpu int) (Setting, error) {
+	gov, err := os.ReadFile(path(cpu, governorFile))
+	if err != nil {
+		return Setting{}, err
+	}
+	s := Setting{Governor: strings.TrimSpace(string(gov))}
+	if epp, err := os.ReadFile(path(cpu, eppFile)); err == nil {
+		s.EPP = strings.TrimSpace(string(epp))
+	}
+	return s, nil
+}
+
+// Write sets the non-empty fields of s on cpu, governor first: amd-pstate
+// rejects EPP changes under the performance governor, and switching the
+// governor may reset EPP.
+func Write(cpu int, s Setting) error {
+	if s.Governor != "" {
+		if err := write(cpu, governorFile, s.Governor); err != nil {
+			return err
+		}
+	}
+	if s.EPP != "" {
+		// The performance governor of amd-pstate sets EPP itself and then
+		// refuses writes to it.
+		if cur, err := Read(cpu); err == nil && cur.EPP == s.EPP {
+			return nil
+		}
+		if err := write(cpu, eppFile, s.EPP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write never creates the file: a missing attribute is an error.
+func write(cpu int, file, value string) error {
+	f, err := os.OpenFile(path(cpu, file), os.O_WRONLY|os.O_TRUNC, 0)
+	if err == nil {
+		_, err = f.WriteString(value)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cpu%d %s=%s: %w", cpu, file, value, err)
+	}
+	return nil
+}
+
+// Online returns the online CPUs. An offline CPU's policy is inactive and
+// its files cannot be read or written until the CPU comes back, e.g. while
+// SMT is switched off.
+func Online() ([]int, error) {
+	data, err := os.ReadFile(filepath.Join(Root, "online"))
+	if err != nil {
+		return nil, err
+	}
+	return topology.ParseCPUList(strings.TrimSpace(string(data)))
+}
+
+// Merge returns target with empty fields taken from cur, i.e. the setting
+// cpu ends up with after Write(cpu, target).
+func Merge(cur, target Setting) Setting {
+	if target.Governor == "" {
+		target.Governor = cur.Governor
+	}
+	if target.EPP == "" {
+		target.EPP = cur.EPP
+	}
+	return target
+}
+
+// Check reports why Write(cpu, s) would fail for this process, or nil. The
+// files are root-only by default; a udev rule or tmpfiles.d entry can grant
+// a group write access.
+func Check(cpu int, s Setting) error {
+	for _, f := range []struct{ file, value, avail string }{
+		{governorFile, s.Governor, "scaling_available_governors"},
+		{eppFile, s.EPP, "energy_performance_available_preferences"},
+	} {
+		if f.value == "" {
+			continue
+		}
+		p := path(cpu, f.file)
+		err := syscall.Access(p, 2) // W_OK
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return fmt.Errorf("%s does not exist (cpufreq driver without it?)", p)
+		case errors.Is(err, os.ErrPermission):
+			return fmt.Errorf("no write access to %s", p)
+		case err != nil:
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if data, err := os.ReadFile(path(cpu, f.avail)); err == nil {
+			if avail := strings.Fields(string(data)); !slices.Contains(avail, f.value) {
+				return fmt.Errorf("cpu%d does not offer %s %q (available: %s)", cpu, f.file, f.value, strings.Join(avail, " "))
+			}
+		}
+	}
+	return nil
+}
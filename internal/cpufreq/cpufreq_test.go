@@ -0,0 +1,83 @@
+package cpufreq
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func fakeCPU(t *testing.T, cpu string, files map[string]string) {
+	t.Helper()
+	dir := filepath.Join(Root, cpu, "cpufreq")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, val := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(val+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	Root = t.TempDir()
+	fakeCPU(t, "cpu0", map[string]string{
+		"scaling_governor":              "powersave",
+		"energy_performance_preference": "balance_performance",
+	})
+	fakeCPU(t, "cpu1", map[string]string{"scaling_governor": "schedutil"})
+
+	s, err := Read(0)
+	if err != nil || s != (Setting{Governor: "powersave", EPP: "balance_performance"}) {
+		t.Fatalf("Read(0) = %#v, %v", s, err)
+	}
+	if s, err := Read(1); err != nil || s != (Setting{Governor: "schedutil"}) {
+		t.Fatalf("Read(1) = %#v, %v", s, err)
+	}
+	if _, err := Read(2); err == nil {
+		t.Fatalf("expected error for missing cpu")
+	}
+
+	if err := Write(0, Setting{EPP: "performance"}); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := Read(0); s != (Setting{Governor: "powersave", EPP: "performance"}) {
+		t.Fatalf("after Write = %#v", s)
+	}
+	if err := Write(1, Setting{Governor: "performance", EPP: "performance"}); err == nil {
+		t.Fatalf("expected error writing EPP without the file")
+	}
+
+	if got := Merge(Setting{Governor: "powersave", EPP: "power"}, Setting{EPP: "performance"}); got != (Setting{Governor: "powersave", EPP: "performance"}) {
+		t.Fatalf("Merge = %#v", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	Root = t.TempDir()
+	fakeCPU(t, "cpu0", map[string]string{
+		"scaling_governor":            "powersave",
+		"scaling_available_governors": "performance powersave",
+	})
+	if err := Check(0, Setting{Governor: "performance"}); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if err := Check(0, Setting{Governor: "ondemand"}); err == nil || !strings.Contains(err.Error(), "available: performance powersave") {
+		t.Fatalf("expected unavailable governor error, got %v", err)
+	}
+	if err := Check(0, Setting{EPP: "performance"}); err == nil {
+		t.Fatalf("expected error for missing EPP file")
+	}
+}
+
+func TestOnline(t *testing.T) {
+	Root = t.TempDir()
+	if err := os.WriteFile(filepath.Join(Root, "online"), []byte("0-3,8\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cpus, err := Online()
+	if err != nil || len(cpus) != 5 || cpus[4] != 8 {
+		t.Fatalf("Online() = %v, %v", cpus, err)
+	}
+}
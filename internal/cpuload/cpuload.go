@@ -0,0 +1,78 @@
+// Package cpuload samples per-CPU utilization from /proc/stat.
+package cpuload
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type times struct {
+	busy  uint64
+	total uint64
+}
+
+// Snapshot holds cumulative per-CPU jiffies at one point in time.
+type Snapshot map[int]times
+
+func Sample() (Snapshot, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	return parse(data), nil
+}
+
+func parse(data []byte) Snapshot {
+	out := Snapshot{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		cpu, err := strconv.Atoi(strings.TrimPrefix(fields[0], "cpu"))
+		if err != nil {
+			continue
+		}
+		var t times
+		for i, f := range fields[1:] {
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				continue
+			}
+			// guest and guest_nice are already included in user and nice.
+			if i >= 8 {
+				break
+			}
+			t.total += v
+			// idle (3) and iowait (4) count as not busy.
+			if i != 3 && i != 4 {
+				t.busy += v
+			}
+		}
+		out[cpu] = t
+	}
+	return out
+}
+
+// Utilization returns the average busy fraction (0..1) of cpus between two
+// snapshots. CPUs missing from either snapshot are ignored.
+func Utilization(prev, cur Snapshot, cpus []int) float64 {
+	var busy, total uint64
+	for _, cpu := range cpus {
+		p, ok1 := prev[cpu]
+		c, ok2 := cur[cpu]
+		if !ok1 || !ok2 || c.total < p.total || c.busy < p.busy {
+			continue
+		}
+		busy += c.busy - p.busy
+		total += c.total - p.total
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(busy) / float64(total)
+}
@@ -0,0 +1,20 @@
+package cpuload
+
+import "testing"
+
+func TestParseAndUtilization(t *testing.T) {
+	prev := parse([]byte("cpu  10 0 10 80 0 0 0 0 0 0\ncpu0 10 0 10 80 0 0 0 0 0 0\ncpu1 0 0 0 100 0 0 0 0 0 0\n"))
+	cur := parse([]byte("cpu  100 0 20 180 0 0 0 0 0 0\ncpu0 100 0 10 90 0 0 0 0 0 0\ncpu1 0 0 10 190 0 0 0 0 0 0\n"))
+	if len(cur) != 2 {
+		t.Fatalf("unexpected snapshot: %+v", cur)
+	}
+	if got := Utilization(prev, cur, []int{0}); got < 0.89 || got > 0.91 {
+		t.Fatalf("cpu0 utilization: %v", got)
+	}
+	if got := Utilization(prev, cur, []int{0, 1}); got < 0.49 || got > 0.51 {
+		t.Fatalf("combined utilization: %v", got)
+	}
+	if got := Utilization(prev, cur, []int{7}); got != 0 {
+		t.Fatalf("missing cpu utilization: %v", got)
+	}
+}
@@ -0,0 +1,116 @@
+// Package exematch matches executable names against the entries of
+// exe_allowlist, ignore_exe and their files. An entry is an exact name, a
+// shell glob when it contains *, ? or [, or a regular expression when it
+// starts with ^. Names are lower-case; globs and exact names are lowercased
+// and regular expressions match case-insensitively.
+package exematch
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// List is a compiled set of entries.
+type List struct {
+	exact map[string]struct{}
+	globs []string
+	res   []*regexp.Regexp
+}
+
+// IsRegexp reports whether entry is a regular expression.
+func IsRegexp(entry string) bool {
+	return strings.HasPrefix(entry, "^")
+}
+
+// IsGlob reports whether entry is a shell glob.
+func IsGlob(entry string) bool {
+	return !IsRegexp(entry) && strings.ContainsAny(entry, "*?[")
+}
+
+// Normalize trims entry and lowercases it unless it is a regular
+// expression, whose escapes lowercasing would change.
+func Normalize(entry string) string {
+	entry = strings.TrimSpace(entry)
+	if IsRegexp(entry) {
+		return entry
+	}
+	return strings.ToLower(entry)
+}
+
+// Compile compiles entries, which should be normalized. The error names the
+// first invalid pattern.
+func Compile(entries []string) (*List, error) {
+	l := &List{exact: make(map[string]struct{}, len(entries))}
+	for _, e := range entries {
+		switch {
+		case e == "":
+		case IsRegexp(e):
+			re, err := regexp.Compile("(?i)" + e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %v", e, strings.TrimPrefix(err.Error(), "error parsing regexp: "))
+			}
+			l.res = append(l.res, re)
+		case IsGlob(e):
+			if _, err := path.Match(e, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %v", e, err)
+			}
+			l.globs = append(l.globs, e)
+		default:
+			l.exact[e] = struct{}{}
+		}
+	}
+	return l, nil
+}
+
+// CompileValid is Compile for entries validated earlier; invalid ones are
+// dropped.
+func CompileValid(entries []string) *List {
+	valid := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if _, err := Compile([]string{e}); err == nil {
+			valid = append(valid, e)
+		}
+	}
+	l, _ := Compile(valid)
+	return l
+}
+
+// Exact reports whether the list names exe literally.
+func (l *List) Exact(exe string) bool {
+	if l == nil {
+		return false
+	}
+	_, ok := l.exact[exe]
+	return ok
+}
+
+// Match reports whether exe matches any entry.
+func (l *List) Match(exe string) bool {
+	if l == nil {
+		return false
+	}
+	if l.Exact(exe) {
+		return true
+	}
+	return l.MatchPattern(exe)
+}
+
+// MatchPattern reports whether exe matches a glob or regular expression.
+func (l *List) MatchPattern(exe string) bool {
+	if l == nil {
+		return false
+	}
+	for _, g := range l.globs {
+		if ok, _ := path.Match(g, exe); ok {
+			return true
+		}
+	}
+	for _, re := range l.res {
+		if re.MatchString(exe) {
+			return true
+		}
+	}
+	return false
+}
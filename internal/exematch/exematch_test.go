@@ -0,0 +1,45 @@
+package exematch
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileAndMatch(t *testing.T) {
+	entries := []string{Normalize(" Steam "), Normalize("*.EXE"), Normalize(`^wine(64)?-preloader$`), Normalize("^Game\\d+")}
+	l, err := Compile(entries)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	for exe, want := range map[string]bool{
+		"steam":            true,
+		"eldenring.exe":    true,
+		"wine64-preloader": true,
+		"wine-preloader":   true,
+		"wineserver":       false,
+		"game12":           true,
+		"steamwebhelper":   false,
+	} {
+		if got := l.Match(exe); got != want {
+			t.Fatalf("Match(%q) = %v, want %v", exe, got, want)
+		}
+	}
+	if !l.Exact("steam") || l.Exact("eldenring.exe") || l.MatchPattern("steam") {
+		t.Fatalf("exact and pattern matches mixed up")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for entry, want := range map[string]string{
+		"[a-":    `invalid glob "[a-"`,
+		"^(wine": `invalid regular expression "^(wine": missing closing )`,
+	} {
+		_, err := Compile([]string{"ok", entry})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("Compile(%q) = %v, want %q", entry, err, want)
+		}
+	}
+	if l := CompileValid([]string{"ok", "[a-"}); !l.Match("ok") {
+		t.Fatalf("CompileValid dropped a valid entry")
+	}
+}
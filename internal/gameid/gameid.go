@@ -0,0 +1,48 @@
+// Package gameid puts the game IDs found through different sources into one
+// form and maps user-defined aliases to the logical game they belong to, so
+// profiles, quirks and scopes do not split across variants of the same
+// title.
+package gameid
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Steam game IDs (SteamGameId) are 64 bits: the app ID in the low 24 bits,
+// the type in the next 8 and, for non-Steam shortcuts, the 32-bit shortcut
+// app ID in the high half. STEAM_COMPAT_APP_ID carries only the latter.
+const (
+	typeShift    = 24
+	typeShortcut = 2
+)
+
+// Normalize returns the canonical form of id: trimmed, "" for Steam's "no
+// app" ID 0, and the shortcut app ID for a 64-bit shortcut game ID.
+func Normalize(id string) string {
+	id = strings.TrimSpace(id)
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return id
+	}
+	switch {
+	case n == 0:
+		return ""
+	case n>>32 != 0 && (n>>typeShift)&0xff == typeShortcut:
+		return strconv.FormatUint(n>>32, 10)
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// Aliases maps normalized game IDs to the logical game they are an alias
+// of.
+type Aliases map[string]string
+
+// Resolve returns the logical game ID for id.
+func (a Aliases) Resolve(id string) string {
+	id = Normalize(id)
+	if logical, ok := a[id]; ok {
+		return logical
+	}
+	return id
+}
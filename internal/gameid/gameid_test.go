@@ -0,0 +1,32 @@
+package gameid
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{" 730 ", "730"},
+		{"0", ""},
+		{"00730", "730"},
+		{"eldenring.exe", "eldenring.exe"},
+		// Shortcut 3141592653: (3141592653 << 32) | 0x02000000.
+		{"13493037702022430720", "3141592653"},
+		// A mod of app 70 keeps its full ID.
+		{"4294967366", "4294967366"},
+	} {
+		if got := Normalize(tc.in); got != tc.want {
+			t.Fatalf("Normalize(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	a := Aliases{"2778580": "1245620", "eldenring.exe": "1245620"}
+	for in, want := range map[string]string{"2778580": "1245620", " eldenring.exe": "1245620", "730": "730"} {
+		if got := a.Resolve(in); got != want {
+			t.Fatalf("Resolve(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := Aliases(nil).Resolve("0730"); got != "730" {
+		t.Fatalf("nil Resolve = %q", got)
+	}
+}
@@ -0,0 +1,75 @@
+package gamemode
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config holds the gamemode.ini settings that overlap with ccdbind's.
+type Config struct {
+	// Governor is the governor gamemoded switches to while games are
+	// registered; empty when it leaves the governor alone.
+	Governor string
+	// Renice is the nice value gamemoded gives registered games, 0 for none.
+	Renice int
+}
+
+// configPaths lists gamemode.ini in the order gamemoded reads it; later
+// files override earlier ones.
+func configPaths() []string {
+	paths := []string{"/usr/share/gamemode/gamemode.ini", "/etc/gamemode.ini"}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".config")
+		}
+	}
+	if dir != "" {
+		paths = append(paths, filepath.Join(dir, "gamemode.ini"))
+	}
+	return paths
+}
+
+// ReadConfig reads gamemoded's configuration, falling back to its defaults.
+func ReadConfig() Config {
+	cfg := Config{Governor: "performance"}
+	for _, p := range configPaths() {
+		if data, err := os.ReadFile(p); err == nil {
+			parseINI(string(data), &cfg)
+		}
+	}
+	return cfg
+}
+
+// parseINI applies the [general] desiredgov and renice keys of an ini file
+// to cfg. gamemoded's renice value is negated: renice=10 means nice -10.
+func parseINI(data string, cfg *Config) {
+	section := ""
+	sc := bufio.NewScanner(strings.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok || section != "general" {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch strings.TrimSpace(key) {
+		case "desiredgov":
+			cfg.Governor = val
+		case "renice":
+			if n, err := strconv.Atoi(val); err == nil {
+				cfg.Renice = -n
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Package gamemode follows Feral's GameMode daemon (gamemoded) on the
+// session bus: which processes are registered with it and which of the
+// settings ccdbind also touches it is configured to change.
+package gamemode
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	busName = "com.feralinteractive.GameMode"
+	objPath = "/com/feralinteractive/GameMode"
+	iface   = "com.feralinteractive.GameMode"
+)
+
+// ErrNotInstalled is returned by New when gamemoded is neither running nor
+// D-Bus activatable.
+var ErrNotInstalled = errors.New("gamemoded is not installed")
+
+// Client tracks the games registered with gamemoded.
+type Client struct {
+	conn    *dbus.Conn
+	changed chan struct{}
+
+	mu   sync.Mutex
+	pids map[int]struct{}
+	cfg  Config
+}
+
+// New connects to the session bus and subscribes to gamemoded's game
+// registration signals. gamemoded does not need to be running yet; it is
+// usually activated by the first game.
+func New() (*Client, error) {
+	conn, err := dbus.SessionBusPrivate()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	bus := conn.BusObject()
+	var running bool
+	if err := bus.Call("org.freedesktop.DBus.NameHasOwner", 0, busName).Store(&running); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !running {
+		var names []string
+		if err := bus.Call("org.freedesktop.DBus.ListActivatableNames", 0).Store(&names); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if !slices.Contains(names, busName) {
+			conn.Close()
+			return nil, ErrNotInstalled
+		}
+	}
+	if err := conn.AddMatchSignal(dbus.WithMatchObjectPath(objPath), dbus.WithMatchInterface(iface)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.AddMatchSignal(dbus.WithMatchInterface("org.freedesktop.DBus"), dbus.WithMatchMember("NameOwnerChanged"), dbus.WithMatchArg(0, busName)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &Client{conn: conn, changed: make(chan struct{}, 1), pids: map[int]struct{}{}, cfg: ReadConfig()}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+	if running {
+		c.list()
+	}
+	go c.dispatch(signals)
+	return c, nil
+}
+
+// Changed receives a value after the set of registered games changes. It
+// is closed when the bus connection is lost.
+func (c *Client) Changed() <-chan struct{} {
+	return c.changed
+}
+
+// Games returns the registered PIDs in ascending order.
+func (c *Client) Games() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]int, 0, len(c.pids))
+	for pid := range c.pids {
+		out = append(out, pid)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// Registered reports whether pid is registered.
+func (c *Client) Registered(pid int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.pids[pid]
+	return ok
+}
+
+// Config returns gamemoded's configuration as of its last start.
+func (c *Client) Config() Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cfg
+}
+
+// list replaces the registered PIDs with gamemoded's own list. The call
+// does not auto-start gamemoded.
+func (c *Client) list() {
+	var games []struct {
+		PID  int32
+		Path dbus.ObjectPath
+	}
+	err := c.conn.Object(busName, objPath).Call(iface+".ListGames", dbus.FlagNoAutoStart).Store(&games)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pids = map[int]struct{}{}
+	if err != nil {
+		return
+	}
+	for _, g := range games {
+		c.pids[int(g.PID)] = struct{}{}
+	}
+}
+
+func (c *Client) dispatch(signals <-chan *dbus.Signal) {
+	defer close(c.changed)
+	for sig := range signals {
+		if !c.apply(sig) {
+			continue
+		}
+		select {
+		case c.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// apply updates the registered PIDs from sig and reports whether they
+// changed.
+func (c *Client) apply(sig *dbus.Signal) bool {
+	switch sig.Name {
+	case iface + ".GameRegistered", iface + ".GameUnregistered":
+		if len(sig.Body) < 1 {
+			return false
+		}
+		pid, ok := sig.Body[0].(int32)
+		if !ok {
+			return false
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if sig.Name == iface+".GameRegistered" {
+			c.pids[int(pid)] = struct{}{}
+		} else {
+			delete(c.pids, int(pid))
+		}
+		return true
+	case "org.freedesktop.DBus.NameOwnerChanged":
+		if len(sig.Body) < 3 {
+			return false
+		}
+		if owner, _ := sig.Body[2].(string); owner != "" {
+			// A new gamemoded reads its configuration afresh.
+			cfg := ReadConfig()
+			c.mu.Lock()
+			c.cfg = cfg
+			c.mu.Unlock()
+			c.list()
+			return true
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.pids = map[int]struct{}{}
+		return true
+	}
+	return false
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,45 @@
+package gamemode
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestParseINI(t *testing.T) {
+	cfg := Config{Governor: "performance"}
+	parseINI(`
+[general]
+; comment
+renice = 10
+desiredgov=schedutil
+
+[custom]
+desiredgov=powersave
+`, &cfg)
+	if cfg != (Config{Governor: "schedutil", Renice: -10}) {
+		t.Fatalf("parseINI = %#v", cfg)
+	}
+	parseINI("[general]\ndesiredgov=\n", &cfg)
+	if cfg.Governor != "" {
+		t.Fatalf("expected empty governor, got %q", cfg.Governor)
+	}
+}
+
+func TestApply(t *testing.T) {
+	c := &Client{pids: map[int]struct{}{}}
+	path := dbus.ObjectPath("/com/feralinteractive/GameMode/Games/42")
+	if !c.apply(&dbus.Signal{Name: iface + ".GameRegistered", Body: []interface{}{int32(42), path}}) {
+		t.Fatalf("expected registration to change the set")
+	}
+	if !c.Registered(42) || len(c.Games()) != 1 {
+		t.Fatalf("games = %v", c.Games())
+	}
+	if c.apply(&dbus.Signal{Name: iface + ".GameRegistered", Body: []interface{}{"x"}}) {
+		t.Fatalf("malformed signal should be ignored")
+	}
+	c.apply(&dbus.Signal{Name: "org.freedesktop.DBus.NameOwnerChanged", Body: []interface{}{busName, ":1.5", ""}})
+	if len(c.Games()) != 0 {
+		t.Fatalf("games after gamemoded exit = %v", c.Games())
+	}
+}
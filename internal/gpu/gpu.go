@@ -0,0 +1,175 @@
+package gpu
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+const (
+	VendorAMD    = "0x1002"
+	VendorNVIDIA = "0x10de"
+	VendorIntel  = "0x8086"
+)
+
+// integratedVRAMLimit is the VRAM size below which an amdgpu device is
+// considered an APU. APUs report their UMA carve-out as VRAM, which is small
+// compared to discrete cards.
+const integratedVRAMLimit = 2 << 30
+
+var cardNameRE = regexp.MustCompile(`^card[0-9]+$`)
+
+type GPU struct {
+	Name       string `json:"name"` // DRM node name, e.g. card1
+	Vendor     string `json:"vendor"`
+	Device     string `json:"device,omitempty"`
+	PCISlot    string `json:"pci_slot,omitempty"`
+	BootVGA    bool   `json:"boot_vga"`
+	VRAMBytes  uint64 `json:"vram_bytes,omitempty"`
+	NUMANode   int    `json:"numa_node"`
+	LocalCPUs  string `json:"local_cpus,omitempty"`
+	Integrated bool   `json:"integrated"`
+}
+
+// VendorName returns a short vendor name for known PCI vendor IDs.
+func (g GPU) VendorName() string {
+	switch g.Vendor {
+	case VendorAMD:
+		return "amd"
+	case VendorNVIDIA:
+		return "nvidia"
+	case VendorIntel:
+		return "intel"
+	default:
+		return g.Vendor
+	}
+}
+
+// List enumerates DRM cards from sysfs.
+func List() ([]GPU, error) {
+	return listAt("/sys/class/drm")
+}
+
+func listAt(root string) ([]GPU, error) {
+	ents, err := os.ReadDir(root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	out := make([]GPU, 0, 2)
+	for _, ent := range ents {
+		name := ent.Name()
+		if !cardNameRE.MatchString(name) {
+			continue
+		}
+		dev := filepath.Join(root, name, "device")
+		g := GPU{
+			Name:     name,
+			Vendor:   readTrim(filepath.Join(dev, "vendor")),
+			Device:   readTrim(filepath.Join(dev, "device")),
+			BootVGA:  readTrim(filepath.Join(dev, "boot_vga")) == "1",
+			NUMANode: -1,
+		}
+		if target, err := filepath.EvalSymlinks(dev); err == nil {
+			g.PCISlot = filepath.Base(target)
+		}
+		if v, err := strconv.ParseUint(readTrim(filepath.Join(dev, "mem_info_vram_total")), 10, 64); err == nil {
+			g.VRAMBytes = v
+		}
+		if v, err := strconv.Atoi(readTrim(filepath.Join(dev, "numa_node"))); err == nil {
+			g.NUMANode = v
+		}
+		if local := readTrim(filepath.Join(dev, "local_cpulist")); local != "" {
+			if canonical, _, err := topology.CanonicalizeCPUList(local); err == nil {
+				g.LocalCPUs = canonical
+			}
+		}
+		g.Integrated = isIntegrated(g)
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool { return cardIndex(out[i].Name) < cardIndex(out[j].Name) })
+	return out, nil
+}
+
+func isIntegrated(g GPU) bool {
+	switch g.Vendor {
+	case VendorIntel:
+		return true
+	case VendorAMD:
+		return g.VRAMBytes > 0 && g.VRAMBytes < integratedVRAMLimit
+	default:
+		return false
+	}
+}
+
+// Select picks a GPU according to filter:
+//
+//   - "" or "auto": the first discrete GPU, else the first GPU
+//   - a DRM node name ("card1")
+//   - a PCI slot ("0000:03:00.0")
+//   - a vendor name ("amd", "nvidia", "intel") or PCI vendor ID ("0x10de"),
+//     preferring discrete GPUs of that vendor
+func Select(gpus []GPU, filter string) (GPU, error) {
+	if len(gpus) == 0 {
+		return GPU{}, errors.New("no DRM GPUs found")
+	}
+	filter = strings.ToLower(strings.TrimSpace(filter))
+	if filter == "" || filter == "auto" {
+		return preferDiscrete(gpus), nil
+	}
+
+	for _, g := range gpus {
+		if g.Name == filter || g.PCISlot == filter {
+			return g, nil
+		}
+	}
+	matched := make([]GPU, 0, len(gpus))
+	for _, g := range gpus {
+		if g.Vendor == filter || g.VendorName() == filter {
+			matched = append(matched, g)
+		}
+	}
+	if len(matched) > 0 {
+		return preferDiscrete(matched), nil
+	}
+
+	names := make([]string, 0, len(gpus))
+	for _, g := range gpus {
+		names = append(names, fmt.Sprintf("%s(%s)", g.Name, g.VendorName()))
+	}
+	return GPU{}, fmt.Errorf("no GPU matches %q (available: %s)", filter, strings.Join(names, ", "))
+}
+
+func preferDiscrete(gpus []GPU) GPU {
+	for _, g := range gpus {
+		if !g.Integrated {
+			return g
+		}
+	}
+	return gpus[0]
+}
+
+func cardIndex(name string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "card"))
+	if err != nil {
+		return 1 << 30
+	}
+	return n
+}
+
+func readTrim(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,59 @@
+package gpu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCard(t *testing.T, root, name string, files map[string]string) {
+	t.Helper()
+	dev := filepath.Join(root, name, "device")
+	if err := os.MkdirAll(dev, 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	for k, v := range files {
+		if err := os.WriteFile(filepath.Join(dev, k), []byte(v+"\n"), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+}
+
+func TestListAndSelect(t *testing.T) {
+	root := t.TempDir()
+	writeCard(t, root, "card0", map[string]string{"vendor": "0x1002", "boot_vga": "1", "mem_info_vram_total": "536870912", "local_cpulist": "0-15"})
+	writeCard(t, root, "card1", map[string]string{"vendor": "0x10de", "boot_vga": "0", "numa_node": "1", "local_cpulist": "8-15"})
+	if err := os.MkdirAll(filepath.Join(root, "card0-DP-1"), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	gpus, err := listAt(root)
+	if err != nil {
+		t.Fatalf("listAt: %v", err)
+	}
+	if len(gpus) != 2 {
+		t.Fatalf("expected 2 gpus, got %+v", gpus)
+	}
+	if !gpus[0].Integrated || gpus[1].Integrated {
+		t.Fatalf("unexpected integrated flags: %+v", gpus)
+	}
+
+	g, err := Select(gpus, "")
+	if err != nil || g.Name != "card1" {
+		t.Fatalf("auto select: got %+v err=%v", g, err)
+	}
+	if g.LocalCPUs != "8-15" || g.NUMANode != 1 {
+		t.Fatalf("unexpected locality: %+v", g)
+	}
+	g, err = Select(gpus, "card0")
+	if err != nil || g.Name != "card0" {
+		t.Fatalf("select card0: got %+v err=%v", g, err)
+	}
+	g, err = Select(gpus, "AMD")
+	if err != nil || g.Name != "card0" {
+		t.Fatalf("select amd: got %+v err=%v", g, err)
+	}
+	if _, err := Select(gpus, "intel"); err == nil {
+		t.Fatalf("expected error for unmatched filter")
+	}
+}
@@ -0,0 +1,73 @@
+// Package guests finds the cgroups of virtual machines and containers, the
+// usual heavyweight background load that runs outside app.slice.
+package guests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+const (
+	KindVM        = "vm"
+	KindContainer = "container"
+)
+
+// Guest is one VM or container cgroup, relative to the cgroup root.
+type Guest struct {
+	Cgroup  string
+	Kind    string
+	Runtime string // libvirt, docker or podman
+	System  bool   // outside the user manager; writing it needs privileges
+}
+
+type pattern struct {
+	glob    string
+	kind    string
+	runtime string
+	system  bool
+}
+
+func patterns(uid int) []pattern {
+	user := fmt.Sprintf("user.slice/user-%d.slice/user@%d.service", uid, uid)
+	return []pattern{
+		// libvirt escapes '-' in the machine name, e.g.
+		// machine-qemu\x2d1\x2dwin11.scope.
+		{"machine.slice/machine-qemu*.scope", KindVM, "libvirt", true},
+		{"machine.slice/libpod-*.scope", KindContainer, "podman", true},
+		{"system.slice/docker-*.scope", KindContainer, "docker", true},
+		{user + "/user.slice/libpod-*.scope", KindContainer, "podman", false},
+	}
+}
+
+// Detect returns the guests running now, sorted by cgroup. Guests started
+// from the user session under app.slice are not listed; they are pinned with
+// it already.
+func Detect(uid int) []Guest {
+	return detectAt(systemdctl.CgroupRoot, uid)
+}
+
+func detectAt(root string, uid int) []Guest {
+	var out []Guest
+	for _, p := range patterns(uid) {
+		matches, err := filepath.Glob(filepath.Join(root, p.glob))
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err != nil || !fi.IsDir() {
+				continue
+			}
+			rel, err := filepath.Rel(root, m)
+			if err != nil {
+				continue
+			}
+			out = append(out, Guest{Cgroup: rel, Kind: p.kind, Runtime: p.runtime, System: p.system})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Cgroup < out[j].Cgroup })
+	return out
+}
@@ -0,0 +1,42 @@
+package guests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetect_FindsVMsAndContainers(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{
+		`machine.slice/machine-qemu\x2d1\x2dwin11.scope`,
+		"machine.slice/machine-nspawn.scope",
+		"system.slice/docker-abc.scope",
+		"system.slice/sshd.service",
+		"user.slice/user-1000.slice/user@1000.service/user.slice/libpod-def.scope",
+		"user.slice/user-1001.slice/user@1001.service/user.slice/libpod-other.scope",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A stray file matching a pattern is not a cgroup.
+	if err := os.WriteFile(filepath.Join(root, "system.slice", "docker-x.scope"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := detectAt(root, 1000)
+	want := []Guest{
+		{Cgroup: `machine.slice/machine-qemu\x2d1\x2dwin11.scope`, Kind: KindVM, Runtime: "libvirt", System: true},
+		{Cgroup: "system.slice/docker-abc.scope", Kind: KindContainer, Runtime: "docker", System: true},
+		{Cgroup: "user.slice/user-1000.slice/user@1000.service/user.slice/libpod-def.scope", Kind: KindContainer, Runtime: "podman"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %#v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("guest %d: got %#v want %#v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package health tracks tick timing and Go runtime statistics of the
+// long-running daemon.
+package health
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Snapshot is a point-in-time view of daemon health. Durations are encoded in
+// nanoseconds.
+type Snapshot struct {
+	StartedAt  time.Time `json:"started_at"`
+	Goroutines int       `json:"goroutines"`
+	HeapAlloc  uint64    `json:"heap_alloc_bytes"`
+	HeapSys    uint64    `json:"heap_sys_bytes"`
+	NumGC      uint32    `json:"num_gc"`
+	// GC pauses; the max covers the last 256 cycles the runtime keeps.
+	LastGCPause time.Duration `json:"last_gc_pause_ns"`
+	MaxGCPause  time.Duration `json:"max_gc_pause_ns"`
+
+	Ticks     uint64 `json:"ticks"`
+	SlowTicks uint64 `json:"slow_ticks"` // took longer than the interval
+	// Lag is how late a timer tick started compared to when it was due.
+	LastTick         time.Time     `json:"last_tick,omitempty"`
+	LastTickDuration time.Duration `json:"last_tick_duration_ns"`
+	MaxTickDuration  time.Duration `json:"max_tick_duration_ns"`
+	LastTickLag      time.Duration `json:"last_tick_lag_ns"`
+	MaxTickLag       time.Duration `json:"max_tick_lag_ns"`
+}
+
+// Tracker records tick timings. It is safe for concurrent use so the
+// control socket and the watchdog can read it while the main loop ticks.
+type Tracker struct {
+	mu        sync.Mutex
+	startedAt time.Time
+
+	running time.Time // start of the tick in progress, zero when idle
+	limit   time.Duration
+	flagged bool
+
+	ticks, slow     uint64
+	last            time.Time
+	lastDur, maxDur time.Duration
+	lastLag, maxLag time.Duration
+}
+
+func NewTracker(now time.Time) *Tracker {
+	return &Tracker{startedAt: now}
+}
+
+// Begin marks the start of a tick. due is when a timer tick was scheduled to
+// run (zero for event-driven ticks); limit is the duration after which the
+// tick counts as slow.
+func (t *Tracker) Begin(now, due time.Time, limit time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running = now
+	t.limit = limit
+	t.flagged = false
+	if !due.IsZero() {
+		lag := max(now.Sub(due), 0)
+		t.lastLag = lag
+		t.maxLag = max(t.maxLag, lag)
+	}
+}
+
+// End marks the end of the tick started by Begin and returns its duration
+// and whether it exceeded the limit.
+func (t *Tracker) End(now time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running.IsZero() {
+		return 0, false
+	}
+	d := now.Sub(t.running)
+	t.running = time.Time{}
+	t.ticks++
+	t.last = now
+	t.lastDur = d
+	t.maxDur = max(t.maxDur, d)
+	slow := t.limit > 0 && d > t.limit
+	if slow {
+		t.slow++
+	}
+	return d, slow
+}
+
+// Stuck reports a tick that has been running longer than its limit. It
+// returns true at most once per tick so a watchdog can poll it.
+func (t *Tracker) Stuck(now time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running.IsZero() || t.flagged || t.limit <= 0 {
+		return 0, false
+	}
+	d := now.Sub(t.running)
+	if d <= t.limit {
+		return 0, false
+	}
+	t.flagged = true
+	return d, true
+}
+
+// Snapshot returns the tick statistics together with current runtime
+// statistics. ReadMemStats briefly stops the world; call it on demand only.
+func (t *Tracker) Snapshot() Snapshot {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	s := Snapshot{
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  ms.HeapAlloc,
+		HeapSys:    ms.HeapSys,
+		NumGC:      ms.NumGC,
+	}
+	if ms.NumGC > 0 {
+		s.LastGCPause = time.Duration(ms.PauseNs[(ms.NumGC+255)%256])
+		for _, p := range ms.PauseNs {
+			s.MaxGCPause = max(s.MaxGCPause, time.Duration(p))
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.StartedAt = t.startedAt
+	s.Ticks = t.ticks
+	s.SlowTicks = t.slow
+	s.LastTick = t.last
+	s.LastTickDuration = t.lastDur
+	s.MaxTickDuration = t.maxDur
+	s.LastTickLag = t.lastLag
+	s.MaxTickLag = t.maxLag
+	return s
+}
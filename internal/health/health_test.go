@@ -0,0 +1,41 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerTicks(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tr := NewTracker(start)
+
+	tr.Begin(start.Add(2*time.Second+30*time.Millisecond), start.Add(2*time.Second), time.Second)
+	if d, slow := tr.End(start.Add(2*time.Second + 130*time.Millisecond)); d != 100*time.Millisecond || slow {
+		t.Fatalf("End = %s, %v", d, slow)
+	}
+
+	tr.Begin(start.Add(4*time.Second), time.Time{}, time.Second)
+	if _, stuck := tr.Stuck(start.Add(4*time.Second + 500*time.Millisecond)); stuck {
+		t.Fatalf("expected tick within limit not to be stuck")
+	}
+	if d, stuck := tr.Stuck(start.Add(5500 * time.Millisecond)); !stuck || d != 1500*time.Millisecond {
+		t.Fatalf("Stuck = %s, %v", d, stuck)
+	}
+	if _, stuck := tr.Stuck(start.Add(6 * time.Second)); stuck {
+		t.Fatalf("expected a stuck tick to be reported once")
+	}
+	if _, slow := tr.End(start.Add(7 * time.Second)); !slow {
+		t.Fatalf("expected 3s tick to be slow")
+	}
+
+	s := tr.Snapshot()
+	if s.Ticks != 2 || s.SlowTicks != 1 {
+		t.Fatalf("ticks=%d slow=%d", s.Ticks, s.SlowTicks)
+	}
+	if s.MaxTickDuration != 3*time.Second || s.LastTickLag != 30*time.Millisecond || s.MaxTickLag != 30*time.Millisecond {
+		t.Fatalf("unexpected snapshot: %+v", s)
+	}
+	if s.Goroutines <= 0 || s.StartedAt != start {
+		t.Fatalf("unexpected runtime fields: %+v", s)
+	}
+}
@@ -0,0 +1,103 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxSize is the size at which the history file is rotated to path+".1".
+const maxSize = 1 << 20
+
+// Event records a policy decision taken by the daemon: which games were
+// active and how the CPUs were split as a result.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Games  []string  `json:"games"`
+	Pinned bool      `json:"pinned"`
+
+	OSCPUs   string `json:"os_cpus,omitempty"`
+	GameCPUs string `json:"game_cpus,omitempty"`
+	// Untouched lists game IDs whose processes were deliberately left alone.
+	Untouched []string `json:"untouched,omitempty"`
+}
+
+func DefaultPath() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(base, "ccdbind", "history.jsonl"), nil
+}
+
+// Append writes ev as one JSON line to path, rotating the file once it grows
+// past maxSize.
+func Append(path string, ev Event) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(path); err == nil && fi.Size() >= maxSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read parses JSON lines from r. Blank lines are skipped.
+func Read(r io.Reader) ([]Event, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	out := make([]Event, 0, 64)
+	line := 0
+	for sc.Scan() {
+		line++
+		b := sc.Bytes()
+		if len(b) == 0 {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal(b, &ev); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		out = append(out, ev)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Load reads all events from path. A missing file yields no events.
+func Load(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
@@ -0,0 +1,34 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppendAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	evs := []Event{
+		{Time: time.Unix(100, 0).UTC(), Games: []string{"42"}, Pinned: true, OSCPUs: "0-7", GameCPUs: "8-15"},
+		{Time: time.Unix(200, 0).UTC(), Pinned: false},
+	}
+	for _, ev := range evs {
+		if err := Append(path, ev); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(loaded) != 2 || !loaded[0].Pinned || loaded[0].GameCPUs != "8-15" || loaded[1].Pinned {
+		t.Fatalf("unexpected events: %+v", loaded)
+	}
+}
+
+func TestLoad_Missing(t *testing.T) {
+	evs, err := Load(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if err != nil || len(evs) != 0 {
+		t.Fatalf("expected no events, got %v err=%v", evs, err)
+	}
+}
@@ -0,0 +1,317 @@
+// Package i18n translates ccdbind's human-readable command output.
+//
+// Messages are looked up by key (for example "status.games") in a catalog
+// of fmt format strings. English is compiled in; other languages are TOML
+// files with the same keys, installed as ccdbind/locale/<lang>.toml under
+// $XDG_DATA_HOME or $XDG_DATA_DIRS so distributions can ship them without
+// rebuilding. JSON output is never translated.
+package i18n
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+//go:embed en.toml
+var embedded []byte
+
+// Catalog maps message keys to format strings for one language.
+type Catalog struct {
+	Lang string
+	msgs map[string]string
+}
+
+// Len reports how many messages c holds.
+func (c Catalog) Len() int { return len(c.msgs) }
+
+// Keys returns the message keys of c in sorted order.
+func (c Catalog) Keys() []string { return sortedKeys(c.msgs) }
+
+var (
+	englishOnce sync.Once
+	english     Catalog
+)
+
+// English returns the built-in baseline catalog.
+func English() Catalog {
+	englishOnce.Do(func() {
+		msgs, err := flatten(embedded)
+		if err != nil {
+			panic("i18n: embedded en.toml: " + err.Error())
+		}
+		english = Catalog{Lang: "en", msgs: msgs}
+	})
+	return english
+}
+
+// Parse reads a translation catalog for lang. Entries with keys English does
+// not know, or whose format verbs differ from the English message, are left
+// out so they cannot garble output; they are reported in the returned error
+// alongside the usable catalog.
+func Parse(lang string, data []byte) (Catalog, error) {
+	msgs, err := flatten(data)
+	if err != nil {
+		return Catalog{}, fmt.Errorf("parse %s catalog: %w", lang, err)
+	}
+	base := English().msgs
+	var errs []error
+	for _, k := range sortedKeys(msgs) {
+		want, ok := base[k]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown message %q", lang, k))
+			delete(msgs, k)
+			continue
+		}
+		if got, exp := verbs(msgs[k]), verbs(want); got != exp {
+			errs = append(errs, fmt.Errorf("%s: message %q uses verbs %q (expected %q)", lang, k, got, exp))
+			delete(msgs, k)
+		}
+	}
+	return Catalog{Lang: lang, msgs: msgs}, errors.Join(errs...)
+}
+
+// flatten decodes a TOML catalog, joining table names and keys with dots.
+func flatten(data []byte) (map[string]string, error) {
+	var raw map[string]any
+	if err := toml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	var walk func(prefix string, m map[string]any) error
+	walk = func(prefix string, m map[string]any) error {
+		for k, v := range m {
+			key := prefix + k
+			switch v := v.(type) {
+			case string:
+				out[key] = v
+			case map[string]any:
+				if err := walk(key+".", v); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("message %q is not a string", key)
+			}
+		}
+		return nil
+	}
+	if err := walk("", raw); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var verbRE = regexp.MustCompile(`%[-+# 0]*(?:\[\d+\])?(?:\d+|\*)?(?:\.(?:\[\d+\])?(?:\d+|\*)?)?(?:\[\d+\])?[a-zA-Z%]`)
+
+// verbs returns the sorted verb letters of format, ignoring %% and any
+// flags, widths or argument indexes, so translations may reorder arguments
+// but not change their number or kind.
+func verbs(format string) string {
+	var vs []byte
+	for _, m := range verbRE.FindAllString(format, -1) {
+		if v := m[len(m)-1]; v != '%' {
+			vs = append(vs, v)
+		}
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+	return string(vs)
+}
+
+// Printer formats messages from a translation, falling back to English.
+type Printer struct {
+	lang string
+	msgs map[string]string
+}
+
+// NewPrinter returns a Printer that prefers the catalogs in order and falls
+// back to English.
+func NewPrinter(catalogs ...Catalog) *Printer {
+	p := &Printer{lang: "en", msgs: map[string]string{}}
+	for k, v := range English().msgs {
+		p.msgs[k] = v
+	}
+	for i := len(catalogs) - 1; i >= 0; i-- {
+		for k, v := range catalogs[i].msgs {
+			p.msgs[k] = v
+		}
+	}
+	if len(catalogs) > 0 && catalogs[0].Len() > 0 {
+		p.lang = catalogs[0].Lang
+	}
+	return p
+}
+
+// Lang reports the language of the preferred catalog.
+func (p *Printer) Lang() string { return p.lang }
+
+// Sprintf formats the message key with args. Unknown keys are formatted as
+// the key itself so a missing message is visible rather than empty.
+func (p *Printer) Sprintf(key string, args ...any) string {
+	format, ok := p.msgs[key]
+	if !ok {
+		format = key
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Println writes the message key to stdout followed by a newline.
+func (p *Printer) Println(key string, args ...any) {
+	fmt.Println(p.Sprintf(key, args...))
+}
+
+// Message is a message key with its arguments, for text that is built
+// before it is known whether it ends up in JSON, which stays English, or in
+// human-readable output.
+type Message struct {
+	Key  string
+	Args []any
+}
+
+// Msg returns the message key with args.
+func Msg(key string, args ...any) Message { return Message{Key: key, Args: args} }
+
+// Format formats m in the language of p.
+func (p *Printer) Format(m Message) string { return p.Sprintf(m.Key, m.Args...) }
+
+var englishPrinter = sync.OnceValue(func() *Printer { return NewPrinter() })
+
+// English formats m in English, as JSON output and logs want it.
+func (m Message) English() string { return englishPrinter().Format(m) }
+
+// Languages returns the languages the user asked for, most preferred first,
+// from LANGUAGE, LC_ALL, LC_MESSAGES and LANG. Each locale is reduced to
+// its language and region ("pt_BR.UTF-8" gives "pt_BR" then "pt"). The C
+// and POSIX locales select English, as does an empty environment.
+func Languages(getenv func(string) string) []string {
+	locale := ""
+	for _, k := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if v := strings.TrimSpace(getenv(k)); v != "" {
+			locale = v
+			break
+		}
+	}
+	if isC(locale) {
+		return nil
+	}
+	var names []string
+	// LANGUAGE is a GNU extension honoured only when a locale is set.
+	if v := strings.TrimSpace(getenv("LANGUAGE")); v != "" && locale != "" {
+		names = strings.Split(v, ":")
+	}
+	names = append(names, locale)
+
+	var out []string
+	seen := map[string]bool{}
+	add := func(s string) {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	for _, n := range names {
+		if isC(n) {
+			continue
+		}
+		n, _, _ = strings.Cut(n, ".")
+		n, _, _ = strings.Cut(n, "@")
+		add(n)
+		if lang, _, ok := strings.Cut(n, "_"); ok {
+			add(lang)
+		}
+	}
+	return out
+}
+
+func isC(locale string) bool {
+	locale, _, _ = strings.Cut(locale, ".")
+	return locale == "" || locale == "C" || locale == "POSIX"
+}
+
+// Dirs returns the directories searched for translations, most preferred
+// first: $XDG_DATA_HOME/ccdbind/locale, then ccdbind/locale under each of
+// $XDG_DATA_DIRS (default /usr/local/share:/usr/share).
+func Dirs(getenv func(string) string) []string {
+	var dirs []string
+	home := getenv("XDG_DATA_HOME")
+	if home == "" {
+		if h, err := os.UserHomeDir(); err == nil {
+			home = filepath.Join(h, ".local", "share")
+		}
+	}
+	if home != "" {
+		dirs = append(dirs, filepath.Join(home, "ccdbind", "locale"))
+	}
+	data := getenv("XDG_DATA_DIRS")
+	if data == "" {
+		data = "/usr/local/share:/usr/share"
+	}
+	for _, d := range strings.Split(data, ":") {
+		if d != "" {
+			dirs = append(dirs, filepath.Join(d, "ccdbind", "locale"))
+		}
+	}
+	return dirs
+}
+
+// Load builds a Printer for langs from the first <lang>.toml found in dirs
+// for each language. English needs no file. Missing files are skipped;
+// problems with files that exist are returned alongside the Printer, which
+// is always usable.
+func Load(langs, dirs []string) (*Printer, error) {
+	var catalogs []Catalog
+	var errs []error
+	for _, lang := range langs {
+		if lang == "en" || strings.HasPrefix(lang, "en_") {
+			break
+		}
+		for _, dir := range dirs {
+			data, err := os.ReadFile(filepath.Join(dir, lang+".toml"))
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err)
+				}
+				continue
+			}
+			c, err := Parse(lang, data)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", filepath.Join(dir, lang+".toml"), err))
+			}
+			if c.Len() > 0 {
+				catalogs = append(catalogs, c)
+			}
+			break
+		}
+	}
+	return NewPrinter(catalogs...), errors.Join(errs...)
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultPrinter *Printer
+)
+
+// Default returns the Printer for the process environment, loading it on
+// first use. Broken translation files are ignored; English fills the gaps.
+func Default() *Printer {
+	defaultOnce.Do(func() {
+		defaultPrinter, _ = Load(Languages(os.Getenv), Dirs(os.Getenv))
+	})
+	return defaultPrinter
+}
@@ -0,0 +1,176 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestEnglish(t *testing.T) {
+	en := English()
+	if en.Len() == 0 {
+		t.Fatal("empty English catalog")
+	}
+	p := NewPrinter()
+	if got := p.Sprintf("status.games_none"); got != "games: none" {
+		t.Fatalf("status.games_none=%q", got)
+	}
+	if got := p.Sprintf("status.budget_class", "os", 2, "0-1", 12.4); got != "  os: cpus=2 (0-1) busy=12%" {
+		t.Fatalf("status.budget_class=%q", got)
+	}
+	if got := p.Sprintf("no.such.key"); got != "no.such.key" {
+		t.Fatalf("unknown key=%q", got)
+	}
+	if got := Msg("status.state", "/x").English(); got != "state: /x" {
+		t.Fatalf("Message.English=%q", got)
+	}
+}
+
+// TestEnglishCoversCommands makes sure every key the commands print exists
+// in the baseline, so a typo cannot reach users as a raw key.
+func TestEnglishCoversCommands(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "cmd", "*", "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`(?:msg\.(?:Sprintf|Println)|i18n\.Msg)\("([^"]+)"`)
+	en := English()
+	known := map[string]bool{}
+	for _, k := range en.Keys() {
+		known[k] = true
+	}
+	seen := 0
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range re.FindAllStringSubmatch(string(data), -1) {
+			seen++
+			if !known[m[1]] {
+				t.Errorf("%s: message %q missing from en.toml", f, m[1])
+			}
+		}
+	}
+	if seen == 0 {
+		t.Fatal("no message keys found in cmd/")
+	}
+}
+
+func TestParse(t *testing.T) {
+	data := []byte(`
+[status]
+games_none = "Spiele: keine"
+state = "Zustand: %s"
+pin_applied = "angewendet: %d"   # wrong verb
+budget_class = "  %[1]s: %[3]s (%[2]d CPUs) ausgelastet=%.0[4]f%%"
+
+[bogus]
+key = "x"
+`)
+	c, err := Parse("de", data)
+	if err == nil {
+		t.Fatal("expected errors for the bad entries")
+	}
+	for _, want := range []string{`"status.pin_applied"`, `"bogus.key"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+	if got, want := c.Keys(), []string{"status.budget_class", "status.games_none", "status.state"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("keys=%v want %v", got, want)
+	}
+
+	p := NewPrinter(c)
+	if p.Lang() != "de" {
+		t.Fatalf("lang=%q", p.Lang())
+	}
+	if got := p.Sprintf("status.state", "/x"); got != "Zustand: /x" {
+		t.Fatalf("translated=%q", got)
+	}
+	if got := p.Sprintf("status.pin_applied", true); got != "pin_applied: true" {
+		t.Fatalf("fallback=%q", got)
+	}
+	if got := p.Sprintf("status.budget_class", "os", 2, "0-1", 50.0); got != "  os: 0-1 (2 CPUs) ausgelastet=50%" {
+		t.Fatalf("reordered=%q", got)
+	}
+
+	if _, err := Parse("de", []byte(`state = 3`)); err == nil {
+		t.Fatal("expected error for a non-string message")
+	}
+	if _, err := Parse("de", []byte(`state = `)); err == nil {
+		t.Fatal("expected error for invalid TOML")
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	cases := []struct {
+		env  map[string]string
+		want []string
+	}{
+		{nil, nil},
+		{map[string]string{"LANG": "C.UTF-8"}, nil},
+		{map[string]string{"LANG": "POSIX", "LANGUAGE": "de"}, nil},
+		{map[string]string{"LANG": "pt_BR.UTF-8"}, []string{"pt_BR", "pt"}},
+		{map[string]string{"LANG": "de_DE.UTF-8", "LC_MESSAGES": "uk_UA.UTF-8"}, []string{"uk_UA", "uk"}},
+		{map[string]string{"LANG": "de_DE.UTF-8", "LC_ALL": "C"}, nil},
+		{map[string]string{"LANG": "sr_RS@latin"}, []string{"sr_RS", "sr"}},
+		{map[string]string{"LANG": "de_DE.UTF-8", "LANGUAGE": "fr:de"}, []string{"fr", "de", "de_DE"}},
+	}
+	for _, tc := range cases {
+		got := Languages(func(k string) string { return tc.env[k] })
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got %v want %v", tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestDirs(t *testing.T) {
+	env := map[string]string{"XDG_DATA_HOME": "/home/u/.local/share", "XDG_DATA_DIRS": "/usr/share::/opt/share"}
+	got := Dirs(func(k string) string { return env[k] })
+	want := []string{"/home/u/.local/share/ccdbind/locale", "/usr/share/ccdbind/locale", "/opt/share/ccdbind/locale"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	user, system := t.TempDir(), t.TempDir()
+	write := func(dir, name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(user, "pt.toml", "[status]\ngames = \"jogos:\"\n")
+	write(system, "pt.toml", "[status]\ngames = \"ignored\"\ngames_none = \"ignored\"\n")
+	write(system, "pt_BR.toml", "[status]\ngames_none = \"jogos: nenhum\"\nstate = \"estado: %d\"\n")
+
+	p, err := Load([]string{"pt_BR", "pt"}, []string{user, system})
+	if err == nil || !strings.Contains(err.Error(), "pt_BR.toml") {
+		t.Fatalf("expected an error naming pt_BR.toml, got %v", err)
+	}
+	if p.Lang() != "pt_BR" {
+		t.Fatalf("lang=%q", p.Lang())
+	}
+	for key, want := range map[string]string{
+		"status.games_none": "jogos: nenhum",
+		"status.games":      "jogos:",
+		"status.state":      "state: %!s(MISSING)",
+	} {
+		if got := p.Sprintf(key); got != want {
+			t.Errorf("%s=%q want %q", key, got, want)
+		}
+	}
+
+	p, err = Load([]string{"en_US", "en", "pt"}, []string{user})
+	if err != nil || p.Lang() != "en" || p.Sprintf("status.games") != "games:" {
+		t.Fatalf("English first: lang=%q err=%v", p.Lang(), err)
+	}
+	p, err = Load([]string{"ja"}, []string{user, system})
+	if err != nil || p.Lang() != "en" {
+		t.Fatalf("missing language: lang=%q err=%v", p.Lang(), err)
+	}
+}
@@ -0,0 +1,233 @@
+// Package irqbalance asks irqbalance to keep interrupts off a set of CPUs.
+//
+// irqbalance only accepts settings over its socket from root, so the banned
+// CPUs are passed the way it reads them at startup: IRQBALANCE_BANNED_CPULIST
+// and IRQBALANCE_BANNED_CPUS in the environment of irqbalance.service, set as
+// a runtime drop-in with SetUnitProperties and followed by a restart. Nothing
+// else sees the variables, and the drop-in lives in /run, so it is gone after
+// a reboot. Both calls go over the system bus and are subject to polkit: the
+// user needs rights for managing irqbalance.service.
+package irqbalance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+const Unit = "irqbalance.service"
+
+// ErrNotRunning is returned when irqbalance.service is not active; there is
+// nothing to coordinate with.
+var ErrNotRunning = errors.New("irqbalance.service is not running")
+
+var envNames = []string{"IRQBALANCE_BANNED_CPULIST", "IRQBALANCE_BANNED_CPUS"}
+
+// Env returns the environment assignments that ban cpus, a canonical CPU
+// list. IRQBALANCE_BANNED_CPUS is the hex mask older irqbalance versions
+// read; newer ones prefer the list.
+func Env(cpus string) ([]string, error) {
+	canonical, set, err := topology.CanonicalizeCPUList(cpus)
+	if err != nil {
+		return nil, err
+	}
+	if len(set) == 0 {
+		return nil, fmt.Errorf("empty cpu list %q", cpus)
+	}
+	return []string{envNames[0] + "=" + canonical, envNames[1] + "=" + hexMask(set)}, nil
+}
+
+// hexMask formats cpus like a sysfs cpumask, see topology.FormatCPUMask.
+func hexMask(cpus []int) string {
+	return topology.FormatCPUMask(cpus)
+}
+
+// Effective is how irqbalance.service gets the ban after a restart.
+type Effective struct {
+	// Env holds the IRQBALANCE_BANNED_* assignments of the unit, NAME=value.
+	Env []string
+	// File is the unit's EnvironmentFile= that sets any of them, which
+	// systemd lets override Environment=, or "".
+	File string
+}
+
+func (e Effective) String() string {
+	if len(e.Env) == 0 {
+		return "no IRQBALANCE_BANNED_* set"
+	}
+	out := strings.Join(e.Env, " ")
+	if e.File != "" {
+		out += " from " + e.File
+	}
+	return out
+}
+
+// Ban restarts irqbalance with cpus banned from receiving interrupts, and
+// returns the ban as the unit has it then.
+func Ban(ctx context.Context, cpus string) (Effective, error) {
+	env, err := Env(cpus)
+	if err != nil {
+		return Effective{}, err
+	}
+	var eff Effective
+	err = withManager(ctx, func(conn *dbus.Conn, m dbus.BusObject, unit dbus.ObjectPath, active bool) error {
+		if !active {
+			return ErrNotRunning
+		}
+		if err := setEnvironment(ctx, m, env); err != nil {
+			return err
+		}
+		if err := restart(ctx, m); err != nil {
+			return err
+		}
+		eff, err = effective(ctx, conn, unit)
+		return err
+	})
+	return eff, err
+}
+
+// Unban clears the banned CPUs and, if irqbalance is running, restarts it so
+// it spreads interrupts over every CPU again. The variables are set empty,
+// which irqbalance reads as no ban: an empty Environment= would also reset
+// assignments of the unit's own.
+func Unban(ctx context.Context) error {
+	return withManager(ctx, func(_ *dbus.Conn, m dbus.BusObject, unit dbus.ObjectPath, active bool) error {
+		if unit == "" {
+			// Not loaded, so there is no drop-in to clear.
+			return nil
+		}
+		env := make([]string, len(envNames))
+		for i, name := range envNames {
+			env[i] = name + "="
+		}
+		if err := setEnvironment(ctx, m, env); err != nil {
+			return err
+		}
+		if !active {
+			return nil
+		}
+		return restart(ctx, m)
+	})
+}
+
+// unitProperty is one (sv) entry of SetUnitProperties.
+type unitProperty struct {
+	Name  string
+	Value dbus.Variant
+}
+
+func setEnvironment(ctx context.Context, m dbus.BusObject, env []string) error {
+	props := []unitProperty{{Name: "Environment", Value: dbus.MakeVariant(env)}}
+	if err := m.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.SetUnitProperties", 0, Unit, true, props).Err; err != nil {
+		return fmt.Errorf("set %s Environment=: %w", Unit, err)
+	}
+	return nil
+}
+
+// envFile is one EnvironmentFile= of a service; Optional is set for the
+// "-" prefix.
+type envFile struct {
+	Path     string
+	Optional bool
+}
+
+// effective reads the unit's Environment= and EnvironmentFiles= back.
+func effective(ctx context.Context, conn *dbus.Conn, unit dbus.ObjectPath) (Effective, error) {
+	obj := conn.Object("org.freedesktop.systemd1", unit)
+	var env []string
+	if err := obj.CallWithContext(ctx, "org.freedesktop.DBus.Properties.Get", 0, "org.freedesktop.systemd1.Service", "Environment").Store(&env); err != nil {
+		return Effective{}, fmt.Errorf("read %s Environment=: %w", Unit, err)
+	}
+	var files []envFile
+	if err := obj.CallWithContext(ctx, "org.freedesktop.DBus.Properties.Get", 0, "org.freedesktop.systemd1.Service", "EnvironmentFiles").Store(&files); err != nil {
+		return Effective{}, fmt.Errorf("read %s EnvironmentFiles=: %w", Unit, err)
+	}
+	return effectiveBan(env, files, os.ReadFile), nil
+}
+
+// effectiveBan returns the IRQBALANCE_BANNED_* values irqbalance starts
+// with: those of env, overridden by any the files set, later files winning.
+// Files that cannot be read are skipped.
+func effectiveBan(env []string, files []envFile, readFile func(string) ([]byte, error)) Effective {
+	values := map[string]string{}
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && isBanVar(k) {
+			values[k] = v
+		}
+	}
+	var from string
+	for _, f := range files {
+		data, err := readFile(f.Path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+			k, v, ok := strings.Cut(line, "=")
+			if k = strings.TrimSpace(k); !ok || !isBanVar(k) {
+				continue
+			}
+			v = strings.TrimSpace(v)
+			if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+				v = v[1 : len(v)-1]
+			}
+			values[k] = v
+			from = f.Path
+		}
+	}
+	eff := Effective{File: from}
+	for _, name := range envNames {
+		if v, ok := values[name]; ok {
+			eff.Env = append(eff.Env, name+"="+v)
+		}
+	}
+	return eff
+}
+
+func isBanVar(name string) bool {
+	return name == envNames[0] || name == envNames[1]
+}
+
+// withManager calls fn with the system manager, the object path of
+// irqbalance.service and whether it is active.
+func withManager(ctx context.Context, fn func(conn *dbus.Conn, m dbus.BusObject, unit dbus.ObjectPath, active bool) error) error {
+	conn, err := dbus.SystemBusPrivate()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.Auth(nil); err != nil {
+		return err
+	}
+	if err := conn.Hello(); err != nil {
+		return err
+	}
+	m := conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
+	active := false
+	var unitPath dbus.ObjectPath
+	// GetUnit fails for units that are not loaded, e.g. not installed.
+	if err := m.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.GetUnit", 0, Unit).Store(&unitPath); err == nil {
+		state, err := conn.Object("org.freedesktop.systemd1", unitPath).GetProperty("org.freedesktop.systemd1.Unit.ActiveState")
+		if err != nil {
+			return err
+		}
+		active = state.Value() == "active"
+	}
+	return fn(conn, m, unitPath, active)
+}
+
+func restart(ctx context.Context, m dbus.BusObject) error {
+	if err := m.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.RestartUnit", 0, Unit, "replace").Err; err != nil {
+		return fmt.Errorf("restart %s: %w", Unit, err)
+	}
+	return nil
+}
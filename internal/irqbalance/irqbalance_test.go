@@ -0,0 +1,68 @@
+package irqbalance
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+	env, err := Env("8-15, 40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"IRQBALANCE_BANNED_CPULIST=8-15,40", "IRQBALANCE_BANNED_CPUS=00000100,0000ff00"}
+	if len(env) != 2 || env[0] != want[0] || env[1] != want[1] {
+		t.Fatalf("Env = %q, want %q", env, want)
+	}
+	if _, err := Env(""); err == nil {
+		t.Fatalf("expected error for empty list")
+	}
+}
+
+func TestHexMask(t *testing.T) {
+	for _, tc := range []struct {
+		cpus []int
+		want string
+	}{
+		{[]int{0}, "00000001"},
+		{[]int{0, 31}, "80000001"},
+		{[]int{32}, "00000001,00000000"},
+	} {
+		if got := hexMask(tc.cpus); got != tc.want {
+			t.Fatalf("hexMask(%v) = %q, want %q", tc.cpus, got, tc.want)
+		}
+	}
+}
+
+func TestEffectiveBan(t *testing.T) {
+	ban := []string{"PATH=/usr/bin", "IRQBALANCE_BANNED_CPULIST=8-15", "IRQBALANCE_BANNED_CPUS=0000ff00"}
+	files := map[string]string{
+		"/etc/default/irqbalance":   "# irqbalance\nIRQBALANCE_ARGS=--hintpolicy=ignore\n",
+		"/etc/sysconfig/irqbalance": "IRQBALANCE_BANNED_CPUS=\"00000001\"\n",
+	}
+	read := func(path string) ([]byte, error) {
+		if data, ok := files[path]; ok {
+			return []byte(data), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	for _, tc := range []struct {
+		name  string
+		env   []string
+		files []envFile
+		want  Effective
+	}{
+		{"banned", ban, []envFile{{Path: "/etc/default/irqbalance"}}, Effective{Env: ban[1:]}},
+		{"missing file", ban, []envFile{{Path: "/nonexistent", Optional: true}}, Effective{Env: ban[1:]}},
+		{"overridden", ban, []envFile{{Path: "/etc/default/irqbalance"}, {Path: "/etc/sysconfig/irqbalance"}},
+			Effective{Env: []string{"IRQBALANCE_BANNED_CPULIST=8-15", "IRQBALANCE_BANNED_CPUS=00000001"}, File: "/etc/sysconfig/irqbalance"}},
+		{"lifted", []string{"IRQBALANCE_BANNED_CPULIST=", "IRQBALANCE_BANNED_CPUS="}, nil,
+			Effective{Env: []string{"IRQBALANCE_BANNED_CPULIST=", "IRQBALANCE_BANNED_CPUS="}}},
+		{"none", []string{"PATH=/usr/bin"}, nil, Effective{}},
+	} {
+		if got := effectiveBan(tc.env, tc.files, read); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: effectiveBan = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
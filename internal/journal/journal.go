@@ -0,0 +1,133 @@
+// Package journal writes entries to the systemd journal over journald's
+// native socket protocol, so structured fields (GAME_ID=, UNIT=, ...) reach
+// the journal as fields rather than text.
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// SocketPath is journald's native protocol socket.
+var SocketPath = "/run/systemd/journal/socket"
+
+// Priority is a syslog priority, as in the PRIORITY= field.
+type Priority int
+
+const (
+	PriErr     Priority = 3
+	PriWarning Priority = 4
+	PriNotice  Priority = 5
+	PriInfo    Priority = 6
+	PriDebug   Priority = 7
+)
+
+// maxMessage bounds MESSAGE= so an entry fits in one datagram; journald's
+// large-entry path through a passed memfd is not implemented.
+const maxMessage = 48 << 10
+
+// StderrIsJournal reports whether stderr is connected to the journal, i.e.
+// the process runs as a systemd service with StandardError=journal. It
+// checks JOURNAL_STREAM against stderr's device and inode as sd-journal
+// recommends, so a redirected stderr is not mistaken for the journal.
+func StderrIsJournal() bool {
+	dev, ino, ok := strings.Cut(os.Getenv("JOURNAL_STREAM"), ":")
+	if !ok {
+		return false
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(2, &st); err != nil {
+		return false
+	}
+	return dev == strconv.FormatUint(uint64(st.Dev), 10) && ino == strconv.FormatUint(st.Ino, 10)
+}
+
+// Writer sends entries to the journal. It is safe for concurrent use.
+type Writer struct {
+	mu    sync.Mutex
+	conn  *net.UnixConn
+	ident string
+}
+
+// NewWriter opens the journal socket. ident becomes SYSLOG_IDENTIFIER=.
+func NewWriter(ident string) (*Writer, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: SocketPath, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{conn: conn, ident: ident}, nil
+}
+
+// Send writes one entry. Field names must be upper case letters, digits and
+// underscores, not starting with an underscore; invalid names are skipped.
+func (w *Writer) Send(p Priority, msg string, fields map[string]string) error {
+	if len(msg) > maxMessage {
+		msg = msg[:maxMessage] + "... (truncated)"
+	}
+	var b bytes.Buffer
+	appendField(&b, "MESSAGE", msg)
+	appendField(&b, "PRIORITY", strconv.Itoa(int(p)))
+	if w.ident != "" {
+		appendField(&b, "SYSLOG_IDENTIFIER", w.ident)
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if validName(k) && k != "MESSAGE" && k != "PRIORITY" {
+			appendField(&b, k, fields[k])
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.conn.Write(b.Bytes()); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	return w.conn.Close()
+}
+
+// appendField encodes KEY=value, or the length-prefixed form for values
+// containing newlines.
+func appendField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteString(key)
+	b.WriteByte('\n')
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(len(value)))
+	b.Write(n[:])
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+func validName(k string) bool {
+	if k == "" || k[0] == '_' || (k[0] >= '0' && k[0] <= '9') {
+		return false
+	}
+	for _, c := range k {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
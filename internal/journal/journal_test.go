@@ -0,0 +1,46 @@
+package journal
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriterSendsNativeProtocol(t *testing.T) {
+	SocketPath = filepath.Join(t.TempDir(), "socket")
+	srv, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: SocketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer srv.Close()
+
+	w, err := NewWriter("ccdbind")
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	defer w.Close()
+	if err := w.Send(PriWarning, "two\nlines", map[string]string{"GAME_ID": "730", "bad-name": "x", "_PID": "1"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	n, err := srv.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	want := "MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\nPRIORITY=4\nSYSLOG_IDENTIFIER=ccdbind\nGAME_ID=730\n"
+	if got := string(buf[:n]); got != want {
+		t.Fatalf("payload = %q, want %q", got, want)
+	}
+}
+
+func TestStderrIsJournal(t *testing.T) {
+	t.Setenv("JOURNAL_STREAM", "")
+	if StderrIsJournal() {
+		t.Fatalf("expected false without JOURNAL_STREAM")
+	}
+	t.Setenv("JOURNAL_STREAM", "1:1")
+	if StderrIsJournal() {
+		t.Fatalf("expected false for a stream that is not stderr")
+	}
+}
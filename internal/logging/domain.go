@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Domain names a subsystem whose debug output can be enabled on its own,
+// without the rest of the debug level.
+type Domain string
+
+const (
+	Scan     Domain = "scan"     // /proc scans and game detection
+	DBus     Domain = "dbus"     // calls to the user manager
+	Pin      Domain = "pin"      // cpuset and AllowedCPUs writes
+	State    Domain = "state"    // state file loads and saves
+	Topology Domain = "topology" // CPU and cache detection
+)
+
+var Domains = []Domain{Scan, DBus, Pin, State, Topology}
+
+// ParseDomains parses a comma or space separated list of domain names, e.g.
+// "scan,dbus". "all" enables every domain.
+func ParseDomains(s string) ([]Domain, error) {
+	var out []Domain
+	for _, name := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return r == ',' || r == ' ' }) {
+		if name == "all" {
+			return append([]Domain(nil), Domains...), nil
+		}
+		d := Domain(name)
+		if !validDomain(d) {
+			return nil, fmt.Errorf("invalid debug domain %q (expected %s or all)", name, domainList())
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+func validDomain(d Domain) bool {
+	for _, v := range Domains {
+		if v == d {
+			return true
+		}
+	}
+	return false
+}
+
+func domainList() string {
+	names := make([]string, len(Domains))
+	for i, d := range Domains {
+		names[i] = string(d)
+	}
+	return strings.Join(names, ", ")
+}
+
+var domains = map[Domain]bool{}
+
+// SetDomains replaces the set of enabled debug domains.
+func SetDomains(ds []Domain) {
+	mu.Lock()
+	defer mu.Unlock()
+	domains = make(map[Domain]bool, len(ds))
+	for _, d := range ds {
+		domains[d] = true
+	}
+}
+
+// Tracing reports whether Tracef output for d is enabled: either d is one of
+// the enabled domains or the level is Debug.
+func Tracing(d Domain) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return domains[d] || level >= Debug
+}
+
+// Tracef logs a debug message for domain d. The message is prefixed with the
+// domain name and the journal entry carries it as DEBUG_DOMAIN.
+func Tracef(d Domain, f Fields, format string, args ...any) {
+	if !Tracing(d) {
+		return
+	}
+	withDomain := make(Fields, len(f)+1)
+	for k, v := range f {
+		withDomain[k] = v
+	}
+	withDomain["DEBUG_DOMAIN"] = string(d)
+	send(Debug, withDomain, string(d)+": "+fmt.Sprintf(format, args...), 3)
+}
@@ -0,0 +1,174 @@
+// Package logging filters log output by level and, when the process runs as
+// a systemd service, sends it to the journal with structured fields instead
+// of plain text on stderr.
+//
+// Existing log.Printf calls keep working: Setup routes the standard logger
+// through this package at Info.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/Reidond/ccdbind/internal/journal"
+)
+
+// Level is a syslog priority; lower is more severe.
+type Level int
+
+const (
+	Error   Level = Level(journal.PriErr)
+	Warning Level = Level(journal.PriWarning)
+	Info    Level = Level(journal.PriInfo)
+	Debug   Level = Level(journal.PriDebug)
+)
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "error", "err":
+		return Error, nil
+	case "warning", "warn":
+		return Warning, nil
+	case "info", "":
+		return Info, nil
+	case "debug":
+		return Debug, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q (expected error, warning, info or debug)", s)
+}
+
+func (l Level) String() string {
+	switch l {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Debug:
+		return "debug"
+	}
+	return "info"
+}
+
+// prefix marks non-info lines in plain text output.
+func (l Level) prefix() string {
+	switch l {
+	case Error:
+		return "ERROR: "
+	case Warning:
+		return "WARN: "
+	case Debug:
+		return "DEBUG: "
+	}
+	return ""
+}
+
+// Fields are journal fields attached to an entry, e.g. GAME_ID, UNIT, PID.
+// They are dropped in plain text output, where the message carries them.
+type Fields map[string]string
+
+var (
+	mu    sync.Mutex
+	level = Info
+	jw    *journal.Writer
+	plain *log.Logger // the standard logger's original output; nil before Setup
+)
+
+// Setup takes over the standard logger. Output goes to the journal when
+// stderr is connected to it and to the standard logger's current writer
+// otherwise. It reports whether the journal is used.
+func Setup(ident string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	plain = log.New(log.Writer(), log.Prefix(), log.Flags())
+	if journal.StderrIsJournal() {
+		if w, err := journal.NewWriter(ident); err == nil {
+			jw = w
+			log.SetFlags(0) // the journal timestamps entries itself
+		}
+	}
+	log.SetOutput(stdWriter{})
+	return jw != nil
+}
+
+func SetLevel(l Level) {
+	mu.Lock()
+	level = l
+	mu.Unlock()
+}
+
+func Enabled(l Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return l <= level
+}
+
+// Journal reports whether Setup connected to the journal.
+func Journal() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return jw != nil
+}
+
+func Logf(l Level, f Fields, format string, args ...any) {
+	emit(l, f, fmt.Sprintf(format, args...), 3)
+}
+
+func Errorf(f Fields, format string, args ...any) {
+	emit(Error, f, fmt.Sprintf(format, args...), 3)
+}
+
+func Warnf(f Fields, format string, args ...any) {
+	emit(Warning, f, fmt.Sprintf(format, args...), 3)
+}
+
+func Infof(f Fields, format string, args ...any) {
+	emit(Info, f, fmt.Sprintf(format, args...), 3)
+}
+
+func Debugf(f Fields, format string, args ...any) {
+	emit(Debug, f, fmt.Sprintf(format, args...), 3)
+}
+
+func emit(l Level, f Fields, msg string, depth int) {
+	if !Enabled(l) {
+		return
+	}
+	send(l, f, msg, depth+1)
+}
+
+// send writes one entry regardless of the level.
+func send(l Level, f Fields, msg string, depth int) {
+	mu.Lock()
+	w, out := jw, plain
+	mu.Unlock()
+	if w != nil {
+		if err := w.Send(journal.Priority(l), msg, f); err == nil {
+			return
+		}
+	}
+	if out == nil {
+		out = log.Default()
+	}
+	_ = out.Output(depth, l.prefix()+msg)
+}
+
+// stdWriter receives lines from the standard logger, already formatted.
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	mu.Lock()
+	w, out, enabled := jw, plain, Info <= level
+	mu.Unlock()
+	if !enabled {
+		return len(p), nil
+	}
+	if w != nil {
+		if err := w.Send(journal.PriInfo, strings.TrimSuffix(string(p), "\n"), nil); err == nil {
+			return len(p), nil
+		}
+	}
+	_, err := out.Writer().Write(p)
+	return len(p), err
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLevelFiltering(t *testing.T) {
+	t.Setenv("JOURNAL_STREAM", "")
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	if Setup("test") {
+		t.Fatalf("expected plain output without JOURNAL_STREAM")
+	}
+
+	SetLevel(Warning)
+	log.Printf("std info")
+	Infof(nil, "info")
+	Warnf(Fields{"GAME_ID": "1"}, "warn %d", 1)
+	Errorf(nil, "err")
+	Debugf(nil, "debug")
+	SetLevel(Debug)
+	log.Printf("std info again")
+	Debugf(nil, "debug again")
+
+	want := "WARN: warn 1\nERROR: err\nstd info again\nDEBUG: debug again\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Fatalf("expected error for unknown level")
+	}
+	if l, err := ParseLevel(" WARN "); err != nil || l != Warning || !strings.EqualFold(l.String(), "warning") {
+		t.Fatalf("ParseLevel = %v, %v", l, err)
+	}
+}
+
+func TestDomains(t *testing.T) {
+	t.Setenv("JOURNAL_STREAM", "")
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	Setup("test")
+	defer SetDomains(nil)
+
+	ds, err := ParseDomains("scan, dbus")
+	if err != nil || len(ds) != 2 || ds[0] != Scan || ds[1] != DBus {
+		t.Fatalf("ParseDomains = %v, %v", ds, err)
+	}
+	if _, err := ParseDomains("scan,gpu"); err == nil {
+		t.Fatalf("expected error for unknown domain")
+	}
+	if all, _ := ParseDomains("all"); len(all) != len(Domains) {
+		t.Fatalf("all = %v", all)
+	}
+
+	SetLevel(Info)
+	SetDomains(ds)
+	Tracef(Scan, nil, "found %d", 2)
+	Tracef(Pin, nil, "hidden")
+	Debugf(nil, "hidden too")
+	SetLevel(Debug)
+	Tracef(Pin, Fields{"UNIT": "app.slice"}, "shown at debug level")
+
+	want := "DEBUG: scan: found 2\nDEBUG: pin: shown at debug level\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+// Package metrics exposes daemon counters and gauges in the Prometheus text
+// exposition format. It covers the handful of metric kinds ccdbind needs
+// without pulling in a client library.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Counter struct{ v atomic.Uint64 }
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+type Gauge struct{ bits atomic.Uint64 }
+
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+// SetBool sets the gauge to 1 or 0.
+func (g *Gauge) SetBool(v bool) {
+	if v {
+		g.Set(1)
+	} else {
+		g.Set(0)
+	}
+}
+
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// Summary tracks the count and sum of observed durations in seconds, without
+// quantiles.
+type Summary struct {
+	mu    sync.Mutex
+	count uint64
+	sum   float64
+}
+
+func (s *Summary) Observe(d time.Duration) {
+	s.mu.Lock()
+	s.count++
+	s.sum += d.Seconds()
+	s.mu.Unlock()
+}
+
+type entry struct {
+	name, help, typ string
+	write           func(w io.Writer, name string)
+}
+
+// Registry holds metrics in registration order.
+type Registry struct {
+	mu      sync.Mutex
+	entries []entry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(name, help, typ string, write func(io.Writer, string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry{name: name, help: help, typ: typ, write: write})
+}
+
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{}
+	r.add(name, help, "counter", func(w io.Writer, name string) {
+		fmt.Fprintf(w, "%s %d\n", name, c.Value())
+	})
+	return c
+}
+
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.add(name, help, "gauge", func(w io.Writer, name string) {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(g.Value()))
+	})
+	return g
+}
+
+func (r *Registry) Summary(name, help string) *Summary {
+	s := &Summary{}
+	r.add(name, help, "summary", func(w io.Writer, name string) {
+		s.mu.Lock()
+		count, sum := s.count, s.sum
+		s.mu.Unlock()
+		fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, formatFloat(sum), name, count)
+	})
+	return s
+}
+
+// GaugeFunc registers a gauge read from fn at scrape time. fn must be safe
+// to call from the HTTP server's goroutines.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.add(name, help, "gauge", func(w io.Writer, name string) {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(fn()))
+	})
+}
+
+// CounterFunc registers a counter with one label, read from fn at scrape
+// time as label value -> count.
+func (r *Registry) CounterFunc(name, help, label string, fn func() map[string]uint64) {
+	r.add(name, help, "counter", func(w io.Writer, name string) {
+		counts := fn()
+		keys := make([]string, 0, len(counts))
+		for k := range counts {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, labelEscaper.Replace(k), counts[k])
+		}
+	})
+}
+
+// WriteTo writes every metric in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	entries := append([]entry(nil), r.entries...)
+	r.mu.Unlock()
+	var buf bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", e.name, e.help, e.name, e.typ)
+		e.write(&buf, e.name)
+	}
+	return buf.WriteTo(w)
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
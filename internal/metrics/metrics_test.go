@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRegistryWritesTextFormat(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("x_total", "Things.")
+	g := r.Gauge("y", "Level.")
+	s := r.Summary("z_seconds", "Durations.")
+	r.CounterFunc("e_total", "Errors.", "op", func() map[string]uint64 {
+		return map[string]uint64{"b": 2, `a"q`: 1}
+	})
+
+	c.Inc()
+	c.Inc()
+	g.SetBool(true)
+	s.Observe(250 * time.Millisecond)
+	s.Observe(250 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	want := `# HELP x_total Things.
+# TYPE x_total counter
+x_total 2
+# HELP y Level.
+# TYPE y gauge
+y 1
+# HELP z_seconds Durations.
+# TYPE z_seconds summary
+z_seconds_sum 0.5
+z_seconds_count 2
+# HELP e_total Errors.
+# TYPE e_total counter
+e_total{op="a\"q"} 1
+e_total{op="b"} 2
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
@@ -0,0 +1,181 @@
+// Package notify shows desktop notifications and asks the user questions
+// through notifications with actions (org.freedesktop.Notifications on the
+// session bus).
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	busName = "org.freedesktop.Notifications"
+	objPath = "/org/freedesktop/Notifications"
+	iface   = "org.freedesktop.Notifications"
+)
+
+type Action struct {
+	Key   string
+	Label string
+}
+
+// Answer reports what the user did with a notification sent by Ask.
+type Answer struct {
+	Key    string // the key passed to Ask
+	Action string // the chosen action key, "" when dismissed or expired
+}
+
+type Notifier struct {
+	conn    *dbus.Conn
+	answers chan Answer
+
+	mu      sync.Mutex
+	pending map[uint32]string // notification id -> Ask key
+}
+
+// Urgency is the urgency hint of a notification.
+type Urgency byte
+
+const (
+	Low Urgency = iota
+	Normal
+	Critical
+)
+
+// New connects to the session bus and checks that a notification server
+// supporting actions is running.
+func New() (*Notifier, error) {
+	conn, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	var caps []string
+	if err := conn.Object(busName, objPath).Call(iface+".GetCapabilities", 0).Store(&caps); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	hasActions := false
+	for _, c := range caps {
+		hasActions = hasActions || c == "actions"
+	}
+	if !hasActions {
+		conn.Close()
+		return nil, errors.New("notification server does not support actions")
+	}
+	if err := conn.AddMatchSignal(dbus.WithMatchObjectPath(objPath), dbus.WithMatchInterface(iface)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	n := &Notifier{conn: conn, answers: make(chan Answer, 16), pending: map[uint32]string{}}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+	go n.dispatch(signals)
+	return n, nil
+}
+
+// Dial connects to the session bus for plain notifications sent with Notify.
+// Its Answers channel is nil.
+func Dial() (*Notifier, error) {
+	conn, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	return &Notifier{conn: conn}, nil
+}
+
+func connect() (*dbus.Conn, error) {
+	conn, err := dbus.SessionBusPrivate()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Notify shows a notification that expires as the server sees fit. A
+// non-zero replaces updates that earlier notification in place, if it is
+// still shown. It returns the notification's id.
+func (n *Notifier) Notify(ctx context.Context, summary, body string, urgency Urgency, replaces uint32) (uint32, error) {
+	hints := map[string]dbus.Variant{"urgency": dbus.MakeVariant(byte(urgency))}
+	var id uint32
+	err := n.conn.Object(busName, objPath).CallWithContext(ctx, iface+".Notify", 0,
+		"ccdbind", replaces, "", summary, body, []string{}, hints, int32(-1)).Store(&id)
+	return id, err
+}
+
+// Answers delivers one Answer per notification. It is closed by Close.
+func (n *Notifier) Answers() <-chan Answer {
+	return n.answers
+}
+
+// Ask shows a notification that stays until the user picks an action or
+// dismisses it.
+func (n *Notifier) Ask(ctx context.Context, key, summary, body string, actions []Action) error {
+	flat := make([]string, 0, 2*len(actions))
+	for _, a := range actions {
+		flat = append(flat, a.Key, a.Label)
+	}
+	hints := map[string]dbus.Variant{"resident": dbus.MakeVariant(true)}
+
+	// Hold the lock across the call so a fast answer cannot be dispatched
+	// before the id is known.
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var id uint32
+	call := n.conn.Object(busName, objPath).CallWithContext(ctx, iface+".Notify", 0,
+		"ccdbind", uint32(0), "", summary, body, flat, hints, int32(0))
+	if err := call.Store(&id); err != nil {
+		return err
+	}
+	n.pending[id] = key
+	return nil
+}
+
+func (n *Notifier) dispatch(signals <-chan *dbus.Signal) {
+	defer close(n.answers)
+	for sig := range signals {
+		var a Answer
+		switch sig.Name {
+		case iface + ".ActionInvoked":
+			if len(sig.Body) < 2 {
+				continue
+			}
+			a.Action, _ = sig.Body[1].(string)
+		case iface + ".NotificationClosed":
+		default:
+			continue
+		}
+		if len(sig.Body) < 1 {
+			continue
+		}
+		id, _ := sig.Body[0].(uint32)
+		n.mu.Lock()
+		key, ok := n.pending[id]
+		delete(n.pending, id)
+		n.mu.Unlock()
+		if !ok {
+			continue // another application's notification, or already answered
+		}
+		if a.Action != "" {
+			// Resident notifications are not closed by the action itself.
+			_ = n.conn.Object(busName, objPath).Call(iface+".CloseNotification", dbus.FlagNoReplyExpected, id).Err
+		}
+		a.Key = key
+		n.answers <- a
+	}
+}
+
+func (n *Notifier) Close() error {
+	return n.conn.Close()
+}
@@ -0,0 +1,132 @@
+// Package peercred identifies the process at the other end of a unix socket
+// and decides whether it may use it.
+//
+// The kernel records the peer's credentials when it connects (SO_PEERCRED),
+// so they cannot be forged by the client, unlike anything sent over the
+// socket.
+package peercred
+
+import (
+	"fmt"
+	"net"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Cred is the peer's process and identity at connect time.
+type Cred struct {
+	PID int
+	UID int
+	GID int
+}
+
+// FromConn returns the credentials of the peer of c.
+func FromConn(c *net.UnixConn) (Cred, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return Cred{}, err
+	}
+	var uc *syscall.Ucred
+	var uerr error
+	if err := raw.Control(func(fd uintptr) {
+		uc, uerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return Cred{}, err
+	}
+	if uerr != nil {
+		return Cred{}, fmt.Errorf("SO_PEERCRED: %w", uerr)
+	}
+	return Cred{PID: int(uc.Pid), UID: int(uc.Uid), GID: int(uc.Gid)}, nil
+}
+
+// Allow is a set of users and groups. The zero value permits no one.
+type Allow struct {
+	Any  bool
+	UIDs map[int]bool
+	GIDs map[int]bool
+}
+
+// ValidEntry reports whether e has the syntax Resolve accepts: "*", a user
+// name or UID, or "@" followed by a group name or GID.
+func ValidEntry(e string) bool {
+	if e == "*" {
+		return true
+	}
+	name := strings.TrimPrefix(e, "@")
+	return name != "" && !strings.ContainsAny(name, " \t:/@*")
+}
+
+// Resolve builds an Allow from entries as checked by ValidEntry, looking up
+// user and group names.
+func Resolve(entries []string) (Allow, error) {
+	a := Allow{UIDs: map[int]bool{}, GIDs: map[int]bool{}}
+	for _, e := range entries {
+		if !ValidEntry(e) {
+			return Allow{}, fmt.Errorf("invalid entry %q", e)
+		}
+		if e == "*" {
+			a.Any = true
+			continue
+		}
+		if group, ok := strings.CutPrefix(e, "@"); ok {
+			gid, err := strconv.Atoi(group)
+			if err != nil {
+				g, lerr := user.LookupGroup(group)
+				if lerr != nil {
+					return Allow{}, lerr
+				}
+				gid, _ = strconv.Atoi(g.Gid)
+			}
+			a.GIDs[gid] = true
+			continue
+		}
+		uid, err := strconv.Atoi(e)
+		if err != nil {
+			u, lerr := user.Lookup(e)
+			if lerr != nil {
+				return Allow{}, lerr
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+		a.UIDs[uid] = true
+	}
+	return a, nil
+}
+
+// Permits reports whether c is allowed. Group entries match the peer's
+// primary group and, through the user database, its supplementary groups.
+func (a Allow) Permits(c Cred) bool {
+	if a.Any || a.UIDs[c.UID] || a.GIDs[c.GID] {
+		return true
+	}
+	if len(a.GIDs) == 0 {
+		return false
+	}
+	for _, gid := range supplementaryGroups(c.UID) {
+		if a.GIDs[gid] {
+			return true
+		}
+	}
+	return false
+}
+
+// supplementaryGroups is replaced in tests.
+var supplementaryGroups = func(uid int) []int {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil
+	}
+	out := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if gid, err := strconv.Atoi(id); err == nil {
+			out = append(out, gid)
+		}
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+package peercred
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFromConn_ReportsOwnCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := net.Dial("unix", path)
+		if err == nil {
+			defer c.Close()
+			buf := make([]byte, 1)
+			_, _ = c.Read(buf)
+		}
+	}()
+	conn, err := ln.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c, err := FromConn(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.UID != os.Getuid() || c.GID != os.Getgid() || c.PID != os.Getpid() {
+		t.Fatalf("cred = %+v, want uid=%d gid=%d pid=%d", c, os.Getuid(), os.Getgid(), os.Getpid())
+	}
+}
+
+func TestResolve_ParsesEntries(t *testing.T) {
+	a, err := Resolve([]string{"1001", "@27", "@1500"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.UIDs[1001] || !a.GIDs[27] || !a.GIDs[1500] || a.Any {
+		t.Fatalf("allow = %+v", a)
+	}
+	if a, err := Resolve([]string{"*"}); err != nil || !a.Any {
+		t.Fatalf("allow = %+v, %v", a, err)
+	}
+	for _, bad := range []string{"", "@", "a b", "x:y", "@@g"} {
+		if _, err := Resolve([]string{bad}); err == nil {
+			t.Fatalf("Resolve(%q) succeeded", bad)
+		}
+	}
+	if _, err := Resolve([]string{"no-such-user-ccdbind"}); err == nil {
+		t.Fatal("unknown user resolved")
+	}
+}
+
+func TestPermits(t *testing.T) {
+	old := supplementaryGroups
+	t.Cleanup(func() { supplementaryGroups = old })
+	supplementaryGroups = func(uid int) []int {
+		if uid == 1002 {
+			return []int{100, 27}
+		}
+		return nil
+	}
+
+	a := Allow{UIDs: map[int]bool{1001: true}, GIDs: map[int]bool{27: true}}
+	for _, tc := range []struct {
+		c    Cred
+		want bool
+	}{
+		{Cred{UID: 1001, GID: 1001}, true},  // listed user
+		{Cred{UID: 1003, GID: 27}, true},    // primary group
+		{Cred{UID: 1002, GID: 1002}, true},  // supplementary group
+		{Cred{UID: 1004, GID: 1004}, false}, // neither
+	} {
+		if got := a.Permits(tc.c); got != tc.want {
+			t.Errorf("Permits(%+v) = %v, want %v", tc.c, got, tc.want)
+		}
+	}
+	if (Allow{}).Permits(Cred{UID: 1001}) {
+		t.Fatal("zero Allow permits")
+	}
+	if !(Allow{Any: true}).Permits(Cred{UID: 4242}) {
+		t.Fatal("Any does not permit")
+	}
+}
@@ -0,0 +1,140 @@
+// Package pinreq implements the drop-file pin request API: wrapper scripts
+// and mods write a small JSON file into the requests directory asking the
+// daemon to treat a PID as a game.
+package pinreq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Policies accepted in a request. An empty policy means PolicyGame.
+const (
+	PolicyGame        = "game"
+	PolicyNoTouchGame = "no_touch_game"
+	PolicyPrefersSwap = "prefers_swap"
+)
+
+type Request struct {
+	PID    int    `json:"pid"`
+	GameID string `json:"game_id"`
+	Policy string `json:"policy,omitempty"`
+	// Scope is a scope the requester already started the process in, such
+	// as ccdpin's systemd-run scope. The daemon pins that scope for the game
+	// instead of creating game-<id>.scope and moving the process out of it.
+	Scope string `json:"scope,omitempty"`
+
+	// Path is the file the request was read from.
+	Path string `json:"-"`
+}
+
+// DefaultDir returns $XDG_RUNTIME_DIR/ccdbind/requests.
+func DefaultDir() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		return "", errors.New("XDG_RUNTIME_DIR not set")
+	}
+	return filepath.Join(base, "ccdbind", "requests"), nil
+}
+
+// Parse decodes and validates a single request.
+func Parse(data []byte) (Request, error) {
+	var r Request
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Request{}, err
+	}
+	r.GameID = strings.TrimSpace(r.GameID)
+	r.Policy = strings.TrimSpace(r.Policy)
+	r.Scope = strings.TrimSpace(r.Scope)
+	if r.PID <= 0 {
+		return Request{}, fmt.Errorf("invalid pid %d", r.PID)
+	}
+	if r.GameID == "" {
+		return Request{}, errors.New("missing game_id")
+	}
+	if r.Scope != "" && (!strings.HasSuffix(r.Scope, ".scope") || strings.ContainsAny(r.Scope, "/ ")) {
+		return Request{}, fmt.Errorf("invalid scope %q", r.Scope)
+	}
+	switch r.Policy {
+	case "":
+		r.Policy = PolicyGame
+	case PolicyGame, PolicyNoTouchGame, PolicyPrefersSwap:
+	default:
+		return Request{}, fmt.Errorf("unknown policy %q", r.Policy)
+	}
+	return r, nil
+}
+
+// Load reads every *.json request in dir, sorted by file name. Files that
+// fail to parse are removed and reported in the returned errors so a bad
+// writer cannot make the daemon re-log the same file every tick.
+func Load(dir string) ([]Request, []error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, []error{err}
+	}
+	sort.Strings(files)
+	out := make([]Request, 0, len(files))
+	var errs []error
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		r, err := Parse(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(path), err))
+			_ = os.Remove(path)
+			continue
+		}
+		r.Path = path
+		out = append(out, r)
+	}
+	return out, errs
+}
+
+// Write stores r in dir as name.json, replacing the file atomically so the
+// daemon never reads half a request, and returns r with Path set.
+func Write(dir, name string, r Request) (Request, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return Request{}, err
+	}
+	if _, err := Parse(data); err != nil {
+		return Request{}, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return Request{}, err
+	}
+	path := filepath.Join(dir, name+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return Request{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return Request{}, err
+	}
+	r.Path = path
+	return r, nil
+}
+
+// Remove deletes the request file.
+func (r Request) Remove() error {
+	if r.Path == "" {
+		return nil
+	}
+	err := os.Remove(r.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
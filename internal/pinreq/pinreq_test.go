@@ -0,0 +1,54 @@
+package pinreq
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.json":   `{"pid": 42, "game_id": "1234", "policy": "prefers_swap"}`,
+		"b.json":   `{"pid": 43, "game_id": "5678"}`,
+		"bad.json": `{"pid": 44, "game_id": "x", "policy": "turbo"}`,
+		"skip.txt": `{"pid": 45, "game_id": "y"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	reqs, errs := Load(dir)
+	if len(errs) != 1 {
+		t.Fatalf("expected one error, got %v", errs)
+	}
+	if len(reqs) != 2 || reqs[0].Policy != PolicyPrefersSwap || reqs[1].Policy != PolicyGame || reqs[1].PID != 43 {
+		t.Fatalf("unexpected requests: %+v", reqs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected invalid request to be removed")
+	}
+	if err := reqs[0].Remove(); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if reqs, _ := Load(dir); len(reqs) != 1 {
+		t.Fatalf("expected one request left, got %+v", reqs)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "requests")
+	req, err := Write(dir, "ccdpin-7", Request{PID: 42, GameID: "570", Scope: "ccdpin-7.scope"})
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	reqs, errs := Load(dir)
+	if len(errs) != 0 || len(reqs) != 1 || reqs[0].Scope != "ccdpin-7.scope" || reqs[0].Path != req.Path {
+		t.Fatalf("Load = %+v, %v", reqs, errs)
+	}
+	if _, err := Write(dir, "bad", Request{PID: 42, GameID: "570", Scope: "../game.slice"}); err == nil {
+		t.Fatal("Write accepted an invalid scope")
+	}
+}
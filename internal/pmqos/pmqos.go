@@ -0,0 +1,70 @@
+// Package pmqos holds a CPU wakeup latency limit through /dev/cpu_dma_latency.
+// While the file stays open, the kernel keeps every CPU out of idle states
+// whose exit latency exceeds the limit; closing it drops the request.
+package pmqos
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// DevicePath is the PM QoS device. Opening it usually needs root or a udev
+// rule granting the user access.
+var DevicePath = "/dev/cpu_dma_latency"
+
+// Check reports why Hold would fail for this process, or nil. The device is
+// root-only by default; a udev rule such as
+//
+//	KERNEL=="cpu_dma_latency", GROUP="users", MODE="0660"
+//
+// grants access to regular users.
+func Check() error {
+	err := syscall.Access(DevicePath, 2) // W_OK
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%s does not exist (kernel without PM QoS support?)", DevicePath)
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("no write access to %s; grant it with a udev rule or leave dma_latency unset", DevicePath)
+	}
+	return fmt.Errorf("%s: %w", DevicePath, err)
+}
+
+// Request is an active latency limit.
+type Request struct {
+	f  *os.File
+	us int
+}
+
+// Hold requests a maximum wakeup latency of us microseconds; 0 keeps the
+// CPUs out of every idle state but polling.
+func Hold(us int) (*Request, error) {
+	if us < 0 {
+		return nil, fmt.Errorf("invalid latency %dus", us)
+	}
+	f, err := os.OpenFile(DevicePath, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	var b [4]byte
+	binary.NativeEndian.PutUint32(b[:], uint32(us))
+	if _, err := f.Write(b[:]); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write %s: %w", DevicePath, err)
+	}
+	return &Request{f: f, us: us}, nil
+}
+
+// Latency returns the requested limit in microseconds.
+func (r *Request) Latency() int {
+	return r.us
+}
+
+// Release drops the request.
+func (r *Request) Release() error {
+	return r.f.Close()
+}
@@ -0,0 +1,39 @@
+package pmqos
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHoldWritesBinaryLatency(t *testing.T) {
+	DevicePath = filepath.Join(t.TempDir(), "cpu_dma_latency")
+	if err := os.WriteFile(DevicePath, nil, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	req, err := Hold(20)
+	if err != nil {
+		t.Fatalf("Hold: %v", err)
+	}
+	defer req.Release()
+	b, err := os.ReadFile(DevicePath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(b) != 4 || binary.NativeEndian.Uint32(b) != 20 || req.Latency() != 20 {
+		t.Fatalf("unexpected device contents %v", b)
+	}
+
+	if err := Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	DevicePath += ".missing"
+	if err := Check(); err == nil {
+		t.Fatalf("expected Check to fail for a missing device")
+	}
+	if _, err := Hold(-1); err == nil {
+		t.Fatalf("expected error for negative latency")
+	}
+}
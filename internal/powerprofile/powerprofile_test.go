@@ -0,0 +1,16 @@
+package powerprofile
+
+import "testing"
+
+func TestValid(t *testing.T) {
+	for _, p := range []string{"", Performance, Balanced, PowerSaver} {
+		if !Valid(p) {
+			t.Fatalf("Valid(%q) = false", p)
+		}
+	}
+	for _, p := range []string{"Performance", "powersave", "low-power"} {
+		if Valid(p) {
+			t.Fatalf("Valid(%q) = true", p)
+		}
+	}
+}
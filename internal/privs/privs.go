@@ -0,0 +1,83 @@
+// Package privs drops process privileges the daemon never needs.
+//
+// ccdbind reads /proc for processes owned by its own UID and talks to the
+// systemd user manager over the session bus; neither requires any capability.
+// Its optional system-wide features go through polkit or through files the
+// administrator made writable, never through a capability.
+package privs
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prCapbsetDrop        = 24
+	prSetNoNewPrivs      = 38
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+
+	linuxCapabilityVersion3 = 0x20080522
+	lastCap                 = 63
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// Drop sets no_new_privs and clears the ambient, bounding, effective,
+// permitted and inheritable capability sets. Clearing the bounding set needs
+// CAP_SETPCAP, so EPERM there is ignored; for an unprivileged user daemon
+// the set is usually already empty of anything useful.
+func Drop() error {
+	if err := prctl(prSetNoNewPrivs, 1, 0); err != nil {
+		return fmt.Errorf("set no_new_privs: %w", err)
+	}
+	if err := prctl(prCapAmbient, prCapAmbientClearAll, 0); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return fmt.Errorf("clear ambient caps: %w", err)
+	}
+	for c := uintptr(0); c <= lastCap; c++ {
+		if err := prctl(prCapbsetDrop, c, 0); err != nil {
+			if errors.Is(err, syscall.EINVAL) {
+				break
+			}
+			if errors.Is(err, syscall.EPERM) {
+				break
+			}
+			return fmt.Errorf("drop bounding cap %d: %w", c, err)
+		}
+	}
+	hdr := capHeader{version: linuxCapabilityVersion3}
+	var data [2]capData
+	if err := allThreads(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); err != nil {
+		return fmt.Errorf("capset: %w", err)
+	}
+	return nil
+}
+
+func prctl(option, arg2, arg3 uintptr) error {
+	return allThreads(syscall.SYS_PRCTL, option, arg2, arg3)
+}
+
+// allThreads applies a per-thread syscall to every thread of the process.
+// AllThreadsSyscall is unavailable in cgo builds; there the calling thread is
+// the best we can do, so Drop should run early in main.
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3)
+	if errno == syscall.ENOTSUP {
+		_, _, errno = syscall.RawSyscall(trap, a1, a2, a3)
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
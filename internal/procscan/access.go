@@ -0,0 +1,148 @@
+package procscan
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Access counts how many of the user's processes the last Scan could read.
+// A process is denied when its exe link cannot be read for lack of
+// permission; the kernel checks environ the same way.
+type Access struct {
+	Owned  int `json:"owned"`
+	Denied int `json:"denied"`
+}
+
+// Broad reports whether so many reads failed that game detection cannot be
+// trusted: at least three processes and half of the user's.
+func (a Access) Broad() bool {
+	return a.Denied >= 3 && a.Denied*2 >= a.Owned
+}
+
+// Access returns the read statistics of the last Scan.
+func (s *Scanner) Access() Access {
+	return s.access
+}
+
+// Problem is a likely cause of denied /proc reads with its remedy.
+type Problem struct {
+	Cause string `json:"cause"`
+	Fix   string `json:"fix"`
+}
+
+func (p Problem) String() string {
+	return p.Cause + "; " + p.Fix
+}
+
+// Diagnose looks for the usual reasons /proc/PID/exe and environ of the
+// user's own processes cannot be read. It always returns at least one
+// problem, falling back to the causes it cannot check for.
+func Diagnose() []Problem {
+	return diagnoseAt("/")
+}
+
+func diagnoseAt(root string) []Problem {
+	var out []Problem
+
+	if opts, ok := procMountOptions(filepath.Join(root, "proc/self/mounts")); ok {
+		hidepid, gid := "", ""
+		for _, o := range strings.Split(opts, ",") {
+			if v, ok := strings.CutPrefix(o, "hidepid="); ok {
+				hidepid = v
+			}
+			if v, ok := strings.CutPrefix(o, "gid="); ok {
+				gid = v
+			}
+		}
+		if hidepid != "" && hidepid != "0" && hidepid != "off" {
+			fix := "remount /proc without hidepid, or run ccdbind in the exempt group"
+			if gid != "" {
+				fix = fmt.Sprintf("add ccdbind's user to group %s (the gid= exempt from hidepid) or remount /proc without hidepid", gid)
+			}
+			out = append(out, Problem{Cause: "/proc is mounted with hidepid=" + hidepid, Fix: fix})
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(root, "proc/sys/kernel/yama/ptrace_scope")); err == nil {
+		if scope := strings.TrimSpace(string(data)); scope == "2" || scope == "3" {
+			out = append(out, Problem{
+				Cause: "Yama restricts ptrace (kernel.yama.ptrace_scope=" + scope + ")",
+				Fix:   "set kernel.yama.ptrace_scope=1 in /etc/sysctl.d, or list the game executables in exe_allowlist",
+			})
+		}
+	}
+
+	if container := containerName(root); container != "" {
+		out = append(out, Problem{
+			Cause: "ccdbind runs inside a " + container + " container, which may hide the games' processes or deny reading them",
+			Fix:   "run ccdbind as a user service on the host",
+		})
+	}
+
+	if data, err := os.ReadFile(filepath.Join(root, "sys/kernel/security/lsm")); err == nil {
+		for _, lsm := range strings.Split(strings.TrimSpace(string(data)), ",") {
+			if lsm == "selinux" || lsm == "apparmor" {
+				out = append(out, Problem{
+					Cause: lsm + " is active and may deny ptrace-style reads",
+					Fix:   "check the audit log (journalctl -k | grep -i denied) for ccdbind",
+				})
+			}
+		}
+	}
+
+	if len(out) == 0 {
+		out = append(out, Problem{
+			Cause: "the processes may be non-dumpable (setuid, or anti-cheat calling PR_SET_DUMPABLE)",
+			Fix:   "list the game executables in exe_allowlist, or pin them with `ccdbind pin`",
+		})
+	}
+	return out
+}
+
+// procMountOptions returns the mount options of the last /proc mount in a
+// mounts file.
+func procMountOptions(path string) (string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	opts, found := "", false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 4 && fields[1] == "/proc" && fields[2] == "proc" {
+			opts, found = fields[3], true
+		}
+	}
+	return opts, found
+}
+
+func containerName(root string) string {
+	if _, err := os.Stat(filepath.Join(root, ".flatpak-info")); err == nil {
+		return "Flatpak"
+	}
+	if _, err := os.Stat(filepath.Join(root, ".dockerenv")); err == nil {
+		return "Docker"
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "run/.containerenv")); err == nil {
+		if strings.Contains(string(data), `engine="podman`) {
+			return "Podman"
+		}
+		return "OCI"
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "run/systemd/container")); err == nil {
+		if name := strings.TrimSpace(string(data)); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func isPermission(err error) bool {
+	return errors.Is(err, os.ErrPermission)
+}
@@ -0,0 +1,65 @@
+package procscan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel, content string) {
+		t.Helper()
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	if got := diagnoseAt(root); len(got) != 1 || !strings.Contains(got[0].Cause, "non-dumpable") {
+		t.Fatalf("fallback = %v", got)
+	}
+
+	write("proc/self/mounts", "sysfs /sys sysfs rw 0 0\nproc /proc proc rw,nosuid,hidepid=invisible,gid=26 0 0\n")
+	write("proc/sys/kernel/yama/ptrace_scope", "2\n")
+	write("run/.containerenv", "engine=\"podman-4.9\"\n")
+	write("sys/kernel/security/lsm", "lockdown,capability,yama,apparmor\n")
+	got := diagnoseAt(root)
+	if len(got) != 4 {
+		t.Fatalf("got %d problems: %v", len(got), got)
+	}
+	for i, want := range []string{"hidepid=invisible", "ptrace_scope=2", "Podman", "apparmor"} {
+		if !strings.Contains(got[i].Cause, want) {
+			t.Fatalf("problem %d = %q, want %q", i, got[i].Cause, want)
+		}
+	}
+	if !strings.Contains(got[0].Fix, "group 26") {
+		t.Fatalf("hidepid fix = %q", got[0].Fix)
+	}
+
+	write("proc/self/mounts", "proc /proc proc rw,hidepid=0 0 0\n")
+	write("proc/sys/kernel/yama/ptrace_scope", "1\n")
+	if got := diagnoseAt(root); len(got) != 2 {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestAccessBroad(t *testing.T) {
+	for _, tc := range []struct {
+		a    Access
+		want bool
+	}{
+		{Access{Owned: 40, Denied: 2}, false},
+		{Access{Owned: 40, Denied: 10}, false},
+		{Access{Owned: 40, Denied: 20}, true},
+		{Access{Owned: 2, Denied: 2}, false},
+	} {
+		if got := tc.a.Broad(); got != tc.want {
+			t.Fatalf("%+v.Broad() = %v", tc.a, got)
+		}
+	}
+}
@@ -123,17 +123,8 @@ func procStartTimeAt(procRoot string, pid int) (uint64, error) {
 }
 
 func exeBasenameLowerAt(procRoot string, pid int) string {
-	path := filepath.Join(procRoot, strconv.Itoa(pid), "exe")
-	target, err := os.Readlink(path)
-	if err != nil {
-		return ""
-	}
-	base := filepath.Base(target)
-	base = strings.TrimSpace(base)
-	if base == "" || base == "." || base == "/" {
-		return ""
-	}
-	return strings.ToLower(base)
+	base, _ := exeNameAt(procRoot, pid)
+	return base
 }
 
 func isOwnedByUIDAt(procRoot string, pid int, uid int) (bool, error) {
@@ -0,0 +1,36 @@
+package procscan
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Cgroup returns the unified (v2) cgroup path of pid, e.g.
+// "/user.slice/user-1000.slice/user@1000.service/app.slice/foo.scope".
+func Cgroup(pid int) (string, error) {
+	return cgroupAt("/proc", pid)
+}
+
+func cgroupAt(procRoot string, pid int) (string, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if rest, ok := strings.CutPrefix(line, "0::"); ok {
+			return strings.TrimSpace(rest), nil
+		}
+	}
+	return "", errors.New("no cgroup v2 entry")
+}
+
+// InUserManager reports whether cgroup lies inside the systemd user manager
+// subtree of uid, i.e. the part of the hierarchy ccdbind is allowed to touch.
+func InUserManager(cgroup string, uid int) bool {
+	prefix := fmt.Sprintf("/user.slice/user-%d.slice/user@%d.service/", uid, uid)
+	return strings.HasPrefix(cgroup, prefix)
+}
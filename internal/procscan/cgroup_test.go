@@ -0,0 +1,29 @@
+package procscan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCgroupAndInUserManager(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "42")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	content := "0::/user.slice/user-1000.slice/user@1000.service/app.slice/game.scope\n"
+	if err := os.WriteFile(filepath.Join(dir, "cgroup"), []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cg, err := cgroupAt(root, 42)
+	if err != nil {
+		t.Fatalf("cgroupAt: %v", err)
+	}
+	if !InUserManager(cg, 1000) {
+		t.Fatalf("expected %q inside user manager", cg)
+	}
+	if InUserManager(cg, 1001) || InUserManager("/system.slice/foo.service", 1000) {
+		t.Fatalf("unexpected match outside user manager")
+	}
+}
@@ -0,0 +1,139 @@
+package procscan
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/gameid"
+	"github.com/Reidond/ccdbind/internal/logging"
+)
+
+// Reading the environment of every user process on every Scan is the bulk
+// of its cost, and races with processes that exit in between. With
+// UseCgroups, Scan lists the processes from the cgroup.procs files of the
+// user's slice instead of /proc, and reads the env keys only where a game
+// can have inherited them from a launcher: in a cgroup that also holds an
+// ignored executable (Steam, Lutris and other launchers start games in
+// their own cgroup), or in a game scope under game.slice. A scope named
+// after a Steam shortcut (steam_app_<id>, as desktops name the scopes of
+// .desktop launches) gives the game ID without reading anything.
+
+// IDSourceCgroup is the IDSource of a game ID taken from the name of the
+// process's cgroup.
+const IDSourceCgroup = "cgroup"
+
+// UseCgroups makes Scan list processes from the cgroups below dir, the
+// user's slice (e.g. /sys/fs/cgroup/user.slice/user-1000.slice), rather
+// than from /proc. An empty dir lists /proc again. Scan falls back to /proc
+// when dir cannot be read.
+func (s *Scanner) UseCgroups(dir string) {
+	s.cgroupDir = dir
+	s.cgroupWarned = false
+}
+
+// listPIDs returns the processes Scan looks at. With UseCgroups it also
+// returns the cgroup of each, relative to the cgroup directory; the map is
+// nil otherwise.
+func (s *Scanner) listPIDs() ([]int, map[int]string, error) {
+	if s.cgroupDir != "" {
+		pids, cgroups, err := listCgroupPIDs(s.cgroupDir)
+		if err == nil {
+			return pids, cgroups, nil
+		}
+		if !s.cgroupWarned {
+			s.cgroupWarned = true
+			logging.Warnf(nil, "scan_backend = %q: %v; scanning /proc instead", "cgroup", err)
+		}
+	}
+	ents, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, nil, err
+	}
+	pids := make([]int, 0, len(ents))
+	for _, ent := range ents {
+		if !ent.IsDir() {
+			continue
+		}
+		if pid, err := strconv.Atoi(ent.Name()); err == nil && pid > 0 {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil, nil
+}
+
+// listCgroupPIDs reads cgroup.procs in dir and every cgroup below it.
+func listCgroupPIDs(dir string) ([]int, map[int]string, error) {
+	if _, err := os.Stat(filepath.Join(dir, "cgroup.procs")); err != nil {
+		return nil, nil, err
+	}
+	var pids []int
+	cgroups := map[int]string{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// A cgroup removed during the walk.
+			if p != dir {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, p)
+		f, err := os.Open(filepath.Join(p, "cgroup.procs"))
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			pid, err := strconv.Atoi(strings.TrimSpace(sc.Text()))
+			if err != nil || pid <= 0 {
+				continue
+			}
+			if _, dup := cgroups[pid]; !dup {
+				pids = append(pids, pid)
+			}
+			cgroups[pid] = filepath.ToSlash(rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Ints(pids)
+	return pids, cgroups, nil
+}
+
+// steamAppID returns the app ID in a cgroup named after a Steam shortcut,
+// e.g. "app-gnome-steam_app_1245620-4242.scope", or "".
+func steamAppID(cg string) string {
+	name := cg[strings.LastIndexByte(cg, '/')+1:]
+	_, rest, ok := strings.Cut(name, "steam_app_")
+	if !ok {
+		return ""
+	}
+	end := 0
+	for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+		end++
+	}
+	if end == 0 || (end < len(rest) && !strings.ContainsRune("-@.", rune(rest[end]))) {
+		return ""
+	}
+	return gameid.Normalize(rest[:end])
+}
+
+// needsEnviron reports whether the processes of cg may carry the env keys:
+// cg holds a launcher, or is a game scope below game.slice.
+func needsEnviron(cg string, launcherCgroups map[string]bool) bool {
+	if launcherCgroups[cg] {
+		return true
+	}
+	dir := cg[:max(strings.LastIndexByte(cg, '/'), 0)]
+	return dir == "game.slice" || strings.HasSuffix(dir, "/game.slice")
+}
@@ -0,0 +1,98 @@
+package procscan
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func writeCgroupProcs(t *testing.T, dir, cg string, pids ...int) {
+	t.Helper()
+	p := filepath.Join(dir, cg)
+	if err := os.MkdirAll(p, 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	data := ""
+	for _, pid := range pids {
+		data += strconv.Itoa(pid) + "\n"
+	}
+	if err := os.WriteFile(filepath.Join(p, "cgroup.procs"), []byte(data), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestListCgroupPIDs(t *testing.T) {
+	dir := t.TempDir()
+	writeCgroupProcs(t, dir, "")
+	writeCgroupProcs(t, dir, "session-2.scope", 30)
+	writeCgroupProcs(t, dir, "user@1000.service/app.slice/app-steam@autostart.service", 20, 10)
+	writeCgroupProcs(t, dir, "user@1000.service/game.slice/game-42.scope", 40)
+	pids, cgroups, err := listCgroupPIDs(dir)
+	if err != nil {
+		t.Fatalf("listCgroupPIDs: %v", err)
+	}
+	if !reflect.DeepEqual(pids, []int{10, 20, 30, 40}) {
+		t.Fatalf("pids = %v", pids)
+	}
+	if cgroups[10] != "user@1000.service/app.slice/app-steam@autostart.service" || cgroups[30] != "session-2.scope" {
+		t.Fatalf("cgroups = %v", cgroups)
+	}
+	if _, _, err := listCgroupPIDs(filepath.Join(dir, "missing")); err == nil {
+		t.Fatalf("expected an error for a missing cgroup")
+	}
+}
+
+func TestSteamAppID(t *testing.T) {
+	for cg, want := range map[string]string{
+		"user@1000.service/app.slice/app-gnome-steam_app_1245620-4242.scope": "1245620",
+		"app.slice/app-steam_app_570@a1b2.service":                           "570",
+		"app.slice/app-steam_app_570.scope":                                  "570",
+		"app.slice/app-gnome-steam-4242.scope":                               "",
+		"app.slice/app-steam_app_570x.scope":                                 "",
+		"app.slice/app-steam_app_-1.scope":                                   "",
+		"steam_app_1.slice/app-foo.scope":                                    "",
+	} {
+		if got := steamAppID(cg); got != want {
+			t.Fatalf("steamAppID(%q) = %q, want %q", cg, got, want)
+		}
+	}
+}
+
+func TestNeedsEnviron(t *testing.T) {
+	launchers := map[string]bool{"user@1000.service/app.slice/app-steam@autostart.service": true}
+	for cg, want := range map[string]bool{
+		"user@1000.service/app.slice/app-steam@autostart.service": true,
+		"user@1000.service/game.slice/game-42.scope":              true,
+		"game.slice/game-42.scope":                                true,
+		"user@1000.service/app.slice/app-foo.scope":               false,
+		"user@1000.service/app.slice/game.slice":                  false,
+		"session-2.scope":                                         false,
+	} {
+		if got := needsEnviron(cg, launchers); got != want {
+			t.Fatalf("needsEnviron(%q) = %v, want %v", cg, got, want)
+		}
+	}
+}
+
+func TestScanCgroups(t *testing.T) {
+	dir := t.TempDir()
+	writeCgroupProcs(t, dir, "")
+	writeCgroupProcs(t, dir, "user@1000.service/app.slice/app-gnome-steam_app_42-1.scope", os.Getpid())
+	s := NewScanner(os.Getuid(), []string{"SteamAppId"}, nil, nil, nil)
+	s.UseCgroups(dir)
+	games, err := s.Scan()
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	procs := games["42"]
+	if len(procs) != 1 || procs[0].PID != os.Getpid() || procs[0].IDSource != IDSourceCgroup {
+		t.Fatalf("Scan = %v, want this process as game 42", games)
+	}
+
+	s.UseCgroups(filepath.Join(dir, "missing"))
+	if _, err := s.Scan(); err != nil {
+		t.Fatalf("Scan falling back to /proc: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package procscan
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Detector finds games that carry none of the env keys and are not on the
+// exe allowlist, typically ones started outside Steam. Detectors run in
+// order on every process Scan could not place otherwise, after the Steam
+// reaper lookup; the first game ID returned wins.
+type Detector interface {
+	// Name is the IDSource of the game IDs it returns.
+	Name() string
+	// Detect returns the game ID of p, or "" if p is not a game.
+	Detect(p *Proc) string
+}
+
+// Built-in detector names, see NewDetector.
+const (
+	DetectLauncherEnv = "launcher_env"
+	DetectWine        = "wine"
+	DetectFullscreen  = "fullscreen"
+)
+
+// DetectorNames lists the built-in detectors.
+var DetectorNames = []string{DetectLauncherEnv, DetectWine, DetectFullscreen}
+
+// NewDetector returns the built-in detector called name.
+func NewDetector(name string) (Detector, error) {
+	switch name {
+	case DetectLauncherEnv:
+		return launcherEnvDetector{}, nil
+	case DetectWine:
+		return wineDetector{}, nil
+	case DetectFullscreen:
+		return newFullscreenDetector(), nil
+	}
+	return nil, fmt.Errorf("unknown detector %q", name)
+}
+
+// SetDetectors replaces the detectors Scan and FastScan consult.
+func (s *Scanner) SetDetectors(ds []Detector) {
+	s.detectors = ds
+}
+
+// Proc is a process handed to a Detector. Its files are read on demand.
+type Proc struct {
+	PID int
+	// Exe is the lowercased executable name, see exeNameAt.
+	Exe string
+
+	s       *Scanner
+	root    string
+	env     map[string]string
+	cmdline []string
+}
+
+// Env returns the value of key in the process's initial environment.
+func (p *Proc) Env(key string) string {
+	if p.env == nil {
+		p.env = environAt(p.root, p.PID)
+	}
+	return p.env[key]
+}
+
+// Cmdline returns the process's argument vector.
+func (p *Proc) Cmdline() []string {
+	if p.cmdline == nil {
+		p.cmdline = cmdlineAt(p.root, p.PID)
+	}
+	return p.cmdline
+}
+
+// Ignored reports whether exe is on the ignore list.
+func (p *Proc) Ignored(exe string) bool {
+	return p.s.ignored(exe)
+}
+
+// IgnoredAncestor reports whether a process above p runs an ignored
+// executable, i.e. p was started by Steam or another launcher ccdbind
+// leaves alone.
+func (p *Proc) IgnoredAncestor() bool {
+	cur := ppidAt(p.root, p.PID)
+	for depth := 0; depth < maxAncestry && cur > 1; depth++ {
+		if name, err := exeNameAt(p.root, cur); err == nil && p.Ignored(name) {
+			return true
+		}
+		cur = ppidAt(p.root, cur)
+	}
+	return false
+}
+
+func (s *Scanner) detect(procRoot string, pid int, exe string) (string, string) {
+	if len(s.detectors) == 0 {
+		return "", ""
+	}
+	p := &Proc{PID: pid, Exe: exe, s: s, root: procRoot}
+	for _, d := range s.detectors {
+		if id := d.Detect(p); id != "" {
+			return id, d.Name()
+		}
+	}
+	return "", ""
+}
+
+func environAt(procRoot string, pid int) map[string]string {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "environ"))
+	if err != nil {
+		return map[string]string{}
+	}
+	env := map[string]string{}
+	for _, entry := range bytes.Split(data, []byte{0}) {
+		if k, v, ok := bytes.Cut(entry, []byte{'='}); ok && len(k) > 0 {
+			env[string(k)] = string(v)
+		}
+	}
+	return env
+}
+
+// launcherEnvKeys are the variables game launchers other than Steam export
+// to the games they start, with the prefix that keeps their IDs apart.
+var launcherEnvKeys = []struct{ key, prefix string }{
+	{"LUTRIS_GAME_UUID", "lutris:"},
+	{"HEROIC_APP_NAME", "heroic:"},
+	{"BOTTLE_NAME", "bottles:"},
+}
+
+// launcherEnvDetector names games started by Lutris, Heroic or Bottles by
+// the launcher's own game ID.
+type launcherEnvDetector struct{}
+
+func (launcherEnvDetector) Name() string { return DetectLauncherEnv }
+
+func (launcherEnvDetector) Detect(p *Proc) string {
+	for _, k := range launcherEnvKeys {
+		if v := strings.TrimSpace(p.Env(k.key)); v != "" {
+			return k.prefix + v
+		}
+	}
+	return ""
+}
+
+// wineLoaders are the executables a Windows program runs in under wine.
+var wineLoaders = map[string]bool{
+	"wine":             true,
+	"wine64":           true,
+	"wine-preloader":   true,
+	"wine64-preloader": true,
+}
+
+// wineSystem are programs wine runs on its own behalf; ignore_exe covers
+// the long-running ones.
+var wineSystem = map[string]bool{
+	"start.exe":           true,
+	"wineboot.exe":        true,
+	"winemenubuilder.exe": true,
+	"rundll32.exe":        true,
+	"regsvr32.exe":        true,
+	"msiexec.exe":         true,
+	"cmd.exe":             true,
+}
+
+// wineProgram returns the lowercased name of the Windows program a wine
+// process runs, from its argv[0] ("C:\\Games\\Game.exe"), or "".
+func wineProgram(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	arg := args[0]
+	if i := strings.LastIndexAny(arg, `\/`); i >= 0 {
+		arg = arg[i+1:]
+	}
+	arg = strings.ToLower(strings.TrimSpace(arg))
+	if !strings.HasSuffix(arg, ".exe") || arg == ".exe" {
+		return ""
+	}
+	return arg
+}
+
+// wineDetector names a Windows program run under wine after its exe, unless
+// it is one of wine's own or was started by an ignored launcher such as
+// Steam, whose games carry the env keys instead.
+type wineDetector struct{}
+
+func (wineDetector) Name() string { return DetectWine }
+
+func (wineDetector) Detect(p *Proc) string {
+	if !wineLoaders[p.Exe] {
+		return ""
+	}
+	prog := wineProgram(p.Cmdline())
+	if prog == "" || wineSystem[prog] || p.Ignored(prog) || p.IgnoredAncestor() {
+		return ""
+	}
+	return prog
+}
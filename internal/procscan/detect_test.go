@@ -0,0 +1,93 @@
+package procscan
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDetectors(t *testing.T) {
+	root := t.TempDir()
+	writeSandboxProc(t, root, 100, 1, "/usr/bin/steam", "steam")
+	writeSandboxProc(t, root, 110, 100, "/opt/wine/bin/wine64-preloader", `C:\Games\Steamed.exe`)
+	writeSandboxProc(t, root, 200, 1, "/usr/bin/python3.12", "lutris")
+	writeSandboxProc(t, root, 210, 200, "/opt/wine/bin/wine64-preloader", `Z:\home\u\Games\Witcher\Witcher3.EXE`)
+	writeSandboxProc(t, root, 220, 200, "/opt/wine/bin/wine64-preloader", `C:\windows\system32\services.exe`)
+	writeSandboxProc(t, root, 230, 200, "/opt/wine/bin/wine-preloader", `C:\windows\command\start.exe`)
+	writeSandboxProc(t, root, 240, 200, "/usr/bin/native-game", "native-game")
+	writeProc(t, root, 300, "/usr/bin/heroic-game", "HEROIC_APP_NAME=Fortnite\x00LUTRIS_GAME_UUID=\x00", "")
+
+	s := NewScanner(1000, nil, nil, []string{"steam", "services.exe"}, nil)
+	var ds []Detector
+	for _, name := range []string{DetectLauncherEnv, DetectWine} {
+		d, err := NewDetector(name)
+		if err != nil {
+			t.Fatalf("NewDetector(%q): %v", name, err)
+		}
+		ds = append(ds, d)
+	}
+	s.SetDetectors(ds)
+	for _, tc := range []struct {
+		pid     int
+		exe     string
+		id, src string
+	}{
+		{110, "wine64-preloader", "", ""},
+		{210, "wine64-preloader", "witcher3.exe", DetectWine},
+		{220, "wine64-preloader", "", ""},
+		{230, "wine-preloader", "", ""},
+		{240, "native-game", "", ""},
+		{300, "heroic-game", "heroic:Fortnite", DetectLauncherEnv},
+	} {
+		if id, src := s.detect(root, tc.pid, tc.exe); id != tc.id || src != tc.src {
+			t.Fatalf("detect(%d) = %q, %q; want %q, %q", tc.pid, id, src, tc.id, tc.src)
+		}
+	}
+	if _, err := NewDetector("x11"); err == nil {
+		t.Fatalf("expected an error for an unknown detector")
+	}
+}
+
+func TestFullscreenDetector(t *testing.T) {
+	root := t.TempDir()
+	writeSandboxProc(t, root, 10, 1, "/opt/wine/bin/wine64-preloader", `C:\Game\Game.exe`)
+	writeSandboxProc(t, root, 20, 1, "/usr/bin/mpv", "mpv")
+	calls := 0
+	d := newFullscreenDetector()
+	d.query = func(context.Context) (int, error) {
+		calls++
+		return 10, nil
+	}
+	s := NewScanner(1000, nil, nil, nil, nil)
+	s.SetDetectors([]Detector{d})
+	if id, src := s.detect(root, 10, "wine64-preloader"); id != "game.exe" || src != DetectFullscreen {
+		t.Fatalf("detect(10) = %q, %q", id, src)
+	}
+	if id, _ := s.detect(root, 20, "mpv"); id != "" {
+		t.Fatalf("detect(20) = %q, want none", id)
+	}
+	if calls != 1 {
+		t.Fatalf("queried %d times within the TTL", calls)
+	}
+}
+
+func TestParseXprop(t *testing.T) {
+	for in, want := range map[string]string{
+		"_NET_ACTIVE_WINDOW(WINDOW): window id # 0x3a00007\n":      "0x3a00007",
+		"_NET_ACTIVE_WINDOW(WINDOW): window id # 0x3a00007, 0x0\n": "0x3a00007",
+		"_NET_ACTIVE_WINDOW(WINDOW): window id # 0x0\n":            "",
+		"_NET_ACTIVE_WINDOW:  not found.\n":                        "",
+	} {
+		if got := parseActiveWindow(in); got != want {
+			t.Fatalf("parseActiveWindow(%q) = %q, want %q", in, got, want)
+		}
+	}
+	for in, want := range map[string]int{
+		"_NET_WM_STATE(ATOM) = _NET_WM_STATE_FOCUSED, _NET_WM_STATE_FULLSCREEN\n_NET_WM_PID(CARDINAL) = 4242\n": 4242,
+		"_NET_WM_STATE(ATOM) = _NET_WM_STATE_MAXIMIZED_VERT\n_NET_WM_PID(CARDINAL) = 4242\n":                    0,
+		"_NET_WM_STATE(ATOM) = _NET_WM_STATE_FULLSCREEN\n_NET_WM_PID:  not found.\n":                            0,
+	} {
+		if got := parseFullscreenPID(in); got != want {
+			t.Fatalf("parseFullscreenPID(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
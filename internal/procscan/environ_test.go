@@ -1,10 +1,47 @@
 package procscan
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
 
-func TestToSetLower(t *testing.T) {
-	set := toSetLower([]string{" a ", "", "A"})
-	if len(set) != 1 {
-		t.Fatalf("expected 1, got %d", len(set))
+func TestIgnored(t *testing.T) {
+	s := NewScanner(1000, nil, []string{"Game.exe", "^bench-.*$"}, []string{" Steam ", "", "*.exe", "^wine.*", "bench-x", "[bad"}, nil)
+	for exe, want := range map[string]bool{
+		"steam":            true,
+		"services.exe":     true,
+		"game.exe":         false,
+		"wine64-preloader": true,
+		"bench-y":          false,
+		"bench-x":          true,
+		"native":           false,
+	} {
+		if got := s.ignored(exe); got != want {
+			t.Fatalf("ignored(%q) = %v, want %v", exe, got, want)
+		}
+	}
+}
+
+func TestGameIDFromEnviron_Normalizes(t *testing.T) {
+	root := t.TempDir()
+	write := func(pid, env string) {
+		dir := filepath.Join(root, pid)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "environ"), []byte(env), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A non-Steam shortcut: SteamAppId=0, SteamGameId is the 64-bit game ID.
+	write("10", "SteamAppId=0\x00SteamGameId=13493037702022430720\x00")
+	write("11", "STEAM_COMPAT_APP_ID=3141592653\x00")
+
+	s := NewScanner(1000, []string{"SteamAppId", "SteamGameId", "STEAM_COMPAT_APP_ID"}, nil, nil, nil)
+	for _, pid := range []int{10, 11} {
+		if id, _ := s.gameIDFromEnvironAt(root, pid); id != "3141592653" {
+			t.Fatalf("pid %d: game id %q", pid, id)
+		}
 	}
 }
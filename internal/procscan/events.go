@@ -0,0 +1,159 @@
+package procscan
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Proc connector constants from linux/connector.h and linux/cn_proc.h.
+const (
+	netlinkConnector = 11
+	cnIdxProc        = 1
+	cnValProc        = 1
+
+	procCnMcastListen = 1
+
+	procEventFork = 0x00000001
+	procEventExec = 0x00000002
+	procEventExit = 0x80000000
+
+	cnMsgLen       = 20 // struct cn_msg without data
+	procEventHdrSz = 16 // what, cpu, timestamp_ns
+)
+
+type EventKind int
+
+const (
+	EventFork EventKind = iota + 1
+	EventExec
+	EventExit
+	// EventLost means the kernel dropped events because the socket buffer
+	// overflowed; the caller should rescan /proc.
+	EventLost
+)
+
+// Event is a process lifecycle event for a thread group leader. Thread
+// creation and exit are filtered out.
+type Event struct {
+	Kind      EventKind
+	PID       int
+	ParentPID int // EventFork only
+}
+
+// Events receives process events from the kernel proc connector.
+type Events struct {
+	f *os.File
+}
+
+// ListenEvents subscribes to the proc connector. Unprivileged listeners need
+// Linux 6.6 or newer; older kernels fail with EPERM and the caller should
+// keep polling.
+func ListenEvents() (*Events, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, netlinkConnector)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: cnIdxProc}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("bind proc connector: %w", err)
+	}
+	// Ask for a larger receive buffer so bursts (a game spawning dozens of
+	// wine processes) don't overflow it; failure just keeps the default.
+	_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 1<<20)
+	if err := syscall.Sendto(fd, listenMessage(), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("subscribe proc connector: %w", err)
+	}
+	// Through the runtime poller Close unblocks a pending Read.
+	return &Events{f: os.NewFile(uintptr(fd), "proc-connector")}, nil
+}
+
+// Read blocks until the kernel delivers events. An overflowed receive
+// buffer is reported as a single EventLost rather than an error.
+func (e *Events) Read() ([]Event, error) {
+	buf := make([]byte, 8192)
+	n, err := e.f.Read(buf)
+	if errors.Is(err, syscall.ENOBUFS) {
+		return []Event{{Kind: EventLost}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseEvents(buf[:n])
+}
+
+func (e *Events) Close() error {
+	return e.f.Close()
+}
+
+func listenMessage() []byte {
+	const total = syscall.NLMSG_HDRLEN + cnMsgLen + 4
+	b := make([]byte, total)
+	ne := binary.NativeEndian
+	ne.PutUint32(b[0:], total)
+	ne.PutUint16(b[4:], syscall.NLMSG_DONE)
+	ne.PutUint32(b[12:], uint32(os.Getpid()))
+	cn := b[syscall.NLMSG_HDRLEN:]
+	ne.PutUint32(cn[0:], cnIdxProc)
+	ne.PutUint32(cn[4:], cnValProc)
+	ne.PutUint16(cn[16:], 4)
+	ne.PutUint32(cn[cnMsgLen:], procCnMcastListen)
+	return b
+}
+
+// parseEvents decodes the netlink messages in one datagram. Unknown event
+// types and non-leader threads are skipped.
+func parseEvents(buf []byte) ([]Event, error) {
+	msgs, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return nil, fmt.Errorf("parse netlink message: %w", err)
+	}
+	ne := binary.NativeEndian
+	out := make([]Event, 0, len(msgs))
+	for _, m := range msgs {
+		if len(m.Data) < cnMsgLen+procEventHdrSz {
+			continue
+		}
+		if ne.Uint32(m.Data[0:]) != cnIdxProc || ne.Uint32(m.Data[4:]) != cnValProc {
+			continue
+		}
+		ev := m.Data[cnMsgLen:]
+		what := ne.Uint32(ev[0:])
+		data := ev[procEventHdrSz:]
+		switch what {
+		case procEventFork:
+			if len(data) < 16 {
+				continue
+			}
+			parentTgid := ne.Uint32(data[4:])
+			childPid, childTgid := ne.Uint32(data[8:]), ne.Uint32(data[12:])
+			if childPid != childTgid {
+				continue
+			}
+			out = append(out, Event{Kind: EventFork, PID: int(childTgid), ParentPID: int(parentTgid)})
+		case procEventExec, procEventExit:
+			if len(data) < 8 {
+				continue
+			}
+			pid, tgid := ne.Uint32(data[0:]), ne.Uint32(data[4:])
+			if pid != tgid {
+				continue
+			}
+			kind := EventExec
+			if what == procEventExit {
+				kind = EventExit
+			}
+			out = append(out, Event{Kind: kind, PID: int(tgid)})
+		}
+	}
+	return out, nil
+}
+
+// IsGame reports whether pid currently looks like a game process by the same
+// rules Scan uses. It is meant for classifying a single exec event.
+func (s *Scanner) IsGame(pid int) bool {
+	return s.isGameAt(s.procRoot, pid)
+}
@@ -0,0 +1,50 @@
+package procscan
+
+import (
+	"encoding/binary"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func procEventMsg(what uint32, fields ...uint32) []byte {
+	ne := binary.NativeEndian
+	data := make([]byte, cnMsgLen+procEventHdrSz+4*len(fields))
+	ne.PutUint32(data[0:], cnIdxProc)
+	ne.PutUint32(data[4:], cnValProc)
+	ne.PutUint16(data[16:], uint16(len(data)-cnMsgLen))
+	ev := data[cnMsgLen:]
+	ne.PutUint32(ev[0:], what)
+	for i, f := range fields {
+		ne.PutUint32(ev[procEventHdrSz+4*i:], f)
+	}
+
+	msg := make([]byte, syscall.NLMSG_HDRLEN+len(data))
+	ne.PutUint32(msg[0:], uint32(len(msg)))
+	ne.PutUint16(msg[4:], syscall.NLMSG_DONE)
+	copy(msg[syscall.NLMSG_HDRLEN:], data)
+	return msg
+}
+
+func TestParseEvents(t *testing.T) {
+	var buf []byte
+	buf = append(buf, procEventMsg(procEventFork, 10, 10, 20, 20)...)
+	buf = append(buf, procEventMsg(procEventFork, 20, 20, 21, 20)...) // thread
+	buf = append(buf, procEventMsg(procEventExec, 20, 20)...)
+	buf = append(buf, procEventMsg(procEventExit, 21, 20, 0, 0)...) // thread
+	buf = append(buf, procEventMsg(procEventExit, 20, 20, 0, 9)...)
+	buf = append(buf, procEventMsg(0x40, 20, 20)...) // PROC_EVENT_COMM
+
+	got, err := parseEvents(buf)
+	if err != nil {
+		t.Fatalf("parseEvents: %v", err)
+	}
+	want := []Event{
+		{Kind: EventFork, PID: 20, ParentPID: 10},
+		{Kind: EventExec, PID: 20},
+		{Kind: EventExit, PID: 20},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events mismatch:\n got %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package procscan
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+)
+
+// fullscreenTTL is how long the fullscreen window's PID is reused between
+// queries; a Scan asks once per process without a game ID.
+const fullscreenTTL = time.Second
+
+// fullscreenDetector treats the process owning the active window as a game
+// while that window is fullscreen. It asks the X server through xprop, so it
+// sees X11 and XWayland windows (wine and Proton games among them) but not
+// native Wayland ones.
+type fullscreenDetector struct {
+	query func(ctx context.Context) (int, error)
+
+	mu      sync.Mutex
+	pid     int
+	at      time.Time
+	warned  bool
+	checked bool
+}
+
+func newFullscreenDetector() *fullscreenDetector {
+	return &fullscreenDetector{query: xpropFullscreenPID}
+}
+
+func (*fullscreenDetector) Name() string { return DetectFullscreen }
+
+func (d *fullscreenDetector) Detect(p *Proc) string {
+	if d.fullscreenPID() != p.PID {
+		return ""
+	}
+	if wineLoaders[p.Exe] {
+		if prog := wineProgram(p.Cmdline()); prog != "" {
+			return prog
+		}
+	}
+	return p.Exe
+}
+
+func (d *fullscreenDetector) fullscreenPID() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if d.checked && now.Sub(d.at) < fullscreenTTL {
+		return d.pid
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	pid, err := d.query(ctx)
+	cancel()
+	if err != nil {
+		if !d.warned {
+			d.warned = true
+			logging.Warnf(nil, "fullscreen detector: %v", err)
+		}
+		pid = 0
+	}
+	d.pid, d.at, d.checked = pid, now, true
+	return pid
+}
+
+func xpropFullscreenPID(ctx context.Context) (int, error) {
+	out, err := exec.CommandContext(ctx, "xprop", "-root", "_NET_ACTIVE_WINDOW").Output()
+	if err != nil {
+		return 0, fmt.Errorf("xprop -root: %w", err)
+	}
+	win := parseActiveWindow(string(out))
+	if win == "" {
+		return 0, nil
+	}
+	out, err = exec.CommandContext(ctx, "xprop", "-id", win, "_NET_WM_STATE", "_NET_WM_PID").Output()
+	if err != nil {
+		// The window may have closed in between.
+		return 0, nil
+	}
+	return parseFullscreenPID(string(out)), nil
+}
+
+// parseActiveWindow returns the window ID in xprop's
+// "_NET_ACTIVE_WINDOW(WINDOW): window id # 0x3a00007", or "" for none.
+func parseActiveWindow(out string) string {
+	_, id, ok := strings.Cut(out, "#")
+	if !ok {
+		return ""
+	}
+	id, _, _ = strings.Cut(strings.TrimSpace(id), ",")
+	id = strings.TrimSpace(id)
+	if n, err := strconv.ParseUint(strings.TrimPrefix(id, "0x"), 16, 32); err != nil || n == 0 {
+		return ""
+	}
+	return id
+}
+
+// parseFullscreenPID returns the _NET_WM_PID from xprop output for a window
+// whose _NET_WM_STATE includes _NET_WM_STATE_FULLSCREEN, or 0.
+func parseFullscreenPID(out string) int {
+	fullscreen, pid := false, 0
+	for _, line := range strings.Split(out, "\n") {
+		name, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name, "_NET_WM_STATE("):
+			for _, atom := range strings.Split(val, ",") {
+				if strings.TrimSpace(atom) == "_NET_WM_STATE_FULLSCREEN" {
+					fullscreen = true
+				}
+			}
+		case strings.HasPrefix(name, "_NET_WM_PID("):
+			pid, _ = strconv.Atoi(strings.TrimSpace(val))
+		}
+	}
+	if !fullscreen {
+		return 0
+	}
+	return pid
+}
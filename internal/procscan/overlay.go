@@ -0,0 +1,65 @@
+package procscan
+
+import (
+	"strconv"
+
+	"github.com/Reidond/ccdbind/internal/gameid"
+)
+
+// Steam starts its in-game overlay as a separate process for each game,
+// `gameoverlayui -pid <game pid> ... -gameid <app ID>`. It is on the default
+// ignore list, so it stays with the Steam client on the OS CPUs, where it
+// competes with the desktop and stutters while the game waits on it. With
+// SetOverlays, Scan reports it under its game's ID so the daemon can place
+// it; Steam Input runs inside the Steam client itself and is not affected.
+
+// IDSourceOverlay is the IDSource of a Steam overlay process reported with
+// the game it draws over.
+const IDSourceOverlay = "overlay"
+
+// overlayExes are the executables of the Steam overlay.
+var overlayExes = map[string]bool{
+	"gameoverlayui":     true,
+	"gameoverlayui64":   true,
+	"gameoverlayui.exe": true,
+}
+
+// SetOverlays makes Scan report the Steam overlay processes of the games it
+// finds, with IDSource IDSourceOverlay. An overlay whose game is not found
+// is not reported.
+func (s *Scanner) SetOverlays(on bool) {
+	s.overlays = on
+}
+
+// overlayTarget returns the game PID and the normalized game ID from an
+// overlay's command line; either may be missing.
+func overlayTarget(args []string) (int, string) {
+	pid, id := 0, ""
+	for i := 1; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-pid":
+			pid, _ = strconv.Atoi(args[i+1])
+		case "-gameid":
+			id = gameid.Normalize(args[i+1])
+		}
+	}
+	return pid, id
+}
+
+// overlayGameID returns the game an overlay with args belongs to among
+// results: the game of its target PID, or else its -gameid if that game
+// runs.
+func (s *Scanner) overlayGameID(args []string, games map[int]string, results map[string][]GameProcess) string {
+	pid, id := overlayTarget(args)
+	if g, ok := games[pid]; ok && pid > 0 {
+		return g
+	}
+	if id == "" {
+		return ""
+	}
+	id = s.aliases.Resolve(id)
+	if len(results[id]) == 0 {
+		return ""
+	}
+	return id
+}
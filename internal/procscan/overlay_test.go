@@ -0,0 +1,30 @@
+package procscan
+
+import (
+	"testing"
+
+	"github.com/Reidond/ccdbind/internal/gameid"
+)
+
+func TestOverlayGameID(t *testing.T) {
+	s := NewScanner(1000, nil, nil, nil, gameid.Aliases{"1245620": "elden-ring"})
+	results := map[string][]GameProcess{
+		"elden-ring": {{PID: 100, GameID: "elden-ring"}},
+		"570":        {{PID: 200, GameID: "570"}},
+	}
+	games := gamePIDs(results)
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"gameoverlayui", "-pid", "200", "-steampid", "7", "-gameid", "1245620"}, "570"},
+		{[]string{"gameoverlayui", "-pid", "999", "-gameid", "1245620"}, "elden-ring"},
+		{[]string{"gameoverlayui", "-pid", "999", "-gameid", "730"}, ""},
+		{[]string{"gameoverlayui", "-gameid"}, ""},
+		{[]string{"gameoverlayui"}, ""},
+	} {
+		if got := s.overlayGameID(tc.args, games, results); got != tc.want {
+			t.Fatalf("overlayGameID(%q) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package procscan
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// Scan reads a few files of every process on the system, thousands on a
+// busy desktop. The per-process reads are spread over a bounded pool of
+// workers, each reading into buffers reused across scans, and ownership is
+// taken from the owner of /proc/PID where that settles it, so most
+// processes cost a stat and a readlink.
+
+const (
+	// maxScanWorkers bounds the goroutines probing processes.
+	maxScanWorkers = 8
+	// parallelScanMin is the process count below which a pool does not pay.
+	parallelScanMin = 64
+)
+
+// probe is what Scan learns about one process before placing it.
+type probe struct {
+	pid    int
+	owned  bool
+	exe    string
+	exeErr error
+	// overlay and ignored classify exe, see Scanner.overlays and ignored.
+	overlay, ignored bool
+	// id and src come from the env keys when they were read.
+	id, src string
+}
+
+// procReader reads /proc files into a buffer kept between reads.
+type procReader struct {
+	buf []byte
+}
+
+var readers = sync.Pool{New: func() any { return &procReader{buf: make([]byte, 0, 8192)} }}
+
+// read returns the contents of /proc/PID/name, valid until the next read.
+func (r *procReader) read(procRoot string, pid int, name string) ([]byte, error) {
+	f, err := os.Open(procRoot + "/" + strconv.Itoa(pid) + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r.buf = r.buf[:0]
+	for {
+		if len(r.buf) == cap(r.buf) {
+			r.buf = append(r.buf, 0)[:len(r.buf)]
+		}
+		n, err := f.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+n]
+		if errors.Is(err, io.EOF) {
+			return r.buf, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// probeAll probes pids, in parallel when there are many. With readEnv the
+// env keys of processes that are neither ignored nor overlays are read too.
+func (s *Scanner) probeAll(procRoot string, pids []int, readEnv bool) []probe {
+	out := make([]probe, len(pids))
+	workers := min(runtime.GOMAXPROCS(0), maxScanWorkers)
+	if len(pids) < parallelScanMin {
+		workers = 1
+	}
+	var next atomic.Int64
+	work := func() {
+		r := readers.Get().(*procReader)
+		defer readers.Put(r)
+		for {
+			i := int(next.Add(1)) - 1
+			if i >= len(pids) {
+				return
+			}
+			out[i] = s.probe(r, procRoot, pids[i], readEnv)
+		}
+	}
+	if workers == 1 {
+		work()
+		return out
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work()
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+func (s *Scanner) probe(r *procReader, procRoot string, pid int, readEnv bool) probe {
+	p := probe{pid: pid}
+	owned, err := ownedAt(r, procRoot, pid, s.UID)
+	if err != nil || !owned {
+		return p
+	}
+	p.owned = true
+	p.exe, p.exeErr = exeNameAt(procRoot, pid)
+	if p.exeErr != nil || p.exe == "" {
+		return p
+	}
+	p.overlay = s.overlays && overlayExes[p.exe]
+	p.ignored = !p.overlay && s.ignored(p.exe)
+	if readEnv && !p.overlay && !p.ignored && len(s.envKeyOrder) > 0 {
+		if data, err := r.read(procRoot, pid, "environ"); err == nil {
+			p.id, p.src = s.gameIDFromEnvironData(data)
+		}
+	}
+	return p
+}
+
+// ownedAt reports whether the real UID of pid is uid. /proc/PID belongs to
+// the effective UID of a process, which settles it for the user's ordinary
+// processes; the status file is read for the rest, such as processes that
+// made themselves non-dumpable and show up as root's.
+func ownedAt(r *procReader, procRoot string, pid, uid int) (bool, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(procRoot+"/"+strconv.Itoa(pid), &st); err != nil {
+		return false, err
+	}
+	if int(st.Uid) == uid {
+		return true, nil
+	}
+	data, err := r.read(procRoot, pid, "status")
+	if err != nil {
+		return false, err
+	}
+	ruid, err := statusUID(data)
+	if err != nil {
+		return false, err
+	}
+	return ruid == uid, nil
+}
+
+// statusUID returns the real UID from the Uid: line of a status file.
+func statusUID(data []byte) (int, error) {
+	i := bytes.Index(data, []byte("\nUid:"))
+	if i < 0 {
+		return 0, errors.New("uid line not found")
+	}
+	line := data[i+len("\nUid:"):]
+	if j := bytes.IndexByte(line, '\n'); j >= 0 {
+		line = line[:j]
+	}
+	fields := bytes.Fields(line)
+	if len(fields) == 0 {
+		return 0, errors.New("unexpected Uid line")
+	}
+	return strconv.Atoi(string(fields[0]))
+}
@@ -0,0 +1,103 @@
+package procscan
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStatusUID(t *testing.T) {
+	status := "Name:\tgame\nState:\tS (sleeping)\nUid:\t1000\t1000\t1000\t1000\nGid:\t1000\t1000\t1000\t1000\n"
+	if uid, err := statusUID([]byte(status)); uid != 1000 || err != nil {
+		t.Fatalf("statusUID = %d, %v", uid, err)
+	}
+	if _, err := statusUID([]byte("Name:\tgame\n")); err == nil {
+		t.Fatal("statusUID accepted a status without Uid")
+	}
+}
+
+func TestProcReaderGrows(t *testing.T) {
+	root := t.TempDir()
+	env := strings.Repeat("K=V\x00", 10000)
+	writeProc(t, root, 1, "/usr/bin/game", env, "")
+	r := &procReader{buf: make([]byte, 0, 16)}
+	data, err := r.read(root, 1, "environ")
+	if err != nil || string(data) != env {
+		t.Fatalf("read = %d bytes, %v; want %d", len(data), err, len(env))
+	}
+}
+
+func TestProbeAll(t *testing.T) {
+	root := t.TempDir()
+	var pids []int
+	// Enough processes for the pool to be used.
+	for pid := 100; pid < 100+2*parallelScanMin; pid++ {
+		switch pid % 3 {
+		case 0:
+			writeProc(t, root, pid, "/usr/bin/game", "SteamAppId="+strconv.Itoa(pid)+"\x00", "")
+		case 1:
+			writeProc(t, root, pid, "/usr/bin/steam", "SteamAppId=1\x00", "")
+		default:
+			writeProc(t, root, pid, "/usr/bin/shell", "", "")
+		}
+		pids = append(pids, pid)
+	}
+	// writeProc gives the processes UID 1000 in status, so unless the tests
+	// run as 1000 the owner of the directory does not settle it.
+	s := NewScanner(1000, []string{"SteamAppId"}, nil, []string{"steam"}, nil)
+	got := s.probeAll(root, pids, true)
+	for i, p := range got {
+		if p.pid != pids[i] || !p.owned {
+			t.Fatalf("probe %d = %+v", i, p)
+		}
+		switch p.pid % 3 {
+		case 0:
+			if p.exe != "game" || p.id != strconv.Itoa(p.pid) || p.src != "SteamAppId" {
+				t.Fatalf("game probe = %+v", p)
+			}
+		case 1:
+			// The env of ignored processes is not read.
+			if !p.ignored || p.id != "" {
+				t.Fatalf("launcher probe = %+v", p)
+			}
+		default:
+			if p.exe != "shell" || p.id != "" {
+				t.Fatalf("shell probe = %+v", p)
+			}
+		}
+	}
+
+	other := NewScanner(1001, nil, nil, nil, nil)
+	if p := other.probeAll(root, pids[:1], false)[0]; p.owned {
+		t.Fatalf("probe of another user's process = %+v", p)
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	s := NewScanner(os.Getuid(), []string{"SteamAppId", "SteamGameId", "STEAM_COMPAT_APP_ID"}, nil, []string{"steam"}, nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Scan(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGameIDFromEnviron(b *testing.B) {
+	root := b.TempDir()
+	env := strings.Repeat("XDG_SOMETHING=/home/user/.local/share\x00", 60) + "SteamAppId=570\x00"
+	writeProc(b, root, 1, "/usr/bin/game", env, "")
+	s := NewScanner(1000, []string{"SteamAppId", "SteamGameId"}, nil, nil, nil)
+	r := &procReader{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, err := r.read(root, 1, "environ")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if id, _ := s.gameIDFromEnvironData(data); id != "570" {
+			b.Fatalf("id %q", id)
+		}
+	}
+}
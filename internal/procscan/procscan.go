@@ -1,14 +1,18 @@
 package procscan
 
 import (
-	"bufio"
 	"bytes"
-	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/exematch"
+	"github.com/Reidond/ccdbind/internal/gameid"
+	"github.com/Reidond/ccdbind/internal/logging"
 )
 
 type GameProcess struct {
@@ -17,6 +21,10 @@ type GameProcess struct {
 	Exe       string
 	GameID    string
 	IDSource  string
+	// Bits is the ELF class of the executable (32 or 64), 0 if unknown. Under
+	// Proton a 32-bit Windows game runs in a 32-bit wine-preloader unless
+	// wine's new WoW64 mode is in use.
+	Bits int
 }
 
 type Scanner struct {
@@ -25,11 +33,30 @@ type Scanner struct {
 	envKeyOrder []string
 	envKeyIndex map[string]int
 
-	exeAllowlist map[string]struct{}
-	ignoreExe    map[string]struct{}
+	exeAllowlist *exematch.List
+	ignoreExe    *exematch.List
+	aliases      gameid.Aliases
+	detectors    []Detector // see SetDetectors
+	overlays     bool       // see SetOverlays
+
+	// Launcher watchlist state, see FastScan.
+	procRoot  string
+	known     map[int]struct{}
+	launchers []int
+	watch     map[int]time.Time
+
+	access Access // see Access
+
+	cgroupDir    string // see UseCgroups
+	cgroupWarned bool
+
+	lineage map[int]lineage // game processes of the last Scan, see descendantID
 }
 
-func NewScanner(uid int, envKeys, exeAllowlist, ignoreExe []string) *Scanner {
+// NewScanner returns a scanner for uid's processes. Game IDs are normalized
+// and mapped through aliases. exeAllowlist and ignoreExe take exematch
+// entries; invalid patterns, which config.Parse rejects, are dropped.
+func NewScanner(uid int, envKeys, exeAllowlist, ignoreExe []string, aliases gameid.Aliases) *Scanner {
 	keys := make([]string, 0, len(envKeys))
 	idx := make(map[string]int, len(envKeys))
 	for _, k := range envKeys {
@@ -48,59 +75,179 @@ func NewScanner(uid int, envKeys, exeAllowlist, ignoreExe []string) *Scanner {
 		UID:          uid,
 		envKeyOrder:  keys,
 		envKeyIndex:  idx,
-		exeAllowlist: toSetLower(exeAllowlist),
-		ignoreExe:    toSetLower(ignoreExe),
+		exeAllowlist: compileExeList(exeAllowlist),
+		ignoreExe:    compileExeList(ignoreExe),
+		aliases:      aliases,
+		procRoot:     "/proc",
+		known:        map[int]struct{}{},
+		watch:        map[int]time.Time{},
 	}
 }
 
 func (s *Scanner) Scan() (map[string][]GameProcess, error) {
-	ents, err := os.ReadDir("/proc")
+	pids, cgroups, err := s.listPIDs()
 	if err != nil {
 		return nil, err
 	}
 	results := map[string][]GameProcess{}
-	for _, ent := range ents {
-		if !ent.IsDir() {
-			continue
+	known := make(map[int]struct{}, len(pids))
+	launchers := make([]int, 0, 8)
+	var access Access
+	// The env keys are read while probing, or with UseCgroups only in
+	// cgroups that may hold a game started by a launcher, see needsEnviron.
+	type proc struct {
+		pid     int
+		exe     string
+		id, src string
+	}
+	var procs, overlays []proc
+	launcherCgroups := map[string]bool{}
+	// Processes without a game ID of their own, looked up under a reaper
+	// once the scan has seen one (see sandboxAppID), under a game process
+	// (see descendantID) and by the detectors.
+	type candidate struct {
+		pid int
+		exe string
+	}
+	var unresolved []candidate
+	sawReaper := false
+	placed := 0
+	add := func(pid int, exeBase, id, src string) {
+		placed++
+		id = s.aliases.Resolve(id)
+		startTime, err := procStartTime(pid)
+		if err != nil {
+			startTime = 0
 		}
-		pid, err := strconv.Atoi(ent.Name())
-		if err != nil || pid <= 0 {
-			continue
+		gp := GameProcess{PID: pid, StartTime: startTime, Exe: exeBase, GameID: id, IDSource: src, Bits: elfBitsAt("/proc", pid)}
+		results[id] = append(results[id], gp)
+		if logging.Tracing(logging.Scan) {
+			logging.Tracef(logging.Scan, logging.Fields{"PID": strconv.Itoa(pid), "GAME_ID": id}, "pid %d %s: game %s from %s, %d-bit", pid, exeBase, id, src, gp.Bits)
 		}
-		owned, err := isOwnedByUID(pid, s.UID)
-		if err != nil || !owned {
+	}
+	for _, pr := range s.probeAll("/proc", pids, cgroups == nil) {
+		if !pr.owned {
 			continue
 		}
+		pid, exeBase := pr.pid, pr.exe
+		known[pid] = struct{}{}
+		access.Owned++
 
-		exeBase := exeBasenameLower(pid)
+		if pr.exeErr != nil {
+			if isPermission(pr.exeErr) {
+				access.Denied++
+			}
+			continue
+		}
 		if exeBase == "" {
 			continue
 		}
-		if _, ignored := s.ignoreExe[exeBase]; ignored {
+		sawReaper = sawReaper || exeBase == "reaper"
+		if pr.overlay {
+			// Placed with their game once the games are known.
+			overlays = append(overlays, proc{pid: pid, exe: exeBase})
 			continue
 		}
-
-		id, src := s.gameIDFromEnviron(pid)
+		if pr.ignored {
+			launchers = append(launchers, pid)
+			if cgroups != nil {
+				launcherCgroups[cgroups[pid]] = true
+			}
+			if logging.Tracing(logging.Scan) {
+				logging.Tracef(logging.Scan, logging.Fields{"PID": strconv.Itoa(pid)}, "pid %d %s: ignored", pid, exeBase)
+			}
+			continue
+		}
+		procs = append(procs, proc{pid: pid, exe: exeBase, id: pr.id, src: pr.src})
+	}
+	for _, p := range procs {
+		id, src := p.id, p.src
+		if cgroups != nil {
+			cg := cgroups[p.pid]
+			if id = steamAppID(cg); id != "" {
+				src = IDSourceCgroup
+			} else if needsEnviron(cg, launcherCgroups) {
+				id, src = s.gameIDFromEnviron(p.pid)
+			}
+		}
 		if id == "" {
-			if _, ok := s.exeAllowlist[exeBase]; ok {
-				id = exeBase
+			if s.exeAllowlist.Match(p.exe) {
+				id = p.exe
 				src = "exe_allowlist"
 			}
 		}
 		if id == "" {
+			unresolved = append(unresolved, candidate{p.pid, p.exe})
 			continue
 		}
-
-		startTime, err := procStartTime(pid)
-		if err != nil {
-			startTime = 0
+		add(p.pid, p.exe, id, src)
+	}
+	if sawReaper {
+		memo := make(map[int]ancestry, len(unresolved))
+		rest := unresolved[:0]
+		for _, c := range unresolved {
+			if id := s.sandboxAppID("/proc", c.pid, memo); id != "" {
+				add(c.pid, c.exe, id, IDSourceReaper)
+				continue
+			}
+			rest = append(rest, c)
 		}
-		gp := GameProcess{PID: pid, StartTime: startTime, Exe: exeBase, GameID: id, IDSource: src}
-		results[id] = append(results[id], gp)
+		unresolved = rest
+	}
+	// Descendants of games belong to them, including those of games the
+	// detectors find, so the tree is resolved before and after them.
+	descendants := func() {
+		if len(results) == 0 {
+			return
+		}
+		games := gamePIDs(results)
+		memo := make(map[int]string, len(unresolved))
+		rest := unresolved[:0]
+		for _, c := range unresolved {
+			if id := s.descendantID("/proc", c.pid, games, memo); id != "" {
+				add(c.pid, c.exe, id, IDSourceParent)
+				continue
+			}
+			rest = append(rest, c)
+		}
+		unresolved = rest
+	}
+	descendants()
+	found := placed
+	rest := unresolved[:0]
+	for _, c := range unresolved {
+		if id, src := s.detect("/proc", c.pid, c.exe); id != "" {
+			add(c.pid, c.exe, id, src)
+			continue
+		}
+		rest = append(rest, c)
 	}
+	unresolved = rest
+	if placed != found {
+		descendants()
+	}
+	if len(overlays) > 0 && len(results) > 0 {
+		games := gamePIDs(results)
+		for _, o := range overlays {
+			if id := s.overlayGameID(cmdlineAt("/proc", o.pid), games, results); id != "" {
+				add(o.pid, o.exe, id, IDSourceOverlay)
+			}
+		}
+	}
+	s.lineage = lineageOf(results)
+	s.known = known
+	s.launchers = launchers
+	s.access = access
+	logging.Tracef(logging.Scan, nil, "scanned %d user processes: %d game(s), %d launcher(s), %d unreadable", len(known), len(results), len(launchers), access.Denied)
 	return results, nil
 }
 
+// StartTime returns the start time of pid in clock ticks since boot, which
+// together with the PID identifies a process.
+func StartTime(pid int) (uint64, error) {
+	return procStartTime(pid)
+}
+
 func procStartTime(pid int) (uint64, error) {
 	path := filepath.Join("/proc", strconv.Itoa(pid), "stat")
 	data, err := os.ReadFile(path)
@@ -128,42 +275,60 @@ func procStartTime(pid int) (uint64, error) {
 	return strconv.ParseUint(fields[19], 10, 64)
 }
 
-func toSetLower(in []string) map[string]struct{} {
-	out := make(map[string]struct{}, len(in))
-	for _, s := range in {
-		s = strings.ToLower(strings.TrimSpace(s))
-		if s == "" {
-			continue
-		}
-		out[s] = struct{}{}
+func compileExeList(in []string) *exematch.List {
+	out := make([]string, 0, len(in))
+	for _, e := range in {
+		out = append(out, exematch.Normalize(e))
 	}
-	return out
+	return exematch.CompileValid(out)
 }
 
-func exeBasenameLower(pid int) string {
-	path := filepath.Join("/proc", strconv.Itoa(pid), "exe")
-	target, err := os.Readlink(path)
-	if err != nil {
-		return ""
+// ignored reports whether exe is on the ignore list. A glob or regular
+// expression there does not hide an executable exe_allowlist matches, so
+// "*.exe" can be ignored except for listed games; only naming the
+// executable in ignore_exe overrides the allowlist.
+func (s *Scanner) ignored(exe string) bool {
+	if s.ignoreExe.Exact(exe) {
+		return true
 	}
-	base := filepath.Base(target)
-	base = strings.TrimSpace(base)
-	if base == "" || base == "." || base == "/" {
-		return ""
-	}
-	return strings.ToLower(base)
+	return s.ignoreExe.MatchPattern(exe) && !s.exeAllowlist.Match(exe)
+}
+
+// Allowlisted reports whether exe matches exe_allowlist.
+func (s *Scanner) Allowlisted(exe string) bool {
+	return s.exeAllowlist.Match(exe)
+}
+
+func exeBasenameLower(pid int) string {
+	base, _ := exeBasename(pid)
+	return base
+}
+
+// exeBasename returns the lowercased executable name of pid, or the error
+// reading its exe link, see exeNameAt.
+func exeBasename(pid int) (string, error) {
+	return exeNameAt("/proc", pid)
 }
 
 func (s *Scanner) gameIDFromEnviron(pid int) (string, string) {
+	return s.gameIDFromEnvironAt("/proc", pid)
+}
+
+func (s *Scanner) gameIDFromEnvironAt(procRoot string, pid int) (string, string) {
 	if len(s.envKeyOrder) == 0 {
 		return "", ""
 	}
-	path := filepath.Join("/proc", strconv.Itoa(pid), "environ")
+	path := filepath.Join(procRoot, strconv.Itoa(pid), "environ")
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return "", ""
 	}
+	return s.gameIDFromEnvironData(data)
+}
 
+// gameIDFromEnvironData returns the game ID and key from the environ file
+// data, preferring the earliest of the env keys.
+func (s *Scanner) gameIDFromEnvironData(data []byte) (string, string) {
 	bestIdx := len(s.envKeyOrder) + 1
 	bestKey := ""
 	bestVal := ""
@@ -183,17 +348,18 @@ func (s *Scanner) gameIDFromEnviron(pid int) (string, string) {
 		if eq <= 0 {
 			continue
 		}
-		k := string(entry[:eq])
-		idx, ok := s.envKeyIndex[k]
+		// Indexing with the converted bytes does not allocate.
+		idx, ok := s.envKeyIndex[string(entry[:eq])]
 		if !ok || idx >= bestIdx {
 			continue
 		}
-		v := strings.TrimSpace(string(entry[eq+1:]))
+		// SteamAppId=0 marks a non-Steam game; a later key may still name it.
+		v := gameid.Normalize(string(entry[eq+1:]))
 		if v == "" {
 			continue
 		}
 		bestIdx = idx
-		bestKey = k
+		bestKey = s.envKeyOrder[idx]
 		bestVal = v
 		if bestIdx == 0 {
 			break
@@ -203,31 +369,52 @@ func (s *Scanner) gameIDFromEnviron(pid int) (string, string) {
 }
 
 func isOwnedByUID(pid int, uid int) (bool, error) {
-	path := filepath.Join("/proc", strconv.Itoa(pid), "status")
-	f, err := os.Open(path)
+	r := readers.Get().(*procReader)
+	defer readers.Put(r)
+	return ownedAt(r, "/proc", pid, uid)
+}
+
+// Adopt builds a GameProcess for an explicitly requested pid, e.g. from a
+// pin request file. It fails unless pid is alive and owned by the scanner's
+// UID, so a request cannot pull in another user's process.
+func (s *Scanner) Adopt(pid int, gameID string, source string) (GameProcess, error) {
+	owned, err := isOwnedByUID(pid, s.UID)
 	if err != nil {
-		return false, err
+		return GameProcess{}, err
 	}
-	defer f.Close()
+	if !owned {
+		return GameProcess{}, fmt.Errorf("pid %d not owned by uid %d", pid, s.UID)
+	}
+	startTime, err := procStartTime(pid)
+	if err != nil {
+		startTime = 0
+	}
+	if gameID != "" {
+		gameID = s.aliases.Resolve(gameID)
+	}
+	return GameProcess{PID: pid, StartTime: startTime, Exe: exeBasenameLower(pid), GameID: gameID, IDSource: source, Bits: elfBitsAt("/proc", pid)}, nil
+}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "Uid:") {
-			continue
-		}
-		fields := strings.Fields(line)
-		if len(fields) < 2 {
-			return false, fmt.Errorf("unexpected Uid line: %q", line)
-		}
-		parsed, err := strconv.Atoi(fields[1])
-		if err != nil {
-			return false, err
-		}
-		return parsed == uid, nil
+// elfBitsAt reads the ELF class byte of the process executable.
+func elfBitsAt(procRoot string, pid int) int {
+	f, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "exe"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	var hdr [5]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		return 0
+	}
+	if string(hdr[:4]) != "\x7fELF" {
+		return 0
 	}
-	if err := scanner.Err(); err != nil {
-		return false, err
+	switch hdr[4] {
+	case 1:
+		return 32
+	case 2:
+		return 64
+	default:
+		return 0
 	}
-	return false, errors.New("uid line not found")
 }
@@ -0,0 +1,31 @@
+package procscan
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestElfBitsAt(t *testing.T) {
+	root := t.TempDir()
+	bins := map[int][]byte{
+		1: []byte("\x7fELF\x01rest"),
+		2: []byte("\x7fELF\x02rest"),
+		3: []byte("#!/bin/sh\n"),
+	}
+	for pid, content := range bins {
+		dir := filepath.Join(root, strconv.Itoa(pid))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "exe"), content, 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	for pid, want := range map[int]int{1: 32, 2: 64, 3: 0, 4: 0} {
+		if got := elfBitsAt(root, pid); got != want {
+			t.Fatalf("pid %d: got %d want %d", pid, got, want)
+		}
+	}
+}
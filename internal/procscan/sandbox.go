@@ -0,0 +1,191 @@
+package procscan
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/gameid"
+)
+
+// Games started by Flatpak or Snap Steam, or inside a Steam Linux Runtime
+// container, run under bwrap and pressure-vessel. The container may be set
+// up with a cleared environment, so the game's processes can lack the env
+// keys Scan looks for. Steam's reaper, which starts every game as
+// `reaper SteamLaunch AppId=N -- ...`, sits above the container and names
+// the game on its command line; sandboxed processes below a reaper are
+// mapped to that AppId.
+
+// IDSourceReaper is the IDSource of a game ID read from the AppId= marker
+// on the command line of a sandboxed process's reaper.
+const IDSourceReaper = "reaper"
+
+// maxAncestry bounds the walk from a process up to its reaper.
+const maxAncestry = 32
+
+// sandboxLaunchers are the executables that set up a game container.
+var sandboxLaunchers = map[string]bool{
+	"bwrap":                  true,
+	"srt-bwrap":              true,
+	"pressure-vessel-wrap":   true,
+	"pressure-vessel-adverb": true,
+	"pv-adverb":              true,
+	"pv-bwrap":               true,
+}
+
+// loaders are dynamic linkers; a program started through one (as the Steam
+// Runtime does for some tools) shows the linker as its exe.
+func isLoader(base string) bool {
+	return strings.HasPrefix(base, "ld-linux") || (strings.HasPrefix(base, "ld-") && strings.Contains(base, ".so"))
+}
+
+// exeNameAt returns the lowercased executable name of pid. The link target
+// is the path inside the process's mount namespace, which is as good as the
+// host's for the name. A binary replaced on disk (a Flatpak update, say)
+// carries a " (deleted)" suffix, and one started through the dynamic linker
+// is named by the program on its command line.
+func exeNameAt(procRoot string, pid int) (string, error) {
+	target, err := os.Readlink(filepath.Join(procRoot, strconv.Itoa(pid), "exe"))
+	if err != nil {
+		return "", err
+	}
+	target = strings.TrimSuffix(target, " (deleted)")
+	base := strings.ToLower(strings.TrimSpace(filepath.Base(target)))
+	if base == "" || base == "." || base == "/" {
+		return "", nil
+	}
+	if isLoader(base) {
+		if prog := loadedProgram(cmdlineAt(procRoot, pid)); prog != "" {
+			return strings.ToLower(filepath.Base(prog)), nil
+		}
+	}
+	return base, nil
+}
+
+// loadedProgram returns the program in the command line of a dynamic
+// linker run as `ld.so [OPTIONS] PROGRAM [ARGS]`.
+func loadedProgram(args []string) string {
+	if len(args) < 2 {
+		return ""
+	}
+	for i := 1; i < len(args); i++ {
+		switch a := args[i]; a {
+		case "--argv0", "--library-path", "--preload", "--audit", "--inhibit-rpath", "--glibc-hwcaps-prepend", "--glibc-hwcaps-mask":
+			i++
+		case "--inhibit-cache", "--list", "--verify", "--list-tunables", "--list-diagnostics":
+		default:
+			if strings.HasPrefix(a, "--") {
+				continue
+			}
+			return a
+		}
+	}
+	return ""
+}
+
+func cmdlineAt(procRoot string, pid int) []string {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cmdline"))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	return strings.Split(string(bytes.TrimRight(data, "\x00")), "\x00")
+}
+
+// reaperAppID returns the normalized game ID from a reaper command line:
+// the AppId= argument before the "--" that starts the game's own command.
+func reaperAppID(args []string) string {
+	if len(args) == 0 || strings.ToLower(filepath.Base(args[0])) != "reaper" {
+		return ""
+	}
+	for _, a := range args[1:] {
+		if a == "--" {
+			break
+		}
+		if v, ok := strings.CutPrefix(a, "AppId="); ok {
+			return gameid.Normalize(v)
+		}
+	}
+	return ""
+}
+
+func ppidAt(procRoot string, pid int) int {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0
+	}
+	line := string(data)
+	idx := strings.LastIndexByte(line, ')')
+	if idx == -1 || idx+2 >= len(line) {
+		return 0
+	}
+	// fields[0] is state (field 3), ppid is field 4.
+	fields := strings.Fields(line[idx+2:])
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+// inAppSandbox reports whether pid runs in a Flatpak sandbox, which has
+// /.flatpak-info in its root, or in a snap, whose processes sit in
+// snap.NAME.* cgroups.
+func inAppSandbox(procRoot string, pid int) bool {
+	if _, err := os.Stat(filepath.Join(procRoot, strconv.Itoa(pid), "root", ".flatpak-info")); err == nil {
+		return true
+	}
+	cg, err := cgroupAt(procRoot, pid)
+	return err == nil && strings.Contains(cg, "/snap.")
+}
+
+// ancestry is the result of walking up from a process: the AppId of the
+// reaper above it, and whether a sandbox lies between them.
+type ancestry struct {
+	appID     string
+	sandboxed bool
+}
+
+// sandboxAppID returns the game ID of pid's reaper when pid or one of the
+// processes between it and the reaper is sandboxed. Results for ancestors
+// are kept in memo, so a Scan walks each process once; memo may be nil.
+func (s *Scanner) sandboxAppID(procRoot string, pid int, memo map[int]ancestry) string {
+	var chain []int
+	var found ancestry
+	cur := pid
+	for depth := 0; depth < maxAncestry && cur > 1; depth++ {
+		if a, ok := memo[cur]; ok {
+			found = a
+			break
+		}
+		if owned, err := isOwnedByUIDAt(procRoot, cur, s.UID); err != nil || !owned {
+			break
+		}
+		chain = append(chain, cur)
+		if id := reaperAppID(cmdlineAt(procRoot, cur)); id != "" {
+			found = ancestry{appID: id, sandboxed: inAppSandbox(procRoot, cur)}
+			chain = chain[:len(chain)-1]
+			if memo != nil {
+				memo[cur] = found
+			}
+			break
+		}
+		cur = ppidAt(procRoot, cur)
+	}
+	// Fill in from the top, each process adding whether it is a sandbox.
+	for i := len(chain) - 1; i >= 0; i-- {
+		p := chain[i]
+		if found.appID != "" && !found.sandboxed {
+			name, _ := exeNameAt(procRoot, p)
+			found.sandboxed = sandboxLaunchers[name] || inAppSandbox(procRoot, p)
+		}
+		if memo != nil {
+			memo[p] = found
+		}
+	}
+	if found.sandboxed {
+		return found.appID
+	}
+	return ""
+}
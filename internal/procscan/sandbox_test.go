@@ -0,0 +1,93 @@
+package procscan
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeSandboxProc adds the stat and cmdline files sandboxAppID reads to a
+// process written by writeProc.
+func writeSandboxProc(t *testing.T, root string, pid, ppid int, exe string, cmdline ...string) {
+	t.Helper()
+	writeProc(t, root, pid, exe, "", "")
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	stat := fmt.Sprintf("%d (%s) S %d 1 1 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 100 0 0", pid, filepath.Base(exe), ppid)
+	if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cmdline"), []byte(strings.Join(cmdline, "\x00")+"\x00"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestReaperAppID(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"/home/u/.steam/root/ubuntu12_32/reaper", "SteamLaunch", "AppId=1245620", "--", "game"}, "1245620"},
+		{[]string{"reaper", "SteamLaunch", "AppId=0", "--", "game"}, ""},
+		{[]string{"reaper", "SteamLaunch", "--", "game", "AppId=7"}, ""},
+		{[]string{"game", "AppId=7"}, ""},
+		{nil, ""},
+	} {
+		if got := reaperAppID(tc.args); got != tc.want {
+			t.Fatalf("reaperAppID(%q) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestExeNameAt(t *testing.T) {
+	root := t.TempDir()
+	writeSandboxProc(t, root, 10, 1, "/app/bin/Game.x86_64 (deleted)", "Game.x86_64")
+	writeSandboxProc(t, root, 11, 1, "/usr/lib/x86_64-linux-gnu/ld-linux-x86-64.so.2", "ld.so", "--argv0", "tool", "--inhibit-cache", "/usr/bin/Tool", "-v")
+	writeSandboxProc(t, root, 12, 1, "/lib/ld-linux.so.2")
+	for pid, want := range map[int]string{10: "game.x86_64", 11: "tool", 12: "ld-linux.so.2"} {
+		if got, err := exeNameAt(root, pid); err != nil || got != want {
+			t.Fatalf("exeNameAt(%d) = %q, %v; want %q", pid, got, err, want)
+		}
+	}
+}
+
+func TestSandboxAppID(t *testing.T) {
+	root := t.TempDir()
+	reaper := []string{"reaper", "SteamLaunch", "AppId=42", "--", "steam-launch-wrapper"}
+	// Steam Linux Runtime container started by host Steam.
+	writeSandboxProc(t, root, 100, 1, "/home/u/.steam/ubuntu12_32/reaper", reaper...)
+	writeSandboxProc(t, root, 200, 100, "/usr/bin/pressure-vessel-wrap", "pressure-vessel-wrap")
+	writeSandboxProc(t, root, 300, 200, "/usr/lib/pressure-vessel/from-host/bin/pv-adverb", "pv-adverb")
+	writeSandboxProc(t, root, 400, 300, "/games/game", "game")
+	// A process under the reaper without a container is left to the env
+	// keys.
+	writeSandboxProc(t, root, 500, 100, "/usr/bin/helper", "helper")
+	// Flatpak Steam: the reaper itself runs in the sandbox.
+	writeSandboxProc(t, root, 600, 1, "/app/bin/reaper", "reaper", "SteamLaunch", "AppId=7", "--", "x")
+	writeSandboxProc(t, root, 700, 600, "/data/game7", "game7")
+	if err := os.MkdirAll(filepath.Join(root, "600", "root"), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "600", "root", ".flatpak-info"), []byte("[Application]\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	// No reaper above.
+	writeSandboxProc(t, root, 800, 1, "/usr/bin/bwrap", "bwrap")
+	writeSandboxProc(t, root, 900, 800, "/usr/bin/app", "app")
+
+	s := NewScanner(1000, nil, nil, nil, nil)
+	memo := map[int]ancestry{}
+	for pid, want := range map[int]string{400: "42", 300: "42", 500: "", 700: "7", 900: ""} {
+		if got := s.sandboxAppID(root, pid, memo); got != want {
+			t.Fatalf("sandboxAppID(%d) = %q, want %q", pid, got, want)
+		}
+		if got := s.sandboxAppID(root, pid, nil); got != want {
+			t.Fatalf("sandboxAppID(%d) without memo = %q, want %q", pid, got, want)
+		}
+	}
+	if a := memo[200]; a.appID != "42" || !a.sandboxed {
+		t.Fatalf("memo[200] = %+v", a)
+	}
+}
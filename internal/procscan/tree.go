@@ -0,0 +1,69 @@
+package procscan
+
+// Games start helpers after their first processes were placed: anti-cheat
+// services, crash reporters, engines spawned by a launcher. Helpers that
+// drop the env keys or run an exe not on the allowlist would otherwise stay
+// outside the game's scope. A process below a game process belongs to that
+// game, and keeps belonging to it when its parent exits and it is
+// reparented.
+
+// IDSourceParent is the IDSource of a process placed with the game process
+// it descends from.
+const IDSourceParent = "parent"
+
+// lineage is a game process remembered from the last Scan.
+type lineage struct {
+	gameID string
+	start  uint64
+}
+
+func gamePIDs(results map[string][]GameProcess) map[int]string {
+	out := make(map[int]string, len(results))
+	for id, procs := range results {
+		for _, gp := range procs {
+			out[gp.PID] = id
+		}
+	}
+	return out
+}
+
+func lineageOf(results map[string][]GameProcess) map[int]lineage {
+	out := make(map[int]lineage, len(results))
+	for id, procs := range results {
+		for _, gp := range procs {
+			out[gp.PID] = lineage{gameID: id, start: gp.StartTime}
+		}
+	}
+	return out
+}
+
+// descendantID returns the game ID of the nearest ancestor of pid in games,
+// or the game pid itself was placed with by the last Scan if it is the same
+// process. Walked ancestors are kept in memo, "" for none, so a Scan reads
+// each process's parent once.
+func (s *Scanner) descendantID(procRoot string, pid int, games map[int]string, memo map[int]string) string {
+	if l, ok := s.lineage[pid]; ok {
+		if start, err := procStartTimeAt(procRoot, pid); err == nil && start == l.start {
+			return l.gameID
+		}
+	}
+	var chain []int
+	found := ""
+	cur := ppidAt(procRoot, pid)
+	for depth := 0; depth < maxAncestry && cur > 1; depth++ {
+		if id, ok := games[cur]; ok {
+			found = id
+			break
+		}
+		if id, ok := memo[cur]; ok {
+			found = id
+			break
+		}
+		chain = append(chain, cur)
+		cur = ppidAt(procRoot, cur)
+	}
+	for _, p := range chain {
+		memo[p] = found
+	}
+	return found
+}
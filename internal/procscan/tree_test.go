@@ -0,0 +1,37 @@
+package procscan
+
+import "testing"
+
+func TestDescendantID(t *testing.T) {
+	root := t.TempDir()
+	writeSandboxProc(t, root, 10, 1, "/usr/bin/launcher", "launcher")
+	writeSandboxProc(t, root, 100, 10, "/games/game", "game")
+	writeSandboxProc(t, root, 200, 100, "/bin/sh", "sh")
+	writeSandboxProc(t, root, 300, 200, "/games/anticheat", "anticheat")
+	writeSandboxProc(t, root, 400, 10, "/usr/bin/updater", "updater")
+	// Reparented after its parent exited.
+	writeSandboxProc(t, root, 500, 1, "/games/engine", "engine")
+
+	s := NewScanner(1000, nil, nil, nil, nil)
+	games := map[int]string{100: "42"}
+	memo := map[int]string{}
+	for pid, want := range map[int]string{300: "42", 200: "42", 400: "", 500: ""} {
+		if got := s.descendantID(root, pid, games, memo); got != want {
+			t.Fatalf("descendantID(%d) = %q, want %q", pid, got, want)
+		}
+	}
+	if id, ok := memo[200]; !ok || id != "42" {
+		t.Fatalf("memo[200] = %q, %v", id, ok)
+	}
+
+	// The last Scan placed 500 with game 42; the same process stays with it.
+	s.lineage = map[int]lineage{500: {gameID: "42", start: 100}}
+	if got := s.descendantID(root, 500, nil, map[int]string{}); got != "42" {
+		t.Fatalf("reparented descendant = %q", got)
+	}
+	// A new process reusing the PID does not.
+	s.lineage[500] = lineage{gameID: "42", start: 7}
+	if got := s.descendantID(root, 500, nil, map[int]string{}); got != "" {
+		t.Fatalf("recycled pid = %q", got)
+	}
+}
@@ -0,0 +1,106 @@
+package procscan
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// watchTTL is how long a launcher descendant stays on the watchlist. Games
+// usually appear within seconds of the wrapper chain starting.
+const watchTTL = time.Minute
+
+// FastScan looks for games among new descendants of ignored launcher
+// processes (Steam, pressure-vessel, wine, ...) found by the last Scan. It
+// only walks /proc/<pid>/task/*/children from those roots, so it is cheap
+// enough to run several times per poll interval. It reports whether a new
+// descendant carries a game env key or allowlisted exe, is found by a
+// Detector, or is a sandboxed process under a Steam reaper; the caller
+// should then run a full Scan.
+func (s *Scanner) FastScan(now time.Time) bool {
+	for pid, expiry := range s.watch {
+		if now.After(expiry) {
+			delete(s.watch, pid)
+		}
+	}
+	if len(s.launchers) == 0 && len(s.watch) == 0 {
+		return false
+	}
+
+	queue := append([]int{}, s.launchers...)
+	for pid := range s.watch {
+		queue = append(queue, pid)
+	}
+	visited := make(map[int]struct{}, len(queue))
+	memo := map[int]ancestry{}
+	found := false
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[pid]; ok {
+			continue
+		}
+		visited[pid] = struct{}{}
+		for _, child := range childrenAt(s.procRoot, pid) {
+			queue = append(queue, child)
+			if _, ok := s.known[child]; ok {
+				continue
+			}
+			if _, ok := s.watch[child]; ok {
+				continue
+			}
+			s.watch[child] = now.Add(watchTTL)
+			if s.isGameAt(s.procRoot, child) || s.sandboxAppID(s.procRoot, child, memo) != "" {
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+func (s *Scanner) isGameAt(procRoot string, pid int) bool {
+	owned, err := isOwnedByUIDAt(procRoot, pid, s.UID)
+	if err != nil || !owned {
+		return false
+	}
+	exe := exeBasenameLowerAt(procRoot, pid)
+	if exe == "" {
+		return false
+	}
+	if s.ignored(exe) {
+		return false
+	}
+	if id, _ := s.gameIDFromEnvironAt(procRoot, pid); id != "" {
+		return true
+	}
+	if s.exeAllowlist.Match(exe) {
+		return true
+	}
+	id, _ := s.detect(procRoot, pid, exe)
+	return id != ""
+}
+
+// childrenAt lists the children of every thread of pid. It needs
+// CONFIG_PROC_CHILDREN; without it FastScan finds nothing and games are
+// picked up by the regular Scan.
+func childrenAt(procRoot string, pid int) []int {
+	files, err := filepath.Glob(filepath.Join(procRoot, strconv.Itoa(pid), "task", "*", "children"))
+	if err != nil {
+		return nil
+	}
+	out := make([]int, 0, 4)
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, f := range strings.Fields(string(data)) {
+			if child, err := strconv.Atoi(f); err == nil && child > 0 {
+				out = append(out, child)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package procscan
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func writeProc(t testing.TB, root string, pid int, exe string, environ string, children string) {
+	t.Helper()
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	task := filepath.Join(dir, "task", strconv.Itoa(pid))
+	if err := os.MkdirAll(task, 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	files := map[string]string{
+		filepath.Join(dir, "status"):    "Name:\tx\nUid:\t1000\t1000\t1000\t1000\n",
+		filepath.Join(dir, "environ"):   environ,
+		filepath.Join(task, "children"): children,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	if err := os.Symlink(exe, filepath.Join(dir, "exe")); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+}
+
+func TestFastScanFindsLateDescendant(t *testing.T) {
+	root := t.TempDir()
+	writeProc(t, root, 100, "/usr/bin/steam", "", "200 ")
+	writeProc(t, root, 200, "/usr/bin/pressure-vessel-wrap", "", "300")
+	writeProc(t, root, 300, "/games/game.exe", "SteamAppId=42\x00", "")
+
+	s := NewScanner(1000, []string{"SteamAppId"}, nil, []string{"steam", "pressure-vessel-wrap"}, nil)
+	s.procRoot = root
+	s.launchers = []int{100}
+	s.known = map[int]struct{}{100: {}, 200: {}}
+
+	now := time.Now()
+	if !s.FastScan(now) {
+		t.Fatalf("expected FastScan to find the game")
+	}
+	if _, ok := s.watch[300]; !ok {
+		t.Fatalf("expected pid 300 on the watchlist: %v", s.watch)
+	}
+	if s.FastScan(now) {
+		t.Fatalf("expected already-watched pid not to be reported again")
+	}
+}
@@ -0,0 +1,85 @@
+// Package quirks holds per-title workarounds. Entries come from the
+// config's [quirks."APPID"] tables and from pin request policies; nothing is
+// embedded or fetched.
+package quirks
+
+import (
+	"sort"
+	"strings"
+)
+
+// Quirk describes known workarounds for a single title.
+type Quirk struct {
+	// NoTouchGame leaves the game's processes alone (no scope, no AllowedCPUs),
+	// typically for anti-cheat that objects to cgroup moves. OS slices are
+	// still pinned.
+	NoTouchGame bool `json:"no_touch_game,omitempty"`
+	// PrefersSwap runs the game on the OS CPUs and moves the OS slices to the
+	// GAME CPUs instead.
+	PrefersSwap bool `json:"prefers_swap,omitempty"`
+	// NeedsSMTOff marks titles that benefit from SMT being disabled.
+	NeedsSMTOff bool `json:"needs_smt_off,omitempty"`
+}
+
+// Override is a local, partial Quirk. Nil fields keep the default.
+type Override struct {
+	NoTouchGame *bool
+	PrefersSwap *bool
+	NeedsSMTOff *bool
+}
+
+// DB answers quirk lookups by game ID. The zero value knows no titles.
+type DB struct {
+	overrides map[string]Override
+}
+
+// WithOverrides returns a copy of db whose lookups apply the given local
+// overrides.
+func (db DB) WithOverrides(overrides map[string]Override) DB {
+	db.overrides = overrides
+	return db
+}
+
+// Lookup returns the effective quirk for gameID.
+func (db DB) Lookup(gameID string) (Quirk, bool) {
+	o, ok := db.overrides[strings.TrimSpace(gameID)]
+	if !ok {
+		return Quirk{}, false
+	}
+	var q Quirk
+	if o.NoTouchGame != nil {
+		q.NoTouchGame = *o.NoTouchGame
+	}
+	if o.PrefersSwap != nil {
+		q.PrefersSwap = *o.PrefersSwap
+	}
+	if o.NeedsSMTOff != nil {
+		q.NeedsSMTOff = *o.NeedsSMTOff
+	}
+	return q, true
+}
+
+// IDs returns the sorted list of game IDs with a quirk.
+func (db DB) IDs() []string {
+	out := make([]string, 0, len(db.overrides))
+	for id := range db.overrides {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Flags returns the quirk's enabled flags by their config names.
+func (q Quirk) Flags() []string {
+	var out []string
+	if q.NoTouchGame {
+		out = append(out, "no_touch_game")
+	}
+	if q.PrefersSwap {
+		out = append(out, "prefers_swap")
+	}
+	if q.NeedsSMTOff {
+		out = append(out, "needs_smt_off")
+	}
+	return out
+}
@@ -0,0 +1,27 @@
+package quirks
+
+import "testing"
+
+func TestLookup_Overrides(t *testing.T) {
+	off := false
+	on := true
+	db := DB{}.WithOverrides(map[string]Override{
+		"42": {NoTouchGame: &off, NeedsSMTOff: &on},
+		"7":  {PrefersSwap: &on},
+	})
+
+	q, ok := db.Lookup("42")
+	if !ok || q.NoTouchGame || !q.NeedsSMTOff {
+		t.Fatalf("unexpected quirk for 42: %+v", q)
+	}
+	q, ok = db.Lookup(" 7 ")
+	if !ok || !q.PrefersSwap {
+		t.Fatalf("unexpected quirk for 7: %+v", q)
+	}
+	if _, ok := db.Lookup("1"); ok {
+		t.Fatalf("expected no quirk for unknown id")
+	}
+	if got := db.IDs(); len(got) != 2 || got[0] != "42" || got[1] != "7" {
+		t.Fatalf("IDs = %v", got)
+	}
+}
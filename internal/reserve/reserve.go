@@ -0,0 +1,176 @@
+// Package reserve finds CPUs set aside by something other than ccdbind:
+// kernel isolation, systemd's system-wide CPUAffinity=, cpusets on the
+// user's slices and exclusive cpuset partitions elsewhere in the hierarchy
+// (kubelet, VM hosts, real-time setups). ccdbind must not place the OS or
+// the games on them.
+package reserve
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// Reservation is a set of CPUs reserved by Source. Cgroup is the cgroup
+// path relative to the cgroup root for reservations made by a cpuset.
+type Reservation struct {
+	Source string `json:"source"`
+	Cgroup string `json:"cgroup,omitempty"`
+	CPUs   string `json:"cpus"`
+}
+
+var (
+	cpuRoot  = "/sys/devices/system/cpu"
+	procRoot = "/proc"
+)
+
+// Detect returns the reservations that apply to uid's processes.
+func Detect(uid int) []Reservation {
+	return detectAt(cpuRoot, procRoot, systemdctl.CgroupRoot, uid)
+}
+
+func detectAt(cpuRoot, procRoot, cgRoot string, uid int) []Reservation {
+	online, err := readList(filepath.Join(cpuRoot, "online"))
+	if err != nil || len(online) == 0 {
+		return nil
+	}
+	var out []Reservation
+	add := func(source, cgroup string, cpus []int) {
+		if len(cpus) > 0 {
+			out = append(out, Reservation{Source: source, Cgroup: cgroup, CPUs: topology.FormatCPUList(cpus)})
+		}
+	}
+
+	if cpus, err := readList(filepath.Join(cpuRoot, "isolated")); err == nil {
+		add("kernel isolcpus", "", cpus)
+	}
+	if allowed, err := pid1Affinity(procRoot); err == nil {
+		add("systemd CPUAffinity", "", minus(online, allowed))
+	}
+
+	// The first cgroup on the way down to the user manager that narrows the
+	// cpuset is the one to blame.
+	userPath := []string{"user.slice", fmt.Sprintf("user-%d.slice", uid), fmt.Sprintf("user@%d.service", uid)}
+	prev := online
+	for i := range userPath {
+		rel := filepath.Join(userPath[:i+1]...)
+		eff, err := readList(filepath.Join(cgRoot, rel, "cpuset.cpus.effective"))
+		if err != nil || len(eff) == 0 {
+			continue
+		}
+		add("cgroup "+rel, rel, minus(prev, eff))
+		prev = eff
+	}
+
+	// Partitions and exclusive CPUs take CPUs away from their siblings.
+	for _, rel := range partitionCandidates(cgRoot) {
+		if rel == userPath[0] || strings.HasPrefix(rel, userPath[0]+"/") {
+			continue
+		}
+		dir := filepath.Join(cgRoot, rel)
+		if part, err := os.ReadFile(filepath.Join(dir, "cpuset.cpus.partition")); err == nil {
+			p := strings.TrimSpace(string(part))
+			if p == "root" || p == "isolated" {
+				if cpus, err := readList(filepath.Join(dir, "cpuset.cpus.effective")); err == nil {
+					add("cgroup partition "+rel, rel, cpus)
+				}
+				continue
+			}
+		}
+		if cpus, err := readList(filepath.Join(dir, "cpuset.cpus.exclusive")); err == nil {
+			add("cgroup exclusive "+rel, rel, cpus)
+		}
+	}
+	return out
+}
+
+// partitionCandidates lists cgroups one and two levels below the root,
+// where partitions are set up in practice (kubepods.slice, machine.slice,
+// system.slice/foo.service).
+func partitionCandidates(cgRoot string) []string {
+	var out []string
+	top, err := os.ReadDir(cgRoot)
+	if err != nil {
+		return nil
+	}
+	for _, e := range top {
+		if !e.IsDir() {
+			continue
+		}
+		out = append(out, e.Name())
+		sub, err := os.ReadDir(filepath.Join(cgRoot, e.Name()))
+		if err != nil {
+			continue
+		}
+		for _, s := range sub {
+			if s.IsDir() {
+				out = append(out, e.Name()+"/"+s.Name())
+			}
+		}
+	}
+	return out
+}
+
+// CPUs returns the union of the reserved CPUs.
+func CPUs(rs []Reservation) []int {
+	lists := make([]string, len(rs))
+	for i, r := range rs {
+		lists[i] = r.CPUs
+	}
+	cpus, _ := topology.ParseCPUList(topology.UnionCPULists(lists...))
+	return cpus
+}
+
+// Overlapping returns the reservations that share CPUs with cpus.
+func Overlapping(rs []Reservation, cpus []int) []Reservation {
+	var out []Reservation
+	for _, r := range rs {
+		rc, err := topology.ParseCPUList(r.CPUs)
+		if err != nil {
+			continue
+		}
+		if len(rc) != len(minus(rc, cpus)) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func pid1Affinity(procRoot string) ([]int, error) {
+	f, err := os.Open(filepath.Join(procRoot, "1", "status"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "Cpus_allowed_list:"); ok {
+			return topology.ParseCPUList(strings.TrimSpace(v))
+		}
+	}
+	return nil, fmt.Errorf("no Cpus_allowed_list in %s/1/status", procRoot)
+}
+
+func readList(path string) ([]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return topology.ParseCPUList(strings.TrimSpace(string(data)))
+}
+
+// minus returns the CPUs in a that are not in b.
+func minus(a, b []int) []int {
+	out := make([]int, 0, len(a))
+	for _, c := range a {
+		if !topology.ContainsCPU(b, c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package reserve
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	root := t.TempDir()
+	cpu, proc, cg := filepath.Join(root, "cpu"), filepath.Join(root, "proc"), filepath.Join(root, "cgroup")
+	writeFile(t, filepath.Join(cpu, "online"), "0-31")
+	writeFile(t, filepath.Join(cpu, "isolated"), "30-31")
+	writeFile(t, filepath.Join(proc, "1", "status"), "Name:\tsystemd\nCpus_allowed_list:\t0-29\n")
+	writeFile(t, filepath.Join(cg, "user.slice", "cpuset.cpus.effective"), "0-27")
+	writeFile(t, filepath.Join(cg, "user.slice", "user-1000.slice", "cpuset.cpus.effective"), "0-27")
+	writeFile(t, filepath.Join(cg, "user.slice", "user-1000.slice", "user@1000.service", "cpuset.cpus.effective"), "0-25")
+	writeFile(t, filepath.Join(cg, "kubepods.slice", "cpuset.cpus.partition"), "root")
+	writeFile(t, filepath.Join(cg, "kubepods.slice", "cpuset.cpus.effective"), "24-25")
+	writeFile(t, filepath.Join(cg, "system.slice", "rt.service", "cpuset.cpus.exclusive"), "22")
+	writeFile(t, filepath.Join(cg, "system.slice", "cpuset.cpus.partition"), "member")
+
+	got := detectAt(cpu, proc, cg, 1000)
+	want := []Reservation{
+		{Source: "kernel isolcpus", CPUs: "30-31"},
+		{Source: "systemd CPUAffinity", CPUs: "30-31"},
+		{Source: "cgroup user.slice", Cgroup: "user.slice", CPUs: "28-31"},
+		{Source: "cgroup user.slice/user-1000.slice/user@1000.service", Cgroup: "user.slice/user-1000.slice/user@1000.service", CPUs: "26-27"},
+		{Source: "cgroup partition kubepods.slice", Cgroup: "kubepods.slice", CPUs: "24-25"},
+		{Source: "cgroup exclusive system.slice/rt.service", Cgroup: "system.slice/rt.service", CPUs: "22"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %#v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("reservation %d: got %#v want %#v", i, got[i], want[i])
+		}
+	}
+
+	if cpus := CPUs(got); len(cpus) != 9 || cpus[0] != 22 {
+		t.Fatalf("CPUs = %v", cpus)
+	}
+	if o := Overlapping(got, []int{0, 1, 22}); len(o) != 1 || o[0].Source != "cgroup exclusive system.slice/rt.service" {
+		t.Fatalf("Overlapping = %#v", o)
+	}
+}
+
+func TestDetect_NothingReserved(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "cpu", "online"), "0-7")
+	writeFile(t, filepath.Join(root, "cpu", "isolated"), "")
+	writeFile(t, filepath.Join(root, "proc", "1", "status"), "Cpus_allowed_list:\t0-7\n")
+	if got := detectAt(filepath.Join(root, "cpu"), filepath.Join(root, "proc"), filepath.Join(root, "cgroup"), 1000); len(got) != 0 {
+		t.Fatalf("expected no reservations, got %#v", got)
+	}
+}
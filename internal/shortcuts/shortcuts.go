@@ -0,0 +1,236 @@
+// Package shortcuts binds global keyboard shortcuts through the XDG desktop
+// portal (org.freedesktop.portal.GlobalShortcuts). The compositor owns the
+// key grab and asks the user to confirm or change the trigger, so this works
+// under Wayland where applications cannot grab keys themselves.
+package shortcuts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	busName    = "org.freedesktop.portal.Desktop"
+	objPath    = "/org/freedesktop/portal/desktop"
+	iface      = "org.freedesktop.portal.GlobalShortcuts"
+	requestIfc = "org.freedesktop.portal.Request"
+)
+
+// Shortcut is a shortcut to bind. PreferredTrigger uses the XDG shortcuts
+// syntax, e.g. "CTRL+SHIFT+F12"; the portal may ignore it.
+type Shortcut struct {
+	ID               string
+	Description      string
+	PreferredTrigger string
+}
+
+// Bound is a shortcut as the portal bound it.
+type Bound struct {
+	ID      string
+	Trigger string // human readable, empty when the portal does not say
+}
+
+type Session struct {
+	conn      *dbus.Conn
+	handle    dbus.ObjectPath
+	activated chan string
+
+	mu      sync.Mutex
+	pending map[dbus.ObjectPath]chan response
+	token   int
+}
+
+type response struct {
+	code    uint32
+	results map[string]dbus.Variant
+}
+
+// Open connects to the session bus and creates a GlobalShortcuts session.
+func Open(ctx context.Context) (*Session, error) {
+	conn, err := dbus.SessionBusPrivate()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, m := range [][]dbus.MatchOption{
+		{dbus.WithMatchInterface(requestIfc), dbus.WithMatchMember("Response")},
+		{dbus.WithMatchObjectPath(objPath), dbus.WithMatchInterface(iface), dbus.WithMatchMember("Activated")},
+	} {
+		if err := conn.AddMatchSignal(m...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	s := &Session{conn: conn, activated: make(chan string, 8), pending: map[dbus.ObjectPath]chan response{}}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+	go s.dispatch(signals)
+
+	resp, err := s.call(ctx, "CreateSession", func(token string) []any {
+		return []any{map[string]dbus.Variant{
+			"handle_token":         dbus.MakeVariant(token),
+			"session_handle_token": dbus.MakeVariant(token),
+		}}
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("CreateSession: %w", err)
+	}
+	var handle string
+	switch v := resp.results["session_handle"].Value().(type) {
+	case string:
+		handle = v
+	case dbus.ObjectPath: // older portals
+		handle = string(v)
+	}
+	if handle == "" {
+		conn.Close()
+		return nil, errors.New("CreateSession: no session handle")
+	}
+	s.handle = dbus.ObjectPath(handle)
+	return s, nil
+}
+
+// Bind registers the shortcuts with the session. The portal usually shows a
+// dialog where the user confirms or changes each trigger.
+func (s *Session) Bind(ctx context.Context, list []Shortcut) ([]Bound, error) {
+	type shortcut struct {
+		ID    string
+		Props map[string]dbus.Variant
+	}
+	arg := make([]shortcut, 0, len(list))
+	for _, sc := range list {
+		props := map[string]dbus.Variant{"description": dbus.MakeVariant(sc.Description)}
+		if sc.PreferredTrigger != "" {
+			props["preferred_trigger"] = dbus.MakeVariant(sc.PreferredTrigger)
+		}
+		arg = append(arg, shortcut{ID: sc.ID, Props: props})
+	}
+	resp, err := s.call(ctx, "BindShortcuts", func(token string) []any {
+		return []any{s.handle, arg, "", map[string]dbus.Variant{"handle_token": dbus.MakeVariant(token)}}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("BindShortcuts: %w", err)
+	}
+	return boundFromResults(resp.results), nil
+}
+
+// Activated delivers the ID of each shortcut the user presses. It is closed
+// by Close.
+func (s *Session) Activated() <-chan string {
+	return s.activated
+}
+
+func (s *Session) Close() error {
+	if s.handle != "" {
+		_ = s.conn.Object(busName, s.handle).Call("org.freedesktop.portal.Session.Close", 0).Err
+	}
+	return s.conn.Close()
+}
+
+// call invokes a portal method that answers through a Request object. The
+// request path is derived from the token and registered before the call so
+// a fast Response cannot be missed.
+func (s *Session) call(ctx context.Context, method string, args func(token string) []any) (response, error) {
+	s.mu.Lock()
+	s.token++
+	token := fmt.Sprintf("ccdbind%d", s.token)
+	path := requestPath(s.conn.Names()[0], token)
+	ch := make(chan response, 1)
+	s.pending[path] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, path)
+		s.mu.Unlock()
+	}()
+
+	if err := s.conn.Object(busName, objPath).CallWithContext(ctx, iface+"."+method, 0, args(token)...).Err; err != nil {
+		return response{}, err
+	}
+	select {
+	case r := <-ch:
+		switch r.code {
+		case 0:
+			return r, nil
+		case 1:
+			return r, errors.New("cancelled by the user")
+		}
+		return r, fmt.Errorf("portal response %d", r.code)
+	case <-ctx.Done():
+		return response{}, ctx.Err()
+	}
+}
+
+func (s *Session) dispatch(signals <-chan *dbus.Signal) {
+	defer close(s.activated)
+	for sig := range signals {
+		switch sig.Name {
+		case requestIfc + ".Response":
+			if len(sig.Body) < 2 {
+				continue
+			}
+			code, _ := sig.Body[0].(uint32)
+			results, _ := sig.Body[1].(map[string]dbus.Variant)
+			s.mu.Lock()
+			ch, ok := s.pending[sig.Path]
+			s.mu.Unlock()
+			if ok {
+				ch <- response{code: code, results: results}
+			}
+		case iface + ".Activated":
+			if len(sig.Body) < 2 {
+				continue
+			}
+			handle, _ := sig.Body[0].(dbus.ObjectPath)
+			id, _ := sig.Body[1].(string)
+			if handle != s.handle || id == "" {
+				continue
+			}
+			select {
+			case s.activated <- id:
+			default: // presses while the last one is still handled are dropped
+			}
+		}
+	}
+}
+
+// requestPath is the Request object the portal creates for a call from
+// sender with handle_token token.
+func requestPath(sender, token string) dbus.ObjectPath {
+	sender = strings.ReplaceAll(strings.TrimPrefix(sender, ":"), ".", "_")
+	return dbus.ObjectPath(objPath + "/request/" + sender + "/" + token)
+}
+
+func boundFromResults(results map[string]dbus.Variant) []Bound {
+	v, ok := results["shortcuts"]
+	if !ok {
+		return nil
+	}
+	var list []struct {
+		ID    string
+		Props map[string]dbus.Variant
+	}
+	if err := dbus.Store([]any{v.Value()}, &list); err != nil {
+		return nil
+	}
+	out := make([]Bound, 0, len(list))
+	for _, sc := range list {
+		trigger, _ := sc.Props["trigger_description"].Value().(string)
+		out = append(out, Bound{ID: sc.ID, Trigger: trigger})
+	}
+	return out
+}
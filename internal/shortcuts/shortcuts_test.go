@@ -0,0 +1,34 @@
+package shortcuts
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestRequestPath(t *testing.T) {
+	got := requestPath(":1.42", "ccdbind1")
+	if want := dbus.ObjectPath("/org/freedesktop/portal/desktop/request/1_42/ccdbind1"); got != want {
+		t.Fatalf("requestPath = %q, want %q", got, want)
+	}
+}
+
+func TestBoundFromResults(t *testing.T) {
+	type shortcut struct {
+		ID    string
+		Props map[string]dbus.Variant
+	}
+	results := map[string]dbus.Variant{
+		"shortcuts": dbus.MakeVariant([]shortcut{
+			{ID: "toggle-pause", Props: map[string]dbus.Variant{"trigger_description": dbus.MakeVariant("Ctrl+Shift+F12")}},
+			{ID: "other", Props: map[string]dbus.Variant{}},
+		}),
+	}
+	got := boundFromResults(results)
+	if len(got) != 2 || got[0] != (Bound{ID: "toggle-pause", Trigger: "Ctrl+Shift+F12"}) || got[1] != (Bound{ID: "other"}) {
+		t.Fatalf("boundFromResults = %#v", got)
+	}
+	if got := boundFromResults(nil); got != nil {
+		t.Fatalf("expected nil for missing shortcuts, got %#v", got)
+	}
+}
@@ -0,0 +1,130 @@
+// Package smt switches simultaneous multithreading off and back on through
+// /sys/devices/system/cpu/smt/control.
+//
+// The control file is root-only. When the user cannot write it (a
+// tmpfiles.d entry can grant that), the switch goes through Unit, a system
+// oneshot unit shipped with ccdbind that writes "off" when started and "on"
+// when stopped. Starting and stopping it over the system bus is subject to
+// polkit, so no setuid helper is involved.
+package smt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Unit is the system unit that keeps SMT off while it is active.
+const Unit = "ccdbind-smt-off.service"
+
+// Sysfs is the method recorded when the control file was written directly.
+const Sysfs = "sysfs"
+
+// Root is the sysfs SMT directory.
+var Root = "/sys/devices/system/cpu/smt"
+
+// ErrUnsupported is returned when the CPU or kernel cannot switch SMT at
+// runtime.
+var ErrUnsupported = errors.New("smt control not supported")
+
+// Control returns the current SMT state: on, off, forceoff, notsupported or
+// notimplemented.
+func Control() (string, error) {
+	data, err := os.ReadFile(filepath.Join(Root, "control"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Disable switches SMT off and returns how: Sysfs or Unit. It returns an
+// empty method and no error when SMT is already off, since then there is
+// nothing to restore.
+func Disable(ctx context.Context) (string, error) {
+	cur, err := Control()
+	if err != nil {
+		return "", err
+	}
+	switch cur {
+	case "off", "forceoff":
+		return "", nil
+	case "on":
+	default:
+		return "", fmt.Errorf("%w (control is %q)", ErrUnsupported, cur)
+	}
+	err = write("off")
+	if err == nil {
+		return Sysfs, nil
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		return "", err
+	}
+	if uerr := callUnit(ctx, "StartUnit"); uerr != nil {
+		return "", fmt.Errorf("%v; %w", err, uerr)
+	}
+	return Unit, nil
+}
+
+// Enable switches SMT back on the way Disable switched it off.
+func Enable(ctx context.Context, method string) error {
+	if method == Unit {
+		return callUnit(ctx, "StopUnit")
+	}
+	return write("on")
+}
+
+func write(value string) error {
+	return os.WriteFile(filepath.Join(Root, "control"), []byte(value), 0)
+}
+
+// callUnit starts or stops Unit on the system manager and waits for the
+// job, so the CPUs are on- or offline when it returns.
+func callUnit(ctx context.Context, method string) error {
+	conn, err := dbus.SystemBusPrivate()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.Auth(nil); err != nil {
+		return err
+	}
+	if err := conn.Hello(); err != nil {
+		return err
+	}
+	if err := conn.AddMatchSignal(dbus.WithMatchInterface("org.freedesktop.systemd1.Manager"), dbus.WithMatchMember("JobRemoved")); err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+
+	m := conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
+	var job dbus.ObjectPath
+	if err := m.CallWithContext(ctx, "org.freedesktop.systemd1.Manager."+method, 0, Unit, "replace").Store(&job); err != nil {
+		return fmt.Errorf("%s %s: %w", method, Unit, err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sig, ok := <-signals:
+			if !ok {
+				return errors.New("system bus connection lost")
+			}
+			if len(sig.Body) < 4 {
+				continue
+			}
+			if path, _ := sig.Body[1].(dbus.ObjectPath); path != job {
+				continue
+			}
+			if result, _ := sig.Body[3].(string); result != "done" {
+				return fmt.Errorf("%s %s: job %s", method, Unit, result)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package smt
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDisableEnableSysfs(t *testing.T) {
+	Root = t.TempDir()
+	t.Cleanup(func() { Root = "/sys/devices/system/cpu/smt" })
+	control := filepath.Join(Root, "control")
+	set := func(v string) {
+		t.Helper()
+		if err := os.WriteFile(control, []byte(v+"\n"), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	ctx := context.Background()
+
+	set("on")
+	method, err := Disable(ctx)
+	if err != nil || method != Sysfs {
+		t.Fatalf("Disable = %q, %v", method, err)
+	}
+	if got, _ := Control(); got != "off" {
+		t.Fatalf("control after Disable = %q", got)
+	}
+	if err := Enable(ctx, method); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	if got, _ := Control(); got != "on" {
+		t.Fatalf("control after Enable = %q", got)
+	}
+
+	set("forceoff")
+	if method, err := Disable(ctx); err != nil || method != "" {
+		t.Fatalf("Disable with SMT already off = %q, %v", method, err)
+	}
+	set("notsupported")
+	if _, err := Disable(ctx); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Disable without SMT = %v", err)
+	}
+}
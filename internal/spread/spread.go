@@ -0,0 +1,309 @@
+// Package spread keeps the busiest threads of a game on distinct physical
+// cores. The scheduler is free to run two hot threads on the SMT siblings
+// of one core while other cores idle, and some engines pin their workers
+// no better; spreading them gives each a core of its own. Threads are
+// sampled from /proc/<pid>/task between calls and pinned with
+// sched_setaffinity, which only narrows them within their cgroup's cpuset.
+package spread
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/affinity"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// HotShare is the share of one CPU a thread must use between two calls to
+// count as hot.
+const HotShare = 0.5
+
+// userHZ is the unit of the utime and stime fields of stat, fixed at 100 on
+// Linux regardless of the kernel's tick rate.
+const userHZ = 100
+
+// Group is the processes of one game and the CPUs their scope runs on.
+type Group struct {
+	CPUs string
+	PIDs []int
+}
+
+type thread struct {
+	tid   int
+	start uint64
+}
+
+type sample struct {
+	ticks uint64
+	at    time.Time
+}
+
+// Balancer remembers the thread samples and placements between calls.
+type Balancer struct {
+	procRoot string
+	cores    [][]int
+	// DryRun logs the placements through Logf instead of applying them.
+	DryRun bool
+	Logf   func(format string, args ...any)
+
+	samples map[thread]sample
+	placed  map[thread]placement
+
+	set func(tid int, cpus []int) error
+	get func(tid int) ([]int, error)
+}
+
+type placement struct {
+	core int   // index into cores
+	home []int // the group's CPUs, restored when the thread cools down
+}
+
+// New returns a Balancer for the physical cores of the machine, each given
+// as its logical CPUs (see topology.DetectCores).
+func New(cores [][]int) *Balancer {
+	return &Balancer{
+		procRoot: "/proc",
+		cores:    cores,
+		samples:  map[thread]sample{},
+		placed:   map[thread]placement{},
+		set:      affinity.Set,
+		get:      affinity.Get,
+	}
+}
+
+// Balance samples the threads of groups and gives each hot one the CPUs of
+// a core no other hot thread runs on, preferring the core it had. Hot
+// threads are only spread within a group with two or more of them and two
+// or more cores; any beyond the cores available keep the whole set.
+// Threads that cooled down or whose group no longer qualifies get the whole
+// set back. It returns the number of threads moved and the first error.
+func (b *Balancer) Balance(groups []Group, now time.Time) (int, error) {
+	seen := map[thread]bool{}
+	busy := map[int]bool{}
+	want := map[thread]placement{}
+	for _, g := range groups {
+		_, cpus, err := topology.CanonicalizeCPUList(g.CPUs)
+		if err != nil || len(cpus) == 0 {
+			continue
+		}
+		hot := b.hotThreads(g.PIDs, now, seen)
+		cores := b.coresWithin(cpus)
+		if len(hot) < 2 || len(cores) < 2 {
+			continue
+		}
+		// Keep the cores hot threads already have before handing out others.
+		var rest []thread
+		for _, t := range hot {
+			if p, ok := b.placed[t]; ok && contains(cores, p.core) && !busy[p.core] {
+				busy[p.core] = true
+				want[t] = placement{core: p.core, home: cpus}
+				continue
+			}
+			rest = append(rest, t)
+		}
+		for _, t := range rest {
+			for _, c := range cores {
+				if !busy[c] {
+					busy[c] = true
+					want[t] = placement{core: c, home: cpus}
+					break
+				}
+			}
+		}
+	}
+	for t := range b.samples {
+		if !seen[t] {
+			delete(b.samples, t)
+		}
+	}
+
+	moved := 0
+	var firstErr error
+	apply := func(t thread, cpus []int) {
+		if err := b.pin(t.tid, cpus); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("thread %d: %w", t.tid, err)
+			}
+			return
+		}
+		moved++
+	}
+	for t, p := range b.placed {
+		if _, ok := want[t]; ok {
+			continue
+		}
+		delete(b.placed, t)
+		if seen[t] {
+			apply(t, p.home)
+		}
+	}
+	for t, p := range want {
+		cpus := b.coreCPUs(p.core, p.home)
+		if cur, err := b.get(t.tid); err == nil && equal(cur, cpus) {
+			b.placed[t] = p
+			continue
+		}
+		b.placed[t] = p
+		apply(t, cpus)
+	}
+	return moved, firstErr
+}
+
+// Reset gives every placed thread that still runs its group's CPUs back and
+// forgets all samples.
+func (b *Balancer) Reset() {
+	for t, p := range b.placed {
+		if start, err := b.startTime(t.tid); err == nil && start == t.start {
+			_ = b.pin(t.tid, p.home)
+		}
+	}
+	b.placed = map[thread]placement{}
+	b.samples = map[thread]sample{}
+}
+
+func (b *Balancer) pin(tid int, cpus []int) error {
+	if b.DryRun {
+		if b.Logf != nil {
+			b.Logf("dry-run: thread %d affinity %s", tid, topology.FormatCPUList(cpus))
+		}
+		return nil
+	}
+	err := b.set(tid, cpus)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// hotThreads returns the threads of pids above HotShare since their last
+// sample, busiest first, and records the new samples. A thread seen for the
+// first time is only sampled.
+func (b *Balancer) hotThreads(pids []int, now time.Time, seen map[thread]bool) []thread {
+	type load struct {
+		t     thread
+		share float64
+	}
+	var hot []load
+	for _, pid := range pids {
+		tids, err := os.ReadDir(filepath.Join(b.procRoot, strconv.Itoa(pid), "task"))
+		if err != nil {
+			continue
+		}
+		for _, e := range tids {
+			tid, err := strconv.Atoi(e.Name())
+			if err != nil {
+				continue
+			}
+			ticks, start, err := readStat(filepath.Join(b.procRoot, strconv.Itoa(pid), "task", e.Name(), "stat"))
+			if err != nil {
+				continue
+			}
+			t := thread{tid: tid, start: start}
+			seen[t] = true
+			prev, ok := b.samples[t]
+			b.samples[t] = sample{ticks: ticks, at: now}
+			elapsed := now.Sub(prev.at).Seconds()
+			if !ok || elapsed <= 0 || ticks < prev.ticks {
+				continue
+			}
+			share := float64(ticks-prev.ticks) / userHZ / elapsed
+			if share >= HotShare {
+				hot = append(hot, load{t, share})
+			}
+		}
+	}
+	sort.SliceStable(hot, func(i, j int) bool { return hot[i].share > hot[j].share })
+	out := make([]thread, len(hot))
+	for i, l := range hot {
+		out[i] = l.t
+	}
+	return out
+}
+
+// coresWithin returns the indexes of the cores with a CPU in cpus.
+func (b *Balancer) coresWithin(cpus []int) []int {
+	var out []int
+	for i, core := range b.cores {
+		for _, cpu := range core {
+			if topology.ContainsCPU(cpus, cpu) {
+				out = append(out, i)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// coreCPUs returns the CPUs of core that are in home.
+func (b *Balancer) coreCPUs(core int, home []int) []int {
+	var out []int
+	for _, cpu := range b.cores[core] {
+		if topology.ContainsCPU(home, cpu) {
+			out = append(out, cpu)
+		}
+	}
+	return out
+}
+
+func (b *Balancer) startTime(tid int) (uint64, error) {
+	_, start, err := readStat(filepath.Join(b.procRoot, strconv.Itoa(tid), "stat"))
+	return start, err
+}
+
+// readStat returns utime+stime and the start time from a stat file.
+func readStat(path string) (uint64, uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	line := strings.TrimSpace(string(data))
+	idx := strings.LastIndexByte(line, ')')
+	if idx == -1 || idx+2 >= len(line) {
+		return 0, 0, fmt.Errorf("invalid stat format")
+	}
+	fields := strings.Fields(line[idx+2:])
+	if len(fields) <= 19 {
+		return 0, 0, fmt.Errorf("stat too short")
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	start, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return utime + stime, start, nil
+}
+
+func contains(s []int, v int) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
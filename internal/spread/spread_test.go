@@ -0,0 +1,133 @@
+package spread
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writeThread writes the stat of tid, a thread of pid, having used ticks of
+// CPU time.
+func writeThread(t *testing.T, root string, pid, tid int, ticks uint64) {
+	t.Helper()
+	stat := fmt.Sprintf("%d (worker) R 1 1 1 0 -1 0 0 0 0 0 %d 0 0 0 20 0 1 0 %d 0 0\n", tid, ticks, 1000+tid)
+	for _, dir := range []string{
+		filepath.Join(root, strconv.Itoa(pid), "task", strconv.Itoa(tid)),
+		filepath.Join(root, strconv.Itoa(tid)),
+	} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+}
+
+func TestReadStat(t *testing.T) {
+	root := t.TempDir()
+	writeThread(t, root, 10, 11, 250)
+	ticks, start, err := readStat(filepath.Join(root, "11", "stat"))
+	if err != nil || ticks != 250 || start != 1011 {
+		t.Fatalf("readStat = %d, %d, %v", ticks, start, err)
+	}
+}
+
+func TestBalance(t *testing.T) {
+	root := t.TempDir()
+	// Four cores with two threads each: 0+4, 1+5, 2+6, 3+7. GAME is 2-3,6-7.
+	cores := [][]int{{0, 4}, {1, 5}, {2, 6}, {3, 7}}
+	b := New(cores)
+	b.procRoot = root
+	aff := map[int][]int{}
+	b.set = func(tid int, cpus []int) error {
+		aff[tid] = append([]int(nil), cpus...)
+		return nil
+	}
+	b.get = func(tid int) ([]int, error) { return aff[tid], nil }
+
+	groups := []Group{{CPUs: "2-3,6-7", PIDs: []int{10}}}
+	now := time.Unix(1000, 0)
+	for tid := 10; tid <= 13; tid++ {
+		writeThread(t, root, 10, tid, 0)
+	}
+	if n, err := b.Balance(groups, now); n != 0 || err != nil {
+		t.Fatalf("first Balance = %d, %v; want only a sample", n, err)
+	}
+
+	// Two seconds later 11 and 12 used 1.8s and 1.2s; 13 0.2s.
+	now = now.Add(2 * time.Second)
+	writeThread(t, root, 10, 11, 180)
+	writeThread(t, root, 10, 12, 120)
+	writeThread(t, root, 10, 13, 20)
+	if n, err := b.Balance(groups, now); n != 2 || err != nil {
+		t.Fatalf("Balance = %d, %v; want 2 moved", n, err)
+	}
+	if !reflect.DeepEqual(aff[11], []int{2, 6}) || !reflect.DeepEqual(aff[12], []int{3, 7}) || aff[13] != nil {
+		t.Fatalf("affinities = %v", aff)
+	}
+
+	// Still hot: nothing moves, and 12 keeps its core though 12 is now the
+	// busier of the two.
+	now = now.Add(2 * time.Second)
+	writeThread(t, root, 10, 11, 300)
+	writeThread(t, root, 10, 12, 310)
+	if n, err := b.Balance(groups, now); n != 0 || err != nil {
+		t.Fatalf("steady Balance = %d, %v", n, err)
+	}
+	if !reflect.DeepEqual(aff[12], []int{3, 7}) {
+		t.Fatalf("12 moved to %v", aff[12])
+	}
+
+	// 12 cools down: with a single hot thread left both get the set back.
+	now = now.Add(2 * time.Second)
+	writeThread(t, root, 10, 11, 490)
+	if n, err := b.Balance(groups, now); n != 2 || err != nil {
+		t.Fatalf("cooling Balance = %d, %v", n, err)
+	}
+	home := []int{2, 3, 6, 7}
+	if !reflect.DeepEqual(aff[11], home) || !reflect.DeepEqual(aff[12], home) {
+		t.Fatalf("affinities after cooling = %v", aff)
+	}
+	if len(b.placed) != 0 {
+		t.Fatalf("placed = %v", b.placed)
+	}
+}
+
+func TestBalanceSharesCoresAcrossGroups(t *testing.T) {
+	root := t.TempDir()
+	b := New([][]int{{0, 2}, {1, 3}})
+	b.procRoot = root
+	aff := map[int][]int{}
+	b.set = func(tid int, cpus []int) error {
+		aff[tid] = cpus
+		return nil
+	}
+	b.get = func(tid int) ([]int, error) { return nil, nil }
+
+	groups := []Group{{CPUs: "0-3", PIDs: []int{10}}, {CPUs: "0-3", PIDs: []int{20}}}
+	now := time.Unix(1000, 0)
+	for _, tid := range []int{10, 11, 20, 21} {
+		writeThread(t, root, tid/10*10, tid, 0)
+	}
+	b.Balance(groups, now)
+	now = now.Add(time.Second)
+	for _, tid := range []int{10, 11, 20, 21} {
+		writeThread(t, root, tid/10*10, tid, 100)
+	}
+	if n, _ := b.Balance(groups, now); n != 2 {
+		t.Fatalf("moved %d threads, want the first game's 2", n)
+	}
+	if aff[20] != nil || aff[21] != nil {
+		t.Fatalf("second game placed on taken cores: %v", aff)
+	}
+
+	b.Reset()
+	if !reflect.DeepEqual(aff[10], []int{0, 1, 2, 3}) || len(b.placed) != 0 {
+		t.Fatalf("after Reset: %v, placed %v", aff, b.placed)
+	}
+}
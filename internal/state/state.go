@@ -1,22 +1,111 @@
 package state
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/Reidond/ccdbind/internal/cpufreq"
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/topology"
 )
 
+// CurrentVersion is the schema version Save writes. Older files are
+// migrated forward on Load, see migrations.
+const CurrentVersion = 2
+
+// ErrCorrupt is returned by Load when neither the state file nor its backup
+// can be used: the JSON does not parse or the checksum does not match.
+var ErrCorrupt = errors.New("state file is corrupt")
+
 type File struct {
-	Version                int               `json:"version"`
-	PinApplied             bool              `json:"pin_applied"`
-	OriginalAllowedCPUs    map[string]string `json:"original_allowed_cpus"`
-	OSCPUs                 string            `json:"os_cpus"`
-	GameCPUs               string            `json:"game_cpus"`
-	UpdatedAt              time.Time         `json:"updated_at"`
-	LastSuccessfulRestore  time.Time         `json:"last_successful_restore"`
-	LastSuccessfulPinApply time.Time         `json:"last_successful_pin_apply"`
+	Version int `json:"version"`
+	// Checksum is the SHA-256 of the file as written with an empty
+	// checksum. Files from version 1 have none.
+	Checksum            string            `json:"checksum"`
+	PinApplied          bool              `json:"pin_applied"`
+	OriginalAllowedCPUs map[string]string `json:"original_allowed_cpus"`
+	OSCPUs              string            `json:"os_cpus"`
+	GameCPUs            string            `json:"game_cpus"`
+
+	// KeptAllowedCPUs holds the slices whose AllowedCPUs someone else
+	// changed while pinned, with that value, under restore_policy =
+	// "keep-modified". They are left alone until the restore, and their
+	// entry in OriginalAllowedCPUs is replaced by the value.
+	KeptAllowedCPUs map[string]string `json:"kept_allowed_cpus,omitempty"`
+
+	// OriginalAllowedMemoryNodes is only populated when memory node pinning is
+	// enabled and the system has more than one NUMA node.
+	OriginalAllowedMemoryNodes map[string]string `json:"original_allowed_memory_nodes,omitempty"`
+	OSMemoryNodes              string            `json:"os_memory_nodes,omitempty"`
+
+	// OriginalGuestCPUs holds the cpuset of each VM or container cgroup
+	// pinned through guest_cpus, restored with the slices.
+	OriginalGuestCPUs map[string]string `json:"original_guest_cpus,omitempty"`
+
+	// IRQBalanceBanned is the CPU list irqbalance was restarted with banned,
+	// kept so a restarted daemon can undo it.
+	IRQBalanceBanned string `json:"irqbalance_banned,omitempty"`
+
+	// SMTDisabled records how SMT was switched off by smt_off (smt.Sysfs or
+	// smt.Unit), so a restarted daemon switches it back on the same way.
+	SMTDisabled string `json:"smt_disabled,omitempty"`
+
+	// OriginalCPUFreq holds the governor and EPP of each game CPU switched
+	// by game_governor or game_epp, restored when the last game exits.
+	OriginalCPUFreq map[int]cpufreq.Setting `json:"original_cpufreq,omitempty"`
+
+	// PowerProfile is the power-profiles-daemon profile switched to by
+	// power_profile, and OriginalPowerProfile the one it replaced, switched
+	// back to when the last game exits.
+	PowerProfile         string `json:"power_profile,omitempty"`
+	OriginalPowerProfile string `json:"original_power_profile,omitempty"`
+
+	// OSSaturatedSince is set while the OS CPUs have stayed above the
+	// saturation threshold for a sustained period, and zero otherwise.
+	OSSaturatedSince time.Time `json:"os_saturated_since"`
+
+	// LentCPUs are GAME CPUs added to the OS slices while the OS CPUs are
+	// saturated, see the lend_cpus profile key. OSCPUs includes them.
+	LentCPUs string `json:"lent_cpus,omitempty"`
+
+	// ScopePIDs maps each PID placed in a game scope to its scope, so a
+	// restarted daemon does not attach them all over again.
+	ScopePIDs map[int]ScopePID `json:"scope_pids,omitempty"`
+
+	// ScopeFailures tracks games whose scope could not be created or pinned;
+	// they are retried with backoff without holding up other games.
+	ScopeFailures map[string]ScopeFailure `json:"scope_failures,omitempty"`
+
+	// Paused is set by `ccdbind pause`; nothing is pinned until resume or,
+	// when PausedUntil is set, until then.
+	Paused      bool      `json:"paused,omitempty"`
+	PausedUntil time.Time `json:"paused_until,omitempty"`
+
+	UpdatedAt              time.Time `json:"updated_at"`
+	LastSuccessfulRestore  time.Time `json:"last_successful_restore"`
+	LastSuccessfulPinApply time.Time `json:"last_successful_pin_apply"`
+}
+
+// ScopePID is a game scope member. StartTime tells the process apart from a
+// later one that reuses the PID.
+type ScopePID struct {
+	Unit      string `json:"unit"`
+	StartTime uint64 `json:"start_time"`
+}
+
+type ScopeFailure struct {
+	Attempts  int       `json:"attempts"`
+	Since     time.Time `json:"since"`
+	LastError string    `json:"last_error"`
+	NextRetry time.Time `json:"next_retry"`
 }
 
 func DefaultPath() (string, error) {
@@ -31,32 +120,149 @@ func DefaultPath() (string, error) {
 	return filepath.Join(base, "ccdbind", "state.json"), nil
 }
 
+// BackupPath is where Save keeps the previous state file.
+func BackupPath(path string) string { return path + ".1" }
+
+// Load reads the state file at path, migrating it to CurrentVersion. A
+// missing file gives an empty state. When the file is corrupt, or missing
+// after a save was interrupted, the backup is used instead; if that fails
+// too, Load returns an empty state and an error wrapping ErrCorrupt so the
+// caller can rebuild it from the live system.
 func Load(path string) (File, error) {
+	st, err := load(path)
+	switch {
+	case err == nil:
+		return st, nil
+	case !errors.Is(err, ErrCorrupt) && !errors.Is(err, os.ErrNotExist):
+		return File{}, err
+	}
+	backup, berr := load(BackupPath(path))
+	if berr == nil {
+		if errors.Is(err, ErrCorrupt) {
+			logging.Warnf(nil, "%v; using the backup %s", err, BackupPath(path))
+		}
+		return backup, nil
+	}
+	if errors.Is(err, os.ErrNotExist) && errors.Is(berr, os.ErrNotExist) {
+		return empty(), nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		err = berr
+	}
+	return empty(), err
+}
+
+func empty() File {
+	return File{Version: CurrentVersion, OriginalAllowedCPUs: map[string]string{}}
+}
+
+func load(path string) (File, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return File{Version: 1}, nil
-		}
+		return File{}, err
+	}
+	st, err := Parse(data)
+	if err != nil {
+		return File{}, fmt.Errorf("%s: %w", path, err)
+	}
+	logging.Tracef(logging.State, nil, "loaded %s version=%d pin_applied=%v paused=%v", path, st.Version, st.PinApplied, st.Paused)
+	return st, nil
+}
+
+// Parse decodes a state file, checks its checksum and migrates it to
+// CurrentVersion. Errors about the content wrap ErrCorrupt.
+func Parse(data []byte) (File, error) {
+	if err := verify(data); err != nil {
 		return File{}, err
 	}
 	var st File
 	if err := json.Unmarshal(data, &st); err != nil {
-		return File{}, err
+		return File{}, fmt.Errorf("%w: %v", ErrCorrupt, err)
 	}
 	if st.Version == 0 {
 		st.Version = 1
 	}
+	if st.Version > CurrentVersion {
+		logging.Warnf(nil, "state file version %d is newer than %d; fields this ccdbind does not know are dropped", st.Version, CurrentVersion)
+	}
+	for v := st.Version; v < CurrentVersion; v++ {
+		migrations[v](&st)
+		st.Version = v + 1
+	}
 	if st.OriginalAllowedCPUs == nil {
 		st.OriginalAllowedCPUs = map[string]string{}
 	}
+	st.Checksum = ""
 	return st, nil
 }
 
+// migrations[v] converts a version v file to version v+1.
+var migrations = map[int]func(*File){
+	// Version 1 stored values read with `systemctl show`, which separates
+	// CPU ranges with spaces; the D-Bus and cgroupfs paths use commas.
+	1: func(st *File) {
+		for _, m := range []map[string]string{st.OriginalAllowedCPUs, st.KeptAllowedCPUs, st.OriginalAllowedMemoryNodes, st.OriginalGuestCPUs} {
+			for k, v := range m {
+				m[k] = normalizeCPUList(v)
+			}
+		}
+		st.OSCPUs = normalizeCPUList(st.OSCPUs)
+		st.GameCPUs = normalizeCPUList(st.GameCPUs)
+		st.OSMemoryNodes = normalizeCPUList(st.OSMemoryNodes)
+	},
+}
+
+// normalizeCPUList rewrites a space separated list with commas. Values that
+// do not parse are kept as they are.
+func normalizeCPUList(s string) string {
+	if !strings.Contains(strings.TrimSpace(s), " ") {
+		return s
+	}
+	canonical, _, err := topology.CanonicalizeCPUList(strings.Join(strings.Fields(s), ","))
+	if err != nil {
+		return s
+	}
+	return canonical
+}
+
+const checksumKey = `"checksum": "`
+
+// verify checks the checksum of data, if it has one.
+func verify(data []byte) error {
+	i := bytes.Index(data, []byte(checksumKey))
+	if i < 0 {
+		return nil
+	}
+	start := i + len(checksumKey)
+	n := bytes.IndexByte(data[start:], '"')
+	if n < 0 {
+		return fmt.Errorf("%w: unterminated checksum", ErrCorrupt)
+	}
+	if n == 0 {
+		return nil
+	}
+	want := string(data[start : start+n])
+	blank := make([]byte, 0, len(data)-n)
+	blank = append(blank, data[:start]...)
+	blank = append(blank, data[start+n:]...)
+	if got := checksum(blank); got != want {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	return nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Save writes st atomically with a checksum. The file it replaces becomes
+// the backup if it is intact; a corrupt one is moved to path.corrupt so it
+// never overwrites a good backup.
 func Save(path string, st File) error {
 	st.UpdatedAt = time.Now()
-	if st.Version == 0 {
-		st.Version = 1
-	}
+	st.Version = CurrentVersion
+	st.Checksum = ""
 	if st.OriginalAllowedCPUs == nil {
 		st.OriginalAllowedCPUs = map[string]string{}
 	}
@@ -68,10 +274,46 @@ func Save(path string, st File) error {
 	if err != nil {
 		return err
 	}
+	data = bytes.Replace(data, []byte(checksumKey+`"`), []byte(checksumKey+checksum(data)+`"`), 1)
 
 	tmp := path + ".tmp"
 	if err := os.WriteFile(tmp, data, 0o644); err != nil {
 		return err
 	}
-	return os.Rename(tmp, path)
+	if err := rotate(path); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	logging.Tracef(logging.State, nil, "saved %s pin_applied=%v os_cpus=%q game_cpus=%q", path, st.PinApplied, st.OSCPUs, st.GameCPUs)
+	return nil
+}
+
+// Remove deletes the state file and its backup, so a later Load does not
+// fall back to a stale backup.
+func Remove(path string) error {
+	for _, p := range []string{path, BackupPath(path)} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotate moves the current state file aside before it is replaced.
+func rotate(path string) error {
+	old, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	dst := BackupPath(path)
+	if _, err := Parse(old); err != nil {
+		dst = path + ".corrupt"
+		logging.Warnf(nil, "state file %s was corrupt (%v); keeping it as %s", path, err, dst)
+	}
+	return os.Rename(path, dst)
 }
@@ -1,8 +1,10 @@
 package state
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -20,7 +22,12 @@ func TestDefaultPath_UsesXDGStateHome(t *testing.T) {
 
 func TestSaveAndLoadRoundtrip(t *testing.T) {
 	path := filepath.Join(t.TempDir(), "state.json")
-	st := File{Version: 1, PinApplied: true, OriginalAllowedCPUs: map[string]string{"app.slice": ""}}
+	st := File{
+		Version:             1,
+		PinApplied:          true,
+		OriginalAllowedCPUs: map[string]string{"app.slice": ""},
+		ScopePIDs:           map[int]ScopePID{4242: {Unit: "game-730.scope", StartTime: 123456}},
+	}
 	if err := Save(path, st); err != nil {
 		t.Fatalf("Save: %v", err)
 	}
@@ -31,7 +38,136 @@ func TestSaveAndLoadRoundtrip(t *testing.T) {
 	if !loaded.PinApplied {
 		t.Fatalf("expected PinApplied true")
 	}
+	if sp := loaded.ScopePIDs[4242]; sp != (ScopePID{Unit: "game-730.scope", StartTime: 123456}) {
+		t.Fatalf("scope pids mismatch: %#v", loaded.ScopePIDs)
+	}
 	if _, err := os.Stat(path); err != nil {
 		t.Fatalf("expected state file to exist: %v", err)
 	}
 }
+
+func TestSaveWritesChecksumAndBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := Save(path, File{OSCPUs: "0-3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(path, File{OSCPUs: "0-5"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"version": 2`) || strings.Contains(string(data), `"checksum": ""`) {
+		t.Fatalf("state file lacks version or checksum:\n%s", data)
+	}
+	backup, err := load(BackupPath(path))
+	if err != nil || backup.OSCPUs != "0-3" {
+		t.Fatalf("backup = %+v, %v", backup, err)
+	}
+	st, err := Load(path)
+	if err != nil || st.OSCPUs != "0-5" || st.Checksum != "" {
+		t.Fatalf("Load = %+v, %v", st, err)
+	}
+}
+
+func TestLoadFallsBackToBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := Save(path, File{PinApplied: true, OSCPUs: "0-3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(path, File{PinApplied: true, OSCPUs: "0-5"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, corrupt := range map[string][]byte{
+		"truncated": data[:len(data)/2],
+		"tampered":  []byte(strings.Replace(string(data), `"0-5"`, `"0-6"`, 1)),
+		"empty":     nil,
+	} {
+		if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		st, err := Load(path)
+		if err != nil || st.OSCPUs != "0-3" {
+			t.Fatalf("%s: Load = %+v, %v; want the backup", name, st, err)
+		}
+	}
+
+	// The corrupt file must not replace the good backup.
+	if err := Save(path, File{OSCPUs: "0-7"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Fatalf("corrupt file not kept: %v", err)
+	}
+	if backup, err := load(BackupPath(path)); err != nil || backup.OSCPUs != "0-3" {
+		t.Fatalf("backup = %+v, %v", backup, err)
+	}
+
+	// A save interrupted between the two renames leaves only the backup.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := Load(path); err != nil || st.OSCPUs != "0-3" {
+		t.Fatalf("missing file: Load = %+v, %v", st, err)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(BackupPath(path), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := Load(path)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("both corrupt: err = %v", err)
+	}
+	if st.Version != CurrentVersion || st.PinApplied || st.OriginalAllowedCPUs == nil {
+		t.Fatalf("both corrupt: state = %+v", st)
+	}
+
+	if err := Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := Load(path); err != nil || st.PinApplied {
+		t.Fatalf("after Remove: Load = %+v, %v", st, err)
+	}
+}
+
+func TestMigrateV1(t *testing.T) {
+	st, err := Parse([]byte(`{
+  "version": 1,
+  "pin_applied": true,
+  "original_allowed_cpus": {"app.slice": "0-3 8-11", "session.slice": "", "odd.slice": "x y"},
+  "original_allowed_memory_nodes": {"app.slice": "0 1"},
+  "os_cpus": "0-3,8-11"
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Version != CurrentVersion {
+		t.Fatalf("version = %d", st.Version)
+	}
+	want := map[string]string{"app.slice": "0-3,8-11", "session.slice": "", "odd.slice": "x y"}
+	for k, v := range want {
+		if st.OriginalAllowedCPUs[k] != v {
+			t.Errorf("original %s = %q, want %q", k, st.OriginalAllowedCPUs[k], v)
+		}
+	}
+	if got := st.OriginalAllowedMemoryNodes["app.slice"]; got != "0-1" {
+		t.Errorf("memory nodes = %q", got)
+	}
+	if st.OSCPUs != "0-3,8-11" {
+		t.Errorf("os_cpus = %q", st.OSCPUs)
+	}
+
+	// Version 0 files predate the field and are version 1.
+	if st, err := Parse([]byte(`{"pin_applied": false}`)); err != nil || st.Version != CurrentVersion {
+		t.Fatalf("unversioned: %+v, %v", st, err)
+	}
+}
@@ -0,0 +1,277 @@
+package systemdctl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+)
+
+// The cgroupfs backend handles units without systemd, for sandboxes where
+// neither systemctl --user nor the user manager's bus is reachable but the
+// user's cgroup subtree is (a container with a delegated cgroup2 mount, for
+// one). Units are mapped to the cgroups systemd would have created for them
+// under the user manager's cgroup: slices nest by their dash-separated
+// prefixes and other units sit in their slice. Transient scopes become
+// cgroups created by ccdbind.
+
+// DetectUserCgroup returns the cgroup of uid's user manager, relative to
+// CgroupRoot. It prefers the one the calling process sits in, which also
+// works when the hierarchy is mounted from a cgroup namespace.
+func DetectUserCgroup(uid int) (string, error) {
+	want := fmt.Sprintf("user@%d.service", uid)
+	if b, err := os.ReadFile("/proc/self/cgroup"); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			p, ok := strings.CutPrefix(line, "0::/")
+			if !ok {
+				continue
+			}
+			if i := strings.Index(p, want); i >= 0 && isDir(filepath.Join(CgroupRoot, p[:i+len(want)])) {
+				return p[:i+len(want)], nil
+			}
+		}
+	}
+	cg := fmt.Sprintf("user.slice/user-%d.slice/%s", uid, want)
+	if !isDir(filepath.Join(CgroupRoot, cg)) {
+		return "", fmt.Errorf("cgroupfs: no cgroup for %s under %s", want, CgroupRoot)
+	}
+	return cg, nil
+}
+
+func isDir(p string) bool {
+	fi, err := os.Stat(p)
+	return err == nil && fi.IsDir()
+}
+
+// SlicePath returns the cgroup path of slice relative to its manager's
+// cgroup: "app-flatpak.slice" is "app.slice/app-flatpak.slice".
+func SlicePath(slice string) string {
+	name := strings.TrimSuffix(slice, ".slice")
+	if name == "-" || name == "" {
+		return ""
+	}
+	parts := strings.Split(name, "-")
+	out := make([]string, 0, len(parts))
+	for i := range parts {
+		out = append(out, strings.Join(parts[:i+1], "-")+".slice")
+	}
+	return path.Join(out...)
+}
+
+// unitCgroup returns the cgroup of unit, relative to CgroupRoot. Slices map
+// by name; other units are looked up in the slices, as their slice is not
+// known without the manager.
+func (s Systemctl) unitCgroup(unit string) (string, error) {
+	if strings.HasSuffix(unit, ".slice") {
+		return path.Join(s.Cgroupfs, SlicePath(unit)), nil
+	}
+	matches := s.findUnits(unit)
+	if len(matches) == 0 {
+		return "", fmt.Errorf("cgroupfs: unit %s not found under %s", unit, s.Cgroupfs)
+	}
+	return matches[0], nil
+}
+
+// findUnits returns the cgroups below the manager's cgroup whose name
+// matches pattern, descending only into slices.
+func (s Systemctl) findUnits(pattern string) []string {
+	root := filepath.Join(CgroupRoot, s.Cgroupfs)
+	var out []string
+	_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || p == root {
+			return nil
+		}
+		name := d.Name()
+		if ok, _ := path.Match(pattern, name); ok {
+			if rel, err := filepath.Rel(CgroupRoot, p); err == nil {
+				out = append(out, rel)
+			}
+		}
+		if !strings.HasSuffix(name, ".slice") {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return out
+}
+
+// listCgroupUnits lists the units matching pattern that have processes. An
+// empty scope is one systemd would have garbage-collected.
+func (s Systemctl) listCgroupUnits(pattern string) []string {
+	var out []string
+	for _, cg := range s.findUnits(pattern) {
+		if populated(cg) {
+			out = append(out, path.Base(cg))
+		}
+	}
+	return out
+}
+
+func populated(cgroup string) bool {
+	b, err := os.ReadFile(cgroupFile(cgroup, "cgroup.events"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if v, ok := strings.CutPrefix(line, "populated "); ok {
+			return strings.TrimSpace(v) == "1"
+		}
+	}
+	return false
+}
+
+// startCgroupSlice creates slice and its parents, with the cpuset and cpu
+// controllers enabled for the units below it.
+func (s Systemctl) startCgroupSlice(slice string) error {
+	if !strings.HasSuffix(slice, ".slice") {
+		return fmt.Errorf("cgroupfs: cannot start %s without systemd", slice)
+	}
+	cg := s.Cgroupfs
+	for _, part := range strings.Split(SlicePath(slice), "/") {
+		if err := s.enableControllers(cg); err != nil {
+			return err
+		}
+		cg = path.Join(cg, part)
+		if err := s.mkdirCgroup(cg); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	return s.enableControllers(cg)
+}
+
+// stopCgroupUnit kills the processes of unit and removes its cgroup.
+func (s Systemctl) stopCgroupUnit(unit string) error {
+	cg, err := s.unitCgroup(unit)
+	if err != nil {
+		return err
+	}
+	if s.DryRun {
+		log.Printf("dry-run: kill and remove %s", cgroupFile(cg, ""))
+		return nil
+	}
+	if err := s.writeCgroupFile(cg, "cgroup.kill", "1"); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := os.Remove(filepath.Join(CgroupRoot, cg))
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			countError("cgroupfs")
+			return fmt.Errorf("cgroupfs remove %s: %w", cg, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (s Systemctl) mkdirCgroup(cg string) error {
+	p := filepath.Join(CgroupRoot, cg)
+	logging.Tracef(logging.Pin, nil, "mkdir %s", p)
+	if s.DryRun {
+		if !isDir(p) {
+			log.Printf("dry-run: mkdir %s", p)
+		}
+		return nil
+	}
+	if err := os.Mkdir(p, 0o755); err != nil {
+		if !errors.Is(err, fs.ErrExist) {
+			countError("cgroupfs")
+		}
+		return fmt.Errorf("cgroupfs mkdir %s: %w", cg, err)
+	}
+	return nil
+}
+
+// enableControllers enables the cpuset and cpu controllers for the children
+// of cg. The cpu controller is optional; cpuset must be delegated to the
+// user for pinning to work at all.
+func (s Systemctl) enableControllers(cg string) error {
+	b, err := os.ReadFile(cgroupFile(cg, "cgroup.subtree_control"))
+	if err != nil {
+		countError("cgroupfs")
+		return fmt.Errorf("cgroupfs read %s: %w", cg, err)
+	}
+	enabled := strings.Fields(string(b))
+	has := func(c string) bool {
+		for _, e := range enabled {
+			if e == c {
+				return true
+			}
+		}
+		return false
+	}
+	if !has("cpuset") {
+		if err := s.writeCgroupFile(cg, "cgroup.subtree_control", "+cpuset"); err != nil {
+			return fmt.Errorf("%w (is the cpuset controller delegated to the user?)", err)
+		}
+	}
+	if !has("cpu") {
+		_ = s.writeCgroupFile(cg, "cgroup.subtree_control", "+cpu")
+	}
+	return nil
+}
+
+// attachCgroup moves pids into cg, one write per PID as the kernel takes
+// them.
+func (s Systemctl) attachCgroup(cg string, pids []int) error {
+	p := cgroupFile(cg, "cgroup.procs")
+	if s.DryRun {
+		log.Printf("dry-run: move pids %v to %s", pids, p)
+		return nil
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY, 0)
+	if err != nil {
+		countError("cgroupfs")
+		return fmt.Errorf("cgroupfs attach %s: %w", cg, err)
+	}
+	defer f.Close()
+	var firstErr error
+	for _, pid := range pids {
+		if pid <= 0 {
+			continue
+		}
+		// ESRCH is a process that exited in the meantime.
+		if _, err := f.WriteString(strconv.Itoa(pid)); err != nil && !errors.Is(err, syscall.ESRCH) && firstErr == nil {
+			firstErr = fmt.Errorf("cgroupfs attach pid %d to %s: %w", pid, cg, err)
+		}
+	}
+	if firstErr != nil {
+		countError("cgroupfs")
+	}
+	return firstErr
+}
+
+// ensureCgroupScope is EnsureTransientScope for the cgroupfs backend. An
+// existing but empty scope counts as newly created, as systemd would have
+// removed it once it emptied.
+func (s Systemctl) ensureCgroupScope(scope string, pids []int, slice string, cpuWeight uint64) (bool, error) {
+	if err := s.startCgroupSlice(slice); err != nil {
+		return false, err
+	}
+	cg := path.Join(s.Cgroupfs, SlicePath(slice), scope)
+	err := s.mkdirCgroup(cg)
+	created := err == nil || (errors.Is(err, fs.ErrExist) && !populated(cg))
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		return false, err
+	}
+	if created && cpuWeight != 0 {
+		if err := s.writeCgroupFile(cg, "cpu.weight", strconv.FormatUint(cpuWeight, 10)); err != nil {
+			logging.Warnf(logging.Fields{"UNIT": scope}, "cpu_weight for %s: %v", scope, err)
+		}
+	}
+	if err := s.attachCgroup(cg, pids); err != nil {
+		return false, err
+	}
+	return created, nil
+}
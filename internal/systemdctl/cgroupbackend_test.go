@@ -0,0 +1,114 @@
+package systemdctl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSlicePath(t *testing.T) {
+	for in, want := range map[string]string{
+		"-.slice":            "",
+		"game.slice":         "game.slice",
+		"app-flatpak.slice":  "app.slice/app-flatpak.slice",
+		"app-a-b.slice":      "app.slice/app-a.slice/app-a-b.slice",
+		"background.slice":   "background.slice",
+		"session-foo.slice":  "session.slice/session-foo.slice",
+		"user-1000.slice":    "user.slice/user-1000.slice",
+		"app-dbus-foo.slice": "app.slice/app-dbus.slice/app-dbus-foo.slice",
+	} {
+		if got := SlicePath(in); got != want {
+			t.Fatalf("SlicePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCgroupfsBackend(t *testing.T) {
+	root := t.TempDir()
+	old := CgroupRoot
+	CgroupRoot = root
+	t.Cleanup(func() { CgroupRoot = old })
+
+	base := "user.slice/user-1000.slice/user@1000.service"
+	write := func(rel, data string) {
+		t.Helper()
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	read := func(rel string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(root, rel))
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		return strings.TrimSpace(string(b))
+	}
+	write(base+"/cgroup.subtree_control", "cpuset cpu memory\n")
+	write(base+"/app.slice/cgroup.subtree_control", "")
+	write(base+"/app.slice/app-flatpak.slice/cpuset.cpus", "\n")
+	write(base+"/app.slice/app-flatpak.slice/cgroup.subtree_control", "")
+	write(base+"/game.slice/cgroup.subtree_control", "cpuset cpu\n")
+	scope := base + "/game.slice/game-1.scope"
+	write(scope+"/cgroup.procs", "")
+	write(scope+"/cgroup.events", "populated 0\nfrozen 0\n")
+
+	sys := Systemctl{Cgroupfs: base}
+	ctx := context.Background()
+	if err := sys.SetAllowedCPUs(ctx, "app-flatpak.slice", "0-7"); err != nil {
+		t.Fatalf("SetAllowedCPUs: %v", err)
+	}
+	if got := read(base + "/app.slice/app-flatpak.slice/cpuset.cpus"); got != "0-7" {
+		t.Fatalf("cpuset.cpus = %q", got)
+	}
+	if got := read(base + "/app.slice/cgroup.subtree_control"); got != "+cpu" {
+		t.Fatalf("parent subtree_control last written %q, want cpuset then cpu enabled", got)
+	}
+	if got, err := sys.GetAllowedCPUs(ctx, "app-flatpak.slice"); err != nil || got != "0-7" {
+		t.Fatalf("GetAllowedCPUs = %q, %v", got, err)
+	}
+
+	mgr := NewCgroupfsManager(sys)
+	created, err := mgr.EnsureTransientScope(ctx, "game-1.scope", []int{42}, "game.slice", "game", 0)
+	if err != nil || !created {
+		t.Fatalf("EnsureTransientScope on an empty scope = %v, %v; want created", created, err)
+	}
+	if got := read(scope + "/cgroup.procs"); got != "42" {
+		t.Fatalf("cgroup.procs = %q", got)
+	}
+	if err := sys.SetAllowedCPUs(ctx, "game-1.scope", "8-15"); err != nil {
+		t.Fatalf("SetAllowedCPUs scope: %v", err)
+	}
+	if got := read(scope + "/cpuset.cpus"); got != "8-15" {
+		t.Fatalf("scope cpuset.cpus = %q", got)
+	}
+
+	if units, _ := sys.ListUnits(ctx, "game-*.scope"); len(units) != 0 {
+		t.Fatalf("ListUnits listed empty scopes: %v", units)
+	}
+	write(scope+"/cgroup.events", "populated 1\nfrozen 0\n")
+	if units, _ := sys.ListUnits(ctx, "game-*.scope"); !reflect.DeepEqual(units, []string{"game-1.scope"}) {
+		t.Fatalf("ListUnits = %v", units)
+	}
+	created, err = mgr.EnsureTransientScope(ctx, "game-1.scope", []int{43}, "game.slice", "game", 0)
+	if err != nil || created {
+		t.Fatalf("EnsureTransientScope on a populated scope = %v, %v; want existing", created, err)
+	}
+	if err := mgr.AttachProcessesToUnit(ctx, "game-1.scope", "", []int{44}); err != nil {
+		t.Fatalf("AttachProcessesToUnit: %v", err)
+	}
+	if got := read(scope + "/cgroup.procs"); got != "44" {
+		t.Fatalf("cgroup.procs after attach = %q", got)
+	}
+
+	if _, err := sys.GetAllowedCPUs(ctx, "missing.service"); err == nil {
+		t.Fatalf("expected an error for a unit without a cgroup")
+	}
+}
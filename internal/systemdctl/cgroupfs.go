@@ -0,0 +1,107 @@
+package systemdctl
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+)
+
+// CgroupRoot is where the cgroup v2 hierarchy is mounted.
+var CgroupRoot = "/sys/fs/cgroup"
+
+// IsCgroupPath reports whether name is a cgroup path (relative to
+// CgroupRoot, e.g. "user.slice/user-1000.slice/session-2.scope") rather than
+// a unit name. Cgroup paths are handled by writing cpuset files directly,
+// which also covers units systemctl --user cannot set properties on.
+func IsCgroupPath(name string) bool {
+	return strings.Contains(name, "/")
+}
+
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// ExpandCgroupGlobs replaces cgroup path globs with the cgroups currently
+// matching them and drops plain cgroup paths that do not exist (session
+// scopes come and go). Unit names are passed through.
+func ExpandCgroupGlobs(names []string) []string {
+	out := make([]string, 0, len(names))
+	seen := make(map[string]struct{}, len(names))
+	add := func(n string) {
+		if _, ok := seen[n]; !ok {
+			seen[n] = struct{}{}
+			out = append(out, n)
+		}
+	}
+	for _, name := range names {
+		if !IsCgroupPath(name) {
+			add(name)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(CgroupRoot, strings.Trim(name, "/")))
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err != nil || !fi.IsDir() {
+				continue
+			}
+			if rel, err := filepath.Rel(CgroupRoot, m); err == nil {
+				add(rel)
+			}
+		}
+	}
+	return out
+}
+
+// MatchCgroupGlob reports whether the cgroup path name matches pattern. A
+// pattern without glob characters must be equal to name.
+func MatchCgroupGlob(pattern, name string) bool {
+	if !isGlob(pattern) {
+		return pattern == name
+	}
+	ok, err := path.Match(strings.Trim(pattern, "/"), strings.Trim(name, "/"))
+	return err == nil && ok
+}
+
+// cgroupFiles maps the unit properties ccdbind uses to cpuset files.
+var cgroupFiles = map[string]string{
+	"AllowedCPUs":        "cpuset.cpus",
+	"AllowedMemoryNodes": "cpuset.mems",
+}
+
+func cgroupFile(cgroup, file string) string {
+	return filepath.Join(CgroupRoot, strings.Trim(cgroup, "/"), file)
+}
+
+func readCgroupFile(cgroup, file string) (string, error) {
+	b, err := os.ReadFile(cgroupFile(cgroup, file))
+	if err != nil {
+		countError("cgroupfs")
+		return "", fmt.Errorf("cgroupfs read %s: %w", cgroup, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// writeCgroupFile writes a cpuset file. An empty value makes the cgroup
+// inherit from its parent again, like clearing AllowedCPUs on a unit. The
+// cpuset controller must be enabled for the cgroup and the file writable by
+// the daemon's user.
+func (s Systemctl) writeCgroupFile(cgroup, file, value string) error {
+	p := cgroupFile(cgroup, file)
+	logging.Tracef(logging.Pin, nil, "write %q to %s", value, p)
+	if s.DryRun {
+		log.Printf("dry-run: write %q to %s", value, p)
+		return nil
+	}
+	if err := os.WriteFile(p, []byte(value+"\n"), 0); err != nil {
+		countError("cgroupfs")
+		return fmt.Errorf("cgroupfs write %s: %w", cgroup, err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package systemdctl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCgroupPaths(t *testing.T) {
+	root := t.TempDir()
+	old := CgroupRoot
+	CgroupRoot = root
+	t.Cleanup(func() { CgroupRoot = old })
+
+	for _, dir := range []string{"user.slice/user-1000.slice/session-2.scope", "user.slice/user-1000.slice/session-5.scope", "user.slice/user-1000.slice/user@1000.service"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+	}
+
+	got := ExpandCgroupGlobs([]string{"app.slice", "user.slice/user-1000.slice/session-*.scope", "user.slice/user-1000.slice/session-9.scope"})
+	want := []string{"app.slice", "user.slice/user-1000.slice/session-2.scope", "user.slice/user-1000.slice/session-5.scope"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ExpandCgroupGlobs = %v, want %v", got, want)
+	}
+	if !MatchCgroupGlob("/user.slice/user-1000.slice/session-*.scope", "user.slice/user-1000.slice/session-9.scope") {
+		t.Fatalf("expected glob to match a vanished session scope")
+	}
+	if MatchCgroupGlob("user.slice/*.scope", "user.slice/user-1000.slice/session-2.scope") {
+		t.Fatalf("expected * not to cross path separators")
+	}
+
+	sys := Systemctl{}
+	scope := "user.slice/user-1000.slice/session-2.scope"
+	if err := sys.SetAllowedCPUs(context.Background(), scope, "0-7"); err != nil {
+		t.Fatalf("SetAllowedCPUs: %v", err)
+	}
+	if v, err := sys.GetAllowedCPUs(context.Background(), scope); err != nil || v != "0-7" {
+		t.Fatalf("GetAllowedCPUs = %q, %v", v, err)
+	}
+	before := ErrorCounts()["cgroupfs"]
+	if _, err := sys.GetAllowedCPUs(context.Background(), "user.slice/gone.scope"); err == nil {
+		t.Fatalf("expected error for a missing cgroup")
+	}
+	if n := ErrorCounts()["cgroupfs"]; n != before+1 {
+		t.Fatalf("cgroupfs errors = %d, want %d", n, before+1)
+	}
+}
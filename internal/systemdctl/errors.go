@@ -0,0 +1,26 @@
+package systemdctl
+
+import "sync"
+
+var callErrors = struct {
+	sync.Mutex
+	n map[string]uint64
+}{n: map[string]uint64{}}
+
+func countError(op string) {
+	callErrors.Lock()
+	callErrors.n[op]++
+	callErrors.Unlock()
+}
+
+// ErrorCounts returns how many systemctl, D-Bus and cgroupfs calls have
+// failed in this process, by operation.
+func ErrorCounts() map[string]uint64 {
+	callErrors.Lock()
+	defer callErrors.Unlock()
+	out := make(map[string]uint64, len(callErrors.n))
+	for op, n := range callErrors.n {
+		out[op] = n
+	}
+	return out
+}
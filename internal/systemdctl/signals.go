@@ -0,0 +1,114 @@
+package systemdctl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Subscribe asks the manager to emit unit signals, which it only sends
+// while a client is subscribed, and starts dispatching them to Changed and
+// Removed. Later calls do nothing.
+func (m *UserManager) Subscribe(ctx context.Context) error {
+	if !m.busReady() {
+		return nil
+	}
+	m.mu.Lock()
+	subscribed := m.changed != nil
+	m.mu.Unlock()
+	if subscribed {
+		return nil
+	}
+	obj := m.conn.Object(systemdBus, systemdPath)
+	if err := obj.CallWithContext(ctx, managerIfc+".Subscribe", 0).Err; err != nil {
+		countError("Subscribe")
+		return fmt.Errorf("subscribe to the user manager: %w", err)
+	}
+	for _, member := range []string{"Reloading", "UnitRemoved", "JobRemoved"} {
+		if err := m.conn.AddMatchSignal(dbus.WithMatchObjectPath(systemdPath), dbus.WithMatchInterface(managerIfc), dbus.WithMatchMember(member)); err != nil {
+			return err
+		}
+	}
+	signals := make(chan *dbus.Signal, 16)
+	m.conn.Signal(signals)
+	m.mu.Lock()
+	m.changed = make(chan struct{}, 1)
+	m.removed = make(chan string, 16)
+	if m.watched == nil {
+		m.watched = map[string]dbus.ObjectPath{}
+	}
+	if m.cache == nil {
+		m.cache = map[propKey]cachedProp{}
+	}
+	changed, removed := m.changed, m.removed
+	m.mu.Unlock()
+	go m.dispatch(signals, changed, removed)
+	return nil
+}
+
+// Changed receives a value after a watched unit's properties may have been
+// changed by someone else, or the manager reloaded. It is nil before
+// Subscribe and closed when the bus connection is lost.
+func (m *UserManager) Changed() <-chan struct{} {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.changed
+}
+
+// Removed receives the name of a unit that was unloaded or whose job
+// finished, typically a scope whose processes all exited. Names are dropped
+// while the channel is full. It is nil before Subscribe and closed when the
+// bus connection is lost.
+func (m *UserManager) Removed() <-chan string {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.removed
+}
+
+func (m *UserManager) dispatch(signals <-chan *dbus.Signal, changed chan struct{}, removed chan string) {
+	defer close(changed)
+	defer close(removed)
+	for sig := range signals {
+		if unit, ok := removedUnit(sig); ok {
+			select {
+			case removed <- unit:
+			default:
+			}
+			continue
+		}
+		if !m.apply(sig) {
+			continue
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// removedUnit returns the unit named by a UnitRemoved or JobRemoved signal.
+func removedUnit(sig *dbus.Signal) (string, bool) {
+	var unit string
+	switch sig.Name {
+	case managerIfc + ".UnitRemoved":
+		// (s id, o unit)
+		if len(sig.Body) >= 1 {
+			unit, _ = sig.Body[0].(string)
+		}
+	case managerIfc + ".JobRemoved":
+		// (u id, o job, s unit, s result)
+		if len(sig.Body) >= 3 {
+			unit, _ = sig.Body[2].(string)
+		}
+	default:
+		return "", false
+	}
+	return unit, unit != ""
+}
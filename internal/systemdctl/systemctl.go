@@ -6,43 +6,172 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"path"
 	"strings"
 	"time"
+
+	"github.com/Reidond/ccdbind/internal/logging"
 )
 
 type Systemctl struct {
 	DryRun bool
+	// Cgroupfs, when set, is the user manager's cgroup relative to
+	// CgroupRoot. Units are then handled by writing cgroup files under it
+	// instead of through systemctl, see DetectUserCgroup.
+	Cgroupfs string
+	// Bus, when connected, reads and writes AllowedCPUs,
+	// AllowedMemoryNodes and LoadState over the user manager's D-Bus
+	// connection instead of running systemctl. See UserManager.Watch.
+	Bus *UserManager
 }
 
 func (s Systemctl) GetAllowedCPUs(ctx context.Context, unit string) (string, error) {
-	cmd := exec.CommandContext(ctx, "systemctl", "--user", "show", "-p", "AllowedCPUs", "--value", unit)
+	return s.getProperty(ctx, unit, "AllowedCPUs")
+}
+
+func (s Systemctl) SetAllowedCPUs(ctx context.Context, unit string, cpus string) error {
+	return s.setProperty(ctx, unit, "AllowedCPUs", cpus)
+}
+
+// GetAllowedMemoryNodes reads the unit's AllowedMemoryNodes (cpuset.mems).
+func (s Systemctl) GetAllowedMemoryNodes(ctx context.Context, unit string) (string, error) {
+	return s.getProperty(ctx, unit, "AllowedMemoryNodes")
+}
+
+// SetAllowedMemoryNodes sets the unit's AllowedMemoryNodes (cpuset.mems) at
+// runtime. An empty value clears the restriction.
+func (s Systemctl) SetAllowedMemoryNodes(ctx context.Context, unit string, nodes string) error {
+	return s.setProperty(ctx, unit, "AllowedMemoryNodes", nodes)
+}
+
+func (s Systemctl) getProperty(ctx context.Context, unit string, prop string) (string, error) {
+	if file, ok := cgroupFiles[prop]; ok && IsCgroupPath(unit) {
+		return readCgroupFile(unit, file)
+	}
+	if s.Cgroupfs != "" {
+		file, ok := cgroupFiles[prop]
+		if !ok {
+			return "", fmt.Errorf("cgroupfs: no cgroup file for %s", prop)
+		}
+		cg, err := s.unitCgroup(unit)
+		if err != nil {
+			return "", err
+		}
+		return readCgroupFile(cg, file)
+	}
+	if s.Bus.busReady() && propertyInterface(unit, prop) != "" {
+		return s.Bus.getUnitProperty(ctx, unit, prop)
+	}
+	cmd := exec.CommandContext(ctx, "systemctl", "--user", "show", "-p", prop, "--value", unit)
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &out
 	if err := cmd.Run(); err != nil {
+		countError("show")
 		return "", fmt.Errorf("systemctl show %s: %w (%s)", unit, err, strings.TrimSpace(out.String()))
 	}
 	return strings.TrimSpace(out.String()), nil
 }
 
-func (s Systemctl) SetAllowedCPUs(ctx context.Context, unit string, cpus string) error {
-	args := []string{"--user", "set-property", "--runtime", unit, fmt.Sprintf("AllowedCPUs=%s", cpus)}
+func (s Systemctl) setProperty(ctx context.Context, unit string, prop string, value string) error {
+	if file, ok := cgroupFiles[prop]; ok && IsCgroupPath(unit) {
+		return s.writeCgroupFile(unit, file, value)
+	}
+	if s.Cgroupfs != "" {
+		file, ok := cgroupFiles[prop]
+		if !ok {
+			return fmt.Errorf("cgroupfs: no cgroup file for %s", prop)
+		}
+		cg, err := s.unitCgroup(unit)
+		if err != nil {
+			return err
+		}
+		// systemd enables cpuset on the parent when a unit needs it.
+		if err := s.enableControllers(path.Dir(cg)); err != nil {
+			return err
+		}
+		return s.writeCgroupFile(cg, file, value)
+	}
+	args := []string{"--user", "set-property", "--runtime", unit, fmt.Sprintf("%s=%s", prop, value)}
+	logging.Tracef(logging.Pin, logging.Fields{"UNIT": unit}, "set-property %s %s=%q", unit, prop, value)
 	if s.DryRun {
 		log.Printf("dry-run: systemctl %s", strings.Join(args, " "))
 		return nil
 	}
+	if s.Bus.busReady() && cpusetProps[prop] && propertyInterface(unit, prop) != "" {
+		return s.Bus.setUnitProperty(ctx, unit, prop, value)
+	}
 	cmd := exec.CommandContext(ctx, "systemctl", args...)
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &out
 	if err := cmd.Run(); err != nil {
+		countError("set-property")
 		return fmt.Errorf("systemctl set-property %s: %w (%s)", unit, err, strings.TrimSpace(out.String()))
 	}
 	return nil
 }
 
+// UnitLoaded reports whether the user manager knows unit, from a unit file
+// or created at runtime. A unit it has never heard of has LoadState
+// not-found; systemctl show still succeeds for it.
+func (s Systemctl) UnitLoaded(ctx context.Context, unit string) (bool, error) {
+	if s.Cgroupfs != "" {
+		_, err := s.unitCgroup(unit)
+		return err == nil, nil
+	}
+	state, err := s.getProperty(ctx, unit, "LoadState")
+	if err != nil {
+		return false, err
+	}
+	return state != "" && state != "not-found", nil
+}
+
 func (s Systemctl) StartUnit(ctx context.Context, unit string) error {
-	args := []string{"--user", "start", unit}
+	if s.Cgroupfs != "" {
+		return s.startCgroupSlice(unit)
+	}
+	return s.run(ctx, "start", unit)
+}
+
+// StopUnit stops unit. For a scope this ends the processes in it.
+func (s Systemctl) StopUnit(ctx context.Context, unit string) error {
+	if s.Cgroupfs != "" {
+		return s.stopCgroupUnit(unit)
+	}
+	return s.run(ctx, "stop", unit)
+}
+
+// ResetFailed forgets failed units matching pattern.
+func (s Systemctl) ResetFailed(ctx context.Context, pattern string) error {
+	if s.Cgroupfs != "" {
+		return nil
+	}
+	return s.run(ctx, "reset-failed", pattern)
+}
+
+// RestartUnit restarts unit, starting it if it is not running.
+func (s Systemctl) RestartUnit(ctx context.Context, unit string) error {
+	return s.run(ctx, "restart", unit)
+}
+
+// EnableUnit enables unit without starting it.
+func (s Systemctl) EnableUnit(ctx context.Context, unit string) error {
+	return s.run(ctx, "enable", unit)
+}
+
+// DisableUnit disables and stops unit.
+func (s Systemctl) DisableUnit(ctx context.Context, unit string) error {
+	return s.run(ctx, "disable", "--now", unit)
+}
+
+// DaemonReload makes the user manager reread unit files.
+func (s Systemctl) DaemonReload(ctx context.Context) error {
+	return s.run(ctx, "daemon-reload")
+}
+
+func (s Systemctl) run(ctx context.Context, verb string, operands ...string) error {
+	args := append([]string{"--user", verb}, operands...)
 	if s.DryRun {
 		log.Printf("dry-run: systemctl %s", strings.Join(args, " "))
 		return nil
@@ -52,11 +181,35 @@ func (s Systemctl) StartUnit(ctx context.Context, unit string) error {
 	cmd.Stdout = &out
 	cmd.Stderr = &out
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("systemctl start %s: %w (%s)", unit, err, strings.TrimSpace(out.String()))
+		countError(verb)
+		return fmt.Errorf("systemctl %s: %w (%s)", strings.Join(args[1:], " "), err, strings.TrimSpace(out.String()))
 	}
 	return nil
 }
 
+// ListUnits returns the names of loaded units matching pattern (a shell glob
+// as understood by `systemctl list-units`).
+func (s Systemctl) ListUnits(ctx context.Context, pattern string) ([]string, error) {
+	if s.Cgroupfs != "" {
+		return s.listCgroupUnits(pattern), nil
+	}
+	cmd := exec.CommandContext(ctx, "systemctl", "--user", "list-units", "--all", "--plain", "--no-legend", pattern)
+	var out, errOut bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	if err := cmd.Run(); err != nil {
+		countError("list-units")
+		return nil, fmt.Errorf("systemctl list-units %s: %w (%s)", pattern, err, strings.TrimSpace(errOut.String()))
+	}
+	units := make([]string, 0, 4)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			units = append(units, fields[0])
+		}
+	}
+	return units, nil
+}
+
 func DefaultContext() (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), 10*time.Second)
 }
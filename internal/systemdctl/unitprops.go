@@ -0,0 +1,321 @@
+package systemdctl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/Reidond/ccdbind/internal/logging"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+const (
+	systemdBus  = "org.freedesktop.systemd1"
+	systemdPath = dbus.ObjectPath("/org/freedesktop/systemd1")
+	managerIfc  = "org.freedesktop.systemd1.Manager"
+	unitIfc     = "org.freedesktop.systemd1.Unit"
+	propsIfc    = "org.freedesktop.DBus.Properties"
+)
+
+// propertyCacheTTL bounds how long a watched unit's property is served from
+// the cache. PropertiesChanged keeps it current; the expiry only guards
+// against a change systemd did not announce.
+const propertyCacheTTL = 30 * time.Second
+
+// cpusetProps are the unit properties carried as a bitmask ("ay") on the bus
+// and as a cpu list everywhere else.
+var cpusetProps = map[string]bool{
+	"AllowedCPUs":        true,
+	"AllowedMemoryNodes": true,
+}
+
+// unitTypeInterfaces maps unit suffixes to the interface holding their
+// cgroup properties.
+var unitTypeInterfaces = map[string]string{
+	".slice":   "org.freedesktop.systemd1.Slice",
+	".scope":   "org.freedesktop.systemd1.Scope",
+	".service": "org.freedesktop.systemd1.Service",
+	".socket":  "org.freedesktop.systemd1.Socket",
+	".mount":   "org.freedesktop.systemd1.Mount",
+	".swap":    "org.freedesktop.systemd1.Swap",
+}
+
+// propertyInterface returns the interface carrying prop on unit, or "" when
+// the property is not handled over the bus.
+func propertyInterface(unit, prop string) string {
+	if prop == "LoadState" {
+		return unitIfc
+	}
+	if !cpusetProps[prop] {
+		return ""
+	}
+	for suffix, ifc := range unitTypeInterfaces {
+		if strings.HasSuffix(unit, suffix) {
+			return ifc
+		}
+	}
+	return ""
+}
+
+type propKey struct{ unit, prop string }
+
+type cachedProp struct {
+	value string
+	at    time.Time
+}
+
+// busReady reports whether m can serve unit properties over its connection.
+// Once the connection is lost Systemctl falls back to running systemctl.
+func (m *UserManager) busReady() bool {
+	return m != nil && m.conn != nil && m.conn.Connected() && m.cgroupfs.Cgroupfs == ""
+}
+
+// unitPath returns the object path of unit, loading it if needed.
+func (m *UserManager) unitPath(ctx context.Context, unit string) (dbus.ObjectPath, error) {
+	m.mu.Lock()
+	p, ok := m.paths[unit]
+	m.mu.Unlock()
+	if ok {
+		return p, nil
+	}
+	obj := m.conn.Object(systemdBus, systemdPath)
+	if err := obj.CallWithContext(ctx, managerIfc+".LoadUnit", 0, unit).Store(&p); err != nil {
+		countError("LoadUnit")
+		return "", fmt.Errorf("LoadUnit %s: %w", unit, err)
+	}
+	m.mu.Lock()
+	if m.paths == nil {
+		m.paths = map[string]dbus.ObjectPath{}
+	}
+	m.paths[unit] = p
+	m.mu.Unlock()
+	return p, nil
+}
+
+// getUnitProperty reads prop of unit over the bus. Values of watched units
+// come from the cache while it is fresh.
+func (m *UserManager) getUnitProperty(ctx context.Context, unit, prop string) (string, error) {
+	key := propKey{unit, prop}
+	m.mu.Lock()
+	_, watched := m.watched[unit]
+	if c, ok := m.cache[key]; ok && watched && time.Since(c.at) < propertyCacheTTL {
+		m.mu.Unlock()
+		return c.value, nil
+	}
+	m.mu.Unlock()
+
+	p, err := m.unitPath(ctx, unit)
+	if err != nil {
+		return "", err
+	}
+	var v dbus.Variant
+	err = m.conn.Object(systemdBus, p).CallWithContext(ctx, propsIfc+".Get", 0, propertyInterface(unit, prop), prop).Store(&v)
+	logging.Tracef(logging.DBus, logging.Fields{"UNIT": unit}, "Get(%q, %q): %v", unit, prop, err)
+	if err != nil {
+		countError("Get")
+		return "", fmt.Errorf("get %s of %s: %w", prop, unit, err)
+	}
+	var value string
+	switch x := v.Value().(type) {
+	case []byte:
+		value = topology.FormatCPUList(bitmaskCPUs(x))
+	case string:
+		value = x
+	default:
+		return "", fmt.Errorf("get %s of %s: unexpected type %s", prop, unit, v.Signature())
+	}
+	m.mu.Lock()
+	if _, ok := m.watched[unit]; ok {
+		m.cache[key] = cachedProp{value: value, at: time.Now()}
+	}
+	m.mu.Unlock()
+	return value, nil
+}
+
+// setUnitProperty sets a cpuset property of unit at runtime, like
+// `systemctl set-property --runtime`. An empty value clears it.
+func (m *UserManager) setUnitProperty(ctx context.Context, unit, prop, value string) error {
+	// systemctl show separates ranges with spaces; accept both forms.
+	cpus, err := topology.ParseCPUList(strings.Join(strings.Fields(value), ","))
+	if err != nil {
+		return fmt.Errorf("set %s of %s: %w", prop, unit, err)
+	}
+	props := []dbusProperty{{Name: prop, Value: dbus.MakeVariant(cpuBitmask(cpus))}}
+	obj := m.conn.Object(systemdBus, systemdPath)
+	err = obj.CallWithContext(ctx, managerIfc+".SetUnitProperties", 0, unit, true, props).Err
+	logging.Tracef(logging.DBus, logging.Fields{"UNIT": unit}, "SetUnitProperties(%q, %s=%q): %v", unit, prop, value, err)
+	key := propKey{unit, prop}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		delete(m.cache, key)
+		countError("SetUnitProperties")
+		return fmt.Errorf("set %s of %s: %w", prop, unit, err)
+	}
+	if _, ok := m.watched[unit]; ok {
+		m.cache[key] = cachedProp{value: topology.FormatCPUList(cpus), at: time.Now()}
+	}
+	return nil
+}
+
+// Watch follows PropertiesChanged for units, replacing the previous set, so
+// their properties are served from a cache and a change made by anyone else
+// is reported on Changed. Cgroup paths are skipped; they are read from
+// cgroupfs directly.
+func (m *UserManager) Watch(ctx context.Context, units []string) error {
+	if !m.busReady() {
+		return nil
+	}
+	want := map[string]bool{}
+	for _, u := range units {
+		if !IsCgroupPath(u) && u != "" {
+			want[u] = true
+		}
+	}
+
+	if err := m.Subscribe(ctx); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	var stale []dbus.ObjectPath
+	for u, p := range m.watched {
+		if !want[u] {
+			stale = append(stale, p)
+			delete(m.watched, u)
+			m.dropLocked(u)
+		}
+	}
+	var added []string
+	for u := range want {
+		if _, ok := m.watched[u]; !ok {
+			added = append(added, u)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, p := range stale {
+		_ = m.conn.RemoveMatchSignal(propertiesChangedMatch(p)...)
+	}
+	var firstErr error
+	for _, u := range added {
+		p, err := m.unitPath(ctx, u)
+		if err == nil {
+			err = m.conn.AddMatchSignal(propertiesChangedMatch(p)...)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("watch %s: %w", u, err)
+			}
+			continue
+		}
+		m.mu.Lock()
+		m.watched[u] = p
+		m.mu.Unlock()
+	}
+	return firstErr
+}
+
+func propertiesChangedMatch(p dbus.ObjectPath) []dbus.MatchOption {
+	return []dbus.MatchOption{dbus.WithMatchObjectPath(p), dbus.WithMatchInterface(propsIfc), dbus.WithMatchMember("PropertiesChanged")}
+}
+
+// apply updates the cache from sig and reports whether a watched property
+// may differ from what ccdbind last saw.
+func (m *UserManager) apply(sig *dbus.Signal) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch sig.Name {
+	case managerIfc + ".Reloading":
+		// Sent with true before and false after a daemon-reload.
+		if len(sig.Body) < 1 || len(m.watched) == 0 {
+			return false
+		}
+		if starting, ok := sig.Body[0].(bool); !ok || starting {
+			return false
+		}
+		m.cache = map[propKey]cachedProp{}
+		return true
+	case propsIfc + ".PropertiesChanged":
+		unit := ""
+		for u, p := range m.watched {
+			if p == sig.Path {
+				unit = u
+				break
+			}
+		}
+		if unit == "" || len(sig.Body) < 3 {
+			return false
+		}
+		changedProps, _ := sig.Body[1].(map[string]dbus.Variant)
+		invalidated, _ := sig.Body[2].([]string)
+		differs, announced := false, false
+		for prop := range cpusetProps {
+			v, ok := changedProps[prop]
+			if !ok {
+				continue
+			}
+			announced = true
+			mask, _ := v.Value().([]byte)
+			value := topology.FormatCPUList(bitmaskCPUs(mask))
+			key := propKey{unit, prop}
+			if c, ok := m.cache[key]; ok && c.value == value {
+				continue
+			}
+			m.cache[key] = cachedProp{value: value, at: time.Now()}
+			differs = true
+		}
+		if !announced && (len(changedProps) > 0 || len(invalidated) > 0) {
+			// systemd does not announce cgroup properties individually;
+			// any change to the unit may have touched them.
+			differs = m.dropLocked(unit)
+		}
+		return differs
+	}
+	return false
+}
+
+// dropLocked forgets the cached properties of unit and reports whether
+// there were any.
+func (m *UserManager) dropLocked(unit string) bool {
+	dropped := false
+	for prop := range cpusetProps {
+		key := propKey{unit, prop}
+		if _, ok := m.cache[key]; ok {
+			delete(m.cache, key)
+			dropped = true
+		}
+	}
+	return dropped
+}
+
+// cpuBitmask encodes cpus as systemd's bitmask, CPU n being bit n%8 of
+// byte n/8.
+func cpuBitmask(cpus []int) []byte {
+	var mask []byte
+	for _, cpu := range cpus {
+		for len(mask) <= cpu/8 {
+			mask = append(mask, 0)
+		}
+		mask[cpu/8] |= 1 << (cpu % 8)
+	}
+	if mask == nil {
+		mask = []byte{}
+	}
+	return mask
+}
+
+// bitmaskCPUs decodes a systemd bitmask, see cpuBitmask.
+func bitmaskCPUs(mask []byte) []int {
+	var cpus []int
+	for i, b := range mask {
+		for bit := 0; bit < 8; bit++ {
+			if b&(1<<bit) != 0 {
+				cpus = append(cpus, i*8+bit)
+			}
+		}
+	}
+	return cpus
+}
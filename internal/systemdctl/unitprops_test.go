@@ -0,0 +1,116 @@
+package systemdctl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestCPUBitmask(t *testing.T) {
+	for _, tc := range []struct {
+		cpus []int
+		mask []byte
+	}{
+		{nil, []byte{}},
+		{[]int{0}, []byte{0x01}},
+		{[]int{0, 1, 2, 3, 8, 9, 10, 11}, []byte{0x0f, 0x0f}},
+		{[]int{15}, []byte{0x00, 0x80}},
+		{[]int{2, 17}, []byte{0x04, 0x00, 0x02}},
+	} {
+		if got := cpuBitmask(tc.cpus); !reflect.DeepEqual(got, tc.mask) {
+			t.Errorf("cpuBitmask(%v) = %x, want %x", tc.cpus, got, tc.mask)
+		}
+		if got := bitmaskCPUs(tc.mask); !reflect.DeepEqual(got, tc.cpus) {
+			t.Errorf("bitmaskCPUs(%x) = %v, want %v", tc.mask, got, tc.cpus)
+		}
+	}
+}
+
+func TestPropertyInterface(t *testing.T) {
+	for _, tc := range []struct{ unit, prop, want string }{
+		{"app.slice", "AllowedCPUs", "org.freedesktop.systemd1.Slice"},
+		{"game-1.scope", "AllowedMemoryNodes", "org.freedesktop.systemd1.Scope"},
+		{"foo.service", "LoadState", unitIfc},
+		{"foo.target", "AllowedCPUs", ""},
+		{"app.slice", "CPUWeight", ""},
+	} {
+		if got := propertyInterface(tc.unit, tc.prop); got != tc.want {
+			t.Errorf("propertyInterface(%q, %q) = %q, want %q", tc.unit, tc.prop, got, tc.want)
+		}
+	}
+}
+
+func TestApplyPropertiesChanged(t *testing.T) {
+	path := dbus.ObjectPath("/org/freedesktop/systemd1/unit/app_2eslice")
+	m := &UserManager{
+		watched: map[string]dbus.ObjectPath{"app.slice": path},
+		cache: map[propKey]cachedProp{
+			{"app.slice", "AllowedCPUs"}: {value: "0-3"},
+		},
+	}
+	changed := func(ifc string, props map[string]dbus.Variant, invalidated []string) *dbus.Signal {
+		return &dbus.Signal{Path: path, Name: propsIfc + ".PropertiesChanged", Body: []interface{}{ifc, props, invalidated}}
+	}
+	key := propKey{"app.slice", "AllowedCPUs"}
+
+	// Our own write echoed back.
+	if m.apply(changed("org.freedesktop.systemd1.Slice", map[string]dbus.Variant{"AllowedCPUs": dbus.MakeVariant([]byte{0x0f})}, nil)) {
+		t.Fatal("unchanged value reported as a change")
+	}
+	if !m.apply(changed("org.freedesktop.systemd1.Slice", map[string]dbus.Variant{"AllowedCPUs": dbus.MakeVariant([]byte{0xff})}, nil)) {
+		t.Fatal("new value not reported")
+	}
+	if got := m.cache[key].value; got != "0-7" {
+		t.Fatalf("cached %q, want 0-7", got)
+	}
+
+	// A change elsewhere on the unit drops the cache once.
+	other := changed(unitIfc, map[string]dbus.Variant{"ActiveState": dbus.MakeVariant("active")}, nil)
+	if !m.apply(other) {
+		t.Fatal("unit change with a cached value not reported")
+	}
+	if _, ok := m.cache[key]; ok {
+		t.Fatal("cache kept after an unannounced change")
+	}
+	if m.apply(other) {
+		t.Fatal("nothing cached, yet a change was reported")
+	}
+
+	// Units that are not watched are ignored.
+	sig := changed("org.freedesktop.systemd1.Slice", map[string]dbus.Variant{"AllowedCPUs": dbus.MakeVariant([]byte{0x01})}, nil)
+	sig.Path = "/org/freedesktop/systemd1/unit/background_2eslice"
+	if m.apply(sig) {
+		t.Fatal("unwatched unit reported")
+	}
+
+	// A finished daemon-reload invalidates everything.
+	m.cache[key] = cachedProp{value: "0-7"}
+	reload := func(starting bool) *dbus.Signal {
+		return &dbus.Signal{Path: systemdPath, Name: managerIfc + ".Reloading", Body: []interface{}{starting}}
+	}
+	if m.apply(reload(true)) {
+		t.Fatal("reload start reported")
+	}
+	if !m.apply(reload(false)) || len(m.cache) != 0 {
+		t.Fatalf("reload end: cache=%v", m.cache)
+	}
+}
+
+func TestRemovedUnit(t *testing.T) {
+	for _, tc := range []struct {
+		sig  *dbus.Signal
+		unit string
+		ok   bool
+	}{
+		{&dbus.Signal{Name: managerIfc + ".UnitRemoved", Body: []interface{}{"game-730.scope", dbus.ObjectPath("/org/freedesktop/systemd1/unit/game_2d730_2escope")}}, "game-730.scope", true},
+		{&dbus.Signal{Name: managerIfc + ".JobRemoved", Body: []interface{}{uint32(7), dbus.ObjectPath("/org/freedesktop/systemd1/job/7"), "game-730.scope", "done"}}, "game-730.scope", true},
+		{&dbus.Signal{Name: managerIfc + ".JobRemoved", Body: []interface{}{uint32(7)}}, "", false},
+		{&dbus.Signal{Name: managerIfc + ".Reloading", Body: []interface{}{false}}, "", false},
+	} {
+		unit, ok := removedUnit(tc.sig)
+		if unit != tc.unit || ok != tc.ok {
+			t.Errorf("removedUnit(%s %v) = %q, %v; want %q, %v", tc.sig.Name, tc.sig.Body, unit, ok, tc.unit, tc.ok)
+		}
+	}
+}
@@ -6,11 +6,15 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/godbus/dbus/v5"
+
+	"github.com/Reidond/ccdbind/internal/logging"
 )
 
 type dbusProperty struct {
@@ -26,6 +30,16 @@ type dbusAuxUnit struct {
 type UserManager struct {
 	DryRun bool
 	conn   *dbus.Conn
+	// cgroupfs creates scopes as plain cgroups when set, see
+	// NewCgroupfsManager.
+	cgroupfs Systemctl
+
+	mu      sync.Mutex
+	paths   map[string]dbus.ObjectPath
+	watched map[string]dbus.ObjectPath // unit -> object path, see Watch
+	cache   map[propKey]cachedProp
+	changed chan struct{}
+	removed chan string
 }
 
 func NewUserManager(dryRun bool) (*UserManager, error) {
@@ -39,6 +53,24 @@ func NewUserManager(dryRun bool) (*UserManager, error) {
 	return &UserManager{conn: conn}, nil
 }
 
+// NewCgroupfsManager returns a UserManager that creates scopes as cgroups
+// under sys.Cgroupfs instead of asking the user manager, for when its bus is
+// unreachable.
+func NewCgroupfsManager(sys Systemctl) *UserManager {
+	return &UserManager{DryRun: sys.DryRun, cgroupfs: sys}
+}
+
+// UserBusReachable reports whether the user manager's bus accepts a
+// connection.
+func UserBusReachable() bool {
+	conn, err := connectUserBus()
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
 func (m *UserManager) Close() error {
 	if m.conn != nil {
 		m.conn.Close()
@@ -47,11 +79,18 @@ func (m *UserManager) Close() error {
 }
 
 // EnsureTransientScope creates a transient scope (if missing) and attaches PIDs.
-// It is safe to call repeatedly.
-func (m *UserManager) EnsureTransientScope(ctx context.Context, scopeName string, pids []int, slice string, description string) (created bool, err error) {
+// It is safe to call repeatedly. A non-zero cpuWeight sets CPUWeight= on a
+// newly created scope.
+func (m *UserManager) EnsureTransientScope(ctx context.Context, scopeName string, pids []int, slice string, description string, cpuWeight uint64) (created bool, err error) {
 	if !strings.HasSuffix(scopeName, ".scope") {
 		return false, fmt.Errorf("scope name must end with .scope: %q", scopeName)
 	}
+	if m.cgroupfs.Cgroupfs != "" {
+		if strings.TrimSpace(slice) == "" {
+			slice = "game.slice"
+		}
+		return m.cgroupfs.ensureCgroupScope(scopeName, pids, slice, cpuWeight)
+	}
 	if m.DryRun {
 		log.Printf("dry-run: StartTransientUnit(%q) slice=%q pids=%v", scopeName, slice, pids)
 		return true, nil
@@ -76,14 +115,19 @@ func (m *UserManager) EnsureTransientScope(ctx context.Context, scopeName string
 		{Name: "Slice", Value: dbus.MakeVariant(slice)},
 		{Name: "PIDs", Value: dbus.MakeVariant(pidsU32)},
 	}
+	if cpuWeight > 0 {
+		props = append(props, dbusProperty{Name: "CPUWeight", Value: dbus.MakeVariant(cpuWeight)})
+	}
 	var aux []dbusAuxUnit
 
 	obj := m.conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
 	call := obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.StartTransientUnit", 0, scopeName, "fail", props, aux)
+	logging.Tracef(logging.DBus, logging.Fields{"UNIT": scopeName}, "StartTransientUnit(%q) slice=%q pids=%v: %v", scopeName, slice, pidsU32, call.Err)
 	if call.Err != nil {
 		if isUnitExistsErr(call.Err) {
 			return false, nil
 		}
+		countError("StartTransientUnit")
 		return false, call.Err
 	}
 	return true, nil
@@ -92,6 +136,13 @@ func (m *UserManager) EnsureTransientScope(ctx context.Context, scopeName string
 // AttachProcessesToUnit attaches the given PIDs to an existing systemd unit.
 // The systemd D-Bus signature is: (s unit, s subcgroup, au pids).
 func (m *UserManager) AttachProcessesToUnit(ctx context.Context, unit string, subcgroup string, pids []int) error {
+	if m.cgroupfs.Cgroupfs != "" {
+		cg, err := m.cgroupfs.unitCgroup(unit)
+		if err != nil {
+			return err
+		}
+		return m.cgroupfs.attachCgroup(path.Join(cg, subcgroup), pids)
+	}
 	if m.DryRun {
 		log.Printf("dry-run: AttachProcessesToUnit(%q, %q) pids=%v", unit, subcgroup, pids)
 		return nil
@@ -114,6 +165,10 @@ func (m *UserManager) AttachProcessesToUnit(ctx context.Context, unit string, su
 
 	obj := m.conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
 	call := obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.AttachProcessesToUnit", 0, unit, subcgroup, pidsU32)
+	logging.Tracef(logging.DBus, logging.Fields{"UNIT": unit}, "AttachProcessesToUnit(%q, %q) pids=%v: %v", unit, subcgroup, pidsU32, call.Err)
+	if call.Err != nil {
+		countError("AttachProcessesToUnit")
+	}
 	return call.Err
 }
 
@@ -7,6 +7,13 @@ import (
 	"strings"
 )
 
+// MaxCPU is the highest CPU number a list may name: the kernel's
+// CONFIG_NR_CPUS tops out at 8192. The bound also keeps a range such as
+// "0-2147483647" from allocating a set of every int.
+const MaxCPU = 8191
+
+// ParseCPUList parses a kernel CPU list such as "0-3,8,10-11" into sorted,
+// unique CPU numbers. Spaces and empty entries are ignored.
 func ParseCPUList(s string) ([]int, error) {
 	s = strings.TrimSpace(s)
 	if s == "" {
@@ -35,6 +42,12 @@ func ParseCPUList(s string) ([]int, error) {
 			if start > end {
 				return nil, fmt.Errorf("invalid cpu range %q", part)
 			}
+			if err := checkCPU(start); err != nil {
+				return nil, err
+			}
+			if err := checkCPU(end); err != nil {
+				return nil, err
+			}
 			for cpu := start; cpu <= end; cpu++ {
 				seen[cpu] = struct{}{}
 			}
@@ -44,6 +57,9 @@ func ParseCPUList(s string) ([]int, error) {
 		if err != nil {
 			return nil, fmt.Errorf("invalid cpu %q: %w", part, err)
 		}
+		if err := checkCPU(cpu); err != nil {
+			return nil, err
+		}
 		seen[cpu] = struct{}{}
 	}
 
@@ -55,6 +71,15 @@ func ParseCPUList(s string) ([]int, error) {
 	return out, nil
 }
 
+func checkCPU(cpu int) error {
+	if cpu < 0 || cpu > MaxCPU {
+		return fmt.Errorf("cpu %d out of range (0-%d)", cpu, MaxCPU)
+	}
+	return nil
+}
+
+// FormatCPUList formats cpus, in any order and with duplicates, as a
+// canonical CPU list: ascending, with runs collapsed into ranges.
 func FormatCPUList(cpus []int) string {
 	if len(cpus) == 0 {
 		return ""
@@ -99,6 +124,7 @@ func FormatCPUList(cpus []int) string {
 	return b.String()
 }
 
+// ContainsCPU reports whether cpu is in cpus.
 func ContainsCPU(cpus []int, cpu int) bool {
 	for _, c := range cpus {
 		if c == cpu {
@@ -108,6 +134,8 @@ func ContainsCPU(cpus []int, cpu int) bool {
 	return false
 }
 
+// CanonicalizeCPUList returns the canonical form of the CPU list s and its
+// CPUs.
 func CanonicalizeCPUList(s string) (string, []int, error) {
 	cpus, err := ParseCPUList(s)
 	if err != nil {
@@ -115,3 +143,93 @@ func CanonicalizeCPUList(s string) (string, []int, error) {
 	}
 	return FormatCPUList(cpus), cpus, nil
 }
+
+// UnionCPULists merges CPU lists into one canonical list. Invalid lists are
+// skipped.
+func UnionCPULists(lists ...string) string {
+	all := make([]int, 0, 64)
+	for _, s := range lists {
+		cpus, err := ParseCPUList(s)
+		if err != nil {
+			continue
+		}
+		all = append(all, cpus...)
+	}
+	return FormatCPUList(all)
+}
+
+// IntersectCPULists returns the CPUs in every one of lists as a canonical
+// list. An invalid list counts as empty.
+func IntersectCPULists(lists ...string) string {
+	if len(lists) == 0 {
+		return ""
+	}
+	common, err := ParseCPUList(lists[0])
+	if err != nil {
+		return ""
+	}
+	for _, s := range lists[1:] {
+		cpus, err := ParseCPUList(s)
+		if err != nil {
+			return ""
+		}
+		kept := common[:0]
+		for _, c := range common {
+			if ContainsCPU(cpus, c) {
+				kept = append(kept, c)
+			}
+		}
+		common = kept
+	}
+	return FormatCPUList(common)
+}
+
+// FormatCPUMask formats cpus like a sysfs cpumask: 32-bit hex words, most
+// significant first, separated by commas.
+func FormatCPUMask(cpus []int) string {
+	highest := 0
+	for _, c := range cpus {
+		highest = max(highest, c)
+	}
+	words := make([]uint32, highest/32+1)
+	for _, c := range cpus {
+		words[c/32] |= 1 << (c % 32)
+	}
+	parts := make([]string, len(words))
+	for i, w := range words {
+		parts[len(words)-1-i] = fmt.Sprintf("%08x", w)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseCPUMask parses a cpumask as FormatCPUMask writes it, or as the
+// kernel prints it in /proc/irq/*/smp_affinity and Cpus_allowed, into
+// sorted CPU numbers.
+func ParseCPUMask(s string) ([]int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	words := strings.Split(s, ",")
+	if len(words)*32 > MaxCPU+1 {
+		return nil, fmt.Errorf("cpu mask %q too long", s)
+	}
+	var out []int
+	for i := len(words) - 1; i >= 0; i-- {
+		w := words[i]
+		if w == "" || len(w) > 8 {
+			return nil, fmt.Errorf("invalid cpu mask word %q", w)
+		}
+		bits, err := strconv.ParseUint(w, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu mask word %q: %w", w, err)
+		}
+		base := (len(words) - 1 - i) * 32
+		for b := 0; b < 32; b++ {
+			if bits&(1<<b) != 0 {
+				out = append(out, base+b)
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,224 @@
+package topology
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// cpuSet is a random set of CPUs for testing/quick, kept small and dense so
+// that runs, gaps and overlaps all show up.
+type cpuSet []int
+
+func (cpuSet) Generate(r *rand.Rand, size int) reflect.Value {
+	top := 1 + r.Intn(64)
+	if r.Intn(8) == 0 {
+		top = MaxCPU + 1
+	}
+	n := r.Intn(size + 1)
+	s := make(cpuSet, n)
+	for i := range s {
+		s[i] = r.Intn(top)
+	}
+	return reflect.ValueOf(s)
+}
+
+// list writes s as a CPU list in a random, non-canonical way: shuffled,
+// with duplicates, spaces, empty entries and single-CPU ranges.
+func (s cpuSet) list(r *rand.Rand) string {
+	parts := make([]string, 0, len(s))
+	for _, c := range s {
+		switch r.Intn(4) {
+		case 0:
+			parts = append(parts, strconv.Itoa(c)+"-"+strconv.Itoa(c))
+		case 1:
+			parts = append(parts, " "+strconv.Itoa(c)+" ")
+		default:
+			parts = append(parts, strconv.Itoa(c))
+		}
+		if r.Intn(8) == 0 {
+			parts = append(parts, "")
+		}
+	}
+	r.Shuffle(len(parts), func(i, j int) { parts[i], parts[j] = parts[j], parts[i] })
+	return strings.Join(parts, ",")
+}
+
+func (s cpuSet) sorted() []int {
+	seen := map[int]bool{}
+	var out []int
+	for _, c := range s {
+		if !seen[c] {
+			seen[c] = true
+			out = append(out, c)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// sameCPUs compares CPU sets, treating nil and empty alike.
+func sameCPUs(a, b []int) bool {
+	return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+}
+
+var quickConfig = &quick.Config{MaxCount: 500}
+
+func check(t *testing.T, name string, f any) {
+	t.Helper()
+	if err := quick.Check(f, quickConfig); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+}
+
+func TestCPUListRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	check(t, "parse(list) = set", func(s cpuSet) bool {
+		got, err := ParseCPUList(s.list(r))
+		return err == nil && sameCPUs(got, s.sorted())
+	})
+	check(t, "parse(format(s)) = s", func(s cpuSet) bool {
+		got, err := ParseCPUList(FormatCPUList(s))
+		return err == nil && sameCPUs(got, s.sorted())
+	})
+	check(t, "canonicalize is idempotent", func(s cpuSet) bool {
+		once, _, err := CanonicalizeCPUList(s.list(r))
+		if err != nil {
+			return false
+		}
+		twice, _, err := CanonicalizeCPUList(once)
+		return err == nil && once == twice
+	})
+	check(t, "mask round trip", func(s cpuSet) bool {
+		got, err := ParseCPUMask(FormatCPUMask(s))
+		return err == nil && sameCPUs(got, s.sorted())
+	})
+}
+
+func TestCPUListAlgebra(t *testing.T) {
+	u, i := UnionCPULists, IntersectCPULists
+	f := func(s cpuSet) string { return FormatCPUList(s) }
+	check(t, "union and intersection commute", func(a, b cpuSet) bool {
+		return u(f(a), f(b)) == u(f(b), f(a)) && i(f(a), f(b)) == i(f(b), f(a))
+	})
+	check(t, "union and intersection associate", func(a, b, c cpuSet) bool {
+		return u(u(f(a), f(b)), f(c)) == u(f(a), u(f(b), f(c))) &&
+			i(i(f(a), f(b)), f(c)) == i(f(a), i(f(b), f(c)))
+	})
+	check(t, "idempotence and identities", func(a cpuSet) bool {
+		return u(f(a), f(a)) == f(a) && i(f(a), f(a)) == f(a) &&
+			u(f(a), "") == f(a) && i(f(a), "") == ""
+	})
+	check(t, "absorption", func(a, b cpuSet) bool {
+		return u(f(a), i(f(a), f(b))) == f(a) && i(f(a), u(f(a), f(b))) == f(a)
+	})
+	check(t, "distribution", func(a, b, c cpuSet) bool {
+		return i(f(a), u(f(b), f(c))) == u(i(f(a), f(b)), i(f(a), f(c))) &&
+			u(f(a), i(f(b), f(c))) == i(u(f(a), f(b)), u(f(a), f(c)))
+	})
+	check(t, "membership", func(a, b cpuSet, cpu uint16) bool {
+		c := int(cpu) % 64
+		in := func(list string) bool {
+			cpus, _ := ParseCPUList(list)
+			return ContainsCPU(cpus, c)
+		}
+		inA, inB := ContainsCPU(a, c), ContainsCPU(b, c)
+		return in(u(f(a), f(b))) == (inA || inB) && in(i(f(a), f(b))) == (inA && inB)
+	})
+}
+
+func TestParseCPUListEdgeCases(t *testing.T) {
+	for in, want := range map[string]string{
+		"3-3":         "3",
+		"0-255":       "0-255",
+		"2,1,2":       "1-2",
+		" 7 , 5-6 ,,": "5-7",
+		"0-1,1-2":     "0-2",
+		"8191":        "8191",
+	} {
+		got, _, err := CanonicalizeCPUList(in)
+		if err != nil || got != want {
+			t.Fatalf("CanonicalizeCPUList(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"-1", "1-", "3-1", "1-2-3", "0-8192", "8192", "0-2147483647", "0x1", "a"} {
+		if got, err := ParseCPUList(in); err == nil {
+			t.Fatalf("ParseCPUList(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestParseCPUMask(t *testing.T) {
+	for in, want := range map[string]string{
+		"00000001":          "0",
+		"80000001":          "0,31",
+		"00000100,0000ff00": "8-15,40",
+		"f":                 "0-3",
+		"":                  "",
+	} {
+		cpus, err := ParseCPUMask(in)
+		if err != nil || FormatCPUList(cpus) != want {
+			t.Fatalf("ParseCPUMask(%q) = %v, %v; want %s", in, cpus, err, want)
+		}
+	}
+	for _, in := range []string{"g", "1,,1", "123456789", strings.Repeat("0,", 256) + "1"} {
+		if _, err := ParseCPUMask(in); err == nil {
+			t.Fatalf("ParseCPUMask(%q) succeeded", in)
+		}
+	}
+}
+
+func FuzzParseCPUList(f *testing.F) {
+	for _, seed := range []string{"", "0", "3-3", "0-255", "2,1,2", "0-3,8-11", " 1 - 2 ", "-1", "3-1", "1-2-3", ",,", "8191", "0-8192"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		cpus, err := ParseCPUList(s)
+		if err != nil {
+			return
+		}
+		for i, c := range cpus {
+			if c < 0 || c > MaxCPU || (i > 0 && c <= cpus[i-1]) {
+				t.Fatalf("ParseCPUList(%q) = %v: not sorted, unique and in range", s, cpus)
+			}
+		}
+		canonical := FormatCPUList(cpus)
+		again, err := ParseCPUList(canonical)
+		if err != nil || !sameCPUs(again, cpus) {
+			t.Fatalf("ParseCPUList(%q) = %v, %v; want %v", canonical, again, err, cpus)
+		}
+		if FormatCPUList(again) != canonical {
+			t.Fatalf("format of %q is not stable", canonical)
+		}
+		if len(cpus) > 0 {
+			masked, err := ParseCPUMask(FormatCPUMask(cpus))
+			if err != nil || !sameCPUs(masked, cpus) {
+				t.Fatalf("mask of %v parsed back as %v, %v", cpus, masked, err)
+			}
+		}
+		if UnionCPULists(s, canonical) != canonical || IntersectCPULists(s, canonical) != canonical {
+			t.Fatalf("%q is not its own union and intersection", s)
+		}
+	})
+}
+
+func FuzzParseCPUMask(f *testing.F) {
+	for _, seed := range []string{"", "1", "ff", "80000001", "00000100,0000ff00", "g", "1,,1"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		cpus, err := ParseCPUMask(s)
+		if err != nil || len(cpus) == 0 {
+			return
+		}
+		list := FormatCPUList(cpus)
+		again, err := ParseCPUList(list)
+		if err != nil || !sameCPUs(again, cpus) {
+			t.Fatalf("mask %q = %v; its list %q parses as %v, %v", s, cpus, list, again, err)
+		}
+	})
+}
@@ -26,3 +26,9 @@ func TestParseCPUList_Invalid(t *testing.T) {
 		t.Fatalf("expected error")
 	}
 }
+
+func TestUnionCPULists(t *testing.T) {
+	if got := UnionCPULists("0-3", "8-11", "2-5", "x"); got != "0-5,8-11" {
+		t.Fatalf("unexpected union: %q", got)
+	}
+}
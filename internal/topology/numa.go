@@ -0,0 +1,80 @@
+package topology
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NodeCPUs maps NUMA node IDs to the canonical CPU list of each node.
+type NodeCPUs map[int]string
+
+// DetectNodes reads NUMA node CPU lists from sysfs. Nodes without CPUs are
+// skipped. A nil map (and no error) means the system exposes no node info.
+func DetectNodes() (NodeCPUs, error) {
+	return Sysfs{}.DetectNodes()
+}
+
+func detectNodesAt(nodeRoot string) (NodeCPUs, error) {
+	files, err := filepath.Glob(filepath.Join(nodeRoot, "node*", "cpulist"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	out := make(NodeCPUs, len(files))
+	for _, path := range files {
+		name := filepath.Base(filepath.Dir(path))
+		id, err := strconv.Atoi(strings.TrimPrefix(name, "node"))
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		canonical, _, err := CanonicalizeCPUList(string(b))
+		if err != nil || canonical == "" {
+			continue
+		}
+		out[id] = canonical
+	}
+	return out, nil
+}
+
+// MemoryNodesFor returns the list of NUMA nodes (in CPU list format) whose
+// CPUs intersect cpuList. It returns "" when the system has fewer than two
+// nodes, since restricting memory placement is meaningless there.
+func (n NodeCPUs) MemoryNodesFor(cpuList string) (string, error) {
+	if len(n) < 2 {
+		return "", nil
+	}
+	_, cpus, err := CanonicalizeCPUList(cpuList)
+	if err != nil {
+		return "", err
+	}
+
+	ids := make([]int, 0, len(n))
+	for id := range n {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	nodes := make([]int, 0, len(ids))
+	for _, id := range ids {
+		_, nodeCPUs, err := CanonicalizeCPUList(n[id])
+		if err != nil {
+			continue
+		}
+		for _, cpu := range cpus {
+			if ContainsCPU(nodeCPUs, cpu) {
+				nodes = append(nodes, id)
+				break
+			}
+		}
+	}
+	return FormatCPUList(nodes), nil
+}
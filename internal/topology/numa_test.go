@@ -0,0 +1,68 @@
+package topology
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryNodesFor(t *testing.T) {
+	nodes := NodeCPUs{0: "0-7,16-23", 1: "8-15,24-31"}
+	got, err := nodes.MemoryNodesFor("0-7")
+	if err != nil {
+		t.Fatalf("MemoryNodesFor: %v", err)
+	}
+	if got != "0" {
+		t.Fatalf("unexpected nodes: %q", got)
+	}
+	got, err = nodes.MemoryNodesFor("4-11")
+	if err != nil {
+		t.Fatalf("MemoryNodesFor: %v", err)
+	}
+	if got != "0-1" {
+		t.Fatalf("unexpected nodes: %q", got)
+	}
+}
+
+func TestMemoryNodesFor_SingleNode(t *testing.T) {
+	nodes := NodeCPUs{0: "0-15"}
+	got, err := nodes.MemoryNodesFor("0-7")
+	if err != nil {
+		t.Fatalf("MemoryNodesFor: %v", err)
+	}
+	if got != "" {
+		t.Fatalf("expected no nodes on single-node system, got %q", got)
+	}
+}
+
+func TestDetectClusterNodes(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"node/node0/cpulist": "0-7\n",
+		"node/node1/cpulist": "8-15\n",
+	}
+	for cpu := 0; cpu < 16; cpu++ {
+		shared := "0-7"
+		if cpu >= 8 {
+			shared = "8-15"
+		}
+		files[fmt.Sprintf("cpu/cpu%d/cache/index3/shared_cpu_list", cpu)] = shared + "\n"
+	}
+	for rel, content := range files {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := detectAt(filepath.Join(root, "cpu"), PreferCache)
+	if err != nil {
+		t.Fatalf("detectAt: %v", err)
+	}
+	if res.Clusters[0].Nodes != "0" || res.Clusters[1].Nodes != "1" {
+		t.Fatalf("unexpected cluster nodes: %+v", res.Clusters)
+	}
+}
@@ -0,0 +1,133 @@
+package topology
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Values for the smt option, which decides how the SMT sibling threads of a
+// core are split between the OS and GAME sets.
+const (
+	// SMTIgnore uses the CPU lists as detected or configured.
+	SMTIgnore = "ignore"
+	// SMTPair keeps the threads of a core together: a core split between
+	// the sets goes wholly to GAME.
+	SMTPair = "pair"
+	// SMTGamePhysicalOnly gives GAME one thread per core and moves the
+	// sibling threads to OS, which is close to running the game with SMT
+	// off while the OS keeps using the spare threads.
+	SMTGamePhysicalOnly = "game-physical-only"
+)
+
+// ValidSMT reports whether m is a known smt value ("" means default).
+func ValidSMT(m string) bool {
+	return m == "" || m == SMTIgnore || m == SMTPair || m == SMTGamePhysicalOnly
+}
+
+// DetectCores returns the logical CPUs of each physical core, read from
+// thread_siblings_list. Cores are ordered by their lowest CPU.
+func DetectCores() ([][]int, error) {
+	return Sysfs{}.DetectCores()
+}
+
+func detectCoresAt(cpuRoot string) ([][]int, error) {
+	files, err := filepath.Glob(filepath.Join(cpuRoot, "cpu[0-9]*", "topology", "thread_siblings_list"))
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var cores [][]int
+	for _, path := range files {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		canonical, cpus, err := CanonicalizeCPUList(strings.TrimSpace(string(b)))
+		if err != nil || len(cpus) == 0 || seen[canonical] {
+			continue
+		}
+		seen[canonical] = true
+		cores = append(cores, cpus)
+	}
+	if len(cores) == 0 {
+		return nil, fmt.Errorf("no thread_siblings_list files found under %s", cpuRoot)
+	}
+	sort.Slice(cores, func(i, j int) bool { return cores[i][0] < cores[j][0] })
+	return cores, nil
+}
+
+// ApplySMT moves sibling threads between osCPUs and gameCPUs according to
+// mode. Cores outside both sets are left alone. It fails when the GAME set
+// would end up empty.
+func ApplySMT(mode, osCPUs, gameCPUs string, cores [][]int) (string, string, error) {
+	if mode == "" || mode == SMTIgnore {
+		return osCPUs, gameCPUs, nil
+	}
+	_, osSet, err := CanonicalizeCPUList(osCPUs)
+	if err != nil {
+		return "", "", err
+	}
+	_, gameSet, err := CanonicalizeCPUList(gameCPUs)
+	if err != nil {
+		return "", "", err
+	}
+	inGame := map[int]bool{}
+	for _, c := range gameSet {
+		inGame[c] = true
+	}
+	inOS := map[int]bool{}
+	for _, c := range osSet {
+		inOS[c] = true
+	}
+
+	for _, core := range cores {
+		switch mode {
+		case SMTPair:
+			split := false
+			for _, c := range core {
+				split = split || inGame[c]
+			}
+			if !split {
+				continue
+			}
+			for _, c := range core {
+				if inOS[c] {
+					delete(inOS, c)
+					inGame[c] = true
+				}
+			}
+		case SMTGamePhysicalOnly:
+			kept := false
+			for _, c := range core {
+				if !inGame[c] {
+					continue
+				}
+				if !kept {
+					kept = true
+					continue
+				}
+				delete(inGame, c)
+				inOS[c] = true
+			}
+		default:
+			return "", "", fmt.Errorf("unknown smt mode %q", mode)
+		}
+	}
+
+	keys := func(m map[int]bool) []int {
+		out := make([]int, 0, len(m))
+		for c := range m {
+			out = append(out, c)
+		}
+		sort.Ints(out)
+		return out
+	}
+	game := keys(inGame)
+	if len(game) == 0 {
+		return "", "", fmt.Errorf("smt = %q leaves no game cpus", mode)
+	}
+	return FormatCPUList(keys(inOS)), FormatCPUList(game), nil
+}
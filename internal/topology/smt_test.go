@@ -0,0 +1,55 @@
+package topology
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectCores(t *testing.T) {
+	root := t.TempDir()
+	// 4 cores, 8 threads: siblings n and n+4.
+	for cpu := 0; cpu < 8; cpu++ {
+		dir := filepath.Join(root, fmt.Sprintf("cpu%d", cpu), "topology")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		list := fmt.Sprintf("%d,%d\n", cpu%4, cpu%4+4)
+		if err := os.WriteFile(filepath.Join(dir, "thread_siblings_list"), []byte(list), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	cores, err := detectCoresAt(root)
+	if err != nil {
+		t.Fatalf("detectCoresAt: %v", err)
+	}
+	if len(cores) != 4 || fmt.Sprint(cores[1]) != "[1 5]" {
+		t.Fatalf("cores = %v", cores)
+	}
+}
+
+func TestApplySMT(t *testing.T) {
+	// 16 cores, siblings n and n+16; CCD0 = 0-7,16-23, CCD1 = 8-15,24-31.
+	cores := make([][]int, 16)
+	for i := range cores {
+		cores[i] = []int{i, i + 16}
+	}
+	for _, tc := range []struct {
+		mode, os, game   string
+		wantOS, wantGame string
+	}{
+		{SMTIgnore, "0-7,16-23", "8-15,24-31", "0-7,16-23", "8-15,24-31"},
+		{SMTGamePhysicalOnly, "0-7,16-23", "8-15,24-31", "0-7,16-31", "8-15"},
+		{SMTPair, "0-7,16-24", "8-15,25-31", "0-7,16-23", "8-15,24-31"},
+		{SMTPair, "0-7,16-23", "8-15,24-31", "0-7,16-23", "8-15,24-31"},
+	} {
+		o, g, err := ApplySMT(tc.mode, tc.os, tc.game, cores)
+		if err != nil || o != tc.wantOS || g != tc.wantGame {
+			t.Fatalf("ApplySMT(%s, %s, %s) = %q, %q, %v", tc.mode, tc.os, tc.game, o, g, err)
+		}
+	}
+	if _, _, err := ApplySMT("half", "0-7", "8-15", cores); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+}
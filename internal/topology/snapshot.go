@@ -0,0 +1,170 @@
+package topology
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Detector is a source of CPU topology: the live sysfs tree, another sysfs
+// tree (a copy from a different machine, a container's bind mount) or a
+// saved Snapshot.
+type Detector interface {
+	// Detect returns the clusters and the default OS/GAME split for
+	// prefer, see DetectPrefer.
+	Detect(prefer string) (Result, error)
+	// DetectCores returns the logical CPUs of each physical core.
+	DetectCores() ([][]int, error)
+	// DetectNodes returns the CPUs of each NUMA node.
+	DetectNodes() (NodeCPUs, error)
+}
+
+// Sysfs reads the topology from the sysfs tree mounted at Root, "/sys" when
+// empty.
+type Sysfs struct {
+	Root string
+}
+
+func (s Sysfs) cpuRoot() string {
+	root := s.Root
+	if root == "" {
+		root = "/sys"
+	}
+	return filepath.Join(root, "devices", "system", "cpu")
+}
+
+func (s Sysfs) Detect(prefer string) (Result, error) { return detectAt(s.cpuRoot(), prefer) }
+
+func (s Sysfs) DetectCores() ([][]int, error) { return detectCoresAt(s.cpuRoot()) }
+
+func (s Sysfs) DetectNodes() (NodeCPUs, error) {
+	return detectNodesAt(filepath.Join(filepath.Dir(s.cpuRoot()), "node"))
+}
+
+// SnapshotVersion is the format version written by Dump.
+const SnapshotVersion = 1
+
+// Snapshot is a saved topology. It answers like the sysfs tree it was taken
+// from, without reading sysfs, so detection can be pinned to a known layout
+// or exercised on machines other than the target.
+type Snapshot struct {
+	Version  int       `json:"version"`
+	Taken    time.Time `json:"taken"`
+	Clusters []Cluster `json:"clusters"`
+	// Cores and Nodes are empty when the source did not report them.
+	Cores [][]int  `json:"cores,omitempty"`
+	Nodes NodeCPUs `json:"nodes,omitempty"`
+}
+
+// Dump takes a snapshot of d. Only cluster detection has to succeed; cores
+// and NUMA nodes are left out when d cannot report them.
+func Dump(d Detector) (Snapshot, error) {
+	res, err := d.Detect(PreferCache)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	s := Snapshot{Version: SnapshotVersion, Taken: time.Now().UTC(), Clusters: res.Clusters}
+	s.Cores, _ = d.DetectCores()
+	s.Nodes, _ = d.DetectNodes()
+	return s, nil
+}
+
+// Detect picks the OS and GAME sets from the saved clusters the way
+// DetectPrefer does from sysfs.
+func (s *Snapshot) Detect(prefer string) (Result, error) {
+	lists := make([]string, 0, len(s.Clusters))
+	for _, c := range s.Clusters {
+		lists = append(lists, c.CPUs)
+	}
+	osCPUs, gameCPUs, _, err := SelectOSAndGame(lists)
+	if err != nil {
+		return Result{}, err
+	}
+	clusters := append([]Cluster(nil), s.Clusters...)
+	if o, g, ok := SelectPreferred(clusters, prefer); ok {
+		osCPUs, gameCPUs = o, g
+	}
+	return Result{OSCPUs: osCPUs, GameCPUs: gameCPUs, Clusters: clusters}, nil
+}
+
+func (s *Snapshot) DetectCores() ([][]int, error) {
+	if len(s.Cores) == 0 {
+		return nil, errors.New("the topology snapshot has no cores")
+	}
+	return s.Cores, nil
+}
+
+func (s *Snapshot) DetectNodes() (NodeCPUs, error) { return s.Nodes, nil }
+
+// Write writes s as indented JSON.
+func (s *Snapshot) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// ReadSnapshot parses a snapshot written by Write. Cluster CPU lists are
+// canonicalized and cluster IDs reassigned as Clusters would, keeping the
+// cache size, frequency and nodes of each.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported topology snapshot version %d (expected %d)", s.Version, SnapshotVersion)
+	}
+	byCPUs := make(map[string]Cluster, len(s.Clusters))
+	lists := make([]string, 0, len(s.Clusters))
+	for _, c := range s.Clusters {
+		canonical, cpus, err := CanonicalizeCPUList(c.CPUs)
+		if err != nil || len(cpus) == 0 {
+			return nil, fmt.Errorf("cluster %d: invalid cpus %q", c.ID, c.CPUs)
+		}
+		byCPUs[canonical] = c
+		lists = append(lists, canonical)
+	}
+	if len(lists) == 0 {
+		return nil, errors.New("the topology snapshot has no clusters")
+	}
+	clusters := Clusters(lists)
+	for i := range clusters {
+		c := byCPUs[clusters[i].CPUs]
+		c.ID, c.CPUs = clusters[i].ID, clusters[i].CPUs
+		clusters[i] = c
+	}
+	s.Clusters = clusters
+	for _, core := range s.Cores {
+		for _, cpu := range core {
+			if err := checkCPU(cpu); err != nil {
+				return nil, fmt.Errorf("cores: %w", err)
+			}
+		}
+	}
+	for id, cpus := range s.Nodes {
+		canonical, _, err := CanonicalizeCPUList(cpus)
+		if err != nil {
+			return nil, fmt.Errorf("node %d: invalid cpus %q", id, cpus)
+		}
+		s.Nodes[id] = canonical
+	}
+	return &s, nil
+}
+
+// LoadSnapshot reads the snapshot at path, see ReadSnapshot.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := ReadSnapshot(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
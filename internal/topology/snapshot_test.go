@@ -0,0 +1,118 @@
+package topology
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeSysfs lays out a 7950X3D-like sysfs tree under root: two CCDs of four
+// cores with SMT, the V-Cache on CCD0, one NUMA node per CCD.
+func writeSysfs(t *testing.T, root string) {
+	t.Helper()
+	files := map[string]string{
+		"devices/system/node/node0/cpulist": "0-3,8-11\n",
+		"devices/system/node/node1/cpulist": "4-7,12-15\n",
+	}
+	for cpu := 0; cpu < 16; cpu++ {
+		shared, size := "0-3,8-11", "98304K"
+		if cpu%8 >= 4 {
+			shared, size = "4-7,12-15", "32768K"
+		}
+		dir := fmt.Sprintf("devices/system/cpu/cpu%d/", cpu)
+		files[dir+"cache/index3/shared_cpu_list"] = shared + "\n"
+		files[dir+"cache/index3/size"] = size + "\n"
+		files[dir+"topology/thread_siblings_list"] = fmt.Sprintf("%d,%d\n", cpu%8, cpu%8+8)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	writeSysfs(t, root)
+	sys := Sysfs{Root: root}
+
+	snap, err := Dump(sys)
+	if err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := snap.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	loaded, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+
+	for _, prefer := range []string{PreferCache, PreferFrequency} {
+		want, err := sys.Detect(prefer)
+		if err != nil {
+			t.Fatalf("sysfs Detect(%s): %v", prefer, err)
+		}
+		got, err := loaded.Detect(prefer)
+		if err != nil {
+			t.Fatalf("snapshot Detect(%s): %v", prefer, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Detect(%s): snapshot %+v, sysfs %+v", prefer, got, want)
+		}
+	}
+	if got := loaded.Clusters[0]; got.CPUs != "0-3,8-11" || got.L3KB != 98304 || got.Nodes != "0" {
+		t.Fatalf("unexpected cluster 0: %+v", got)
+	}
+
+	wantCores, _ := sys.DetectCores()
+	if cores, err := loaded.DetectCores(); err != nil || !reflect.DeepEqual(cores, wantCores) {
+		t.Fatalf("DetectCores: %v, %v (want %v)", cores, err, wantCores)
+	}
+	wantNodes, _ := sys.DetectNodes()
+	if nodes, err := loaded.DetectNodes(); err != nil || !reflect.DeepEqual(nodes, wantNodes) {
+		t.Fatalf("DetectNodes: %v, %v (want %v)", nodes, err, wantNodes)
+	}
+}
+
+func TestReadSnapshot_Canonicalizes(t *testing.T) {
+	in := `{"version":1,"clusters":[{"id":7,"cpus":"8,9,10,11,12,13,14,15","l3_kb":32768},{"id":3,"cpus":"0-7","l3_kb":98304}]}`
+	s, err := ReadSnapshot(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+	want := []Cluster{{ID: 0, CPUs: "0-7", L3KB: 98304}, {ID: 1, CPUs: "8-15", L3KB: 32768}}
+	if !reflect.DeepEqual(s.Clusters, want) {
+		t.Fatalf("clusters: %+v", s.Clusters)
+	}
+	res, err := s.Detect(PreferCache)
+	if err != nil || res.GameCPUs != "0-7" || res.OSCPUs != "8-15" {
+		t.Fatalf("Detect: os=%q game=%q err=%v", res.OSCPUs, res.GameCPUs, err)
+	}
+	if _, err := s.DetectCores(); err == nil {
+		t.Fatalf("expected an error for a snapshot without cores")
+	}
+}
+
+func TestReadSnapshot_RejectsInvalid(t *testing.T) {
+	for _, in := range []string{
+		`{"version":2,"clusters":[{"id":0,"cpus":"0-7"}]}`,
+		`{"version":1,"clusters":[]}`,
+		`{"version":1,"clusters":[{"id":0,"cpus":"7-0"}]}`,
+		`{"version":1,"clusters":[{"id":0,"cpus":"0-7"}],"cores":[[0,9000]]}`,
+		`{"version":1,"clusters":[{"id":0,"cpus":"0-7"}],"nodes":{"0":"x"}}`,
+	} {
+		if _, err := ReadSnapshot(strings.NewReader(in)); err == nil {
+			t.Errorf("expected an error for %s", in)
+		}
+	}
+}
@@ -6,13 +6,127 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 )
 
+// Cluster is a group of CPUs sharing a last-level (L3) cache: a CCD on AMD,
+// the whole die on most Intel and ARM parts. IDs are assigned in order of
+// each cluster's lowest CPU, so cluster 0 always contains CPU0 and IDs are
+// stable across boots.
+type Cluster struct {
+	ID   int    `json:"id"`
+	CPUs string `json:"cpus"`
+	// L3KB and MaxFreqKHz are filled in by Detect when sysfs reports them:
+	// the L3 size and the highest cpuinfo_max_freq among the cluster's CPUs.
+	L3KB       int `json:"l3_kb,omitempty"`
+	MaxFreqKHz int `json:"max_freq_khz,omitempty"`
+	// Nodes lists the NUMA nodes of the cluster's CPUs on systems with
+	// more than one node, e.g. one per CCD with NPS4 or L3-as-NUMA.
+	Nodes string `json:"nodes,omitempty"`
+}
+
+// Values for the prefer option, which decides which cluster becomes GAME
+// when clusters are not alike.
+const (
+	// PreferCache picks the cluster with the largest L3, e.g. the V-Cache
+	// CCD of a 7950X3D/9950X3D.
+	PreferCache = "cache"
+	// PreferFrequency picks the cluster with the highest max frequency.
+	PreferFrequency = "frequency"
+)
+
+// ValidPrefer reports whether p is a known prefer value ("" means default).
+func ValidPrefer(p string) bool {
+	return p == "" || p == PreferCache || p == PreferFrequency
+}
+
+// SelectPreferred picks the GAME cluster by prefer: the one with the largest
+// L3 or the highest max frequency. ok is false when that metric is missing or
+// no single cluster stands out, so symmetric parts keep the default split.
+func SelectPreferred(clusters []Cluster, prefer string) (osCPUs string, gameCPUs string, ok bool) {
+	metric := func(c Cluster) int { return c.L3KB }
+	if prefer == PreferFrequency {
+		metric = func(c Cluster) int { return c.MaxFreqKHz }
+	}
+	best, bestVal, tied := -1, 0, false
+	for _, c := range clusters {
+		v := metric(c)
+		switch {
+		case v > bestVal:
+			best, bestVal, tied = c.ID, v, false
+		case v == bestVal:
+			tied = true
+		}
+	}
+	if best < 0 || tied {
+		return "", "", false
+	}
+	osCPUs, gameCPUs, err := SelectCluster(clusters, best)
+	if err != nil {
+		return "", "", false
+	}
+	return osCPUs, gameCPUs, true
+}
+
 type Result struct {
 	OSCPUs   string
 	GameCPUs string
-	Lists    []string
+	Clusters []Cluster
+}
+
+// Clusters deduplicates CPU lists and assigns them stable IDs.
+func Clusters(lists []string) []Cluster {
+	type entry struct {
+		cpus  string
+		first int
+	}
+	seen := make(map[string]struct{}, len(lists))
+	entries := make([]entry, 0, len(lists))
+	for _, s := range lists {
+		canonical, cpus, err := CanonicalizeCPUList(s)
+		if err != nil || len(cpus) == 0 {
+			continue
+		}
+		if _, ok := seen[canonical]; ok {
+			continue
+		}
+		seen[canonical] = struct{}{}
+		entries = append(entries, entry{cpus: canonical, first: cpus[0]})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].first < entries[j].first })
+	out := make([]Cluster, 0, len(entries))
+	for i, e := range entries {
+		out = append(out, Cluster{ID: i, CPUs: e.cpus})
+	}
+	return out
+}
+
+// SelectCluster uses cluster id as the GAME CPUs and every other cluster as
+// the OS CPUs. Choosing cluster 0 therefore puts the OS off CPU0.
+func SelectCluster(clusters []Cluster, id int) (osCPUs string, gameCPUs string, err error) {
+	found := false
+	other := make([]int, 0, 64)
+	for _, c := range clusters {
+		if c.ID == id {
+			gameCPUs = c.CPUs
+			found = true
+			continue
+		}
+		_, cpus, err := CanonicalizeCPUList(c.CPUs)
+		if err != nil {
+			continue
+		}
+		other = append(other, cpus...)
+	}
+	if !found {
+		return "", "", fmt.Errorf("no cluster %d (have %d clusters)", id, len(clusters))
+	}
+	osCPUs = FormatCPUList(other)
+	if osCPUs == "" {
+		return "", "", fmt.Errorf("cluster %d is the only cluster", id)
+	}
+	return osCPUs, gameCPUs, nil
 }
 
 // SelectOSAndGame picks OS CPUs as the list containing CPU0 and GAME CPUs as the
@@ -70,8 +184,21 @@ func SelectOSAndGame(lists []string) (osCPUs string, gameCPUs string, canonicalL
 	return osCPUs, gameCPUs, canonicalLists, nil
 }
 
+// Detect reads the cluster topology from sysfs. The GAME cluster is chosen
+// by the default prefer (cache), see DetectPrefer.
 func Detect() (Result, error) {
-	files, err := filepath.Glob("/sys/devices/system/cpu/cpu*/cache/index3/shared_cpu_list")
+	return DetectPrefer(PreferCache)
+}
+
+// DetectPrefer is Detect with an explicit prefer value. When the clusters do
+// not differ in the preferred metric, OS CPUs are the cluster containing CPU0
+// and GAME CPUs are everything else.
+func DetectPrefer(prefer string) (Result, error) {
+	return Sysfs{}.Detect(prefer)
+}
+
+func detectAt(cpuRoot string, prefer string) (Result, error) {
+	files, err := filepath.Glob(filepath.Join(cpuRoot, "cpu*", "cache", "index3", "shared_cpu_list"))
 	if err != nil {
 		return Result{}, err
 	}
@@ -80,12 +207,26 @@ func Detect() (Result, error) {
 	}
 
 	raw := make([]string, 0, len(files))
+	l3KB := map[string]int{}
+	maxFreq := map[string]int{}
 	for _, path := range files {
 		b, err := os.ReadFile(path)
 		if err != nil {
 			continue
 		}
 		raw = append(raw, string(b))
+		canonical, _, err := CanonicalizeCPUList(string(b))
+		if err != nil {
+			continue
+		}
+		index3 := filepath.Dir(path)
+		if kb := readSizeKB(filepath.Join(index3, "size")); kb > l3KB[canonical] {
+			l3KB[canonical] = kb
+		}
+		cpuDir := filepath.Dir(filepath.Dir(index3))
+		if khz := readInt(filepath.Join(cpuDir, "cpufreq", "cpuinfo_max_freq")); khz > maxFreq[canonical] {
+			maxFreq[canonical] = khz
+		}
 	}
 	if len(raw) == 0 {
 		return Result{}, errors.New("failed to read any cpu lists")
@@ -95,5 +236,74 @@ func Detect() (Result, error) {
 	if err != nil {
 		return Result{}, err
 	}
-	return Result{OSCPUs: osCPUs, GameCPUs: gameCPUs, Lists: lists}, nil
+	clusters := Clusters(lists)
+	nodes, _ := detectNodesAt(filepath.Join(filepath.Dir(cpuRoot), "node"))
+	for i := range clusters {
+		clusters[i].L3KB = l3KB[clusters[i].CPUs]
+		clusters[i].MaxFreqKHz = maxFreq[clusters[i].CPUs]
+		clusters[i].Nodes, _ = nodes.MemoryNodesFor(clusters[i].CPUs)
+	}
+	if o, g, ok := SelectPreferred(clusters, prefer); ok {
+		osCPUs, gameCPUs = o, g
+	}
+	return Result{OSCPUs: osCPUs, GameCPUs: gameCPUs, Clusters: clusters}, nil
+}
+
+// readSizeKB parses a sysfs cache size such as "32768K" or "96M".
+func readSizeKB(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	s := strings.TrimSpace(string(b))
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "K"):
+		s = strings.TrimSuffix(s, "K")
+	case strings.HasSuffix(s, "M"):
+		s, mult = strings.TrimSuffix(s, "M"), 1024
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n * mult
+}
+
+func readInt(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// PreferLocal returns the union of the non-OS clusters that intersect local
+// (for example the CPUs attached to a GPU's PCIe root). It returns "" when no
+// cluster intersects local.
+func PreferLocal(clusters []Cluster, osCPUs string, local string) string {
+	_, localCPUs, err := CanonicalizeCPUList(local)
+	if err != nil || len(localCPUs) == 0 {
+		return ""
+	}
+	osCanonical, _, _ := CanonicalizeCPUList(osCPUs)
+
+	near := make([]int, 0, 64)
+	for _, c := range clusters {
+		canonical, cpus, err := CanonicalizeCPUList(c.CPUs)
+		if err != nil || canonical == "" || canonical == osCanonical {
+			continue
+		}
+		for _, cpu := range cpus {
+			if ContainsCPU(localCPUs, cpu) {
+				near = append(near, cpus...)
+				break
+			}
+		}
+	}
+	return FormatCPUList(near)
 }
@@ -1,6 +1,11 @@
 package topology
 
-import "testing"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
 
 func TestSelectOSAndGame(t *testing.T) {
 	osCPUs, gameCPUs, lists, err := SelectOSAndGame([]string{"0-3", "4-7"})
@@ -17,3 +22,75 @@ func TestSelectOSAndGame(t *testing.T) {
 		t.Fatalf("unexpected lists: %v", lists)
 	}
 }
+
+func TestPreferLocal(t *testing.T) {
+	clusters := Clusters([]string{"0-7", "8-15", "16-23", "24-31"})
+	if got := PreferLocal(clusters, "0-7", "16-31"); got != "16-31" {
+		t.Fatalf("unexpected near cpus: %q", got)
+	}
+	if got := PreferLocal(clusters, "0-7", "0-3"); got != "" {
+		t.Fatalf("expected no near cpus outside OS list, got %q", got)
+	}
+}
+
+func TestClustersAndSelectCluster(t *testing.T) {
+	clusters := Clusters([]string{"16-23", "8-15", "0-7", "8-15"})
+	if len(clusters) != 3 || clusters[0].CPUs != "0-7" || clusters[1].CPUs != "8-15" || clusters[2].ID != 2 {
+		t.Fatalf("unexpected clusters: %+v", clusters)
+	}
+	osCPUs, gameCPUs, err := SelectCluster(clusters, 1)
+	if err != nil || osCPUs != "0-7,16-23" || gameCPUs != "8-15" {
+		t.Fatalf("SelectCluster(1): os=%q game=%q err=%v", osCPUs, gameCPUs, err)
+	}
+	if _, _, err := SelectCluster(clusters, 5); err == nil {
+		t.Fatalf("expected error for unknown cluster")
+	}
+}
+
+func TestDetectPreferX3D(t *testing.T) {
+	root := t.TempDir()
+	// 7950X3D-like: CCD0 has the V-Cache, CCD1 clocks higher.
+	for cpu := 0; cpu < 16; cpu++ {
+		shared, size, freq := "0-7", "98304K", "5250000"
+		if cpu >= 8 {
+			shared, size, freq = "8-15", "32768K", "5750000"
+		}
+		dir := filepath.Join(root, fmt.Sprintf("cpu%d", cpu))
+		files := map[string]string{
+			filepath.Join(dir, "cache", "index3", "shared_cpu_list"): shared + "\n",
+			filepath.Join(dir, "cache", "index3", "size"):            size + "\n",
+			filepath.Join(dir, "cpufreq", "cpuinfo_max_freq"):        freq + "\n",
+		}
+		for path, content := range files {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("MkdirAll: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+		}
+	}
+
+	res, err := detectAt(root, PreferCache)
+	if err != nil {
+		t.Fatalf("detectAt: %v", err)
+	}
+	if res.GameCPUs != "0-7" || res.OSCPUs != "8-15" {
+		t.Fatalf("cache: os=%q game=%q", res.OSCPUs, res.GameCPUs)
+	}
+	if res.Clusters[0].L3KB != 98304 || res.Clusters[1].MaxFreqKHz != 5750000 {
+		t.Fatalf("unexpected cluster info: %+v", res.Clusters)
+	}
+
+	res, err = detectAt(root, PreferFrequency)
+	if err != nil || res.GameCPUs != "8-15" || res.OSCPUs != "0-7" {
+		t.Fatalf("frequency: os=%q game=%q err=%v", res.OSCPUs, res.GameCPUs, err)
+	}
+}
+
+func TestSelectPreferredSymmetric(t *testing.T) {
+	clusters := []Cluster{{ID: 0, CPUs: "0-7", L3KB: 32768}, {ID: 1, CPUs: "8-15", L3KB: 32768}}
+	if _, _, ok := SelectPreferred(clusters, PreferCache); ok {
+		t.Fatalf("expected no preference between identical clusters")
+	}
+}
@@ -12,10 +12,12 @@ CONFIGDIR="${CONFIGDIR:-${XDG_CONFIG_HOME:-${HOME}/.config}/ccdbind}"
 STATEDIR="${STATEDIR:-${XDG_STATE_HOME:-${HOME}/.local/state}/ccdbind}"
 STATEDIR_PIN="${STATEDIR_PIN:-${XDG_STATE_HOME:-${HOME}/.local/state}/ccdpin}"
 SYSTEMD_USER_DIR="${SYSTEMD_USER_DIR:-${XDG_CONFIG_HOME:-${HOME}/.config}/systemd/user}"
+SYSTEMD_SYSTEM_DIR="${SYSTEMD_SYSTEM_DIR:-/etc/systemd/system}"
 
 DRY_RUN="${DRY_RUN:-0}"
 PURGE="${PURGE:-0}"
 FORCE="${FORCE:-0}"
+SYSTEM_UNITS="${SYSTEM_UNITS:-0}"
 
 setup_colors() {
     if [[ -t 1 ]] && [[ -z "${NO_COLOR:-}" ]]; then
@@ -46,6 +48,7 @@ ${BOLD}Options:${NC}
     -n, --dry-run       Print actions without executing
     -p, --purge         Also remove configuration and state files
     -f, --force         Don't prompt for confirmation
+    --system            Also remove the system unit for smt_off (uses sudo)
     --prefix=PATH       Install prefix (default: ~/.local)
     --bindir=PATH       Binary directory (default: PREFIX/bin)
     --configdir=PATH    Config directory (default: ~/.config/ccdbind)
@@ -143,6 +146,25 @@ stop_service() {
         info "Disabling ccdbind.service..."
         systemctl --user disable ccdbind.service || true
     fi
+
+    if systemctl --user is-enabled --quiet ccdbind-hotkey.service 2>/dev/null; then
+        info "Disabling ccdbind-hotkey.service..."
+        systemctl --user disable --now ccdbind-hotkey.service || true
+    fi
+}
+
+# Undo whatever a crashed daemon may have left pinned.
+restore_all() {
+    local bin="${BINDIR}/ccdbind"
+    if [[ ! -x "$bin" ]]; then
+        return 0
+    fi
+    if [[ "$DRY_RUN" == "1" ]]; then
+        echo "${bin} restore-all"
+        return 0
+    fi
+    info "Restoring slices pinned by ccdbind..."
+    "$bin" restore-all || warn "restore-all failed; run it again before removing the state directory"
 }
 
 reload_systemd() {
@@ -157,6 +179,24 @@ reload_systemd() {
     fi
 }
 
+# Remove the system units, as root or through sudo
+remove_system_units() {
+    local unit="${SYSTEMD_SYSTEM_DIR}/ccdbind-smt-off.service"
+    local sudo=()
+
+    [[ -f "$unit" ]] || return 0
+    if [[ "${EUID}" -ne 0 ]]; then
+        has_cmd sudo || die "--system needs root or sudo"
+        sudo=(sudo)
+    fi
+
+    info "Removing systemd system units..."
+    run ${sudo[@]+"${sudo[@]}"} rm -f "$unit"
+    if has_cmd systemctl; then
+        run ${sudo[@]+"${sudo[@]}"} systemctl daemon-reload
+    fi
+}
+
 main() {
     setup_colors
 
@@ -166,6 +206,7 @@ main() {
             -n|--dry-run)   DRY_RUN=1 ;;
             -p|--purge)     PURGE=1 ;;
             -f|--force)     FORCE=1 ;;
+            --system)       SYSTEM_UNITS=1 ;;
             --prefix=*)     PREFIX="$(parse_arg "$1")"; BINDIR="${PREFIX}/bin" ;;
             --bindir=*)     BINDIR="$(parse_arg "$1")" ;;
             --configdir=*)  CONFIGDIR="$(parse_arg "$1")" ;;
@@ -192,13 +233,19 @@ main() {
     fi
 
     stop_service
+    restore_all
 
     info "Removing systemd user units..."
     rm_file "${SYSTEMD_USER_DIR}/ccdbind.service" && info "  Removed ccdbind.service"
+    rm_file "${SYSTEMD_USER_DIR}/ccdbind-hotkey.service" && info "  Removed ccdbind-hotkey.service"
     rm_file "${SYSTEMD_USER_DIR}/game.slice" && info "  Removed game.slice"
 
     reload_systemd
 
+    if [[ "$SYSTEM_UNITS" == "1" ]] || [[ "${EUID}" -eq 0 ]]; then
+        remove_system_units
+    fi
+
     info "Removing binaries..."
     rm_file "${BINDIR}/ccdbind" && info "  Removed ccdbind"
     rm_file "${BINDIR}/ccdpin" && info "  Removed ccdpin"